@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestForbidden 测试服务端授权策略拒绝时返回 ErrForbidden，且不重试
+func TestForbidden(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message":"授权策略不允许","error":"授权策略不允许","code":"forbidden"}`))
+	}))
+	defer server.Close()
+
+	client := NewLockClient(server.URL, "node-1")
+	result, err := client.Lock(context.Background(), &Request{Type: "delete", ResourceID: "sha256:forbidden"})
+	if err != nil || result.Acquired || !errors.Is(result.Error, ErrForbidden) {
+		t.Errorf("加锁被拒绝时 LockResult.Error 应为 ErrForbidden: result=%+v, err=%v", result, err)
+	}
+	err = client.Unlock(context.Background(), &Request{Type: "delete", ResourceID: "sha256:forbidden", FencingToken: 1})
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("解锁被拒绝时应返回 ErrForbidden，实际 %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("被拒绝的请求不应重试，期望2次请求，实际 %d", calls)
+	}
+}
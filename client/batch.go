@@ -0,0 +1,287 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// batchLockRequest 批量加锁请求体（与服务端 BatchLockRequest 保持一致）
+type batchLockRequest struct {
+	NodeID        string     `json:"node_id"`
+	SessionID     string     `json:"session_id,omitempty"`
+	WaitTimeoutMs int64      `json:"wait_timeout_ms,omitempty"`
+	Resources     []*Request `json:"resources"`
+}
+
+// LockMany 批量加锁：一次获得一组资源的锁（例如一个镜像的所有层），要么全部获得，要么都不持有
+// 服务端按规范顺序授予，避免两个节点拉取有重叠的镜像时各自持有对方等待的层。
+// 被占用的资源在服务端排队；其他节点成功完成某个资源的操作后，该资源标记为 completed 不再加锁，
+// 其余资源全部获得后返回。获得锁后每个 Request 的 SessionID、FencingToken 和 Mode 会被填写，之后逐个 Unlock。
+func (c *LockClient) LockMany(ctx context.Context, requests []*Request) (*LockManyResult, error) {
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("没有需要加锁的资源")
+	}
+	for _, request := range requests {
+		request.NodeID = c.NodeID
+	}
+
+	sessionID := ""
+	completed := make(map[string]bool)
+	for {
+		pending := make([]*Request, 0, len(requests))
+		for _, request := range requests {
+			if !completed[resourceKey(request.Type, request.ResourceID)] {
+				pending = append(pending, request)
+			}
+		}
+		if len(pending) == 0 {
+			return &LockManyResult{Resources: batchResults(requests, nil, completed)}, nil
+		}
+
+		resp, err := c.lockBatch(ctx, sessionID, pending)
+		if err != nil {
+			c.cancelBatchDetached(sessionID, pending)
+			return nil, err
+		}
+		sessionID = resp.SessionID
+
+		if resp.Error != "" {
+			return &LockManyResult{
+				Resources: batchResults(requests, resp.Results, completed),
+				Error:     responseError(resp.Error, resp.Code),
+			}, nil
+		}
+		if resp.Acquired {
+			// 记录会话ID和fencing token，后续逐个 Unlock/KeepAlive 时自动携带
+			for _, result := range resp.Results {
+				for _, request := range pending {
+					if request.Type == result.Type && request.ResourceID == result.ResourceID {
+						request.SessionID = sessionID
+						request.FencingToken = result.FencingToken
+						request.Mode = result.Mode
+					}
+				}
+			}
+			return &LockManyResult{
+				Acquired:  true,
+				Resources: batchResults(requests, resp.Results, completed),
+				LeaseTTL:  time.Duration(resp.LeaseTTLMs) * time.Millisecond,
+			}, nil
+		}
+
+		if err := c.waitForBatch(ctx, sessionID, pending, resp.Results, completed); err != nil {
+			// 放弃等待：把本会话移出所有资源的等待队列（已分配给本会话的资源一并释放）
+			c.cancelBatchDetached(sessionID, pending)
+			return nil, err
+		}
+	}
+}
+
+// lockBatch 发送批量加锁请求（带重试机制）
+func (c *LockClient) lockBatch(ctx context.Context, sessionID string, pending []*Request) (*BatchLockResponse, error) {
+	var lastErr error
+	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(c.RetryInterval):
+			}
+		}
+
+		server := c.serverFor(pending[0].ResourceID)
+		resp, err := c.lockBatchOnce(ctx, sessionID, pending)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+		if !c.shouldRetry(err) {
+			return nil, err
+		}
+		c.failover(ctx, server, err)
+	}
+	return nil, fmt.Errorf("批量加锁失败，已重试%d次: %w", c.MaxRetries, lastErr)
+}
+
+// lockBatchOnce 发送一次批量加锁请求（未指定等待时间时使用 ctx 的截止时间）
+func (c *LockClient) lockBatchOnce(ctx context.Context, sessionID string, pending []*Request) (*BatchLockResponse, error) {
+	body := batchLockRequest{NodeID: c.NodeID, SessionID: sessionID}
+	for _, request := range pending {
+		body.Resources = append(body.Resources, &Request{Type: request.Type, ResourceID: request.ResourceID, Mode: request.Mode})
+		if request.WaitTimeoutMs > body.WaitTimeoutMs {
+			body.WaitTimeoutMs = request.WaitTimeoutMs
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok && body.WaitTimeoutMs == 0 {
+		if remaining := time.Until(deadline).Milliseconds(); remaining > 0 {
+			body.WaitTimeoutMs = remaining
+		}
+	}
+	jsonData, err := json.Marshal(&body)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.serverFor(pending[0].ResourceID)+"/lock/batch", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.ShortClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusForbidden {
+		return nil, fmt.Errorf("服务器返回错误状态码: %d, 响应: %s", resp.StatusCode, string(respBody))
+	}
+
+	var batchResp BatchLockResponse
+	if err := json.Unmarshal(respBody, &batchResp); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+	return &batchResp, nil
+}
+
+// waitForBatch 订阅所有排队资源的事件，等到值得重新请求时返回：
+//   - 某个资源被分配给本会话（lock_assigned），或本会话的等待被中止（aborted）
+//   - 某个资源已被其他节点成功完成（标记为 completed，并移出该资源的等待队列）
+//   - 每秒一次（事件可能在订阅建立之前发出）
+func (c *LockClient) waitForBatch(ctx context.Context, sessionID string, pending []*Request, results []*ResourceResult, completed map[string]bool) error {
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queued := make(map[string]*Request)
+	events := make(chan *OperationEvent, len(results))
+	for _, result := range results {
+		if result.Status != ResourceStatusQueued {
+			continue
+		}
+		for _, request := range pending {
+			if request.Type == result.Type && request.ResourceID == result.ResourceID {
+				queued[resourceKey(request.Type, request.ResourceID)] = request
+				go c.subscribeEvents(waitCtx, request.Type, request.ResourceID, events)
+			}
+		}
+	}
+	if len(queued) == 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			return nil
+		case event := <-events:
+			request, ok := queued[resourceKey(event.Type, event.ResourceID)]
+			if !ok {
+				continue
+			}
+			if event.Event == EventTypeProgress {
+				c.notifyProgress(event)
+				continue
+			}
+			if event.Success && request.Mode != LockModeShared {
+				// 其他节点已完成该资源的操作：不再等待它
+				completed[resourceKey(request.Type, request.ResourceID)] = true
+				c.cancelWaitDetached(&Request{Type: request.Type, ResourceID: request.ResourceID, SessionID: sessionID})
+				return nil
+			}
+			if (event.Event == EventTypeLockAssigned || event.Event == EventTypeAborted) && event.SessionID == sessionID {
+				// 锁已分配给本会话，或等待因死锁被中止（重新请求时服务端返回死锁错误）
+				return nil
+			}
+		}
+	}
+}
+
+// subscribeEvents 订阅一个资源的事件并转发到 events，连接关闭或 ctx 取消时返回
+func (c *LockClient) subscribeEvents(ctx context.Context, lockType, resourceID string, events chan<- *OperationEvent) {
+	subscribeURL := fmt.Sprintf("%s/lock/subscribe?type=%s&resource_id=%s&node_id=%s",
+		c.serverFor(resourceID), url.QueryEscape(lockType), url.QueryEscape(resourceID), url.QueryEscape(c.NodeID))
+	req, err := http.NewRequestWithContext(ctx, "GET", subscribeURL, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := c.LongClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event OperationEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			continue
+		}
+		select {
+		case events <- &event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// cancelBatchDetached 放弃批量加锁后把本会话移出所有资源的等待队列
+func (c *LockClient) cancelBatchDetached(sessionID string, pending []*Request) {
+	if sessionID == "" {
+		return
+	}
+	for _, request := range pending {
+		c.cancelWaitDetached(&Request{Type: request.Type, ResourceID: request.ResourceID, SessionID: sessionID})
+	}
+}
+
+// batchResults 按调用方的请求顺序整理每个资源的结果
+func batchResults(requests []*Request, results []*ResourceResult, completed map[string]bool) []*ResourceResult {
+	byKey := make(map[string]*ResourceResult, len(results))
+	for _, result := range results {
+		byKey[resourceKey(result.Type, result.ResourceID)] = result
+	}
+
+	ordered := make([]*ResourceResult, 0, len(requests))
+	for _, request := range requests {
+		key := resourceKey(request.Type, request.ResourceID)
+		if completed[key] {
+			ordered = append(ordered, &ResourceResult{Type: request.Type, ResourceID: request.ResourceID,
+				Mode: request.Mode, Status: ResourceStatusCompleted})
+		} else if result, ok := byKey[key]; ok {
+			ordered = append(ordered, result)
+		}
+	}
+	return ordered
+}
+
+// resourceKey 资源的唯一标识（与服务端 LockKey 相同）
+func resourceKey(lockType, resourceID string) string {
+	return lockType + ":" + resourceID
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestLockMany 测试批量加锁：排队的资源被其他节点完成后标记为 completed，其余资源获得后写回请求
+func TestLockMany(t *testing.T) {
+	var mu sync.Mutex
+	var batchBodies []batchLockRequest
+	var cancelled []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/lock/batch":
+			var body batchLockRequest
+			json.NewDecoder(r.Body).Decode(&body)
+			mu.Lock()
+			batchBodies = append(batchBodies, body)
+			attempt := len(batchBodies)
+			mu.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			if attempt == 1 {
+				w.Write([]byte(`{"acquired":false,"session_id":"s-1","results":[
+					{"type":"pull","resource_id":"sha256:a","mode":"exclusive","status":"available"},
+					{"type":"pull","resource_id":"sha256:b","mode":"exclusive","status":"queued"}]}`))
+				return
+			}
+			w.Write([]byte(`{"acquired":true,"session_id":"s-1","lease_ttl_ms":30000,"results":[
+				{"type":"pull","resource_id":"sha256:a","mode":"exclusive","status":"acquired","fencing_token":5}]}`))
+		case "/lock/subscribe":
+			w.Header().Set("Content-Type", "text/event-stream")
+			event := OperationEvent{Event: EventTypeCompleted, Type: r.URL.Query().Get("type"),
+				ResourceID: r.URL.Query().Get("resource_id"), NodeID: "other-node", Success: true}
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case "/lock/queue":
+			var body Request
+			json.NewDecoder(r.Body).Decode(&body)
+			mu.Lock()
+			cancelled = append(cancelled, body.ResourceID+"@"+body.SessionID)
+			mu.Unlock()
+			w.Write([]byte(`{"cancelled":true,"removed":1}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewLockClient(server.URL, "test-node")
+	requests := []*Request{
+		{Type: OperationTypePull, ResourceID: "sha256:b"},
+		{Type: OperationTypePull, ResourceID: "sha256:a"},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := client.LockMany(ctx, requests)
+	if err != nil {
+		t.Fatalf("批量加锁失败: %v", err)
+	}
+	if !result.Acquired || result.LeaseTTL != 30*time.Second {
+		t.Fatalf("期望获得其余资源，实际 %+v", result)
+	}
+	if result.Resources[0].Status != ResourceStatusCompleted || result.Resources[1].Status != ResourceStatusAcquired {
+		t.Errorf("期望 sha256:b completed、sha256:a acquired，实际 %s, %s", result.Resources[0].Status, result.Resources[1].Status)
+	}
+	if requests[1].SessionID != "s-1" || requests[1].FencingToken != 5 {
+		t.Errorf("获得的资源应写回会话ID和token: %+v", requests[1])
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batchBodies) != 2 || len(batchBodies[1].Resources) != 1 || batchBodies[1].SessionID != "s-1" {
+		t.Errorf("第二次请求应只包含未完成的资源并携带会话ID: %+v", batchBodies)
+	}
+	if len(cancelled) != 1 || cancelled[0] != "sha256:b@s-1" {
+		t.Errorf("已完成的资源应移出等待队列，实际 %v", cancelled)
+	}
+}
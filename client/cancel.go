@@ -0,0 +1,62 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// CancelWait 取消等待：把 request 对应会话的排队请求移出服务端的等待队列
+// 如果锁在取消之前已经分配给该会话，服务端会把锁交给下一个等待者
+// 节点不在队列中时不返回错误（取消是幂等的）
+func (c *LockClient) CancelWait(ctx context.Context, request *Request) error {
+	jsonData, err := json.Marshal(&Request{
+		Type:       request.Type,
+		ResourceID: request.ResourceID,
+		NodeID:     c.NodeID,
+		SessionID:  request.SessionID,
+	})
+	if err != nil {
+		return fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.serverFor(request.ResourceID)+"/lock/queue", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.ShortClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("服务器返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	var cancelResp CancelWaitResponse
+	if err := json.Unmarshal(body, &cancelResp); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+	return nil
+}
+
+// cancelWaitDetached 放弃等待后通知服务端（调用方的 ctx 可能已经取消，使用独立的超时）
+func (c *LockClient) cancelWaitDetached(request *Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.RequestTimeout)
+	defer cancel()
+	if err := c.CancelWait(ctx, request); err != nil {
+		log.Printf("[CancelWait] 取消等待失败: type=%s, resource_id=%s, error=%v",
+			request.Type, request.ResourceID, err)
+	}
+}
@@ -8,15 +8,27 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
+// ErrDeadlock 等待因死锁被服务端中止（用 errors.Is 判断 LockResult.Error 和 LockManyResult.Error）
+// 已持有的其他锁不受影响：调用方应释放它们之后再重新加锁
+var ErrDeadlock = errors.New("检测到死锁，等待已被中止")
+
+// ErrForbidden 服务端的授权策略不允许本节点执行该操作（用 errors.Is 判断），重试不会成功
+var ErrForbidden = errors.New("授权策略不允许该操作")
+
 // LockClient 分布式锁客户端
 type LockClient struct {
-	ServerURL   string       // 锁服务端地址
+	ServerURL   string       // 锁服务端地址（配置了多个地址时为当前使用的地址）
+	ServerURLs  []string     // 按优先级排列的服务端地址，出错时在这些地址之间故障转移（见 failover.go）
+	Discover    DiscoverFunc // 服务端发现函数（可选，设置后优先于 ServerURLs）
 	ShortClient *http.Client // 短连接客户端（用于普通HTTP请求，有超时）
 	LongClient  *http.Client // 长连接客户端（用于SSE订阅和镜像操作，无超时）
 	NodeID      string       // 当前节点ID
@@ -25,29 +37,69 @@ type LockClient struct {
 	MaxRetries     int           // 最大重试次数（默认3次）
 	RetryInterval  time.Duration // 重试间隔（默认1秒）
 	RequestTimeout time.Duration // 请求超时时间（默认30秒）
+
+	// OnProgress 等待期间收到持有者上报的进度时调用（可选）
+	// 在订阅事件的goroutine中同步调用，不应阻塞
+	OnProgress func(event *OperationEvent)
+
+	serverMu sync.RWMutex // 保护 ServerURL 和 ServerURLs（故障转移时修改）
+
+	// 集群路由（见 EnableClusterRouting）
+	ringMu         sync.RWMutex
+	ring           *HashRing
+	ringStale      bool // 收到过重定向，下次请求时在后台重新获取环
+	ringRefreshing bool
 }
 
+// Locker 锁客户端接口：LockClient（HTTP）和 GRPCLockClient（gRPC）都实现该接口
+type Locker interface {
+	Lock(ctx context.Context, request *Request) (*LockResult, error)
+	Unlock(ctx context.Context, request *Request) error
+	KeepAlive(ctx context.Context, request *Request) error
+	StartKeepAlive(ctx context.Context, request *Request, interval time.Duration) (stop func())
+	Status(ctx context.Context, request *Request) (*StatusResponse, error)
+	CancelWait(ctx context.Context, request *Request) error
+}
+
+var _ Locker = (*LockClient)(nil)
+
 // NewLockClient 创建新的锁客户端
+// serverURL 可以是逗号分隔的多个地址（按优先级排列），出错时依次故障转移；
+// 地址可以是 unix:///run/distributed-lock.sock（通过 unix socket 访问）
 func NewLockClient(serverURL, nodeID string) *LockClient {
-	return &LockClient{
-		ServerURL: serverURL,
+	serverURLs := parseServerURLs(serverURL)
+	if len(serverURLs) > 0 {
+		serverURL = serverURLs[0]
+	}
+	// 同时支持 http(s):// 和 unix:// 地址，请求头中携带追踪上下文
+	transport := tracedTransport(newTransport())
+	c := &LockClient{
+		ServerURL:  serverURL,
+		ServerURLs: serverURLs,
 		ShortClient: &http.Client{
-			Timeout: 30 * time.Second, // 短连接设置超时
+			Transport: transport,
+			Timeout:   30 * time.Second, // 短连接设置超时
 		},
 		LongClient: &http.Client{
 			// 长连接不设置超时，用于SSE订阅和镜像操作（下载时间可能很长）
+			Transport: transport,
 		},
 		NodeID:         nodeID,
 		MaxRetries:     3,
 		RetryInterval:  1 * time.Second,
 		RequestTimeout: 30 * time.Second,
 	}
+	c.ShortClient.CheckRedirect = c.followRedirect
+	c.LongClient.CheckRedirect = c.followRedirect
+	return c
 }
 
 // Lock 获取锁（带重试机制）
-func (c *LockClient) Lock(ctx context.Context, request *Request) (*LockResult, error) {
+func (c *LockClient) Lock(ctx context.Context, request *Request) (result *LockResult, err error) {
 	// 设置节点ID
 	request.NodeID = c.NodeID
+	ctx, span := startSpan(ctx, "LockClient.Lock", c.NodeID, request)
+	defer func() { endLockSpan(span, request, result, err) }()
 
 	var lastErr error
 	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
@@ -60,8 +112,13 @@ func (c *LockClient) Lock(ctx context.Context, request *Request) (*LockResult, e
 			}
 		}
 
+		server := c.serverFor(request.ResourceID)
 		result, err := c.tryLockOnce(ctx, request)
 		if err == nil {
+			if result.Acquired {
+				// 记录fencing token，后续 Unlock/KeepAlive 使用同一个 request 时自动携带
+				request.FencingToken = result.FencingToken
+			}
 			return result, nil
 		}
 
@@ -70,22 +127,54 @@ func (c *LockClient) Lock(ctx context.Context, request *Request) (*LockResult, e
 		if !c.shouldRetry(err) {
 			return nil, err
 		}
+		c.failover(ctx, server, err)
 	}
 
 	return nil, fmt.Errorf("获取锁失败，已重试%d次: %w", c.MaxRetries, lastErr)
 }
 
-// tryLockOnce 尝试获取锁（单次尝试）
+// tryLockOnce 尝试获取锁（单次尝试），没有获得锁时等待
 func (c *LockClient) tryLockOnce(ctx context.Context, request *Request) (*LockResult, error) {
-	// 序列化请求
-	jsonData, err := json.Marshal(request)
+	result, err := c.postLock(ctx, request)
+	if err != nil || result != nil {
+		return result, err
+	}
+
+	// 如果没有获得锁，需要等待
+	// 这里使用 SSE 订阅方式等待锁释放（不是轮询）
+	// 注意：SSE订阅需要长时间保持连接，不应该使用带超时的context
+	// 创建一个新的context，取消超时限制，但保留取消功能
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	waitCtx, span := startSpan(waitCtx, "LockClient.Wait", c.NodeID, request)
+	result, err = c.waitForLock(waitCtx, request)
+	endLockSpan(span, request, result, err)
+	if err != nil || !result.Acquired {
+		// 放弃等待（ctx 取消、其他节点已完成操作等）：把本节点移出服务端的等待队列，
+		// 避免锁之后被分配给已经离开的节点，阻塞排在后面的节点
+		c.cancelWaitDetached(request)
+	}
+	return result, err
+}
+
+// postLock 发送一次加锁请求，不等待
+// 返回 nil 结果和 nil 错误表示已进入等待队列（同一会话重复请求时服务端保留原来的排队位置）
+func (c *LockClient) postLock(ctx context.Context, request *Request) (*LockResult, error) {
+	// 序列化请求（未指定等待时间时使用 ctx 的截止时间，服务端会在期限后把请求移出队列）
+	lockRequest := *request
+	if deadline, ok := ctx.Deadline(); ok && lockRequest.WaitTimeoutMs == 0 {
+		if remaining := time.Until(deadline).Milliseconds(); remaining > 0 {
+			lockRequest.WaitTimeoutMs = remaining
+		}
+	}
+	jsonData, err := json.Marshal(&lockRequest)
 	if err != nil {
 		return nil, fmt.Errorf("序列化请求失败: %w", err)
 	}
 
 	// 创建HTTP请求（使用传入的context，可以响应上层取消）
 	// 超时由 ShortClient.Timeout 控制
-	req, err := http.NewRequestWithContext(ctx, "POST", c.ServerURL+"/lock", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.serverFor(request.ResourceID)+"/lock", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("创建请求失败: %w", err)
 	}
@@ -123,32 +212,46 @@ func (c *LockClient) tryLockOnce(ctx context.Context, request *Request) (*LockRe
 	if err := json.Unmarshal(body, &lockResp); err != nil {
 		return nil, fmt.Errorf("解析响应失败: %w", err)
 	}
+	// 记录服务端分配的会话ID：排队后重新请求、续约和解锁都以会话识别持有者
+	if lockResp.SessionID != "" {
+		request.SessionID = lockResp.SessionID
+	}
 
-	// 检查是否有错误（例如delete操作时引用计数不为0）
+	// 检查是否有错误（例如delete操作时引用计数不为0、等待因死锁被中止）
 	if lockResp.Error != "" {
 		return &LockResult{
 			Acquired: false,
-			Error:    fmt.Errorf("%s", lockResp.Error),
+			Error:    responseError(lockResp.Error, lockResp.Code),
 		}, nil
 	}
 
+	// 操作已由其他节点完成（服务端的完成记录仍然有效），跳过操作
+	if lockResp.Skip {
+		result := &LockResult{
+			Skipped:    true,
+			Completion: lockResp.Completion,
+		}
+		if lockResp.Completion != nil {
+			result.PeerAddr = lockResp.Completion.PeerAddr
+		}
+		return result, nil
+	}
+
 	// 如果获得锁，直接返回
 	if lockResp.Acquired {
 		return &LockResult{
-			Acquired: true,
+			Acquired:     true,
+			LeaseTTL:     time.Duration(lockResp.LeaseTTLMs) * time.Millisecond,
+			FencingToken: lockResp.FencingToken,
 		}, nil
 	}
 
-	// 如果没有获得锁，需要等待
-	// 这里使用 SSE 订阅方式等待锁释放（不是轮询）
-	// 注意：SSE订阅需要长时间保持连接，不应该使用带超时的context
-	// 创建一个新的context，取消超时限制，但保留取消功能
-	waitCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	return c.waitForLock(waitCtx, request)
+	// 没有获得锁，已进入等待队列
+	return nil, nil
 }
 
 // waitForLock 等待锁释放（使用 SSE 订阅模式）
+// 订阅连接出错或断开时切换服务端（配置了多个服务端时），以同一会话重新请求锁后重新订阅，连续失败超过 MaxRetries 次时返回错误
 func (c *LockClient) waitForLock(ctx context.Context, request *Request) (*LockResult, error) {
 	// 定期检查锁是否已经被分配（用于处理操作失败的情况）
 	// 如果操作失败，锁会被processQueue分配给队头节点，但不会广播事件
@@ -156,6 +259,8 @@ func (c *LockClient) waitForLock(ctx context.Context, request *Request) (*LockRe
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
+	failures := 0 // 连续的订阅失败次数（收到事件后清零）
+
 resubscribe:
 	for {
 		select {
@@ -164,18 +269,20 @@ resubscribe:
 		case <-ticker.C:
 			// 定期重新请求锁，检查锁是否已经被processQueue分配
 			// 这样可以处理操作失败的情况：锁被分配给队头节点，但不广播事件
-			result, err := c.tryLockOnce(ctx, request)
-			if err == nil && result.Acquired {
+			if result, done := c.recheckLock(ctx, request); done {
 				return result, nil
 			}
 		default:
 		}
 
 		// 构建订阅 URL
-		subscribeURL := fmt.Sprintf("%s/lock/subscribe?type=%s&resource_id=%s",
-			c.ServerURL,
+		// 携带 node_id：本节点失效时服务端会关闭订阅连接
+		server := c.serverFor(request.ResourceID)
+		subscribeURL := fmt.Sprintf("%s/lock/subscribe?type=%s&resource_id=%s&node_id=%s",
+			server,
 			url.QueryEscape(request.Type),
-			url.QueryEscape(request.ResourceID))
+			url.QueryEscape(request.ResourceID),
+			url.QueryEscape(c.NodeID))
 
 		// 创建 SSE 订阅请求
 		req, err := http.NewRequestWithContext(ctx, "GET", subscribeURL, nil)
@@ -189,14 +296,28 @@ resubscribe:
 		// 注意：SSE订阅需要长时间保持连接，用于镜像操作时下载时间可能很长
 		resp, err := c.LongClient.Do(req)
 		if err != nil {
-			return nil, fmt.Errorf("订阅失败: %w", err)
+			result, done, err := c.resumeWait(ctx, request, server, fmt.Errorf("订阅失败: %w", err), &failures)
+			if done || err != nil {
+				return result, err
+			}
+			continue
 		}
 
 		// 检查响应状态码
 		if resp.StatusCode != http.StatusOK {
 			body, _ := io.ReadAll(resp.Body)
 			resp.Body.Close()
-			return nil, fmt.Errorf("订阅失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+			var denied LockResponse
+			if resp.StatusCode == http.StatusForbidden && json.Unmarshal(body, &denied) == nil && denied.Code == ErrorCodeForbidden {
+				// 授权策略不允许订阅：重试不会成功
+				return nil, fmt.Errorf("订阅失败: %w", responseError(denied.Error, denied.Code))
+			}
+			cause := fmt.Errorf("订阅失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+			result, done, err := c.resumeWait(ctx, request, server, cause, &failures)
+			if done || err != nil {
+				return result, err
+			}
+			continue
 		}
 
 		// 使用 bufio.Scanner 读取 SSE 流
@@ -212,8 +333,7 @@ resubscribe:
 				// 定期重新请求锁，检查锁是否已经被processQueue分配
 				// 这样可以处理操作失败的情况：锁被分配给队头节点，但不广播事件
 				resp.Body.Close()
-				result, err := c.tryLockOnce(ctx, request)
-				if err == nil && result.Acquired {
+				if result, done := c.recheckLock(ctx, request); done {
 					return result, nil
 				}
 				// 如果没有获得锁，继续SSE订阅（重新建立连接）
@@ -228,6 +348,7 @@ resubscribe:
 			if line == "" {
 				// 处理之前收集的事件数据
 				if currentEventJSON != "" {
+					failures = 0
 					var event OperationEvent
 					if err := json.Unmarshal([]byte(currentEventJSON), &event); err == nil {
 						result, done, needResubscribe := c.handleOperationEvent(ctx, request, &event)
@@ -252,7 +373,11 @@ resubscribe:
 		// 如果扫描结束（连接断开），检查是否有错误
 		if err := scanner.Err(); err != nil {
 			resp.Body.Close()
-			return nil, fmt.Errorf("读取 SSE 流失败: %w", err)
+			result, done, err := c.resumeWait(ctx, request, server, fmt.Errorf("读取 SSE 流失败: %w", err), &failures)
+			if done || err != nil {
+				return result, err
+			}
+			continue
 		}
 
 		// 处理最后一个事件（如果连接关闭前没有空行）
@@ -275,17 +400,46 @@ resubscribe:
 		resp.Body.Close()
 
 		// 连接正常关闭，但没有收到事件
-		// 可能是操作失败，锁已经被processQueue分配给了队头节点
-		// 重新请求锁，检查锁是否已经被分配
-		result, err := c.tryLockOnce(ctx, request)
-		if err == nil && result.Acquired {
-			return result, nil
+		// 可能是操作失败，锁已经被processQueue分配给了队头节点；也可能是服务端关闭或资源迁移到了其他服务端
+		// 重新请求锁（检查锁是否已经被分配）后重新订阅
+		result, done, err := c.resumeWait(ctx, request, server, fmt.Errorf("SSE 连接关闭，未收到事件: %w", io.EOF), &failures)
+		if done || err != nil {
+			return result, err
 		}
+	}
+}
+
+// recheckLock 等待期间重新请求锁，返回是否已经有结果（获得锁、操作已完成或死锁）
+func (c *LockClient) recheckLock(ctx context.Context, request *Request) (*LockResult, bool) {
+	result, err := c.postLock(ctx, request)
+	if err == nil && result != nil && (result.Acquired || result.Skipped || errors.Is(result.Error, ErrDeadlock)) {
+		return result, true
+	}
+	return nil, false
+}
+
+// resumeWait 订阅连接失败后恢复等待：必要时切换服务端，以同一会话重新请求锁（重新进入新服务端的等待队列）
+// 返回 done=true 表示已经有结果；cause 不可重试或连续失败超过 MaxRetries 次时返回错误
+func (c *LockClient) resumeWait(ctx context.Context, request *Request, server string, cause error, failures *int) (*LockResult, bool, error) {
+	*failures++
+	if *failures > c.MaxRetries || !c.shouldRetry(cause) {
+		return nil, false, cause
+	}
+	log.Printf("[waitForLock] 订阅中断，重新请求锁: resource_id=%s, server=%s, error=%v", request.ResourceID, server, cause)
+	c.failover(ctx, server, cause)
 
-		// 如果还是没有获得锁，继续等待（重新订阅）
-		// 连接正常关闭，但没有收到事件，返回错误
-		return nil, fmt.Errorf("SSE 连接关闭，未收到事件")
+	if *failures > 1 {
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-time.After(c.RetryInterval):
+		}
 	}
+	result, err := c.postLock(ctx, request)
+	if err == nil && result != nil {
+		return result, true, nil
+	}
+	return nil, false, nil
 }
 
 // handleOperationEvent 处理操作完成事件
@@ -297,16 +451,28 @@ func (c *LockClient) handleOperationEvent(ctx context.Context, request *Request,
 		return nil, false, false
 	}
 
+	// 持有者上报的进度：通知调用方，继续等待
+	if event.Event == EventTypeProgress {
+		c.notifyProgress(event)
+		return nil, false, false
+	}
+
 	// 如果操作成功，说明其他节点已完成操作
 	// 注意：上层已经检查过资源是否存在，如果资源已存在就不会请求锁
 	// 所以这里不需要返回 Skipped，直接返回错误让上层处理
 	// 上层应该通过文件系统检查资源是否已存在
 	if event.Success {
+		if request.Mode == LockModeShared {
+			// 共享请求需要实际持有锁（例如使用期间阻止删除），独占持有者完成后服务端会把锁分配给队头的共享请求
+			return nil, false, true
+		}
 		// 操作成功，但当前节点没有获得锁
 		// 上层应该检查资源是否已存在，如果存在就不需要操作
+		// 完成者提供了 blob 服务地址时一并返回，上层可以直接从完成者拉取
 		return &LockResult{
 			Acquired: false,
 			Error:    fmt.Errorf("其他节点已完成操作，请检查资源是否已存在"),
+			PeerAddr: event.PeerAddr,
 		}, true, false
 	}
 
@@ -319,9 +485,10 @@ func (c *LockClient) handleOperationEvent(ctx context.Context, request *Request,
 	// 注意：即使 event.Error 不为空，也不应该直接返回 Acquired: false
 	// 因为操作失败时，锁会被重新分配给队头节点，队头节点应该重新请求锁
 	//
-	// 如果事件的NodeID匹配当前节点，说明锁已被分配给自己，应该立即重新请求锁
-	// 如果事件的NodeID不匹配当前节点，说明锁被分配给了其他节点，需要继续等待
-	if event.NodeID == request.NodeID {
+	// 如果事件的会话匹配当前请求，说明锁已被分配给自己，应该立即重新请求锁
+	// 如果不匹配，说明锁被分配给了其他节点（或同一节点上的其他会话），需要继续等待
+	// aborted 事件（死锁）同样按会话匹配：重新请求时服务端返回死锁错误
+	if assignedTo(event, request) {
 		// 锁已被分配给自己，立即重新请求锁
 		// 不需要等待，因为服务端已经完成了锁的分配
 		// 注意：即使 event.Error 不为空，也要重新请求锁，因为锁已经被分配给自己了
@@ -338,7 +505,7 @@ func (c *LockClient) handleOperationEvent(ctx context.Context, request *Request,
 		}, true, false
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", c.ServerURL+"/lock", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.serverFor(request.ResourceID)+"/lock", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return &LockResult{
 			Acquired: false,
@@ -373,18 +540,20 @@ func (c *LockClient) handleOperationEvent(ctx context.Context, request *Request,
 		}, true, false
 	}
 
-	// 检查是否有错误
+	// 检查是否有错误（aborted 事件之后重新请求会返回死锁错误）
 	if lockResp.Error != "" {
 		return &LockResult{
 			Acquired: false,
-			Error:    fmt.Errorf("%s", lockResp.Error),
+			Error:    responseError(lockResp.Error, lockResp.Code),
 		}, true, false
 	}
 
 	// 如果获得锁
 	if lockResp.Acquired {
 		return &LockResult{
-			Acquired: true,
+			Acquired:     true,
+			LeaseTTL:     time.Duration(lockResp.LeaseTTLMs) * time.Millisecond,
+			FencingToken: lockResp.FencingToken,
 		}, true, false
 	}
 
@@ -399,6 +568,33 @@ func (c *LockClient) handleOperationEvent(ctx context.Context, request *Request,
 	return nil, false, true
 }
 
+// responseError 把服务端返回的错误信息转换为 error（有错误码时返回对应的哨兵错误）
+func responseError(message, code string) error {
+	switch code {
+	case ErrorCodeDeadlock:
+		return fmt.Errorf("%w: %s", ErrDeadlock, message)
+	case ErrorCodeForbidden:
+		return fmt.Errorf("%w: %s", ErrForbidden, message)
+	}
+	return fmt.Errorf("%s", message)
+}
+
+// assignedTo 判断 lock_assigned 事件是否分配给了该请求
+// 双方都有会话ID时按会话匹配（同一节点上的其他会话不算），否则按节点ID匹配（旧服务端）
+func assignedTo(event *OperationEvent, request *Request) bool {
+	if event.SessionID != "" && request.SessionID != "" {
+		return event.SessionID == request.SessionID
+	}
+	return event.NodeID == request.NodeID
+}
+
+// notifyProgress 把进度事件交给 OnProgress 回调（未设置时忽略）
+func (c *LockClient) notifyProgress(event *OperationEvent) {
+	if c.OnProgress != nil {
+		c.OnProgress(event)
+	}
+}
+
 // shouldRetry 判断是否应该重试
 func (c *LockClient) shouldRetry(err error) bool {
 	if err == nil {
@@ -406,11 +602,15 @@ func (c *LockClient) shouldRetry(err error) bool {
 	}
 	errStr := err.Error()
 	// 网络错误、超时、连接失败等可以重试
+	// 503：集群模式下资源正在迁移，或复制模式下正在选举leader
 	return contains(errStr, "timeout") ||
 		contains(errStr, "connection") ||
 		contains(errStr, "network") ||
 		contains(errStr, "EOF") ||
-		contains(errStr, "refused")
+		contains(errStr, "refused") ||
+		contains(errStr, "状态码: 503") ||
+		// unix socket 不存在：服务端未启动或正在重启
+		errors.Is(err, syscall.ENOENT)
 }
 
 func contains(s, substr string) bool {
@@ -429,9 +629,11 @@ func contains(s, substr string) bool {
 }
 
 // Unlock 释放锁（带重试机制）
-func (c *LockClient) Unlock(ctx context.Context, request *Request) error {
+func (c *LockClient) Unlock(ctx context.Context, request *Request) (err error) {
 	// 设置节点ID
 	request.NodeID = c.NodeID
+	ctx, span := startUnlockSpan(ctx, "LockClient.Unlock", c.NodeID, request)
+	defer func() { endSpan(span, err) }()
 
 	var lastErr error
 	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
@@ -444,6 +646,7 @@ func (c *LockClient) Unlock(ctx context.Context, request *Request) error {
 			}
 		}
 
+		server := c.serverFor(request.ResourceID)
 		err := c.tryUnlockOnce(ctx, request)
 		if err == nil {
 			return nil
@@ -454,6 +657,7 @@ func (c *LockClient) Unlock(ctx context.Context, request *Request) error {
 		if !c.shouldRetry(err) {
 			return err
 		}
+		c.failover(ctx, server, err)
 	}
 
 	return fmt.Errorf("释放锁失败，已重试%d次: %w", c.MaxRetries, lastErr)
@@ -472,7 +676,7 @@ func (c *LockClient) tryUnlockOnce(ctx context.Context, request *Request) error
 
 	// 创建HTTP请求（使用传入的context，可以响应上层取消）
 	// 超时由 ShortClient.Timeout 控制
-	req, err := http.NewRequestWithContext(ctx, "POST", c.ServerURL+"/unlock", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.serverFor(request.ResourceID)+"/unlock", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("创建请求失败: %w", err)
 	}
@@ -506,7 +710,7 @@ func (c *LockClient) tryUnlockOnce(ctx context.Context, request *Request) error
 	}
 
 	if !unlockResp.Released {
-		return fmt.Errorf("释放锁失败: %s", unlockResp.Message)
+		return fmt.Errorf("释放锁失败: %w", responseError(unlockResp.Message, unlockResp.Code))
 	}
 
 	return nil
@@ -184,3 +184,47 @@ func TestTimeout(t *testing.T) {
 	}
 }
 
+
+// TestKeepAlive 测试续约请求与租约时长解析
+func TestKeepAlive(t *testing.T) {
+	var mu sync.Mutex
+	keepAliveCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/lock":
+			w.Write([]byte(`{"acquired":true,"message":"成功获得锁","lease_ttl_ms":90,"fencing_token":7}`))
+		case "/lock/keepalive":
+			mu.Lock()
+			keepAliveCount++
+			mu.Unlock()
+			w.Write([]byte(`{"renewed":true,"message":"续约成功","lease_ttl_ms":90}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewLockClient(server.URL, "test-node")
+	ctx := context.Background()
+	request := &Request{Type: OperationTypePull, ResourceID: "sha256:test123"}
+
+	result, err := client.Lock(ctx, request)
+	if err != nil {
+		t.Fatalf("获取锁失败: %v", err)
+	}
+	if result.LeaseTTL != 90*time.Millisecond {
+		t.Fatalf("期望租约时长 90ms，实际 %v", result.LeaseTTL)
+	}
+	if result.FencingToken != 7 || request.FencingToken != 7 {
+		t.Fatalf("期望 fencing token 7 写回请求，实际 result=%d, request=%d", result.FencingToken, request.FencingToken)
+	}
+
+	stop := client.StartKeepAlive(ctx, request, KeepAliveInterval(result.LeaseTTL))
+	time.Sleep(100 * time.Millisecond)
+	stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if keepAliveCount < 2 {
+		t.Errorf("期望至少续约2次，实际 %d", keepAliveCount)
+	}
+}
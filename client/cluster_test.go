@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCluster 两个模拟的集群成员，记录各自收到的请求
+type fakeCluster struct {
+	mu      sync.Mutex
+	ring    HashRing
+	servers map[string]*httptest.Server
+	hits    map[string][]string // 成员ID -> 收到的请求路径
+}
+
+func newFakeCluster(t *testing.T, ids ...string) *fakeCluster {
+	t.Helper()
+	fc := &fakeCluster{servers: make(map[string]*httptest.Server), hits: make(map[string][]string)}
+	for _, id := range ids {
+		id := id
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fc.serve(id, w, r)
+		}))
+		t.Cleanup(server.Close)
+		fc.servers[id] = server
+	}
+	fc.ring = HashRing{Version: 1, VirtualNodes: 64}
+	for _, id := range ids {
+		fc.ring.Members = append(fc.ring.Members, RingMember{ID: id, URL: fc.servers[id].URL})
+	}
+	fc.ring.build()
+	return fc
+}
+
+func (fc *fakeCluster) serve(id string, w http.ResponseWriter, r *http.Request) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if r.URL.Path == "/cluster/ring" {
+		json.NewEncoder(w).Encode(&fc.ring)
+		return
+	}
+	fc.hits[id] = append(fc.hits[id], r.URL.Path)
+
+	if r.URL.Path == "/lock/status" {
+		var body Request
+		json.NewDecoder(r.Body).Decode(&body)
+		if owner := fc.ring.Owner(body.ResourceID); owner.ID != id {
+			http.Redirect(w, r, owner.URL+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+		w.Write([]byte(fmt.Sprintf(`{"holder":%q}`, id)))
+		return
+	}
+	w.Write([]byte(`{"node_id":"test-node","state":"alive"}`))
+}
+
+func (fc *fakeCluster) hitCount(id string) int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return len(fc.hits[id])
+}
+
+// TestHashRingMatchesServer 测试客户端的环与服务端（server.HashRing）的划分一致
+func TestHashRingMatchesServer(t *testing.T) {
+	ring := &HashRing{Version: 1, VirtualNodes: 64, Members: []RingMember{
+		{ID: "lock-3", URL: "http://c"}, {ID: "lock-1", URL: "http://a"}, {ID: "lock-2", URL: "http://b"},
+	}}
+	if err := ring.build(); err != nil {
+		t.Fatalf("创建环失败: %v", err)
+	}
+	// 与 server/cluster_test.go 中 TestHashRingOwnership 的向量相同
+	for _, expected := range []struct{ resourceID, owner string }{
+		{"sha256:a", "lock-2"},
+		{"sha256:b", "lock-3"},
+		{"sha256:d", "lock-1"},
+		{"global", "lock-3"},
+	} {
+		if owner := ring.Owner(expected.resourceID).ID; owner != expected.owner {
+			t.Errorf("%s 的 owner 应为 %s，实际 %s", expected.resourceID, expected.owner, owner)
+		}
+	}
+	if err := (&HashRing{VirtualNodes: 64}).build(); err == nil {
+		t.Error("空成员列表应返回错误")
+	}
+}
+
+// TestClusterRouting 测试启用集群路由后请求直接发给 owner，收到重定向后重新获取环
+func TestClusterRouting(t *testing.T) {
+	fc := newFakeCluster(t, "lock-1", "lock-2")
+	client := NewLockClient(fc.servers["lock-1"].URL, "test-node")
+	if err := client.EnableClusterRouting(context.Background()); err != nil {
+		t.Fatalf("获取环失败: %v", err)
+	}
+	var remote string
+	for i := 0; remote == ""; i++ {
+		if candidate := fmt.Sprintf("sha256:%d", i); client.Ring().Owner(candidate).ID == "lock-2" {
+			remote = candidate
+		}
+	}
+
+	status, err := client.Status(context.Background(), &Request{Type: "pull", ResourceID: remote})
+	if err != nil || status.Holder != "lock-2" {
+		t.Fatalf("请求应直接发给 owner lock-2: status=%+v, err=%v", status, err)
+	}
+	if fc.hitCount("lock-1") != 0 {
+		t.Errorf("lock-1 不应收到请求: %v", fc.hits)
+	}
+
+	// 节点心跳发给所有成员
+	if _, err := client.Heartbeat(context.Background()); err != nil {
+		t.Fatalf("心跳失败: %v", err)
+	}
+	if fc.hitCount("lock-1") != 1 || fc.hitCount("lock-2") != 2 {
+		t.Errorf("心跳应发给所有成员: %v", fc.hits)
+	}
+
+	// 环变更：全部资源移到 lock-1，旧的 owner 重定向
+	fc.mu.Lock()
+	fc.ring = HashRing{Version: 2, VirtualNodes: 64, Members: []RingMember{{ID: "lock-1", URL: fc.servers["lock-1"].URL}}}
+	fc.ring.build()
+	fc.mu.Unlock()
+
+	status, err = client.Status(context.Background(), &Request{Type: "pull", ResourceID: remote})
+	if err != nil || status.Holder != "lock-1" {
+		t.Fatalf("应跟随重定向到新的 owner: status=%+v, err=%v", status, err)
+	}
+	client.serverFor(remote) // 触发后台重新获取环
+	deadline := time.Now().Add(2 * time.Second)
+	for client.Ring().Version != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("收到重定向后应重新获取环")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if url := client.serverFor(remote); url != fc.servers["lock-1"].URL {
+		t.Errorf("新环上的 owner 应为 lock-1，实际 %s", url)
+	}
+}
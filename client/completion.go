@@ -0,0 +1,48 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Invalidate 作废服务端保存的完成记录（例如本地发现镜像层损坏，或层已被删除）
+// lockType 为空时作废该资源上所有操作类型的记录；之后的 Lock 重新获得锁并执行操作
+// 返回：作废的记录数量
+func (c *LockClient) Invalidate(ctx context.Context, lockType, resourceID string) (int, error) {
+	jsonData, err := json.Marshal(map[string]string{"type": lockType, "resource_id": resourceID, "node_id": c.NodeID})
+	if err != nil {
+		return 0, fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.serverFor(resourceID)+"/lock/invalidate", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.ShortClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("读取响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("服务器返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	var invalidateResp struct {
+		Invalidated int `json:"invalidated"`
+	}
+	if err := json.Unmarshal(body, &invalidateResp); err != nil {
+		return 0, fmt.Errorf("解析响应失败: %w", err)
+	}
+	return invalidateResp.Invalidated, nil
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestLockSkippedByCompletion 测试服务端返回 skip 时 Lock 不等待，直接返回完成记录；以及作废完成记录
+func TestLockSkippedByCompletion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/lock":
+			w.Write([]byte(`{"acquired":false,"skip":true,"session_id":"s-1",` +
+				`"completion":{"type":"pull","resource_id":"sha256:done","node_id":"node-a","result":"/layers/done"}}`))
+		case "/lock/invalidate":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["resource_id"] != "sha256:done" || body["type"] != "" {
+				t.Errorf("作废请求不正确: %v", body)
+			}
+			w.Write([]byte(`{"invalidated":1}`))
+		default:
+			t.Errorf("跳过操作时不应请求 %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := NewLockClient(server.URL, "test-node")
+	result, err := client.Lock(context.Background(), &Request{Type: "pull", ResourceID: "sha256:done"})
+	if err != nil {
+		t.Fatalf("加锁失败: %v", err)
+	}
+	if result.Acquired || !result.Skipped || result.Completion == nil ||
+		result.Completion.NodeID != "node-a" || result.Completion.Result != "/layers/done" {
+		t.Fatalf("期望跳过操作并返回完成记录: %+v", result)
+	}
+
+	if n, err := client.Invalidate(context.Background(), "", "sha256:done"); err != nil || n != 1 {
+		t.Errorf("作废完成记录失败: n=%d, err=%v", n, err)
+	}
+}
+
+// TestLockReturnsPeerAddr 测试等待期间其他节点完成操作时，LockResult 返回完成者的 blob 服务地址
+func TestLockReturnsPeerAddr(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/lock":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"acquired":false,"session_id":"s-2"}`))
+		case "/lock/subscribe":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte(`data: {"event":"completed","type":"pull","resource_id":"sha256:peer","node_id":"node-a","success":true,"peer_addr":"10.0.0.1:8087"}` + "\n\n"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case "/lock/queue":
+			w.Write([]byte(`{"cancelled":false}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewLockClient(server.URL, "test-node")
+	result, err := client.Lock(context.Background(), &Request{Type: "pull", ResourceID: "sha256:peer"})
+	if err != nil {
+		t.Fatalf("加锁失败: %v", err)
+	}
+	if result.Acquired || result.PeerAddr != "10.0.0.1:8087" {
+		t.Errorf("期望返回完成者地址: %+v", result)
+	}
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestLockDeadlockError 测试服务端返回 code=deadlock 时 Lock 的错误可以用 ErrDeadlock 判断
+func TestLockDeadlockError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"acquired":false,"session_id":"s-1","error":"检测到死锁，等待已被中止","code":"deadlock"}`))
+	}))
+	defer server.Close()
+
+	client := NewLockClient(server.URL, "test-node")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := client.Lock(ctx, &Request{Type: OperationTypePull, ResourceID: "sha256:deadlock", SessionID: "s-1"})
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	if result.Acquired || !errors.Is(result.Error, ErrDeadlock) {
+		t.Errorf("期望 ErrDeadlock，实际 %+v", result)
+	}
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 多服务端故障转移
+//
+// 客户端可以配置多个服务端地址（ServerURLs，按优先级排列）或发现函数（Discover）。请求遇到连接错误、
+// 超时或 503 时，客户端探测所有地址，优先切换到 Raft leader，其次是第一个可用的服务端，然后重试；
+// 等待锁期间订阅连接断开时，以同一个会话ID向新的服务端重新请求锁并重新订阅——服务端保留了等待队列
+// （Raft 复制模式，或开启持久化的服务端重启后）时排队位置不变，否则重新排到队尾。
+
+// failoverProbeTimeout 探测单个服务端的超时时间
+const failoverProbeTimeout = 2 * time.Second
+
+// DiscoverFunc 返回按优先级排列的服务端地址
+type DiscoverFunc func(ctx context.Context) ([]string, error)
+
+// NewLockClientWithServers 创建配置了多个服务端地址的锁客户端，第一个地址为初始服务端
+func NewLockClientWithServers(serverURLs []string, nodeID string) *LockClient {
+	return NewLockClient(strings.Join(serverURLs, ","), nodeID)
+}
+
+// NewLockClientWithDiscovery 创建通过 discover 获取服务端地址的锁客户端，并选出初始服务端
+func NewLockClientWithDiscovery(ctx context.Context, discover DiscoverFunc, nodeID string) (*LockClient, error) {
+	c := NewLockClient("", nodeID)
+	c.Discover = discover
+	if _, err := c.SelectServer(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseServerURLs 解析逗号分隔的服务端地址
+func parseServerURLs(serverURL string) []string {
+	var urls []string
+	for _, part := range strings.Split(serverURL, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			urls = append(urls, part)
+		}
+	}
+	return urls
+}
+
+// currentServer 当前使用的服务端地址
+func (c *LockClient) currentServer() string {
+	c.serverMu.RLock()
+	defer c.serverMu.RUnlock()
+	return c.ServerURL
+}
+
+// endpoints 候选服务端地址（Discover 优先，失败时使用 ServerURLs）
+func (c *LockClient) endpoints(ctx context.Context) []string {
+	if c.Discover != nil {
+		urls, err := c.Discover(ctx)
+		if err == nil && len(urls) > 0 {
+			c.serverMu.Lock()
+			c.ServerURLs = urls
+			c.serverMu.Unlock()
+			return urls
+		}
+		log.Printf("[Failover] 发现服务端失败，使用已知地址: error=%v", err)
+	}
+	c.serverMu.RLock()
+	defer c.serverMu.RUnlock()
+	if len(c.ServerURLs) == 0 && c.ServerURL != "" {
+		return []string{c.ServerURL}
+	}
+	return append([]string(nil), c.ServerURLs...)
+}
+
+// SelectServer 探测所有候选服务端，切换到 Raft leader（非复制模式的服务端视为leader），
+// 没有 leader 时切换到第一个可用的服务端。返回选中的地址，全部不可用时返回错误且不切换
+func (c *LockClient) SelectServer(ctx context.Context) (string, error) {
+	candidates := c.endpoints(ctx)
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("没有可用的服务端地址")
+	}
+
+	healthy := ""
+	var lastErr error
+	for _, server := range candidates {
+		leader, err := c.probeServer(ctx, server)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if leader {
+			healthy = server
+			break
+		}
+		if healthy == "" {
+			healthy = server
+		}
+	}
+	if healthy == "" {
+		return "", fmt.Errorf("所有服务端均不可用: %w", lastErr)
+	}
+
+	c.serverMu.Lock()
+	if c.ServerURL != healthy {
+		log.Printf("[Failover] 切换服务端: %s -> %s", c.ServerURL, healthy)
+		c.ServerURL = healthy
+	}
+	c.serverMu.Unlock()
+	return healthy, nil
+}
+
+// probeServer 探测服务端是否可用，返回它是否为 leader
+// 通过 GET /raft/status 判断：404 表示服务端未启用复制模式，视为 leader
+func (c *LockClient) probeServer(ctx context.Context, server string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, failoverProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", server+"/raft/status", nil)
+	if err != nil {
+		return false, fmt.Errorf("创建请求失败: %w", err)
+	}
+	resp, err := c.ShortClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return true, nil
+	case http.StatusOK:
+		var status struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			return false, fmt.Errorf("解析响应失败: %w", err)
+		}
+		return status.Role == "leader", nil
+	default:
+		return false, fmt.Errorf("服务器返回错误状态码: %d", resp.StatusCode)
+	}
+}
+
+// failover 请求 failed 服务端出错后切换服务端
+// 只处理可重试的错误（连接错误、超时、503）；启用集群路由时由环决定服务端，不切换；
+// 其他请求已经切换过（当前服务端不再是 failed）时不重复探测
+func (c *LockClient) failover(ctx context.Context, failed string, err error) {
+	if !c.shouldRetry(err) || c.Ring() != nil || c.currentServer() != failed {
+		return
+	}
+	c.serverMu.RLock()
+	single := c.Discover == nil && len(c.ServerURLs) < 2
+	c.serverMu.RUnlock()
+	if single {
+		return
+	}
+	if _, selectErr := c.SelectServer(ctx); selectErr != nil {
+		log.Printf("[Failover] 切换服务端失败: error=%v", selectErr)
+	}
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newRaftStatusServer 模拟复制模式的副本，/raft/status 返回 role，/lock 交给 lock 处理
+func newRaftStatusServer(t *testing.T, role string, lock http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/raft/status":
+			if role == "" {
+				http.Error(w, "副本已停止", http.StatusServiceUnavailable)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"role": role})
+		case "/lock":
+			lock(w, r)
+		default:
+			http.Error(w, "当前没有可用的leader，请稍后重试", http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestFailoverOnConnectionError 测试服务端不可达时切换到下一个可用的服务端
+func TestFailoverOnConnectionError(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/lock" {
+			http.NotFound(w, r) // 非复制模式：没有 /raft/status
+			return
+		}
+		w.Write([]byte(`{"acquired":true,"fencing_token":7}`))
+	}))
+	defer healthy.Close()
+
+	client := NewLockClientWithServers([]string{dead.URL, healthy.URL}, "test-node")
+	client.RetryInterval = 10 * time.Millisecond
+	result, err := client.Lock(context.Background(), &Request{Type: "pull", ResourceID: "sha256:failover"})
+	if err != nil || !result.Acquired || result.FencingToken != 7 {
+		t.Fatalf("应切换到可用的服务端并获得锁: result=%+v, err=%v", result, err)
+	}
+	if client.ServerURL != healthy.URL {
+		t.Errorf("当前服务端应为 %s，实际 %s", healthy.URL, client.ServerURL)
+	}
+
+	// 逗号分隔的地址与列表等价
+	if parsed := NewLockClient(dead.URL+", "+healthy.URL, "test-node"); parsed.ServerURL != dead.URL || len(parsed.ServerURLs) != 2 {
+		t.Errorf("逗号分隔的地址解析不正确: %s %v", parsed.ServerURL, parsed.ServerURLs)
+	}
+}
+
+// TestSelectServerPrefersLeader 测试探测时优先选择 leader，并支持发现函数
+func TestSelectServerPrefersLeader(t *testing.T) {
+	follower := newRaftStatusServer(t, "follower", nil)
+	leader := newRaftStatusServer(t, "leader", nil)
+
+	discover := func(ctx context.Context) ([]string, error) {
+		return []string{follower.URL, leader.URL}, nil
+	}
+	client, err := NewLockClientWithDiscovery(context.Background(), discover, "test-node")
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	if client.ServerURL != leader.URL {
+		t.Errorf("应选择 leader %s，实际 %s", leader.URL, client.ServerURL)
+	}
+
+	none := func(ctx context.Context) ([]string, error) { return nil, nil }
+	if _, err := NewLockClientWithDiscovery(context.Background(), none, "test-node"); err == nil {
+		t.Error("没有可用的服务端时应返回错误")
+	}
+}
+
+// TestWaitResumesOnNewLeader 测试等待期间原服务端不可用时，以同一会话向新的 leader 重新请求锁
+func TestWaitResumesOnNewLeader(t *testing.T) {
+	old := newRaftStatusServer(t, "", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"acquired":false,"session_id":"session-1"}`))
+	})
+	var resumedSession string
+	leader := newRaftStatusServer(t, "leader", func(w http.ResponseWriter, r *http.Request) {
+		var body Request
+		json.NewDecoder(r.Body).Decode(&body)
+		resumedSession = body.SessionID
+		w.Write([]byte(`{"acquired":true,"fencing_token":3,"session_id":"session-1"}`))
+	})
+
+	client := NewLockClientWithServers([]string{old.URL, leader.URL}, "test-node")
+	client.RetryInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := client.Lock(ctx, &Request{Type: "pull", ResourceID: "sha256:resume"})
+	if err != nil || !result.Acquired {
+		t.Fatalf("切换到新的 leader 后应获得锁: result=%+v, err=%v", result, err)
+	}
+	if resumedSession != "session-1" {
+		t.Errorf("重新请求应携带原来的会话ID（保留排队位置），实际 %q", resumedSession)
+	}
+	if client.ServerURL != leader.URL {
+		t.Errorf("当前服务端应为 leader，实际 %s", client.ServerURL)
+	}
+}
@@ -0,0 +1,437 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"distributed-lock/lockpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// EventTypeSubscribed gRPC Watch 流建立后的第一条事件
+const EventTypeSubscribed = "subscribed"
+
+// GRPCLockClient 通过 gRPC 访问锁服务的客户端（服务端设置 GRPC_PORT），与 LockClient 实现相同的 Locker 接口
+//
+// 等待锁时只使用 Watch 流：流建立（收到 subscribed）之后以同一会话再请求一次锁，之后锁的分配一定会以事件送达，
+// 不需要像 HTTP 客户端那样定期重新请求锁
+type GRPCLockClient struct {
+	NodeID string // 当前节点ID
+
+	// 重试配置（含义与 LockClient 相同）
+	MaxRetries     int
+	RetryInterval  time.Duration
+	RequestTimeout time.Duration
+
+	// OnProgress 等待期间收到持有者上报的进度时调用（可选）
+	OnProgress func(event *OperationEvent)
+
+	conn *grpc.ClientConn
+	rpc  lockpb.LockServiceClient
+}
+
+var _ Locker = (*GRPCLockClient)(nil)
+
+// NewGRPCLockClient 创建 gRPC 锁客户端，target 为服务端地址（例如 127.0.0.1:9086）
+// 未指定 opts 时使用不加密的连接；调用的 metadata 中携带追踪上下文
+func NewGRPCLockClient(target, nodeID string, opts ...grpc.DialOption) (*GRPCLockClient, error) {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	opts = append(opts, tracedDialOption())
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("创建 gRPC 连接失败: %w", err)
+	}
+	return &GRPCLockClient{
+		NodeID:         nodeID,
+		MaxRetries:     3,
+		RetryInterval:  1 * time.Second,
+		RequestTimeout: 30 * time.Second,
+		conn:           conn,
+		rpc:            lockpb.NewLockServiceClient(conn),
+	}, nil
+}
+
+// Close 关闭 gRPC 连接
+func (c *GRPCLockClient) Close() error {
+	return c.conn.Close()
+}
+
+// Lock 获取锁（带重试机制），未获得锁时通过 Watch 流等待
+func (c *GRPCLockClient) Lock(ctx context.Context, request *Request) (result *LockResult, err error) {
+	request.NodeID = c.NodeID
+	ctx, span := startSpan(ctx, "GRPCLockClient.Lock", c.NodeID, request)
+	defer func() { endLockSpan(span, request, result, err) }()
+
+	var lastErr error
+	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(c.RetryInterval):
+			}
+		}
+
+		result, err := c.tryLockOnce(ctx, request)
+		if err == nil {
+			if result.Acquired {
+				request.FencingToken = result.FencingToken
+			}
+			return result, nil
+		}
+		lastErr = err
+		if !retryableGRPC(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("获取锁失败，已重试%d次: %w", c.MaxRetries, lastErr)
+}
+
+// tryLockOnce 请求一次锁，进入等待队列时等待
+func (c *GRPCLockClient) tryLockOnce(ctx context.Context, request *Request) (*LockResult, error) {
+	result, err := c.acquire(ctx, request)
+	if err != nil || result != nil {
+		return result, err
+	}
+
+	waitCtx, span := startSpan(ctx, "GRPCLockClient.Wait", c.NodeID, request)
+	result, err = c.waitForLock(waitCtx, request)
+	endLockSpan(span, request, result, err)
+	if err != nil || !result.Acquired {
+		// 放弃等待：把本会话移出服务端的等待队列
+		c.cancelWaitDetached(request)
+	}
+	return result, err
+}
+
+// acquire 发送一次 Acquire，返回 nil 结果和 nil 错误表示已进入等待队列
+func (c *GRPCLockClient) acquire(ctx context.Context, request *Request) (*LockResult, error) {
+	in := &lockpb.AcquireRequest{
+		Type:          request.Type,
+		ResourceId:    request.ResourceID,
+		NodeId:        c.NodeID,
+		SessionId:     request.SessionID,
+		Mode:          request.Mode,
+		WaitTimeoutMs: request.WaitTimeoutMs,
+	}
+	if deadline, ok := ctx.Deadline(); ok && in.WaitTimeoutMs == 0 {
+		if remaining := time.Until(deadline).Milliseconds(); remaining > 0 {
+			in.WaitTimeoutMs = remaining
+		}
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, c.RequestTimeout)
+	defer cancel()
+	resp, err := c.rpc.Acquire(callCtx, in)
+	if err != nil {
+		if forbidden := forbiddenGRPC(err); forbidden != nil {
+			return &LockResult{Error: forbidden}, nil
+		}
+		return nil, fmt.Errorf("加锁请求失败: %w", err)
+	}
+	if resp.SessionId != "" {
+		request.SessionID = resp.SessionId
+	}
+
+	switch {
+	case resp.Error != "":
+		return &LockResult{Error: responseError(resp.Error, resp.Code)}, nil
+	case resp.Skip:
+		result := &LockResult{Skipped: true, Completion: completionFromProto(resp.Completion)}
+		if result.Completion != nil {
+			result.PeerAddr = result.Completion.PeerAddr
+		}
+		return result, nil
+	case resp.Acquired:
+		return &LockResult{
+			Acquired:     true,
+			LeaseTTL:     time.Duration(resp.LeaseTtlMs) * time.Millisecond,
+			FencingToken: resp.FencingToken,
+		}, nil
+	}
+	return nil, nil
+}
+
+// waitForLock 通过 Watch 流等待锁分配给本会话
+// 流中断时重新订阅并重新请求锁（同一会话保留排队位置），连续失败超过 MaxRetries 次时返回错误
+func (c *GRPCLockClient) waitForLock(ctx context.Context, request *Request) (*LockResult, error) {
+	failures := 0
+	for {
+		result, done, err := c.watchOnce(ctx, request, &failures)
+		if done {
+			return result, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		failures++
+		if failures > c.MaxRetries || !retryableGRPC(err) {
+			return nil, err
+		}
+		log.Printf("[GRPCWatch] 订阅中断，重新订阅: resource_id=%s, error=%v", request.ResourceID, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.RetryInterval):
+		}
+	}
+}
+
+// watchOnce 建立一次 Watch 流并等待，done=false 表示流中断（err 为中断原因）
+func (c *GRPCLockClient) watchOnce(ctx context.Context, request *Request, failures *int) (*LockResult, bool, error) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.rpc.Watch(watchCtx, &lockpb.WatchRequest{Type: request.Type, ResourceId: request.ResourceID, NodeId: c.NodeID})
+	if err != nil {
+		return nil, false, fmt.Errorf("订阅失败: %w", err)
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		return nil, false, fmt.Errorf("订阅失败: %w", err)
+	}
+	if first.Event != EventTypeSubscribed {
+		return nil, false, fmt.Errorf("订阅失败: 第一条事件应为 %s，实际 %s", EventTypeSubscribed, first.Event)
+	}
+
+	// 订阅建立之前锁可能已经分配给本会话（或操作已完成）：以同一会话再请求一次
+	if result, err := c.acquire(ctx, request); err != nil || result != nil {
+		return result, true, err
+	}
+
+	for {
+		message, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = fmt.Errorf("订阅流被服务端结束: %w", status.Error(codes.Unavailable, err.Error()))
+			}
+			return nil, false, err
+		}
+		*failures = 0
+
+		event := eventFromProto(message)
+		if event.Type != request.Type || event.ResourceID != request.ResourceID {
+			continue
+		}
+		switch {
+		case event.Event == EventTypeProgress:
+			if c.OnProgress != nil {
+				c.OnProgress(event)
+			}
+			continue
+		case event.Success && request.Mode != LockModeShared:
+			// 其他节点已完成操作（与 LockClient 相同：由上层检查资源是否已存在）
+			return &LockResult{
+				Error:    fmt.Errorf("其他节点已完成操作，请检查资源是否已存在"),
+				PeerAddr: event.PeerAddr,
+			}, true, nil
+		case event.Success || assignedTo(event, request):
+			// 锁已分配给本会话（或独占持有者完成后共享请求可以获得锁）：重新请求以获得 fencing token
+			if result, err := c.acquire(ctx, request); err != nil || result != nil {
+				return result, true, err
+			}
+		}
+	}
+}
+
+// Unlock 释放锁（带重试机制）
+func (c *GRPCLockClient) Unlock(ctx context.Context, request *Request) (err error) {
+	request.NodeID = c.NodeID
+	ctx, span := startUnlockSpan(ctx, "GRPCLockClient.Unlock", c.NodeID, request)
+	defer func() { endSpan(span, err) }()
+
+	var lastErr error
+	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.RetryInterval):
+			}
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, c.RequestTimeout)
+		resp, err := c.rpc.Release(callCtx, &lockpb.ReleaseRequest{
+			Type:         request.Type,
+			ResourceId:   request.ResourceID,
+			NodeId:       c.NodeID,
+			SessionId:    request.SessionID,
+			FencingToken: request.FencingToken,
+			Error:        request.Error,
+			Result:       request.Result,
+			PeerAddr:     request.PeerAddr,
+		})
+		cancel()
+		if err == nil {
+			if !resp.Released {
+				return fmt.Errorf("释放锁失败: %s", resp.Message)
+			}
+			return nil
+		}
+		if forbidden := forbiddenGRPC(err); forbidden != nil {
+			return forbidden
+		}
+		lastErr = fmt.Errorf("解锁请求失败: %w", err)
+		if !retryableGRPC(err) {
+			return lastErr
+		}
+	}
+	return fmt.Errorf("释放锁失败，已重试%d次: %w", c.MaxRetries, lastErr)
+}
+
+// KeepAlive 续约（单次请求），返回错误表示续约失败
+func (c *GRPCLockClient) KeepAlive(ctx context.Context, request *Request) error {
+	callCtx, cancel := context.WithTimeout(ctx, c.RequestTimeout)
+	defer cancel()
+	resp, err := c.rpc.Keepalive(callCtx, &lockpb.KeepaliveRequest{
+		Type:         request.Type,
+		ResourceId:   request.ResourceID,
+		NodeId:       c.NodeID,
+		SessionId:    request.SessionID,
+		FencingToken: request.FencingToken,
+	})
+	if err != nil {
+		return fmt.Errorf("续约请求失败: %w", err)
+	}
+	if !resp.Renewed {
+		return fmt.Errorf("续约失败: %s", resp.Message)
+	}
+	return nil
+}
+
+// StartKeepAlive 启动后台续约协程（与 LockClient.StartKeepAlive 相同）
+func (c *GRPCLockClient) StartKeepAlive(ctx context.Context, request *Request, interval time.Duration) (stop func()) {
+	return keepAliveLoop(ctx, interval, func(ctx context.Context) {
+		if err := c.KeepAlive(ctx, request); err != nil && ctx.Err() == nil {
+			log.Printf("[KeepAlive] 续约失败: type=%s, resource_id=%s, error=%v",
+				request.Type, request.ResourceID, err)
+		}
+	})
+}
+
+// Status 查询本会话在资源上的状态
+func (c *GRPCLockClient) Status(ctx context.Context, request *Request) (*StatusResponse, error) {
+	callCtx, cancel := context.WithTimeout(ctx, c.RequestTimeout)
+	defer cancel()
+	resp, err := c.rpc.Status(callCtx, &lockpb.StatusRequest{
+		Type:       request.Type,
+		ResourceId: request.ResourceID,
+		NodeId:     c.NodeID,
+		SessionId:  request.SessionID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("状态查询失败: %w", err)
+	}
+	return &StatusResponse{
+		Acquired:      resp.Acquired,
+		Completed:     resp.Completed,
+		Success:       resp.Success,
+		Error:         resp.Error,
+		Code:          resp.Code,
+		FencingToken:  resp.FencingToken,
+		Mode:          resp.Mode,
+		Queued:        resp.Queued,
+		QueuePosition: int(resp.QueuePosition),
+		QueueLength:   int(resp.QueueLength),
+		Holder:        resp.Holder,
+		SharedHolders: int(resp.SharedHolders),
+		Completion:    completionFromProto(resp.Completion),
+	}, nil
+}
+
+// CancelWait 取消等待（幂等）
+func (c *GRPCLockClient) CancelWait(ctx context.Context, request *Request) error {
+	callCtx, cancel := context.WithTimeout(ctx, c.RequestTimeout)
+	defer cancel()
+	if _, err := c.rpc.Cancel(callCtx, &lockpb.CancelRequest{
+		Type:       request.Type,
+		ResourceId: request.ResourceID,
+		NodeId:     c.NodeID,
+		SessionId:  request.SessionID,
+	}); err != nil {
+		return fmt.Errorf("取消等待失败: %w", err)
+	}
+	return nil
+}
+
+// cancelWaitDetached 放弃等待后通知服务端（调用方的 ctx 可能已经取消，使用独立的超时）
+func (c *GRPCLockClient) cancelWaitDetached(request *Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.RequestTimeout)
+	defer cancel()
+	if err := c.CancelWait(ctx, request); err != nil {
+		log.Printf("[CancelWait] 取消等待失败: type=%s, resource_id=%s, error=%v",
+			request.Type, request.ResourceID, err)
+	}
+}
+
+// retryableGRPC 服务端暂时不可用（连接失败、没有leader、资源迁移中）或单次请求超时时可以重试
+func retryableGRPC(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if s, ok := status.FromError(err); ok {
+			return s.Code() == codes.Unavailable || s.Code() == codes.DeadlineExceeded
+		}
+	}
+	return false
+}
+
+// forbiddenGRPC 服务端拒绝（PermissionDenied）时返回 ErrForbidden，与 HTTP 的 403 相同，重试不会成功；其他错误返回nil
+func forbiddenGRPC(err error) error {
+	if s, ok := status.FromError(err); ok && s.Code() == codes.PermissionDenied {
+		return fmt.Errorf("%w: %s", ErrForbidden, s.Message())
+	}
+	return nil
+}
+
+func eventFromProto(event *lockpb.Event) *OperationEvent {
+	return &OperationEvent{
+		Event:        event.Event,
+		Type:         event.Type,
+		ResourceID:   event.ResourceId,
+		NodeID:       event.NodeId,
+		Success:      event.Success,
+		Error:        event.Error,
+		CompletedAt:  fromUnixMs(event.CompletedAtUnixMs),
+		FencingToken: event.FencingToken,
+		Mode:         event.Mode,
+		SessionID:    event.SessionId,
+		Code:         event.Code,
+		BytesDone:    event.BytesDone,
+		BytesTotal:   event.BytesTotal,
+		Phase:        event.Phase,
+		PeerAddr:     event.PeerAddr,
+	}
+}
+
+func completionFromProto(record *lockpb.Completion) *CompletionRecord {
+	if record == nil {
+		return nil
+	}
+	return &CompletionRecord{
+		Type:         record.Type,
+		ResourceID:   record.ResourceId,
+		NodeID:       record.NodeId,
+		SessionID:    record.SessionId,
+		FencingToken: record.FencingToken,
+		CompletedAt:  fromUnixMs(record.CompletedAtUnixMs),
+		ExpiresAt:    fromUnixMs(record.ExpiresAtUnixMs),
+		Result:       record.Result,
+		PeerAddr:     record.PeerAddr,
+	}
+}
+
+// fromUnixMs 毫秒时间戳转换为时间（0为零值）
+func fromUnixMs(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
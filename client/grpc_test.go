@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"distributed-lock/lockpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeLockService 模拟服务端：第一次 Acquire 进入等待队列，Watch 建立后推送 lock_assigned，之后的 Acquire 获得锁
+type fakeLockService struct {
+	lockpb.UnimplementedLockServiceServer
+
+	mu        sync.Mutex
+	acquires  int
+	sessions  []string
+	assigned  bool
+	cancelled int
+}
+
+func (s *fakeLockService) Acquire(ctx context.Context, in *lockpb.AcquireRequest) (*lockpb.AcquireResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acquires++
+	s.sessions = append(s.sessions, in.SessionId)
+	if s.assigned {
+		return &lockpb.AcquireResponse{Acquired: true, FencingToken: 2, SessionId: "session-1"}, nil
+	}
+	return &lockpb.AcquireResponse{SessionId: "session-1"}, nil
+}
+
+func (s *fakeLockService) Watch(in *lockpb.WatchRequest, stream lockpb.LockService_WatchServer) error {
+	stream.Send(&lockpb.Event{Event: EventTypeSubscribed, Type: in.Type, ResourceId: in.ResourceId})
+	time.Sleep(20 * time.Millisecond) // 等待客户端以同一会话重新请求（仍在排队）
+	stream.Send(&lockpb.Event{Event: EventTypeProgress, Type: in.Type, ResourceId: in.ResourceId, BytesDone: 10, BytesTotal: 100})
+	s.mu.Lock()
+	s.assigned = true
+	s.mu.Unlock()
+	stream.Send(&lockpb.Event{Event: EventTypeLockAssigned, Type: in.Type, ResourceId: in.ResourceId, NodeId: in.NodeId, SessionId: "session-1"})
+	<-stream.Context().Done()
+	return nil
+}
+
+func (s *fakeLockService) Cancel(ctx context.Context, in *lockpb.CancelRequest) (*lockpb.CancelResponse, error) {
+	s.mu.Lock()
+	s.cancelled++
+	s.mu.Unlock()
+	return &lockpb.CancelResponse{Cancelled: true}, nil
+}
+
+// TestGRPCLockWaitsOnStream 测试 gRPC 客户端通过 Watch 流等待锁：收到 subscribed 后以同一会话重新请求，
+// 收到进度回调，收到 lock_assigned 后获得锁
+func TestGRPCLockWaitsOnStream(t *testing.T) {
+	service := &fakeLockService{}
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	lockpb.RegisterLockServiceServer(server, service)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	client, err := NewGRPCLockClient("passthrough:///bufnet", "test-node",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	var progress []*OperationEvent
+	client.OnProgress = func(event *OperationEvent) { progress = append(progress, event) }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request := &Request{Type: "pull", ResourceID: "sha256:grpc-client"}
+	result, err := client.Lock(ctx, request)
+	if err != nil || !result.Acquired || result.FencingToken != 2 {
+		t.Fatalf("应通过 Watch 流获得锁: result=%+v, err=%v", result, err)
+	}
+	if request.FencingToken != 2 || request.SessionID != "session-1" {
+		t.Errorf("请求应记录 fencing token 和会话ID: %+v", request)
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if service.acquires != 3 {
+		t.Errorf("期望3次 Acquire（排队、订阅后重新请求、分配后请求），实际 %d", service.acquires)
+	}
+	for _, session := range service.sessions[1:] {
+		if session != "session-1" {
+			t.Errorf("重新请求应携带原来的会话ID，实际 %q", session)
+		}
+	}
+	if service.cancelled != 0 {
+		t.Errorf("获得锁后不应取消等待")
+	}
+	if len(progress) != 1 || progress[0].BytesDone != 10 {
+		t.Errorf("应收到1次进度回调: %+v", progress)
+	}
+}
+
+// forbiddenLockService 模拟授权策略拒绝所有请求的服务端
+type forbiddenLockService struct {
+	lockpb.UnimplementedLockServiceServer
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *forbiddenLockService) Acquire(ctx context.Context, in *lockpb.AcquireRequest) (*lockpb.AcquireResponse, error) {
+	s.mu.Lock()
+	s.calls++
+	s.mu.Unlock()
+	return nil, status.Error(codes.PermissionDenied, "授权策略不允许")
+}
+
+func (s *forbiddenLockService) Release(ctx context.Context, in *lockpb.ReleaseRequest) (*lockpb.ReleaseResponse, error) {
+	s.mu.Lock()
+	s.calls++
+	s.mu.Unlock()
+	return nil, status.Error(codes.PermissionDenied, "授权策略不允许")
+}
+
+// TestGRPCForbidden 测试服务端返回 PermissionDenied 时与 HTTP 客户端一样返回 ErrForbidden，且不重试
+func TestGRPCForbidden(t *testing.T) {
+	service := &forbiddenLockService{}
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	lockpb.RegisterLockServiceServer(server, service)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	client, err := NewGRPCLockClient("passthrough:///bufnet", "test-node",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := client.Lock(ctx, &Request{Type: "delete", ResourceID: "sha256:grpc-forbidden"})
+	if err != nil || result.Acquired || !errors.Is(result.Error, ErrForbidden) {
+		t.Errorf("加锁被拒绝时 LockResult.Error 应为 ErrForbidden: result=%+v, err=%v", result, err)
+	}
+	err = client.Unlock(ctx, &Request{Type: "delete", ResourceID: "sha256:grpc-forbidden", FencingToken: 1})
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("解锁被拒绝时应返回 ErrForbidden，实际 %v", err)
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if service.calls != 2 {
+		t.Errorf("被拒绝的请求不应重试，期望2次请求，实际 %d", service.calls)
+	}
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultKeepAliveInterval 默认心跳间隔（服务端默认租约为30秒）
+const DefaultKeepAliveInterval = 10 * time.Second
+
+// KeepAlive 续约（持有者心跳，单次请求）
+// 返回错误表示续约失败：锁可能已因租约过期被回收，持有者应停止操作
+func (c *LockClient) KeepAlive(ctx context.Context, request *Request) error {
+	jsonData, err := json.Marshal(&Request{
+		Type:         request.Type,
+		ResourceID:   request.ResourceID,
+		NodeID:       c.NodeID,
+		FencingToken: request.FencingToken,
+		SessionID:    request.SessionID,
+	})
+	if err != nil {
+		return fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.serverFor(request.ResourceID)+"/lock/keepalive", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.ShortClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	var keepAliveResp KeepAliveResponse
+	if err := json.Unmarshal(body, &keepAliveResp); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+
+	if !keepAliveResp.Renewed {
+		return fmt.Errorf("续约失败: %s", keepAliveResp.Message)
+	}
+	return nil
+}
+
+// StartKeepAlive 启动后台续约协程，按 interval 周期调用 KeepAlive
+// interval <= 0 时使用 DefaultKeepAliveInterval
+// 返回 stop 函数，释放锁之前必须调用（可重复调用）
+func (c *LockClient) StartKeepAlive(ctx context.Context, request *Request, interval time.Duration) (stop func()) {
+	return keepAliveLoop(ctx, interval, func(ctx context.Context) {
+		server := c.serverFor(request.ResourceID)
+		if err := c.KeepAlive(ctx, request); err != nil && ctx.Err() == nil {
+			// 单次续约失败不退出：网络抖动时下一次心跳仍可能成功（服务端不可用时切换到其他服务端）
+			log.Printf("[KeepAlive] 续约失败: type=%s, resource_id=%s, error=%v",
+				request.Type, request.ResourceID, err)
+			c.failover(ctx, server, err)
+		}
+	})
+}
+
+// keepAliveLoop 在后台按 interval 周期调用 renew（interval <= 0 时使用 DefaultKeepAliveInterval）
+// 返回 stop 函数：取消 ctx 并等待协程退出（可重复调用）
+func keepAliveLoop(ctx context.Context, interval time.Duration, renew func(ctx context.Context)) (stop func()) {
+	if interval <= 0 {
+		interval = DefaultKeepAliveInterval
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				renew(ctx)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			wg.Wait()
+		})
+	}
+}
+
+// KeepAliveInterval 根据租约时长计算心跳间隔（租约的1/3，保证丢失一次心跳仍不过期）
+// leaseTTL <= 0（服务端未启用租约）时返回0
+func KeepAliveInterval(leaseTTL time.Duration) time.Duration {
+	if leaseTTL <= 0 {
+		return 0
+	}
+	return leaseTTL / 3
+}
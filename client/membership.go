@@ -0,0 +1,148 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultHeartbeatInterval 默认节点心跳间隔（服务端默认30秒没有心跳判定节点失效）
+const DefaultHeartbeatInterval = 10 * time.Second
+
+// Register 注册本节点（address 为可选的节点地址）
+// 注册后需要定期发送心跳（见 StartHeartbeat），否则服务端判定节点失效并释放它持有的锁
+func (c *LockClient) Register(ctx context.Context, address string) (*NodeResponse, error) {
+	return c.postNode(ctx, "/nodes/register", address)
+}
+
+// Heartbeat 发送一次节点心跳（未注册时服务端自动注册）
+func (c *LockClient) Heartbeat(ctx context.Context) (*NodeResponse, error) {
+	return c.postNode(ctx, "/nodes/heartbeat", "")
+}
+
+// postNode 发送节点注册/心跳请求
+// 启用集群路由时发给环上的每个成员（每个成员只回收自己负责的资源上该节点持有的锁），任一成员失败都返回错误
+func (c *LockClient) postNode(ctx context.Context, path, address string) (*NodeResponse, error) {
+	var first *NodeResponse
+	for _, server := range c.servers() {
+		nodeResp, err := c.postNodeTo(ctx, server, path, address)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", server, err)
+		}
+		if first == nil {
+			first = nodeResp
+		}
+	}
+	return first, nil
+}
+
+// postNodeTo 向一个服务端发送节点注册/心跳请求
+func (c *LockClient) postNodeTo(ctx context.Context, server, path, address string) (*NodeResponse, error) {
+	jsonData, err := json.Marshal(map[string]string{"node_id": c.NodeID, "address": address})
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", server+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.ShortClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("服务器返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	var nodeResp NodeResponse
+	if err := json.Unmarshal(body, &nodeResp); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+	return &nodeResp, nil
+}
+
+// StartHeartbeat 启动后台节点心跳协程，按 interval 周期调用 Heartbeat
+// interval <= 0 时使用 DefaultHeartbeatInterval
+// 返回 stop 函数（可重复调用）
+func (c *LockClient) StartHeartbeat(ctx context.Context, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = DefaultHeartbeatInterval
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := c.Heartbeat(ctx); err != nil && ctx.Err() == nil {
+					// 单次心跳失败不退出：网络抖动时下一次心跳仍可能成功
+					log.Printf("[Heartbeat] 节点心跳失败: node=%s, error=%v", c.NodeID, err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			wg.Wait()
+		})
+	}
+}
+
+// ListNodes 列出服务端已注册的节点（state 为空时返回全部，否则只返回该状态的节点）
+func (c *LockClient) ListNodes(ctx context.Context, state string) ([]*NodeInfo, error) {
+	listURL := c.currentServer() + "/nodes"
+	if state != "" {
+		listURL += "?state=" + url.QueryEscape(state)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", listURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+
+	resp, err := c.ShortClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("服务器返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	var listResp NodeListResponse
+	if err := json.Unmarshal(body, &listResp); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+	return listResp.Nodes, nil
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStartHeartbeat 测试注册后后台协程按间隔发送心跳，并能列出节点
+func TestStartHeartbeat(t *testing.T) {
+	var mu sync.Mutex
+	heartbeats := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/nodes/register", "/nodes/heartbeat":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if r.URL.Path == "/nodes/heartbeat" {
+				mu.Lock()
+				heartbeats++
+				mu.Unlock()
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"registered": r.URL.Path == "/nodes/register", "alive": true, "node_timeout_ms": 30000,
+				"node": map[string]interface{}{"node_id": body["node_id"], "address": body["address"], "state": "alive"},
+			})
+		case "/nodes":
+			if r.URL.Query().Get("state") != NodeStateAlive {
+				t.Errorf("期望按 state=alive 过滤，实际 %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"nodes":[{"node_id":"test-node","state":"alive"}],"total":1}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewLockClient(server.URL, "test-node")
+	ctx := context.Background()
+
+	resp, err := client.Register(ctx, "10.0.0.1:9000")
+	if err != nil {
+		t.Fatalf("注册失败: %v", err)
+	}
+	if !resp.Registered || resp.Node.Address != "10.0.0.1:9000" || resp.NodeTimeoutMs != 30000 {
+		t.Errorf("注册响应不正确: %+v, node=%+v", resp, resp.Node)
+	}
+
+	stop := client.StartHeartbeat(ctx, 20*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	stop()
+
+	mu.Lock()
+	if heartbeats < 2 {
+		t.Errorf("期望至少2次心跳，实际 %d", heartbeats)
+	}
+	mu.Unlock()
+
+	nodes, err := client.ListNodes(ctx, NodeStateAlive)
+	if err != nil || len(nodes) != 1 || nodes[0].NodeID != "test-node" {
+		t.Errorf("列出节点失败: nodes=%v, err=%v", nodes, err)
+	}
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Upgrade 把共享锁升级为独占锁（期间不释放锁）
+// 其他共享持有者尚未释放时按 RetryInterval 轮询，直到升级完成或 ctx 被取消
+// 成功后 request.FencingToken 和 request.Mode 更新为独占锁的值
+func (c *LockClient) Upgrade(ctx context.Context, request *Request) error {
+	for {
+		modeResp, err := c.changeModeOnce(ctx, "/lock/upgrade", request)
+		if err != nil {
+			return err
+		}
+		if modeResp.Upgraded {
+			request.FencingToken = modeResp.FencingToken
+			request.Mode = LockModeExclusive
+			return nil
+		}
+		if !modeResp.Pending {
+			return fmt.Errorf("升级失败: %s", modeResp.Message)
+		}
+
+		// 等待其他共享持有者释放
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.RetryInterval):
+		}
+	}
+}
+
+// Downgrade 把独占锁降级为共享锁（期间不释放锁），队列中等待的共享请求可以一起获得锁
+// 成功后 request.FencingToken 和 request.Mode 更新为共享锁的值
+func (c *LockClient) Downgrade(ctx context.Context, request *Request) error {
+	modeResp, err := c.changeModeOnce(ctx, "/lock/downgrade", request)
+	if err != nil {
+		return err
+	}
+	if !modeResp.Downgraded {
+		return fmt.Errorf("降级失败: %s", modeResp.Message)
+	}
+	request.FencingToken = modeResp.FencingToken
+	request.Mode = LockModeShared
+	return nil
+}
+
+// changeModeOnce 发送一次升级/降级请求
+func (c *LockClient) changeModeOnce(ctx context.Context, path string, request *Request) (*ModeChangeResponse, error) {
+	jsonData, err := json.Marshal(&Request{
+		Type:         request.Type,
+		ResourceID:   request.ResourceID,
+		NodeID:       c.NodeID,
+		FencingToken: request.FencingToken,
+		SessionID:    request.SessionID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.serverFor(request.ResourceID)+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.ShortClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusForbidden {
+		return nil, fmt.Errorf("服务器返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	var modeResp ModeChangeResponse
+	if err := json.Unmarshal(body, &modeResp); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+	return &modeResp, nil
+}
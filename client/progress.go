@@ -0,0 +1,154 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultProgressInterval 默认进度上报间隔
+const DefaultProgressInterval = time.Second
+
+// ReportProgress 上报持有者的操作进度（单次请求），服务端广播给该资源的订阅者并续约
+// 返回错误表示上报被拒绝：锁可能已因租约过期被回收，持有者应停止操作
+func (c *LockClient) ReportProgress(ctx context.Context, request *Request, bytesDone, bytesTotal int64, phase string) error {
+	jsonData, err := json.Marshal(&ProgressRequest{
+		Type:         request.Type,
+		ResourceID:   request.ResourceID,
+		NodeID:       c.NodeID,
+		SessionID:    request.SessionID,
+		FencingToken: request.FencingToken,
+		BytesDone:    bytesDone,
+		BytesTotal:   bytesTotal,
+		Phase:        phase,
+	})
+	if err != nil {
+		return fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.serverFor(request.ResourceID)+"/lock/progress", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.ShortClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	var progressResp ProgressResponse
+	if err := json.Unmarshal(body, &progressResp); err != nil {
+		return fmt.Errorf("服务器返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+	if !progressResp.Accepted {
+		return fmt.Errorf("上报进度失败: %s", progressResp.Message)
+	}
+	return nil
+}
+
+// ProgressReporter 后台进度上报：Add/SetTotal/SetPhase 只更新本地计数，
+// 后台协程按间隔把有变化的进度发送给服务端（避免每次 Write 都发送请求）
+type ProgressReporter struct {
+	client  *LockClient
+	request *Request
+
+	mu         sync.Mutex
+	bytesDone  int64
+	bytesTotal int64
+	phase      string
+	dirty      bool
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// StartProgressReporter 启动后台进度上报协程，interval <= 0 时使用 DefaultProgressInterval
+// 释放锁之前必须调用 Stop
+func (c *LockClient) StartProgressReporter(ctx context.Context, request *Request, interval time.Duration) *ProgressReporter {
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &ProgressReporter{client: c, request: request, cancel: cancel}
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.flush(ctx)
+			}
+		}
+	}()
+	return r
+}
+
+// Add 增加已完成的字节数
+func (r *ProgressReporter) Add(n int64) {
+	r.mu.Lock()
+	r.bytesDone += n
+	r.dirty = true
+	r.mu.Unlock()
+}
+
+// SetTotal 设置总字节数（未知时为0）
+func (r *ProgressReporter) SetTotal(total int64) {
+	r.mu.Lock()
+	r.bytesTotal = total
+	r.dirty = true
+	r.mu.Unlock()
+}
+
+// SetPhase 设置当前阶段，例如 downloading, extracting
+func (r *ProgressReporter) SetPhase(phase string) {
+	r.mu.Lock()
+	r.phase = phase
+	r.dirty = true
+	r.mu.Unlock()
+}
+
+// Stop 停止后台上报（可重复调用）
+func (r *ProgressReporter) Stop() {
+	r.once.Do(func() {
+		r.cancel()
+		r.wg.Wait()
+	})
+}
+
+// flush 进度有变化时发送一次
+func (r *ProgressReporter) flush(ctx context.Context) {
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return
+	}
+	bytesDone, bytesTotal, phase := r.bytesDone, r.bytesTotal, r.phase
+	r.dirty = false
+	r.mu.Unlock()
+
+	if err := r.client.ReportProgress(ctx, r.request, bytesDone, bytesTotal, phase); err != nil && ctx.Err() == nil {
+		// 上报失败不影响操作本身：持有者是否仍持有锁由续约和解锁判断
+		log.Printf("[ProgressReporter] 上报进度失败: type=%s, resource_id=%s, error=%v",
+			r.request.Type, r.request.ResourceID, err)
+	}
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestOnProgress 测试等待期间收到的 progress 事件交给 OnProgress，不影响继续等待锁
+func TestOnProgress(t *testing.T) {
+	var mu sync.Mutex
+	lockCalls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/lock":
+			mu.Lock()
+			lockCalls++
+			attempt := lockCalls
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			if attempt == 1 {
+				w.Write([]byte(`{"acquired":false,"session_id":"s-2"}`))
+				return
+			}
+			w.Write([]byte(`{"acquired":true,"session_id":"s-2","fencing_token":7}`))
+		case "/lock/subscribe":
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, event := range []OperationEvent{
+				{Event: EventTypeProgress, Type: "pull", ResourceID: "sha256:big", NodeID: "node-a", SessionID: "s-1", BytesDone: 10, BytesTotal: 100, Phase: "downloading"},
+				{Event: EventTypeProgress, Type: "pull", ResourceID: "sha256:big", NodeID: "node-a", SessionID: "s-1", BytesDone: 60, BytesTotal: 100, Phase: "downloading"},
+				{Event: EventTypeLockAssigned, Type: "pull", ResourceID: "sha256:big", NodeID: "test-node", SessionID: "s-2"},
+			} {
+				data, _ := json.Marshal(event)
+				fmt.Fprintf(w, "data: %s\n\n", data)
+			}
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	client := NewLockClient(server.URL, "test-node")
+	var progress []int64
+	client.OnProgress = func(event *OperationEvent) {
+		progress = append(progress, event.BytesDone)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := client.Lock(ctx, &Request{Type: "pull", ResourceID: "sha256:big"})
+	if err != nil || !result.Acquired || result.FencingToken != 7 {
+		t.Fatalf("期望进度事件之后获得锁: result=%+v, err=%v", result, err)
+	}
+	if len(progress) != 2 || progress[0] != 10 || progress[1] != 60 {
+		t.Errorf("OnProgress 应依次收到两次进度，实际 %v", progress)
+	}
+}
+
+// TestProgressReporter 测试后台上报携带持有者身份，并合并两次发送之间的更新
+func TestProgressReporter(t *testing.T) {
+	bodies := make(chan ProgressRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/lock/progress" {
+			t.Errorf("期望 /lock/progress，实际 %s", r.URL.Path)
+		}
+		var body ProgressRequest
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies <- body
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"accepted":true,"message":"进度已广播"}`))
+	}))
+	defer server.Close()
+
+	client := NewLockClient(server.URL, "test-node")
+	request := &Request{Type: "pull", ResourceID: "sha256:big", SessionID: "s-1", FencingToken: 3}
+	reporter := client.StartProgressReporter(context.Background(), request, 20*time.Millisecond)
+	reporter.SetTotal(100)
+	reporter.Add(30)
+	reporter.Add(20)
+
+	for {
+		var body ProgressRequest
+		select {
+		case body = <-bodies:
+		case <-time.After(2 * time.Second):
+			t.Fatal("超时未收到进度上报")
+		}
+		if body.NodeID != "test-node" || body.SessionID != "s-1" || body.FencingToken != 3 {
+			t.Fatalf("进度请求应携带持有者身份: %+v", body)
+		}
+		if body.BytesDone == 50 && body.BytesTotal == 100 {
+			break
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	reporter.Stop()
+	if len(bodies) != 0 {
+		t.Errorf("进度没有变化时不应重复发送，实际多发送 %d 次", len(bodies))
+	}
+}
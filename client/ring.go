@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// 集群路由
+//
+// 服务端以集群模式运行时，资源按一致性哈希分给多个服务端进程。EnableClusterRouting 从任意成员获取环，
+// 之后带资源ID的请求直接发给 owner；环变更后请求被重定向（307），客户端跟随重定向并在后台重新获取环。
+// HashRing 的算法必须与服务端（server.HashRing）保持一致。
+
+// RingMember 环上的一个服务端
+type RingMember struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// HashRing 一致性哈希环（与服务端 GET /cluster/ring 的响应一致）
+type HashRing struct {
+	Version      uint64       `json:"version"`
+	VirtualNodes int          `json:"virtual_nodes"`
+	Members      []RingMember `json:"members"`
+
+	points []ringPoint
+}
+
+// ringPoint 环上的一个虚拟节点
+type ringPoint struct {
+	hash   uint32
+	member int
+}
+
+// build 按成员计算虚拟节点（从JSON解析后调用）
+func (r *HashRing) build() error {
+	if len(r.Members) == 0 || r.VirtualNodes <= 0 {
+		return fmt.Errorf("无效的环: 成员数量=%d, 虚拟节点数量=%d", len(r.Members), r.VirtualNodes)
+	}
+	sort.Slice(r.Members, func(i, j int) bool { return r.Members[i].ID < r.Members[j].ID })
+	r.points = make([]ringPoint, 0, len(r.Members)*r.VirtualNodes)
+	for i, member := range r.Members {
+		for v := 0; v < r.VirtualNodes; v++ {
+			r.points = append(r.points, ringPoint{hash: ringHash(member.ID + "#" + strconv.Itoa(v)), member: i})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash == r.points[j].hash {
+			return r.points[i].member < r.points[j].member
+		}
+		return r.points[i].hash < r.points[j].hash
+	})
+	return nil
+}
+
+// ringHash FNV-1a 哈希再做一次 murmur3 的 fmix32 混合（与服务端一致）
+func ringHash(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	hash := h.Sum32()
+	hash ^= hash >> 16
+	hash *= 0x85ebca6b
+	hash ^= hash >> 13
+	hash *= 0xc2b2ae35
+	hash ^= hash >> 16
+	return hash
+}
+
+// Owner 返回负责 resourceID 的成员
+func (r *HashRing) Owner(resourceID string) RingMember {
+	hash := ringHash(resourceID)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.Members[r.points[i].member]
+}
+
+// EnableClusterRouting 从任意成员（默认 ServerURL）获取哈希环，之后按资源直接请求 owner
+func (c *LockClient) EnableClusterRouting(ctx context.Context) error {
+	ring, err := c.fetchRing(ctx, c.currentServer())
+	if err != nil {
+		return err
+	}
+	c.ringMu.Lock()
+	c.ring = ring
+	c.ringMu.Unlock()
+	return nil
+}
+
+// Ring 当前使用的环（未启用集群路由时返回nil）
+func (c *LockClient) Ring() *HashRing {
+	c.ringMu.RLock()
+	defer c.ringMu.RUnlock()
+	return c.ring
+}
+
+// serverFor 负责 resourceID 的服务端地址（未启用集群路由时为 ServerURL）
+func (c *LockClient) serverFor(resourceID string) string {
+	c.ringMu.RLock()
+	ring, stale := c.ring, c.ringStale
+	c.ringMu.RUnlock()
+	if ring == nil {
+		return c.currentServer()
+	}
+	if stale {
+		c.refreshRingAsync()
+	}
+	return ring.Owner(resourceID).URL
+}
+
+// servers 所有服务端地址（未启用集群路由时只有 ServerURL）
+func (c *LockClient) servers() []string {
+	ring := c.Ring()
+	if ring == nil {
+		return []string{c.currentServer()}
+	}
+	urls := make([]string, 0, len(ring.Members))
+	for _, member := range ring.Members {
+		urls = append(urls, member.URL)
+	}
+	return urls
+}
+
+// followRedirect 跟随服务端的重定向（集群模式下环已变更，或复制模式下转发到leader），并标记环需要重新获取
+func (c *LockClient) followRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("重定向次数过多")
+	}
+	c.ringMu.Lock()
+	c.ringStale = c.ring != nil
+	c.ringMu.Unlock()
+	return nil
+}
+
+// refreshRingAsync 在后台重新获取环（同一时刻只有一个）
+func (c *LockClient) refreshRingAsync() {
+	c.ringMu.Lock()
+	if c.ringRefreshing {
+		c.ringMu.Unlock()
+		return
+	}
+	c.ringRefreshing = true
+	c.ringStale = false
+	c.ringMu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.RequestTimeout)
+		defer cancel()
+		defer func() {
+			c.ringMu.Lock()
+			c.ringRefreshing = false
+			c.ringMu.Unlock()
+		}()
+
+		// 依次尝试环上的成员（旧环上的成员可能已经被移除）
+		for _, server := range c.servers() {
+			ring, err := c.fetchRing(ctx, server)
+			if err != nil {
+				continue
+			}
+			c.ringMu.Lock()
+			if c.ring == nil || ring.Version > c.ring.Version {
+				c.ring = ring
+			}
+			c.ringMu.Unlock()
+			return
+		}
+		log.Printf("[ClusterRouting] 重新获取环失败，继续使用版本 %d", c.Ring().Version)
+	}()
+}
+
+// fetchRing 从 server 获取环
+func (c *LockClient) fetchRing(ctx context.Context, server string) (*HashRing, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", server+"/cluster/ring", nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	resp, err := c.ShortClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("服务器返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	var ring HashRing
+	if err := json.Unmarshal(body, &ring); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+	if err := ring.build(); err != nil {
+		return nil, err
+	}
+	return &ring, nil
+}
@@ -0,0 +1,239 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SemaphorePermit 持有的信号量许可：持有期间后台自动续约，使用完毕后必须调用 Release
+type SemaphorePermit struct {
+	Name      string        // 信号量名称
+	SessionID string        // 持有许可的会话
+	Permits   int           // 持有的许可数量
+	LeaseTTL  time.Duration // 许可租约时长（0表示服务端未启用租约）
+
+	client        *LockClient
+	stopKeepAlive func()
+	once          sync.Once
+}
+
+// AcquireSemaphore 获取信号量 name 的 permits 个许可（permits <= 0 时为1），许可不足时阻塞等待
+// 等待期间订阅 /lock/subscribe?type=semaphore 的 permits_granted 事件，并每秒重新请求一次（获取是幂等的）
+// ctx 取消时放弃等待（如果许可恰好已经分配，会立即释放）
+func (c *LockClient) AcquireSemaphore(ctx context.Context, name string, permits int) (*SemaphorePermit, error) {
+	if permits <= 0 {
+		permits = 1
+	}
+	request := &SemaphoreRequest{Name: name, NodeID: c.NodeID, Permits: permits}
+
+	var events chan *OperationEvent
+	var ticker *time.Ticker
+	for {
+		semResp, err := c.acquireSemaphoreOnce(ctx, request)
+		if err != nil {
+			if ctx.Err() == nil && c.shouldRetry(err) {
+				// 网络错误：稍后用同一会话重新请求
+				log.Printf("[AcquireSemaphore] 请求失败，稍后重试: name=%s, error=%v", name, err)
+			} else {
+				if request.SessionID != "" {
+					c.releaseSemaphoreDetached(request)
+				}
+				return nil, err
+			}
+		} else {
+			request.SessionID = semResp.SessionID
+			if semResp.Acquired {
+				return c.newSemaphorePermit(request, time.Duration(semResp.LeaseTTLMs)*time.Millisecond), nil
+			}
+		}
+
+		if events == nil && request.SessionID != "" {
+			subCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			events = make(chan *OperationEvent, 16)
+			go c.subscribeEvents(subCtx, SemaphoreType, name, events)
+			ticker = time.NewTicker(1 * time.Second)
+			defer ticker.Stop()
+		}
+
+		var tick <-chan time.Time
+		if ticker != nil {
+			tick = ticker.C
+		} else {
+			tick = time.After(c.RetryInterval)
+		}
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				c.releaseSemaphoreDetached(request)
+				return nil, ctx.Err()
+			case <-tick:
+				break wait
+			case event := <-events:
+				if event.Event == EventTypePermitsGranted && event.SessionID == request.SessionID {
+					break wait
+				}
+			}
+		}
+	}
+}
+
+// acquireSemaphoreOnce 发送一次获取许可请求
+func (c *LockClient) acquireSemaphoreOnce(ctx context.Context, request *SemaphoreRequest) (*SemaphoreResponse, error) {
+	var semResp SemaphoreResponse
+	status, err := c.postSemaphore(ctx, "/semaphore/acquire", request, &semResp)
+	if err != nil {
+		return nil, err
+	}
+	if semResp.Error != "" {
+		return nil, fmt.Errorf("获取许可失败: %s", semResp.Error)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("服务器返回错误状态码: %d", status)
+	}
+	return &semResp, nil
+}
+
+// newSemaphorePermit 获得许可后启动后台续约
+func (c *LockClient) newSemaphorePermit(request *SemaphoreRequest, leaseTTL time.Duration) *SemaphorePermit {
+	permit := &SemaphorePermit{
+		Name:          request.Name,
+		SessionID:     request.SessionID,
+		Permits:       request.Permits,
+		LeaseTTL:      leaseTTL,
+		client:        c,
+		stopKeepAlive: func() {},
+	}
+	if interval := KeepAliveInterval(leaseTTL); interval > 0 {
+		permit.startKeepAlive(interval)
+	}
+	return permit
+}
+
+// startKeepAlive 启动后台续约协程，Release 时停止
+func (p *SemaphorePermit) startKeepAlive(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := p.KeepAlive(ctx); err != nil && ctx.Err() == nil {
+					// 单次续约失败不退出：网络抖动时下一次心跳仍可能成功
+					log.Printf("[KeepAlive] 许可续约失败: name=%s, error=%v", p.Name, err)
+				}
+			}
+		}
+	}()
+
+	p.stopKeepAlive = func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// KeepAlive 续约许可（单次请求，通常由后台协程调用）
+// 返回错误表示续约失败：许可可能已因租约过期被回收
+func (p *SemaphorePermit) KeepAlive(ctx context.Context) error {
+	var keepAliveResp KeepAliveResponse
+	request := &SemaphoreRequest{Name: p.Name, NodeID: p.client.NodeID, SessionID: p.SessionID}
+	if _, err := p.client.postSemaphore(ctx, "/semaphore/keepalive", request, &keepAliveResp); err != nil {
+		return err
+	}
+	if !keepAliveResp.Renewed {
+		return fmt.Errorf("续约失败: %s", keepAliveResp.Message)
+	}
+	return nil
+}
+
+// Release 停止续约并释放许可（可重复调用，只有第一次发送请求）
+func (p *SemaphorePermit) Release(ctx context.Context) error {
+	var err error
+	p.once.Do(func() {
+		p.stopKeepAlive()
+		err = p.client.releaseSemaphore(ctx, &SemaphoreRequest{Name: p.Name, NodeID: p.client.NodeID, SessionID: p.SessionID})
+	})
+	return err
+}
+
+// WithSemaphore 获取 permits 个许可后执行 fn，返回前释放许可
+func (c *LockClient) WithSemaphore(ctx context.Context, name string, permits int, fn func() error) error {
+	permit, err := c.AcquireSemaphore(ctx, name, permits)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		releaseCtx, cancel := context.WithTimeout(context.Background(), c.RequestTimeout)
+		defer cancel()
+		if err := permit.Release(releaseCtx); err != nil {
+			log.Printf("[WithSemaphore] 释放许可失败: name=%s, error=%v", name, err)
+		}
+	}()
+	return fn()
+}
+
+// releaseSemaphore 释放许可或放弃等待（幂等）
+func (c *LockClient) releaseSemaphore(ctx context.Context, request *SemaphoreRequest) error {
+	var releaseResp SemaphoreReleaseResponse
+	status, err := c.postSemaphore(ctx, "/semaphore/release", request, &releaseResp)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("服务器返回错误状态码: %d, 响应: %s", status, releaseResp.Message)
+	}
+	return nil
+}
+
+// releaseSemaphoreDetached 放弃等待后通知服务端（调用方的 ctx 可能已经取消，使用独立的超时）
+func (c *LockClient) releaseSemaphoreDetached(request *SemaphoreRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.RequestTimeout)
+	defer cancel()
+	if err := c.releaseSemaphore(ctx, request); err != nil {
+		log.Printf("[ReleaseSemaphore] 放弃等待失败: name=%s, error=%v", request.Name, err)
+	}
+}
+
+// postSemaphore 发送信号量请求并解析JSON响应，返回状态码
+func (c *LockClient) postSemaphore(ctx context.Context, path string, request *SemaphoreRequest, out interface{}) (int, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return 0, fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.serverFor(request.Name)+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.ShortClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("读取响应失败: %w", err)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return resp.StatusCode, fmt.Errorf("服务器返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestAcquireSemaphoreWaitsForGrant 测试许可不足时排队，收到本会话的 permits_granted 事件后重新请求并获得许可，Release 释放
+func TestAcquireSemaphoreWaitsForGrant(t *testing.T) {
+	var mu sync.Mutex
+	granted := false
+	released := ""
+	grant := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/semaphore/acquire":
+			var body SemaphoreRequest
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.Name != "registry" || body.NodeID != "test-node" || body.Permits != 2 {
+				t.Errorf("获取请求不正确: %+v", body)
+			}
+			if body.SessionID == "" {
+				body.SessionID = "session-1"
+			}
+			mu.Lock()
+			acquired := granted
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"acquired": acquired, "session_id": body.SessionID, "permits": 2, "capacity": 2})
+		case "/lock/subscribe":
+			if r.URL.Query().Get("type") != SemaphoreType || r.URL.Query().Get("resource_id") != "registry" {
+				t.Errorf("订阅参数不正确: %s", r.URL.RawQuery)
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.(http.Flusher).Flush()
+			select {
+			case <-grant:
+			case <-r.Context().Done():
+				return
+			}
+			mu.Lock()
+			granted = true
+			mu.Unlock()
+			// 其他会话的事件应被忽略
+			fmt.Fprintf(w, "data: {\"event\":\"permits_granted\",\"type\":\"semaphore\",\"resource_id\":\"registry\",\"session_id\":\"other\"}\n\n")
+			fmt.Fprintf(w, "data: {\"event\":\"permits_granted\",\"type\":\"semaphore\",\"resource_id\":\"registry\",\"session_id\":\"session-1\",\"permits\":2}\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case "/semaphore/release":
+			var body SemaphoreRequest
+			json.NewDecoder(r.Body).Decode(&body)
+			mu.Lock()
+			released = body.SessionID
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"released":true,"dequeued":false,"message":"已释放许可"}`))
+		default:
+			t.Errorf("意外的请求: %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := NewLockClient(server.URL, "test-node")
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(grant)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	permit, err := client.AcquireSemaphore(ctx, "registry", 2)
+	if err != nil {
+		t.Fatalf("获取许可失败: %v", err)
+	}
+	if permit.SessionID != "session-1" || permit.Permits != 2 {
+		t.Errorf("许可信息不正确: %+v", permit)
+	}
+
+	if err := permit.Release(ctx); err != nil {
+		t.Fatalf("释放许可失败: %v", err)
+	}
+	permit.Release(ctx)
+	mu.Lock()
+	defer mu.Unlock()
+	if released != "session-1" {
+		t.Errorf("应释放本会话的许可，实际 %q", released)
+	}
+}
+
+// TestAcquireSemaphoreCancel 测试 ctx 取消时放弃等待并通知服务端
+func TestAcquireSemaphoreCancel(t *testing.T) {
+	dequeued := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/semaphore/acquire":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"acquired":false,"session_id":"session-2","permits":1,"capacity":1,"queue_position":0}`))
+		case "/lock/subscribe":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case "/semaphore/release":
+			var body SemaphoreRequest
+			json.NewDecoder(r.Body).Decode(&body)
+			dequeued <- body.SessionID
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"released":false,"dequeued":true,"message":"已放弃等待"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewLockClient(server.URL, "test-node")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := client.AcquireSemaphore(ctx, "global", 1); err == nil {
+		t.Fatal("ctx 超时后应返回错误")
+	}
+	select {
+	case session := <-dequeued:
+		if session != "session-2" {
+			t.Errorf("应放弃本会话的等待，实际 %q", session)
+		}
+	default:
+		t.Error("放弃等待时应通知服务端")
+	}
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Status 查询 request 对应会话在服务端的状态：是否持有锁、排队位置、等待的操作是否已完成
+// 只读查询，不会加入队列或分配锁。无法使用 SSE 订阅时可以周期调用它代替等待事件：
+// Acquired 为 true 时可以开始操作，Completed && Success 时跳过操作
+func (c *LockClient) Status(ctx context.Context, request *Request) (*StatusResponse, error) {
+	jsonData, err := json.Marshal(&Request{
+		Type:       request.Type,
+		ResourceID: request.ResourceID,
+		NodeID:     c.NodeID,
+		SessionID:  request.SessionID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.serverFor(request.ResourceID)+"/lock/status", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.ShortClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("服务器返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	var statusResp StatusResponse
+	if err := json.Unmarshal(body, &statusResp); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+	return &statusResp, nil
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestStatus 测试状态查询携带本节点ID和会话ID，并解析排队位置
+func TestStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/lock/status" || r.Method != "POST" {
+			t.Errorf("期望 POST /lock/status，实际 %s %s", r.Method, r.URL.Path)
+		}
+		var body Request
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.NodeID != "test-node" || body.SessionID != "session-1" {
+			t.Errorf("请求应携带节点ID和会话ID: %+v", body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"acquired":false,"completed":false,"success":false,"queued":true,"queue_position":2,"queue_length":3,"holder":"node-0"}`))
+	}))
+	defer server.Close()
+
+	client := NewLockClient(server.URL, "test-node")
+	status, err := client.Status(context.Background(), &Request{Type: "pull", ResourceID: "sha256:status", SessionID: "session-1"})
+	if err != nil {
+		t.Fatalf("查询状态失败: %v", err)
+	}
+	if status.Acquired || !status.Queued || status.QueuePosition != 2 || status.QueueLength != 3 || status.Holder != "node-0" {
+		t.Errorf("状态解析不正确: %+v", status)
+	}
+}
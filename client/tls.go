@@ -0,0 +1,50 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// LoadClientTLSConfig 读取访问 https:// 服务端的 TLS 配置
+// caFile 为校验服务端证书的 CA（为空时使用系统 CA）；certFile/keyFile 为本节点的客户端证书（服务端启用 mTLS 时必须提供），
+// 服务端要求请求中的 node_id 与证书的 CommonName 或 SAN 一致
+func LoadClientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 CA 证书失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s 中没有有效的 PEM 证书", caFile)
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端证书失败: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// SetTLSConfig 设置 ShortClient 和 LongClient 访问 https:// 服务端使用的 TLS 配置（unix:// 地址不使用 TLS）
+// 应在发出请求之前调用
+func (c *LockClient) SetTLSConfig(config *tls.Config) {
+	transport := newTransport()
+	transport.TLSClientConfig = config
+	c.ShortClient.Transport = tracedTransport(transport)
+	c.LongClient.Transport = c.ShortClient.Transport
+}
+
+// NewLockClientWithTLS 创建使用 TLS（服务端启用 mTLS 时出示 config 中的客户端证书）的锁客户端
+func NewLockClientWithTLS(serverURL, nodeID string, config *tls.Config) *LockClient {
+	c := NewLockClient(serverURL, nodeID)
+	c.SetTLSConfig(config)
+	return c
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// issueTestCertificate 签发证书（parent 为 nil 时生成自签名 CA）
+func issueTestCertificate(t *testing.T, commonName string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("签发证书失败: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// TestMutualTLS 测试 ShortClient 和 LongClient（SSE 订阅）都出示客户端证书
+func TestMutualTLS(t *testing.T) {
+	ca := issueTestCertificate(t, "test-ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	var mu sync.Mutex
+	seen := make(map[string]string) // 请求路径 -> 客户端证书的 CommonName
+	lockCalls := 0
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Path] = r.TLS.PeerCertificates[0].Subject.CommonName
+		if r.URL.Path == "/lock" {
+			lockCalls++
+		}
+		attempt := lockCalls
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/lock":
+			if attempt == 1 {
+				w.Write([]byte(`{"acquired":false,"session_id":"s-1"}`))
+				return
+			}
+			w.Write([]byte(`{"acquired":true,"session_id":"s-1","fencing_token":5}`))
+		case "/lock/subscribe":
+			w.Header().Set("Content-Type", "text/event-stream")
+			data, _ := json.Marshal(OperationEvent{Event: EventTypeLockAssigned, Type: "pull", ResourceID: "sha256:tls", NodeID: "node-1", SessionID: "s-1"})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{issueTestCertificate(t, "lock-server", &ca)},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	// 没有客户端证书时握手失败
+	plain := NewLockClientWithTLS(server.URL, "node-1", &tls.Config{RootCAs: pool})
+	plain.MaxRetries = 0
+	if _, err := plain.Lock(context.Background(), &Request{Type: "pull", ResourceID: "sha256:tls"}); err == nil {
+		t.Fatal("没有客户端证书时应失败")
+	}
+
+	client := NewLockClientWithTLS(server.URL, "node-1", &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{issueTestCertificate(t, "node-1", &ca)}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := client.Lock(ctx, &Request{Type: "pull", ResourceID: "sha256:tls"})
+	if err != nil || !result.Acquired || result.FencingToken != 5 {
+		t.Fatalf("应通过 mTLS 获得锁: result=%+v, err=%v", result, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if seen["/lock"] != "node-1" || seen["/lock/subscribe"] != "node-1" {
+		t.Errorf("加锁和订阅请求都应出示 node-1 的证书: %v", seen)
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+// 分布式追踪
+//
+// Lock、Unlock 各有一个 span，等待锁（SSE 订阅或 gRPC Watch）有一个子 span；每个 HTTP 请求和 gRPC 调用另有客户端 span，
+// 并把追踪上下文写入请求头（traceparent）或 gRPC metadata，服务端在同一个追踪中记录 TryLock、排队等待和 Unlock。
+// 调用方传入的 ctx 带有上游（例如 containerd 调用 content 插件）的追踪时，这些 span 都在上游的追踪之下。
+// 使用全局的 TracerProvider 和传播器，由进程初始化（见 tracing 包）；未初始化时为空操作。
+
+// tracerName 客户端 span 的 instrumentation scope
+const tracerName = "distributed-lock/client"
+
+var tracer = otel.Tracer(tracerName)
+
+// tracedTransport 为 HTTP 请求创建客户端 span 并在请求头中传递追踪上下文
+func tracedTransport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
+
+// tracedDialOption 为 gRPC 调用创建客户端 span 并在 metadata 中传递追踪上下文
+func tracedDialOption() grpc.DialOption {
+	return grpc.WithStatsHandler(otelgrpc.NewClientHandler())
+}
+
+// startSpan 开始一个锁操作的 span
+func startSpan(ctx context.Context, name, nodeID string, request *Request) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(
+		attribute.String("lock.type", request.Type),
+		attribute.String("lock.resource_id", request.ResourceID),
+		attribute.String("lock.node_id", nodeID),
+		attribute.String("lock.mode", request.Mode),
+	))
+}
+
+// startUnlockSpan 开始解锁的 span，记录解锁的会话、fencing token 和操作结果
+func startUnlockSpan(ctx context.Context, name, nodeID string, request *Request) (context.Context, trace.Span) {
+	ctx, span := startSpan(ctx, name, nodeID, request)
+	span.SetAttributes(
+		attribute.String("lock.session_id", request.SessionID),
+		attribute.Int64("lock.fencing_token", int64(request.FencingToken)),
+		attribute.Bool("lock.success", request.Error == ""),
+	)
+	return ctx, span
+}
+
+// endLockSpan 结束加锁（或等待锁）的 span，记录结果
+func endLockSpan(span trace.Span, request *Request, result *LockResult, err error) {
+	span.SetAttributes(attribute.String("lock.session_id", request.SessionID))
+	if result != nil {
+		span.SetAttributes(
+			attribute.Bool("lock.acquired", result.Acquired),
+			attribute.Bool("lock.skipped", result.Skipped),
+		)
+		if result.Acquired {
+			span.SetAttributes(attribute.Int64("lock.fencing_token", int64(result.FencingToken)))
+		}
+		if err == nil && result.Error != nil {
+			err = result.Error
+		}
+	}
+	endSpan(span, err)
+}
+
+// endSpan 结束 span，err 不为 nil 时记录错误
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	testSpansOnce sync.Once
+	testSpans     *tracetest.InMemoryExporter
+)
+
+// recordSpans 把全局 TracerProvider 设置为写入内存的 provider（整个测试进程只设置一次）
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	testSpansOnce.Do(func() {
+		testSpans = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(testSpans)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return testSpans
+}
+
+// TestTraceContextPropagation 测试 Lock/Unlock 的请求头携带调用方的追踪上下文，并在该追踪中记录客户端 span
+func TestTraceContextPropagation(t *testing.T) {
+	exporter := recordSpans(t)
+
+	var mu sync.Mutex
+	traceparents := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents[r.URL.Path] = r.Header.Get("traceparent")
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/lock":
+			w.Write([]byte(`{"acquired":true,"fencing_token":7,"session_id":"s-1","lease_ttl_ms":30000}`))
+		case "/unlock":
+			w.Write([]byte(`{"released":true,"message":"成功释放锁"}`))
+		}
+	}))
+	defer server.Close()
+
+	ctx, root := otel.Tracer("test").Start(context.Background(), "containerd.Write")
+	traceID := root.SpanContext().TraceID()
+
+	client := NewLockClient(server.URL, "node-1")
+	request := &Request{Type: "pull", ResourceID: "sha256:traced"}
+	result, err := ClusterLock(ctx, client, request)
+	if err != nil || !result.Acquired {
+		t.Fatalf("加锁失败: result=%+v, err=%v", result, err)
+	}
+	if err := ClusterUnLock(ctx, client, request); err != nil {
+		t.Fatalf("解锁失败: %v", err)
+	}
+	root.End()
+
+	mu.Lock()
+	for _, path := range []string{"/lock", "/unlock"} {
+		// traceparent: 00-<trace-id>-<span-id>-<flags>
+		if !strings.Contains(traceparents[path], traceID.String()) {
+			t.Errorf("%s 的请求头应携带追踪 %s，实际 traceparent=%q", path, traceID, traceparents[path])
+		}
+	}
+	mu.Unlock()
+
+	spans := make(map[string]tracetest.SpanStub)
+	clientSpans := 0
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID() != traceID {
+			continue
+		}
+		spans[span.Name] = span
+		if span.SpanKind == trace.SpanKindClient {
+			clientSpans++
+		}
+	}
+	for _, name := range []string{"LockClient.Lock", "LockClient.Unlock"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("缺少 span %s", name)
+			continue
+		}
+		if span.Parent.SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("%s 的父 span 应为调用方的 span", name)
+		}
+	}
+	if clientSpans != 2 {
+		t.Errorf("每个 HTTP 请求应有一个客户端 span，期望2个，实际 %d", clientSpans)
+	}
+}
@@ -12,6 +12,12 @@ const (
 	OperationTypeDelete = "delete" // 删除镜像层
 )
 
+// 锁模式常量（与服务端保持一致）
+const (
+	LockModeExclusive = "exclusive" // 独占（默认）
+	LockModeShared    = "shared"    // 共享：多个节点可以同时持有，与独占互斥
+)
+
 // Request 锁请求结构
 // Type + ResourceID 作为仲裁Key作为唯一标识
 type Request struct {
@@ -21,35 +27,285 @@ type Request struct {
 	Error      string `json:"error,omitempty"` // 错误信息（用于解锁时传递，序列化为字符串）
 	// Success 字段已移除，服务端会根据 Error 自动推断：Error == "" → Success = true
 	// contentv2 只需要设置 Error 即可
+
+	// FencingToken 获得锁时由 Lock 自动填写，Unlock/KeepAlive 时携带
+	// 锁被服务端重新分配后旧token失效，解锁会被拒绝
+	FencingToken uint64 `json:"fencing_token,omitempty"`
+
+	// Mode 锁模式：exclusive（默认）或 shared，Upgrade/Downgrade 成功后自动更新
+	Mode string `json:"mode,omitempty"`
+
+	// WaitTimeoutMs 最长排队等待时间（毫秒），服务端超过期限后把请求移出队列
+	// 为0时 Lock 使用 ctx 的截止时间（没有截止时间则一直等待）
+	WaitTimeoutMs int64 `json:"wait_timeout_ms,omitempty"`
+
+	// SessionID 会话ID：第一次 Lock 时由服务端分配并自动写回，之后的请求自动携带
+	// 同一节点上并发加锁时每个 Request 是不同的会话，不要在不同的加锁流程之间复用同一个 Request
+	SessionID string `json:"session_id,omitempty"`
+
+	// Result 可选：解锁时携带的操作结果（例如本地路径），保存在服务端的完成记录中，
+	// 之后跳过操作的节点可以从 LockResult.Completion 读取
+	Result string `json:"result,omitempty"`
+
+	// PeerAddr 可选：解锁时携带本节点的 blob 服务地址（host:port），
+	// 等待同一资源的节点可以从这里拉取结果（LockResult.PeerAddr）而不是重新下载
+	PeerAddr string `json:"peer_addr,omitempty"`
 }
 
 // LockResponse 加锁响应
 type LockResponse struct {
-	Acquired bool   `json:"acquired"` // 是否获得锁
-	Message  string `json:"message"`  // 响应消息
-	Error    string `json:"error"`    // 错误信息（例如delete操作时引用计数不为0）
+	Acquired   bool   `json:"acquired"`               // 是否获得锁
+	Message    string `json:"message"`                // 响应消息
+	Error      string `json:"error"`                  // 错误信息（例如delete操作时引用计数不为0）
+	LeaseTTLMs int64  `json:"lease_ttl_ms,omitempty"` // 锁租约时长（毫秒），0表示服务端未启用租约
+
+	FencingToken uint64 `json:"fencing_token,omitempty"` // 获得锁时的fencing token
+	SessionID    string `json:"session_id,omitempty"`    // 服务端分配的会话ID
+	Code         string `json:"code,omitempty"`          // 错误码（例如 deadlock）
+
+	Skip       bool              `json:"skip"`                 // 操作已由其他节点完成，跳过操作
+	Completion *CompletionRecord `json:"completion,omitempty"` // skip 时返回的完成记录
+}
+
+// CompletionRecord 服务端保存的一次成功完成的操作
+type CompletionRecord struct {
+	Type         string    `json:"type"`
+	ResourceID   string    `json:"resource_id"`
+	NodeID       string    `json:"node_id"`             // 完成操作的节点
+	SessionID    string    `json:"session_id"`          // 完成操作的会话
+	FencingToken uint64    `json:"fencing_token"`       // 完成操作时持有的token
+	CompletedAt  time.Time `json:"completed_at"`        // 完成时间
+	ExpiresAt    time.Time `json:"expires_at"`          // 过期时间
+	Result       string    `json:"result,omitempty"`    // 完成者解锁时携带的结果
+	PeerAddr     string    `json:"peer_addr,omitempty"` // 完成者的 blob 服务地址
+}
+
+// 批量加锁中单个资源的状态
+const (
+	ResourceStatusAcquired  = "acquired"  // 已获得锁
+	ResourceStatusQueued    = "queued"    // 被占用，正在等待
+	ResourceStatusAvailable = "available" // 空闲，但整批未获得，没有持有
+	ResourceStatusCompleted = "completed" // 其他节点已完成该资源的操作，不需要再加锁
+)
+
+// ResourceResult 批量加锁中单个资源的结果
+type ResourceResult struct {
+	Type         string `json:"type"`
+	ResourceID   string `json:"resource_id"`
+	Mode         string `json:"mode"`
+	Status       string `json:"status"`
+	FencingToken uint64 `json:"fencing_token,omitempty"` // acquired 时返回
+}
+
+// BatchLockResponse 批量加锁响应
+type BatchLockResponse struct {
+	Acquired   bool              `json:"acquired"`               // 是否获得全部资源
+	SessionID  string            `json:"session_id"`             // 整批共用的会话ID
+	Results    []*ResourceResult `json:"results"`                // 每个资源的结果（按规范顺序）
+	Message    string            `json:"message"`                // 响应消息
+	Error      string            `json:"error,omitempty"`        // 错误信息
+	Code       string            `json:"code,omitempty"`         // 错误码（例如 deadlock）
+	LeaseTTLMs int64             `json:"lease_ttl_ms,omitempty"` // 锁租约时长（毫秒）
+}
+
+// LockManyResult 批量加锁结果
+type LockManyResult struct {
+	// Acquired 除已被其他节点完成的资源外，其余资源全部获得（全部资源都已完成时为false）
+	Acquired  bool
+	Resources []*ResourceResult // 每个资源的结果：acquired 或 completed（ctx 取消时为最后一次请求的状态）
+	LeaseTTL  time.Duration     // 锁租约时长
+	Error     error             // 错误信息（服务端拒绝时，例如同一批中的操作互相冲突）
 }
 
 // UnlockResponse 解锁响应
 type UnlockResponse struct {
-	Released bool   `json:"released"` // 是否成功释放
+	Released bool   `json:"released"`       // 是否成功释放
+	Message  string `json:"message"`        // 响应消息
+	Code     string `json:"code,omitempty"` // 错误码（例如 forbidden）
+}
+
+// KeepAliveResponse 续约响应
+type KeepAliveResponse struct {
+	Renewed    bool   `json:"renewed"`                // 是否续约成功
+	Message    string `json:"message"`                // 响应消息
+	LeaseTTLMs int64  `json:"lease_ttl_ms,omitempty"` // 续约后的租约时长（毫秒）
+}
+
+// ProgressRequest 进度上报请求
+type ProgressRequest struct {
+	Type         string `json:"type"`
+	ResourceID   string `json:"resource_id"`
+	NodeID       string `json:"node_id"`
+	SessionID    string `json:"session_id,omitempty"`
+	FencingToken uint64 `json:"fencing_token,omitempty"`
+	BytesDone    int64  `json:"bytes_done"`            // 已完成的字节数
+	BytesTotal   int64  `json:"bytes_total,omitempty"` // 总字节数（未知时为0）
+	Phase        string `json:"phase,omitempty"`       // 当前阶段
+}
+
+// ProgressResponse 进度上报响应
+type ProgressResponse struct {
+	Accepted   bool   `json:"accepted"`               // 是否已广播
+	Message    string `json:"message"`                // 响应消息
+	LeaseTTLMs int64  `json:"lease_ttl_ms,omitempty"` // 续约后的租约时长（毫秒）
+}
+
+// SemaphoreType 信号量事件的订阅类型（与服务端保持一致）
+const SemaphoreType = "semaphore"
+
+// SemaphoreRequest 获取/释放/续约信号量许可请求
+type SemaphoreRequest struct {
+	Name      string `json:"name"` // 信号量名称，例如 global、registry:docker.io
+	NodeID    string `json:"node_id"`
+	SessionID string `json:"session_id,omitempty"` // 获取时为空由服务端分配，排队后重新请求时携带
+	Permits   int    `json:"permits,omitempty"`    // 请求的许可数量（默认1）
+}
+
+// SemaphoreResponse 获取许可响应
+type SemaphoreResponse struct {
+	Acquired      bool   `json:"acquired"`                 // 是否已持有许可
+	SessionID     string `json:"session_id"`               // 本次获取的会话
+	Permits       int    `json:"permits"`                  // 请求的许可数量
+	Capacity      int    `json:"capacity"`                 // 信号量容量（<= 0 表示不限制）
+	Available     int    `json:"available"`                // 剩余许可数量（不限制时为-1）
+	QueuePosition int    `json:"queue_position,omitempty"` // 排队位置（从0开始）
+	LeaseTTLMs    int64  `json:"lease_ttl_ms,omitempty"`   // 持有者的租约时长（毫秒）
+	Message       string `json:"message"`                  // 响应消息
+	Error         string `json:"error,omitempty"`          // 错误信息（请求的许可超过容量）
+}
+
+// SemaphoreReleaseResponse 释放许可响应
+type SemaphoreReleaseResponse struct {
+	Released bool   `json:"released"` // 是否释放了许可
+	Dequeued bool   `json:"dequeued"` // 是否放弃了等待
 	Message  string `json:"message"`  // 响应消息
 }
 
+// 节点状态（与服务端保持一致）
+const (
+	NodeStateAlive   = "alive"   // 心跳正常
+	NodeStateSuspect = "suspect" // 心跳超时，尚未判定失效
+	NodeStateDead    = "dead"    // 已判定失效，持有的锁和排队请求已被清理
+)
+
+// NodeInfo 节点成员信息
+type NodeInfo struct {
+	NodeID       string    `json:"node_id"`
+	Address      string    `json:"address,omitempty"`
+	State        string    `json:"state"`
+	RegisteredAt time.Time `json:"registered_at"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// NodeResponse 节点注册/心跳响应
+type NodeResponse struct {
+	Registered    bool      `json:"registered,omitempty"`      // 注册：是否成功
+	Alive         bool      `json:"alive,omitempty"`           // 心跳：是否成功
+	Node          *NodeInfo `json:"node"`                      // 节点当前信息
+	NodeTimeoutMs int64     `json:"node_timeout_ms,omitempty"` // 服务端判定节点失效的时长（毫秒），0表示不检测
+	Message       string    `json:"message,omitempty"`         // 响应消息
+}
+
+// NodeListResponse 节点列表响应
+type NodeListResponse struct {
+	Nodes []*NodeInfo `json:"nodes"`
+	Total int         `json:"total"`
+}
+
+// StatusResponse 状态查询响应（/lock/status）
+type StatusResponse struct {
+	Acquired      bool   `json:"acquired"`                // 是否持有锁（操作尚未完成）
+	Completed     bool   `json:"completed"`               // 等待的操作是否已完成
+	Success       bool   `json:"success"`                 // 操作是否成功（成功时跳过操作）
+	Error         string `json:"error,omitempty"`         // 等待被服务端中止时的错误信息
+	Code          string `json:"code,omitempty"`          // 错误码，例如 deadlock
+	FencingToken  uint64 `json:"fencing_token,omitempty"` // 持有锁时的fencing token
+	Mode          string `json:"mode,omitempty"`          // 持有锁的模式
+	Queued        bool   `json:"queued"`                  // 是否在等待队列中
+	QueuePosition int    `json:"queue_position"`          // 排队位置（从0开始，不在队列中为-1）
+	QueueLength   int    `json:"queue_length"`            // 等待队列长度
+	Holder        string `json:"holder,omitempty"`        // 当前独占持有者的节点ID
+	SharedHolders int    `json:"shared_holders"`          // 共享持有者数量
+
+	Completion *CompletionRecord `json:"completion,omitempty"` // 等待的操作已完成时的完成记录
+}
+
+// CancelWaitResponse 取消等待响应
+type CancelWaitResponse struct {
+	Cancelled bool   `json:"cancelled"` // 是否取消了排队请求（或释放了已分配的锁）
+	Removed   int    `json:"removed"`   // 移出队列的请求数量
+	Released  bool   `json:"released"`  // 锁在取消之前已分配给本会话，已释放给下一个等待者
+	Message   string `json:"message"`   // 响应消息
+}
+
+// ModeChangeResponse 升级/降级响应
+type ModeChangeResponse struct {
+	Upgraded   bool   `json:"upgraded,omitempty"`   // 是否已升级为独占锁
+	Downgraded bool   `json:"downgraded,omitempty"` // 是否已降级为共享锁
+	Pending    bool   `json:"pending,omitempty"`    // 升级正在等待其他共享持有者释放
+	Message    string `json:"message"`              // 响应消息
+	Error      string `json:"error,omitempty"`      // 错误信息
+
+	FencingToken uint64 `json:"fencing_token,omitempty"` // 切换模式后新的fencing token
+}
+
 // LockResult 加锁结果
 type LockResult struct {
-	Acquired bool  // 是否获得锁
-	Error    error // 错误信息
+	Acquired bool          // 是否获得锁
+	LeaseTTL time.Duration // 锁租约时长，持有者需要在到期前续约（0表示服务端未启用租约）
+	Error    error         // 错误信息
+
+	FencingToken uint64 // 获得锁时的fencing token，解锁时必须携带（Lock 会自动写回 Request）
+
+	Skipped    bool              // 操作已由其他节点完成，不需要加锁（Acquired 为 false）
+	Completion *CompletionRecord // Skipped 时服务端返回的完成记录
+
+	// PeerAddr 操作由其他节点完成时（Skipped，或等待期间收到 completed 事件），
+	// 完成者解锁时携带的 blob 服务地址，为空表示完成者没有提供
+	PeerAddr string
 }
 
+// 事件类型常量（与服务端保持一致）
+const (
+	EventTypeCompleted    = "completed"     // 持有者操作成功完成
+	EventTypeLockAssigned = "lock_assigned" // 锁已分配给队头节点
+	EventTypeHolderLost   = "holder_lost"   // 持有者租约过期，视为操作失败
+	EventTypeAborted      = "aborted"       // 等待被服务端中止（Code 说明原因）
+	EventTypeProgress     = "progress"      // 持有者上报的操作进度
+
+	EventTypePermitsGranted  = "permits_granted"  // 信号量：许可已分配给等待的会话
+	EventTypePermitsReleased = "permits_released" // 信号量：持有者释放了许可（或许可被回收）
+)
+
+// 错误码（与服务端保持一致）
+const (
+	ErrorCodeDeadlock  = "deadlock"  // 检测到死锁，等待被中止
+	ErrorCodeForbidden = "forbidden" // 授权策略不允许该操作
+)
+
 // OperationEvent 操作完成事件（与服务端保持一致）
 type OperationEvent struct {
-	Type        string    `json:"type"`         // 操作类型：pull, update, delete
-	ResourceID  string    `json:"resource_id"`  // 资源ID
-	NodeID      string    `json:"node_id"`      // 执行操作的节点ID
-	Success     bool      `json:"success"`      // 操作是否成功
-	Error       string    `json:"error"`        // 错误信息（如果有）
-	CompletedAt time.Time `json:"completed_at"` // 完成时间
+	Event       string    `json:"event,omitempty"` // 事件类型：completed, lock_assigned, holder_lost, aborted, progress, permits_granted, permits_released
+	Type        string    `json:"type"`            // 操作类型：pull, update, delete
+	ResourceID  string    `json:"resource_id"`     // 资源ID
+	NodeID      string    `json:"node_id"`         // 执行操作的节点ID
+	Success     bool      `json:"success"`         // 操作是否成功
+	Error       string    `json:"error"`           // 错误信息（如果有）
+	CompletedAt time.Time `json:"completed_at"`    // 完成时间
+
+	FencingToken uint64 `json:"fencing_token"`        // 事件对应授予的fencing token
+	Mode         string `json:"mode,omitempty"`       // lock_assigned：分配的锁模式
+	SessionID    string `json:"session_id,omitempty"` // lock_assigned/holder_lost/aborted：对应的会话
+	Code         string `json:"code,omitempty"`       // aborted：中止原因
+
+	BytesDone  int64  `json:"bytes_done,omitempty"`  // progress：已完成的字节数
+	BytesTotal int64  `json:"bytes_total,omitempty"` // progress：总字节数（未知时为0）
+	Phase      string `json:"phase,omitempty"`       // progress：当前阶段，例如 downloading, extracting
+
+	PeerAddr string `json:"peer_addr,omitempty"` // completed：持有者的 blob 服务地址
+
+	Permits   int `json:"permits,omitempty"`   // permits_granted/permits_released：分配或释放的许可数量
+	Available int `json:"available,omitempty"` // permits_granted/permits_released：剩余许可数量（不限制时为-1）
 }
 
 // ClusterLock 获取分布式锁
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Unix socket 传输
+//
+// 服务端地址可以是 unix:///run/distributed-lock.sock（服务端设置 LOCK_SOCKET 或通过 socket 激活监听），
+// 请求路径直接拼在 socket 路径之后（unix:///run/distributed-lock.sock/lock），短连接请求和 SSE 订阅都经过该 socket。
+// 请求时沿路径逐级查找第一个 socket 文件，它之前的部分为 socket 路径，之后的部分为请求路径。
+
+// unixScheme unix socket 服务端地址的协议
+const unixScheme = "unix"
+
+// newTransport 创建支持 unix:// 地址的 HTTP 传输
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.RegisterProtocol(unixScheme, &unixRoundTripper{})
+	return transport
+}
+
+// unixRoundTripper 把 unix:// 请求转发到对应 socket 的 HTTP 传输（每个 socket 一个，各自复用连接）
+type unixRoundTripper struct {
+	transports sync.Map // socket 路径 -> *http.Transport
+}
+
+func (u *unixRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	socketPath, requestPath, err := splitUnixURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, ok := u.transports.Load(socketPath)
+	if !ok {
+		dialer := &net.Dialer{}
+		transport, _ = u.transports.LoadOrStore(socketPath, &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+			MaxIdleConns:    16,
+			IdleConnTimeout: http.DefaultTransport.(*http.Transport).IdleConnTimeout,
+		})
+	}
+
+	// 改写为 http://unix/<请求路径>，连接由上面的 DialContext 建立
+	forward := req.Clone(req.Context())
+	forward.URL = &url.URL{Scheme: "http", Host: unixScheme, Path: requestPath, RawQuery: req.URL.RawQuery}
+	forward.Host = unixScheme
+	return transport.(*http.Transport).RoundTrip(forward)
+}
+
+// splitUnixURL 把 unix:///run/lock.sock/lock 拆分为 socket 路径和请求路径
+// 找不到 socket 文件时返回包装 ENOENT 的错误（服务端未启动或正在重启，可以重试和故障转移）
+func splitUnixURL(u *url.URL) (socketPath, requestPath string, err error) {
+	if u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+		return "", "", fmt.Errorf("无效的 unix socket 地址 %s（应为 unix:///绝对路径）", u.String())
+	}
+	path := u.Path
+	for end := 1; end <= len(path); end++ {
+		if end < len(path) && path[end] != '/' {
+			continue
+		}
+		if info, statErr := os.Stat(path[:end]); statErr == nil && info.Mode()&os.ModeSocket != 0 {
+			requestPath = path[end:]
+			if requestPath == "" {
+				requestPath = "/"
+			}
+			return path[:end], requestPath, nil
+		}
+	}
+	return "", "", &net.OpError{Op: "dial", Net: unixScheme, Addr: &net.UnixAddr{Name: path, Net: unixScheme}, Err: syscall.ENOENT}
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestUnixSocketTransport 测试 unix:// 地址：短连接请求和 SSE 订阅都经过 socket，请求路径拼在 socket 路径之后
+func TestUnixSocketTransport(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "run", "lock.sock")
+	var mu sync.Mutex
+	lockCalls := 0
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/lock":
+			mu.Lock()
+			lockCalls++
+			attempt := lockCalls
+			mu.Unlock()
+			if attempt == 1 {
+				w.Write([]byte(`{"acquired":false,"session_id":"s-1"}`))
+				return
+			}
+			w.Write([]byte(`{"acquired":true,"session_id":"s-1","fencing_token":4}`))
+		case "/lock/subscribe":
+			if r.URL.Query().Get("resource_id") != "sha256:unix" {
+				t.Errorf("订阅参数不正确: %s", r.URL.RawQuery)
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			data, _ := json.Marshal(OperationEvent{Event: EventTypeLockAssigned, Type: "pull", ResourceID: "sha256:unix", NodeID: "test-node", SessionID: "s-1"})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			t.Errorf("未预期的请求路径: %s", r.URL.Path)
+		}
+	}))
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0755); err != nil {
+		t.Fatalf("创建目录失败: %v", err)
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	client := NewLockClient("unix://"+socketPath, "test-node")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := client.Lock(ctx, &Request{Type: "pull", ResourceID: "sha256:unix"})
+	if err != nil || !result.Acquired || result.FencingToken != 4 {
+		t.Fatalf("应通过 unix socket 获得锁: result=%+v, err=%v", result, err)
+	}
+}
+
+// TestUnixSocketMissing 测试 socket 不存在时返回可重试的错误（服务端未启动或正在重启）
+func TestUnixSocketMissing(t *testing.T) {
+	client := NewLockClient("unix://"+filepath.Join(t.TempDir(), "missing.sock"), "test-node")
+	client.MaxRetries = 0
+	_, err := client.Lock(context.Background(), &Request{Type: "pull", ResourceID: "sha256:unix"})
+	if err == nil || !client.shouldRetry(err) {
+		t.Errorf("socket 不存在时应返回可重试的错误，实际 %v", err)
+	}
+}
@@ -54,6 +54,10 @@ func (c *LockClient) Lock(ctx context.Context, request *Request) (*LockResult, e
 
 		result, err := c.tryLockOnce(ctx, request)
 		if err == nil {
+			if result.Acquired {
+				// 记录fencing token，后续 Unlock 使用同一个 request 时自动携带
+				request.FencingToken = result.FencingToken
+			}
 			return result, nil
 		}
 
@@ -117,6 +121,10 @@ func (c *LockClient) tryLockOnce(ctx context.Context, request *Request) (*LockRe
 	if err := json.Unmarshal(body, &lockResp); err != nil {
 		return nil, fmt.Errorf("解析响应失败: %w", err)
 	}
+	// 记录会话ID：排队后重新请求时携带，服务端识别为同一会话，不会重复排队
+	if lockResp.SessionID != "" {
+		request.SessionID = lockResp.SessionID
+	}
 
 	// 检查是否有错误（例如delete操作时引用计数不为0）
 	if lockResp.Error != "" {
@@ -138,8 +146,9 @@ func (c *LockClient) tryLockOnce(ctx context.Context, request *Request) (*LockRe
 	// 如果获得锁，直接返回
 	if lockResp.Acquired {
 		return &LockResult{
-			Acquired: true,
-			Skipped:  false,
+			Acquired:     true,
+			Skipped:      false,
+			FencingToken: lockResp.FencingToken,
 		}, nil
 	}
 
@@ -187,6 +196,8 @@ func (c *LockClient) waitForLock(ctx context.Context, request *Request) (*LockRe
 				Completed bool   `json:"completed"` // 操作是否完成
 				Success   bool   `json:"success"`   // 操作是否成功
 				Error     string `json:"error"`     // 错误信息
+
+				FencingToken uint64 `json:"fencing_token,omitempty"` // 持有锁时的fencing token
 			}
 			if err := json.Unmarshal(body, &statusResp); err != nil {
 				continue
@@ -237,8 +248,9 @@ func (c *LockClient) waitForLock(ctx context.Context, request *Request) (*LockRe
 						}
 						if lockResp.Acquired {
 							return &LockResult{
-								Acquired: true,
-								Skipped:  false,
+								Acquired:     true,
+								Skipped:      false,
+								FencingToken: lockResp.FencingToken,
 							}, nil // 获得锁，可以开始操作
 						}
 					}
@@ -248,8 +260,9 @@ func (c *LockClient) waitForLock(ctx context.Context, request *Request) (*LockRe
 			// 如果获得锁，可以开始操作
 			if statusResp.Acquired {
 				return &LockResult{
-					Acquired: true,
-					Skipped:  false,
+					Acquired:     true,
+					Skipped:      false,
+					FencingToken: statusResp.FencingToken,
 				}, nil
 			}
 		}
@@ -17,6 +17,12 @@ type Request struct {
 	NodeID     string `json:"node_id"`         // 发起仲裁的节点唯一标识
 	Error      string `json:"error,omitempty"` // 错误信息（用于解锁时传递，序列化为字符串）
 	// Success 字段已移除，服务端会根据 Error 自动推断：Error == "" → Success = true
+
+	// FencingToken 获得锁时由 Lock 自动填写，解锁时必须携带
+	FencingToken uint64 `json:"fencing_token,omitempty"`
+
+	// SessionID 第一次加锁时由服务端分配，Lock 自动写回；之后的重新请求、状态查询和解锁都携带同一个会话ID
+	SessionID string `json:"session_id,omitempty"`
 }
 
 // LockResponse 加锁响应
@@ -25,6 +31,9 @@ type LockResponse struct {
 	Skip     bool   `json:"skip"`     // 是否需要跳过操作（操作已完成且成功）
 	Message  string `json:"message"`  // 响应消息
 	Error    string `json:"error"`    // 错误信息（例如delete操作时引用计数不为0）
+
+	FencingToken uint64 `json:"fencing_token,omitempty"` // 获得锁时的fencing token
+	SessionID    string `json:"session_id,omitempty"`    // 服务端分配的会话ID
 }
 
 // UnlockResponse 解锁响应
@@ -38,6 +47,8 @@ type LockResult struct {
 	Acquired bool  // 是否获得锁
 	Skipped  bool  // 是否跳过操作（操作已完成且成功）
 	Error    error // 错误信息
+
+	FencingToken uint64 // 获得锁时的fencing token，解锁时必须携带（Lock 会自动写回 Request）
 }
 
 // ClusterLock 获取分布式锁
@@ -18,6 +18,8 @@ type Writer struct {
 	locked     bool   // 是否已获得锁
 	skipped    bool   // 是否跳过了操作（操作已完成且成功）
 
+	fencingToken uint64 // 获得锁时的fencing token，解锁时携带
+
 	refCountManager *lockcallback.RefCountManager
 	storage         RefCountStorage
 }
@@ -98,6 +100,7 @@ func OpenWriter(ctx context.Context, serverURL, nodeID, resourceID string) (*Wri
 		// 获得锁，可以开始操作
 		writer.locked = true
 		writer.skipped = false
+		writer.fencingToken = result.FencingToken
 	} else {
 		// 没有获得锁，也没有跳过（可能是错误情况）
 		if result.Error != nil {
@@ -149,9 +152,10 @@ func (w *Writer) Commit(ctx context.Context, success bool, err error) error {
 
 	// 准备解锁请求
 	request := &lockclient.Request{
-		Type:       w.lockType,
-		ResourceID: w.resourceID,
-		NodeID:     w.nodeID,
+		Type:         w.lockType,
+		ResourceID:   w.resourceID,
+		NodeID:       w.nodeID,
+		FencingToken: w.fencingToken,
 	}
 
 	// 根据 success 和 err 设置 Error 字段
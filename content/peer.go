@@ -0,0 +1,181 @@
+package content
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 节点间直接传输镜像层
+//
+// 持有者完成下载后，等待同一层的节点不必再从镜像仓库下载：每个节点用 BlobServer 把自己的
+// host 存储（containerd local store 目录布局：<root>/blobs/sha256/<hex>）以只读方式提供出来，
+// 持有者解锁时携带 blob 服务地址（client.Request.PeerAddr），等待者从 completed 事件或完成记录
+// 拿到地址后调用 FetchBlob 拉取，边读边校验 digest。
+
+// DefaultBlobPort blob 服务默认端口
+const DefaultBlobPort = 8087
+
+// ErrDigestMismatch 拉取的内容与期望的 digest 不一致
+var ErrDigestMismatch = errors.New("digest 校验失败")
+
+// ParseDigest 解析 "sha256:<hex>" 格式的 digest，返回 hex 部分（目前只支持 sha256）
+func ParseDigest(dgst string) (string, error) {
+	encoded, ok := strings.CutPrefix(dgst, "sha256:")
+	if !ok {
+		return "", fmt.Errorf("不支持的 digest 算法: %q", dgst)
+	}
+	if len(encoded) != sha256.Size*2 {
+		return "", fmt.Errorf("无效的 digest: %q", dgst)
+	}
+	for _, c := range encoded {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return "", fmt.Errorf("无效的 digest: %q", dgst)
+		}
+	}
+	return encoded, nil
+}
+
+// BlobPath 返回 digest 在存储目录 root 下的路径
+func BlobPath(root, dgst string) (string, error) {
+	encoded, err := ParseDigest(dgst)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "blobs", "sha256", encoded), nil
+}
+
+// BlobServer 只读 blob 服务：GET/HEAD /blobs/<digest> 返回存储目录中已提交的镜像层（支持 Range）
+type BlobServer struct {
+	root string // host 存储根目录
+}
+
+// NewBlobServer 创建 blob 服务，root 为 host 存储根目录（只提供已提交的 blob，不提供 ingest 中的数据）
+func NewBlobServer(root string) *BlobServer {
+	return &BlobServer{root: root}
+}
+
+// ServeHTTP 实现 http.Handler
+func (s *BlobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "只支持 GET/HEAD", http.StatusMethodNotAllowed)
+		return
+	}
+	dgst, ok := strings.CutPrefix(r.URL.Path, "/blobs/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	path, err := BlobPath(s.root, dgst)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "读取 blob 失败", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "读取 blob 失败", http.StatusInternalServerError)
+		return
+	}
+
+	// digest 即内容，可以作为强 ETag
+	w.Header().Set("Docker-Content-Digest", dgst)
+	w.Header().Set("ETag", `"`+dgst+`"`)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
+// FetchBlob 从节点 peerAddr（host:port）的 blob 服务拉取 dgst，写入 dst 并校验 digest
+// 返回写入的字节数。校验失败时返回 ErrDigestMismatch，调用方必须丢弃已写入 dst 的数据
+// httpClient 为 nil 时使用 http.DefaultClient（由 ctx 控制超时）
+func FetchBlob(ctx context.Context, httpClient *http.Client, peerAddr, dgst string, dst io.Writer) (int64, error) {
+	encoded, err := ParseDigest(dgst)
+	if err != nil {
+		return 0, err
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+peerAddr+"/blobs/"+dgst, nil)
+	if err != nil {
+		return 0, fmt.Errorf("创建请求失败: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("从节点 %s 拉取失败: %w", peerAddr, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("从节点 %s 拉取失败: 状态码 %d", peerAddr, resp.StatusCode)
+	}
+
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(dst, hasher), resp.Body)
+	if err != nil {
+		return n, fmt.Errorf("从节点 %s 拉取失败: %w", peerAddr, err)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != encoded {
+		return n, fmt.Errorf("%w: 期望 %s, 实际 sha256:%s", ErrDigestMismatch, dgst, actual)
+	}
+	return n, nil
+}
+
+// FetchBlobToStore 从节点拉取 dgst 并提交到存储目录 root（先写入 ingest，校验通过后原子重命名）
+// 本地已存在时直接返回。返回 blob 的路径
+func FetchBlobToStore(ctx context.Context, httpClient *http.Client, peerAddr, root, dgst string) (string, error) {
+	path, err := BlobPath(root, dgst)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	ingestDir := filepath.Join(root, "ingest")
+	if err := os.MkdirAll(ingestDir, 0755); err != nil {
+		return "", fmt.Errorf("创建目录失败 %s: %w", ingestDir, err)
+	}
+	tmp, err := os.CreateTemp(ingestDir, "peer-")
+	if err != nil {
+		return "", fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // 提交成功后已被重命名，删除失败可以忽略
+
+	if _, err := FetchBlob(ctx, httpClient, peerAddr, dgst, tmp); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	// 与 containerd local store 一致：已提交的 blob 只读
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		return "", fmt.Errorf("设置权限失败: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("创建目录失败 %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("提交 blob 失败: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,119 @@
+package content
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeBlob 在存储目录 root 中写入一个已提交的 blob，返回它的 digest
+func writeBlob(t *testing.T, root string, data []byte) string {
+	t.Helper()
+	sum := sha256.Sum256(data)
+	dgst := "sha256:" + hex.EncodeToString(sum[:])
+	path, err := BlobPath(root, dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0444); err != nil {
+		t.Fatal(err)
+	}
+	return dgst
+}
+
+// TestFetchBlobFromPeer 测试从另一个节点的 host 存储拉取镜像层并提交到本地存储
+func TestFetchBlobFromPeer(t *testing.T) {
+	holderRoot, waiterRoot := t.TempDir(), t.TempDir()
+	data := []byte(strings.Repeat("layer-data", 1000))
+	dgst := writeBlob(t, holderRoot, data)
+
+	peer := httptest.NewServer(NewBlobServer(holderRoot))
+	defer peer.Close()
+	peerAddr := strings.TrimPrefix(peer.URL, "http://")
+
+	path, err := FetchBlobToStore(context.Background(), nil, peerAddr, waiterRoot, dgst)
+	if err != nil {
+		t.Fatalf("从节点拉取失败: %v", err)
+	}
+	fetched, err := os.ReadFile(path)
+	if err != nil || string(fetched) != string(data) {
+		t.Fatalf("拉取的内容不一致: err=%v, len=%d", err, len(fetched))
+	}
+	if expected, _ := BlobPath(waiterRoot, dgst); path != expected {
+		t.Errorf("blob 应提交到本地存储 %s，实际 %s", expected, path)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(waiterRoot, "ingest")); len(entries) != 0 {
+		t.Errorf("提交后 ingest 中不应残留临时文件: %d", len(entries))
+	}
+
+	// 节点上没有的 blob
+	missing := writeBlob(t, t.TempDir(), []byte("not-on-peer"))
+	if _, err := FetchBlobToStore(context.Background(), nil, peerAddr, waiterRoot, missing); err == nil {
+		t.Error("节点上不存在的 blob 应拉取失败")
+	}
+}
+
+// TestFetchBlobDigestMismatch 测试节点返回的内容与 digest 不一致时拒绝提交
+func TestFetchBlobDigestMismatch(t *testing.T) {
+	holderRoot, waiterRoot := t.TempDir(), t.TempDir()
+	dgst := writeBlob(t, holderRoot, []byte("original"))
+	path, _ := BlobPath(holderRoot, dgst)
+	os.Chmod(path, 0644)
+	if err := os.WriteFile(path, []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	peer := httptest.NewServer(NewBlobServer(holderRoot))
+	defer peer.Close()
+
+	_, err := FetchBlobToStore(context.Background(), nil, strings.TrimPrefix(peer.URL, "http://"), waiterRoot, dgst)
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("期望 digest 校验失败，实际 %v", err)
+	}
+	if local, _ := BlobPath(waiterRoot, dgst); fileExists(local) {
+		t.Error("校验失败的内容不应提交到本地存储")
+	}
+}
+
+// TestBlobServerRejectsInvalidRequests 测试 blob 服务只接受合法 digest 的 GET/HEAD 请求
+func TestBlobServerRejectsInvalidRequests(t *testing.T) {
+	root := t.TempDir()
+	dgst := writeBlob(t, root, []byte("head-me"))
+	peer := httptest.NewServer(NewBlobServer(root))
+	defer peer.Close()
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodHead, "/blobs/" + dgst, http.StatusOK},
+		{http.MethodGet, "/blobs/sha256:../../etc/passwd", http.StatusBadRequest},
+		{http.MethodGet, "/blobs/md5:abc", http.StatusBadRequest},
+		{http.MethodPut, "/blobs/" + dgst, http.StatusMethodNotAllowed},
+	} {
+		req, _ := http.NewRequest(tc.method, peer.URL+tc.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s: 期望 %d，实际 %d", tc.method, tc.path, tc.status, resp.StatusCode)
+		}
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
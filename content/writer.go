@@ -17,6 +17,14 @@ type Writer struct {
 	locked     bool   // 是否已获得锁
 	skipped    bool   // 是否跳过了操作（操作已完成且成功）
 
+	stopKeepAlive func() // 停止租约续约（持有锁期间后台心跳）
+	fencingToken  uint64 // 获得锁时的fencing token，解锁时携带
+
+	progress *client.ProgressReporter // 把写入的字节数上报给等待同一层的节点
+
+	semaphores []string                  // 下载前需要获取许可的信号量（限制集群范围内的并发下载）
+	permits    []*client.SemaphorePermit // 持有的许可，Commit 时释放
+
 	refCountManager *callback.RefCountManager
 	storage         RefCountStorage
 }
@@ -45,10 +53,18 @@ func NewWriter(serverURL, nodeID, resourceID string) (*Writer, error) {
 // OpenWriter 打开Writer（对应ClusterLock）
 // 在调用此函数时会尝试获取分布式锁
 func OpenWriter(ctx context.Context, serverURL, nodeID, resourceID string) (*Writer, error) {
+	return OpenWriterWithSemaphores(ctx, serverURL, nodeID, resourceID, nil)
+}
+
+// OpenWriterWithSemaphores 打开Writer，获得锁之后再依次从 semaphores 中的每个信号量获取一个许可
+// （例如 []string{"global", "registry:docker.io"}），限制整个集群同时下载的数量。许可在 Commit 时释放
+// 所有节点应按相同的顺序列出信号量，避免相互等待
+func OpenWriterWithSemaphores(ctx context.Context, serverURL, nodeID, resourceID string, semaphores []string) (*Writer, error) {
 	writer, err := NewWriter(serverURL, nodeID, resourceID)
 	if err != nil {
 		return nil, err
 	}
+	writer.semaphores = semaphores
 
 	// 在获取锁之前，先用本地计数判断是否应执行操作
 	skip, errMsg := writer.refCountManager.ShouldSkipOperation(callback.OperationTypePull, writer.resourceID)
@@ -79,6 +95,23 @@ func OpenWriter(ctx context.Context, serverURL, nodeID, resourceID string) (*Wri
 		// 获得锁，可以开始操作
 		writer.locked = true
 		writer.skipped = false
+		writer.fencingToken = result.FencingToken
+		// 持有锁期间定期续约，避免下载耗时超过租约被服务端回收
+		// 只有持有者需要下载，获得锁之后才占用许可（许可不足时等待，等待期间同样需要续约）
+		writer.stopKeepAlive = writer.client.StartKeepAlive(context.Background(), request,
+			client.KeepAliveInterval(result.LeaseTTL))
+		if err := writer.acquirePermits(ctx); err != nil {
+			writer.stopKeepAlive()
+			request.Error = err.Error()
+			client.ClusterUnLock(context.WithoutCancel(ctx), writer.client, request)
+			return nil, fmt.Errorf("获取下载许可失败: %w", err)
+		}
+		writer.progress = writer.client.StartProgressReporter(context.Background(), request, client.DefaultProgressInterval)
+		writer.progress.SetPhase("downloading")
+	} else if result.Skipped {
+		// 其他节点已完成该层（服务端完成记录仍然有效），不需要写入
+		writer.skipped = true
+		writer.locked = false
 	} else {
 		return nil, fmt.Errorf("无法获得锁")
 	}
@@ -86,6 +119,27 @@ func OpenWriter(ctx context.Context, serverURL, nodeID, resourceID string) (*Wri
 	return writer, nil
 }
 
+// acquirePermits 按顺序获取每个信号量的一个许可，失败时释放已获取的许可
+func (w *Writer) acquirePermits(ctx context.Context) error {
+	for _, name := range w.semaphores {
+		permit, err := w.client.AcquireSemaphore(ctx, name, 1)
+		if err != nil {
+			w.releasePermits(context.Background())
+			return fmt.Errorf("信号量 %s: %w", name, err)
+		}
+		w.permits = append(w.permits, permit)
+	}
+	return nil
+}
+
+// releasePermits 按获取的相反顺序释放许可
+func (w *Writer) releasePermits(ctx context.Context) {
+	for i := len(w.permits) - 1; i >= 0; i-- {
+		w.permits[i].Release(ctx)
+	}
+	w.permits = nil
+}
+
 // Write 写入数据
 func (w *Writer) Write(p []byte) (n int, err error) {
 	if w.skipped {
@@ -98,9 +152,19 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 	}
 	// 这里应该实现实际的写入逻辑
 	// 例如写入到本地文件系统或对象存储
+	if w.progress != nil {
+		w.progress.Add(int64(len(p)))
+	}
 	return len(p), nil
 }
 
+// SetExpectedSize 设置镜像层的总大小（例如 descriptor 中的 size），随进度一起上报
+func (w *Writer) SetExpectedSize(size int64) {
+	if w.progress != nil {
+		w.progress.SetTotal(size)
+	}
+}
+
 // Commit 提交操作（记录操作结果）
 func (w *Writer) Commit(ctx context.Context, success bool, err error) error {
 	if w.skipped {
@@ -114,9 +178,10 @@ func (w *Writer) Commit(ctx context.Context, success bool, err error) error {
 
 	// 准备解锁请求
 	request := &client.Request{
-		Type:       w.lockType,
-		ResourceID: w.resourceID,
-		NodeID:     w.nodeID,
+		Type:         w.lockType,
+		ResourceID:   w.resourceID,
+		NodeID:       w.nodeID,
+		FencingToken: w.fencingToken,
 	}
 
 	// 根据 success 和 err 设置 Error 字段
@@ -136,6 +201,15 @@ func (w *Writer) Commit(ctx context.Context, success bool, err error) error {
 		w.refCountManager.UpdateRefCount(callback.OperationTypePull, w.resourceID, result)
 	}
 
+	// 停止进度上报、释放下载许可并停止续约后再释放锁
+	if w.progress != nil {
+		w.progress.Stop()
+	}
+	w.releasePermits(ctx)
+	if w.stopKeepAlive != nil {
+		w.stopKeepAlive()
+	}
+
 	// 释放锁
 	if unlockErr := client.ClusterUnLock(ctx, w.client, request); unlockErr != nil {
 		return fmt.Errorf("释放锁失败: %w", unlockErr)
@@ -3,12 +3,15 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
 
+	lockcontent "conch-content/content"
+
 	"github.com/pelletier/go-toml/v2"
 )
 
@@ -24,8 +27,14 @@ type Config struct {
 	LockServiceURL string              `toml:"lock_service_url"`
 	Nodes          map[string]NodeInfo `toml:"nodes"`
 
+	// BlobPort 节点间直接传输镜像层的 blob 服务端口（所有节点相同，默认 8087）
+	BlobPort int `toml:"blob_port,omitempty"`
+
 	CurrentNode NodeInfo
 	AllNodes    []NodeInfo
+
+	// PeerAddr 当前节点的 blob 服务地址（ip:blob_port），当前节点没有配置 ip 时为空（不提供也不通告）
+	PeerAddr string
 }
 
 // ParseConfig 从指定路径加载配置文件
@@ -71,6 +80,14 @@ func ParseConfig(path string) (*Config, error) {
 	})
 	cfg.AllNodes = allNodes
 
+	// blob 服务地址：持有者解锁时通告给等待者，等待者从这里拉取镜像层
+	if cfg.BlobPort == 0 {
+		cfg.BlobPort = lockcontent.DefaultBlobPort
+	}
+	if cfg.CurrentNode.IP != "" {
+		cfg.PeerAddr = net.JoinHostPort(cfg.CurrentNode.IP, fmt.Sprint(cfg.BlobPort))
+	}
+
 	// 自动生成 socket path（如果未设置）
 	if cfg.SocketPath == "" {
 		cfg.SocketPath = fmt.Sprintf("/run/containerd-content-%s.sock", strings.ToLower(cfg.CurrentNodeID))
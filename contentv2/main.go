@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath" 
 	"syscall" 
 	"os/signal" 
 	"conch-content/client"
+	lockcontent "conch-content/content"
+	"conch-content/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 
@@ -36,6 +41,14 @@ func main() {
 		fmt.Printf("初始化失败: %v\n", err)
 		os.Exit(1)
 	}
+	// 追踪：设置 LOCK_TRACE_EXPORTER 后导出（见 tracing 包）；未设置时仍把 containerd 的追踪上下文传给锁服务端
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.ConfigFromEnv("conch-content"))
+	if err != nil {
+		fmt.Printf("初始化追踪失败: %v\n", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
 	// 分布式锁
 	lockClient := client.NewLockClient(cfg.LockServiceURL, cfg.CurrentNode.ID)
 
@@ -60,11 +73,27 @@ func main() {
 		filepath.Join(cfg.CurrentNode.Root, "host"),
 		filepath.Join(cfg.CurrentNode.Root, "merged"),
 		cfg.CurrentNode.ID,
+		cfg.PeerAddr,
 		lockClient,
 	)
 
-	// 创建 gRPC 服务器并注册 ContentService
-	grpcServer := grpc.NewServer()
+	// 启动 blob 服务：把 host 存储提供给其他节点，持有者完成下载后等待者直接从这里拉取
+	var blobServer *http.Server
+	if cfg.PeerAddr != "" {
+		blobServer = &http.Server{
+			Addr:    cfg.PeerAddr,
+			Handler: lockcontent.NewBlobServer(filepath.Join(cfg.CurrentNode.Root, "host")),
+		}
+		go func() {
+			if err := blobServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Printf("blob 服务异常退出: %v\n", err)
+			}
+		}()
+		fmt.Printf("blob 服务已启动: %s\n", cfg.PeerAddr)
+	}
+
+	// 创建 gRPC 服务器并注册 ContentService（从 containerd 请求的 metadata 继续追踪）
+	grpcServer := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
 	contentService := contentserver.New(store)
 
 	contentapi.RegisterContentServer(grpcServer, contentService)
@@ -86,4 +115,7 @@ func main() {
 	// 优雅关闭
 	fmt.Println(" 正在关闭服务...")
 	grpcServer.GracefulStop()
-}
\ No newline at end of file
+	if blobServer != nil {
+		blobServer.Close()
+	}
+}
\ No newline at end of file
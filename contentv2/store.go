@@ -6,29 +6,39 @@ import (
 	"fmt"
 
 	"conch-content/client"
+	lockcontent "conch-content/content"
 
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/content/local"
 	"github.com/containerd/containerd/errdefs"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer 插件的 span（containerd 的追踪上下文由 gRPC 服务的 otelgrpc 从 metadata 取出，见 main.go）
+var tracer = otel.Tracer("conch-content/contentv2")
+
 // Store implements content.Store with read-write separation:
 // - Reads from 'merged' (shared global view via mergefs)
 // - Writes to 'host' (local temporary storage)
 // - On commit, syncs blob from host → merged to make it globally visible.
+// - Layers completed by another node are fetched from that node's blob server (digest verified), not the registry.
 type Store struct {
 	readStore  content.Store // .../merged
 	writeStore content.Store // .../host
 	hostRoot   string
 	mergedRoot string
 	nodeID     string
+	peerAddr   string // 本节点的 blob 服务地址，解锁时通告给等待者（为空表示不提供）
 	lockClient *client.LockClient
 }
 
 // NewStore creates a coordinated content store.
-func NewStore(hostRoot, mergedRoot, nodeID string, lockClient *client.LockClient) (*Store, error) {
+func NewStore(hostRoot, mergedRoot, nodeID, peerAddr string, lockClient *client.LockClient) (*Store, error) {
 	writeStore, err := local.NewStore(hostRoot)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create host write store: %w", err)
@@ -45,6 +55,7 @@ func NewStore(hostRoot, mergedRoot, nodeID string, lockClient *client.LockClient
 		hostRoot:   hostRoot,
 		mergedRoot: mergedRoot,
 		nodeID:     nodeID,
+		peerAddr:   peerAddr,
 		lockClient: lockClient,
 	}, nil
 }
@@ -58,7 +69,7 @@ func (s *Store) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.
 }
 
 // TODO
-func (s *Store) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+func (s *Store) Writer(ctx context.Context, opts ...content.WriterOpt) (_ content.Writer, err error) {
 	var wOpts content.WriterOpts
 	for _, opt := range opts {
 		if err := opt(&wOpts); err != nil {
@@ -72,10 +83,25 @@ func (s *Store) Writer(ctx context.Context, opts ...content.WriterOpt) (content.
 	}
 
 	resourceID := dgst.String()
+	ctx, span := tracer.Start(ctx, "contentv2.Store.Writer", trace.WithAttributes(
+		attribute.String("content.digest", resourceID),
+		attribute.Int64("content.size", wOpts.Desc.Size),
+		attribute.String("lock.node_id", s.nodeID),
+	))
+	defer func() {
+		// 其他节点已完成（ErrAlreadyExists）不是错误
+		if err != nil && !errdefs.IsAlreadyExists(err) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	req := &client.Request{
 		Type:       client.OperationTypePull,
 		ResourceID: resourceID,
 		NodeID:     s.nodeID,
+		PeerAddr:   s.peerAddr, // 解锁时随 completed 事件通告给等待者
 	}
 
 	fmt.Printf("[writer]digest=%s\n", resourceID)
@@ -85,6 +111,21 @@ func (s *Store) Writer(ctx context.Context, opts ...content.WriterOpt) (content.
 		return nil, fmt.Errorf("distributed lock failed for %s: %w", resourceID, err)
 	}
 
+	// 其他节点已完成该层：优先从完成者的 blob 服务拉取到本地 host 存储，
+	// 返回 ErrAlreadyExists 让 containerd 跳过从镜像仓库下载
+	if !result.Acquired && result.PeerAddr != "" && result.PeerAddr != s.peerAddr {
+		err := s.fetchFromPeer(ctx, result.PeerAddr, wOpts.Desc)
+		if err == nil {
+			return nil, fmt.Errorf("content %s fetched from peer %s: %w", resourceID, result.PeerAddr, errdefs.ErrAlreadyExists)
+		}
+		fmt.Printf("从节点拉取失败，检查共享视图 resourceID=%q, peer=%q: %v\n", resourceID, result.PeerAddr, err)
+	}
+	if result.Skipped || result.PeerAddr != "" {
+		if _, err := s.readStore.Info(ctx, dgst); err == nil {
+			return nil, fmt.Errorf("content %s: %w", resourceID, errdefs.ErrAlreadyExists)
+		}
+	}
+
 	// 检查是否有错误
 	if result.Error != nil {
 		return nil, fmt.Errorf("distributed lock error for %s: %w", resourceID, result.Error)
@@ -100,11 +141,16 @@ func (s *Store) Writer(ctx context.Context, opts ...content.WriterOpt) (content.
 			_ = client.ClusterUnLock(ctx, s.lockClient, req)
 			return nil, err
 		}
+		// 持有锁期间后台续约，避免大镜像层下载超过租约被服务端回收
+		stopKeepAlive := s.lockClient.StartKeepAlive(context.Background(), req,
+			client.KeepAliveInterval(result.LeaseTTL))
 		return &distributedWriter{
-			writer:     w,
-			lockClient: s.lockClient,
-			request:    req,
-			digest:     dgst,
+			writer:        w,
+			lockClient:    s.lockClient,
+			request:       req,
+			digest:        dgst,
+			stopKeepAlive: stopKeepAlive,
+			unlockCtx:     context.WithoutCancel(ctx),
 		}, nil
 	}
 
@@ -112,6 +158,44 @@ func (s *Store) Writer(ctx context.Context, opts ...content.WriterOpt) (content.
 	return nil, fmt.Errorf("unexpected lock result: acquired=%v", result.Acquired)
 }
 
+// fetchFromPeer 从完成者的 blob 服务拉取 desc 到 host 存储
+// 写入 host 存储的 writer，Commit 时 containerd 再次校验大小和 digest，校验失败的数据被丢弃
+func (s *Store) fetchFromPeer(ctx context.Context, peerAddr string, desc ocispec.Descriptor) (err error) {
+	ctx, span := tracer.Start(ctx, "contentv2.Store.fetchFromPeer", trace.WithAttributes(
+		attribute.String("content.digest", desc.Digest.String()),
+		attribute.String("content.peer_addr", peerAddr),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	ref := "peer-" + desc.Digest.String()
+	w, err := s.writeStore.Writer(ctx, content.WithRef(ref), content.WithDescriptor(desc))
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	defer w.Close()
+
+	size, err := lockcontent.FetchBlob(ctx, nil, peerAddr, desc.Digest.String(), w)
+	if err != nil {
+		_ = s.writeStore.Abort(ctx, ref)
+		return err
+	}
+	if err := w.Commit(ctx, size, desc.Digest); err != nil && !errdefs.IsAlreadyExists(err) {
+		_ = s.writeStore.Abort(ctx, ref)
+		return err
+	}
+	fmt.Printf("从节点拉取完成 resourceID=%q, peer=%q, size=%d\n", desc.Digest.String(), peerAddr, size)
+	return nil
+}
+
 func (s *Store) Abort(ctx context.Context, ref string) error {
 	return s.writeStore.Abort(ctx, ref)
 }
@@ -19,6 +19,11 @@ type distributedWriter struct {
 	request    *client.Request
 	digest     digest.Digest
 	err        string
+
+	stopKeepAlive func() // 停止租约续约
+
+	// unlockCtx 打开 writer 时的 context（不随其取消），Close 时的解锁请求与加锁在同一个追踪中
+	unlockCtx context.Context
 }
 
 func (dw *distributedWriter) Write(p []byte) (int, error) {
@@ -33,6 +38,9 @@ func (dw *distributedWriter) Close() error {
 	// unlock in Close()
 	// 注意：如果 Commit() 没有被调用，dw.err 可能是空字符串
 	// 这种情况下应该标记为失败（操作被取消）
+	if dw.stopKeepAlive != nil {
+		dw.stopKeepAlive()
+	}
 	if dw.lockClient != nil && dw.request != nil {
 		if dw.err == "" {
 			// Commit() 没有被调用，可能是异常关闭，标记为失败
@@ -42,7 +50,11 @@ func (dw *distributedWriter) Close() error {
 		}
 		// Success 会在客户端自动根据 Error 推断（Error != "" → Success = false）
 		fmt.Printf("解锁 resourceID=%q, nodeID=%q\n", dw.request.ResourceID, dw.request.NodeID)
-		_ = client.ClusterUnLock(context.Background(), dw.lockClient, dw.request)
+		unlockCtx := dw.unlockCtx
+		if unlockCtx == nil {
+			unlockCtx = context.Background()
+		}
+		_ = client.ClusterUnLock(unlockCtx, dw.lockClient, dw.request)
 	}
 	return closeErr
 }
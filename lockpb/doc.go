@@ -0,0 +1,6 @@
+// Package lockpb 分布式锁服务的 gRPC 接口（protobuf 定义见 lock.proto）
+//
+// 修改 lock.proto 后在仓库根目录重新生成：
+//
+//	protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative lockpb/lock.proto
+package lockpb
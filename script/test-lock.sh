@@ -20,6 +20,7 @@ RESPONSE1=$(curl -s -X POST "$SERVER_URL/lock" \
     \"node_id\": \"NODEA\"
   }")
 echo "响应: $RESPONSE1"
+TOKEN1=$(echo "$RESPONSE1" | grep -o '"fencing_token":[0-9]*' | cut -d: -f2)
 echo ""
 
 # 测试 2: 节点 B 尝试获取同一资源的锁（应该排队或跳过）
@@ -42,6 +43,7 @@ RESPONSE3=$(curl -s -X POST "$SERVER_URL/unlock" \
     \"type\": \"pull\",
     \"resource_id\": \"$RESOURCE_ID\",
     \"node_id\": \"NODEA\",
+    \"fencing_token\": $TOKEN1,
     \"success\": true
   }")
 echo "响应: $RESPONSE3"
@@ -57,6 +59,7 @@ RESPONSE4=$(curl -s -X POST "$SERVER_URL/lock" \
     \"node_id\": \"NODEB\"
   }")
 echo "响应: $RESPONSE4"
+TOKEN4=$(echo "$RESPONSE4" | grep -o '"fencing_token":[0-9]*' | cut -d: -f2)
 echo ""
 
 # 测试 5: 节点 B 释放锁
@@ -67,6 +70,7 @@ RESPONSE5=$(curl -s -X POST "$SERVER_URL/unlock" \
     \"type\": \"pull\",
     \"resource_id\": \"$RESOURCE_ID\",
     \"node_id\": \"NODEB\",
+    \"fencing_token\": $TOKEN4,
     \"success\": true
   }")
 echo "响应: $RESPONSE5"
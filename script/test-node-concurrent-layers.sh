@@ -36,6 +36,7 @@ RESPONSE_A1=$(curl -s -X POST "$SERVER_URL/lock" \
 echo "[节点A] $RESPONSE_A1"
 
 ACQUIRED_A1=$(echo "$RESPONSE_A1" | grep -o '"acquired":true' | wc -l)
+TOKEN_A1=$(echo "$RESPONSE_A1" | grep -o '"fencing_token":[0-9]*' | cut -d: -f2)
 if [ "$ACQUIRED_A1" -eq 0 ]; then
     echo "❌ 错误: 节点A应该获得layer1的锁"
     exit 1
@@ -74,6 +75,7 @@ RESPONSE_B2=$(curl -s -X POST "$SERVER_URL/lock" \
 echo "[节点B] $RESPONSE_B2"
 
 ACQUIRED_B2=$(echo "$RESPONSE_B2" | grep -o '"acquired":true' | wc -l)
+TOKEN_B2=$(echo "$RESPONSE_B2" | grep -o '"fencing_token":[0-9]*' | cut -d: -f2)
 if [ "$ACQUIRED_B2" -eq 0 ]; then
     echo "❌ 错误: 节点B应该能够获得layer2的锁（不同资源，可以并发）"
     exit 1
@@ -89,6 +91,7 @@ RESPONSE_UNLOCK_B2=$(curl -s -X POST "$SERVER_URL/unlock" \
         \"type\": \"pull\",
         \"resource_id\": \"$LAYER2\",
         \"node_id\": \"$NODE_B\",
+        \"fencing_token\": $TOKEN_B2,
         \"success\": true
     }")
 echo "[节点B] $RESPONSE_UNLOCK_B2"
@@ -103,6 +106,7 @@ RESPONSE_UNLOCK_A1=$(curl -s -X POST "$SERVER_URL/unlock" \
         \"type\": \"pull\",
         \"resource_id\": \"$LAYER1\",
         \"node_id\": \"$NODE_A\",
+        \"fencing_token\": $TOKEN_A1,
         \"success\": false
     }")
 echo "[节点A] $RESPONSE_UNLOCK_A1"
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 管理接口（只读）
+//
+// 遍历所有分段，列出持有中的锁、每个key的等待队列、每个key的订阅者数量，以及按节点汇总。
+// 每个分段只在读锁下复制需要的字段，不阻塞加锁/解锁太久；不同分段之间不是同一时刻的快照。
+
+const (
+	// defaultAdminPageLimit 未指定 limit 时每页返回的数量
+	defaultAdminPageLimit = 100
+	// maxAdminPageLimit 每页最多返回的数量
+	maxAdminPageLimit = 1000
+)
+
+// AdminFilter 管理接口的过滤条件（空字段表示不过滤）
+type AdminFilter struct {
+	Type       string // 操作类型，精确匹配
+	ResourceID string // 资源ID前缀，例如 sha256:ab
+	NodeID     string // 节点ID，精确匹配
+	Mode       string // 锁模式：exclusive / shared
+}
+
+// matchKey 判断操作类型和资源ID是否满足过滤条件
+func (f *AdminFilter) matchKey(lockType, resourceID string) bool {
+	return (f.Type == "" || f.Type == lockType) && strings.HasPrefix(resourceID, f.ResourceID)
+}
+
+// match 判断一个请求是否满足过滤条件
+func (f *AdminFilter) match(request *LockRequest) bool {
+	return f.matchKey(request.Type, request.ResourceID) &&
+		(f.NodeID == "" || f.NodeID == request.NodeID) &&
+		(f.Mode == "" || f.Mode == request.Mode)
+}
+
+// AdminLockEntry 一个持有中的锁（独占持有者或一个共享持有者）
+type AdminLockEntry struct {
+	Key            string    `json:"key"`
+	Type           string    `json:"type"`
+	ResourceID     string    `json:"resource_id"`
+	NodeID         string    `json:"node_id"`
+	SessionID      string    `json:"session_id"`
+	Mode           string    `json:"mode"`
+	FencingToken   uint64    `json:"fencing_token"`
+	AcquiredAt     time.Time `json:"acquired_at"`
+	AgeMs          int64     `json:"age_ms"`
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+	Completed      bool      `json:"completed"` // 已完成（成功）的锁保留到资源被再次请求，用于让等待者跳过操作
+	Success        bool      `json:"success"`
+}
+
+// AdminWaiter 等待队列中的一个请求
+type AdminWaiter struct {
+	Position     int       `json:"position"` // 从0开始，0为队头
+	NodeID       string    `json:"node_id"`
+	SessionID    string    `json:"session_id"`
+	Mode         string    `json:"mode"`
+	EnqueuedAt   time.Time `json:"enqueued_at"`
+	WaitedMs     int64     `json:"waited_ms"`
+	WaitDeadline time.Time `json:"wait_deadline,omitempty"`
+}
+
+// AdminQueueEntry 一个key的完整等待队列（按FIFO顺序）
+type AdminQueueEntry struct {
+	Key        string         `json:"key"`
+	Type       string         `json:"type"`
+	ResourceID string         `json:"resource_id"`
+	Length     int            `json:"length"`
+	Waiters    []*AdminWaiter `json:"waiters"`
+}
+
+// AdminSubscriberEntry 一个key的订阅者数量
+type AdminSubscriberEntry struct {
+	Key         string `json:"key"`
+	Type        string `json:"type"`
+	ResourceID  string `json:"resource_id"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// AdminNodeSummary 一个节点的汇总
+type AdminNodeSummary struct {
+	NodeID        string    `json:"node_id"`
+	Held          int       `json:"held"`                // 持有的独占锁数量（未完成）
+	Shared        int       `json:"shared"`              // 持有的共享锁数量
+	Queued        int       `json:"queued"`              // 排队中的请求数量
+	Subscriptions int       `json:"subscriptions"`       // 携带 node_id 的订阅连接数量
+	State         string    `json:"state,omitempty"`     // 成员状态（未注册的节点为空）
+	LastSeen      time.Time `json:"last_seen,omitempty"` // 最后心跳时间（未注册的节点为零值）
+}
+
+// AdminLocks 列出所有持有中的锁（按key、节点排序）
+func (lm *LockManager) AdminLocks(filter *AdminFilter) []*AdminLockEntry {
+	now := lm.now()
+	entries := make([]*AdminLockEntry, 0)
+	for _, shard := range lm.shards {
+		shard.mu.RLock()
+		for key, lockInfo := range shard.locks {
+			if filter.match(lockInfo.Request) {
+				entries = append(entries, newAdminLockEntry(key, lockInfo, now))
+			}
+		}
+		for key, holders := range shard.shared {
+			for _, holder := range holders {
+				if filter.match(holder.Request) {
+					entries = append(entries, newAdminLockEntry(key, holder, now))
+				}
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Key != entries[j].Key {
+			return entries[i].Key < entries[j].Key
+		}
+		return entries[i].FencingToken < entries[j].FencingToken
+	})
+	return entries
+}
+
+// newAdminLockEntry 复制锁信息
+// 注意：调用此函数时，shard.mu 必须已经加锁（读锁或写锁）
+func newAdminLockEntry(key string, lockInfo *LockInfo, now time.Time) *AdminLockEntry {
+	request := lockInfo.Request
+	mode := request.Mode
+	if mode == "" {
+		mode = LockModeExclusive
+	}
+	return &AdminLockEntry{
+		Key:            key,
+		Type:           request.Type,
+		ResourceID:     request.ResourceID,
+		NodeID:         request.NodeID,
+		SessionID:      request.SessionID,
+		Mode:           mode,
+		FencingToken:   lockInfo.FencingToken,
+		AcquiredAt:     lockInfo.AcquiredAt,
+		AgeMs:          now.Sub(lockInfo.AcquiredAt).Milliseconds(),
+		LeaseExpiresAt: lockInfo.LeaseExpiresAt,
+		Completed:      lockInfo.Completed,
+		Success:        lockInfo.Success,
+	}
+}
+
+// AdminQueues 列出每个key的完整等待队列（按key排序）
+// 过滤条件中的 NodeID/Mode 选择包含匹配请求的队列，返回的队列仍然完整（便于查看节点排在第几位）
+func (lm *LockManager) AdminQueues(filter *AdminFilter) []*AdminQueueEntry {
+	now := lm.now()
+	entries := make([]*AdminQueueEntry, 0)
+	for _, shard := range lm.shards {
+		shard.mu.RLock()
+		for key, queue := range shard.queues {
+			if len(queue) == 0 {
+				continue
+			}
+			matched := false
+			for _, request := range queue {
+				if filter.match(request) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+
+			entry := &AdminQueueEntry{
+				Key:        key,
+				Type:       queue[0].Type,
+				ResourceID: queue[0].ResourceID,
+				Length:     len(queue),
+				Waiters:    make([]*AdminWaiter, 0, len(queue)),
+			}
+			for i, request := range queue {
+				entry.Waiters = append(entry.Waiters, &AdminWaiter{
+					Position:     i,
+					NodeID:       request.NodeID,
+					SessionID:    request.SessionID,
+					Mode:         request.Mode,
+					EnqueuedAt:   request.Timestamp,
+					WaitedMs:     now.Sub(request.Timestamp).Milliseconds(),
+					WaitDeadline: request.WaitDeadline,
+				})
+			}
+			entries = append(entries, entry)
+		}
+		shard.mu.RUnlock()
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+// AdminSubscribers 列出每个key的订阅者数量（按key排序，只使用 Type/ResourceID 过滤）
+func (lm *LockManager) AdminSubscribers(filter *AdminFilter) []*AdminSubscriberEntry {
+	entries := make([]*AdminSubscriberEntry, 0)
+	for _, shard := range lm.shards {
+		shard.mu.RLock()
+		for key, subscribers := range shard.subscribers {
+			lockType, resourceID, ok := splitLockKey(key)
+			if !ok || len(subscribers) == 0 || !filter.matchKey(lockType, resourceID) {
+				continue
+			}
+			entries = append(entries, &AdminSubscriberEntry{
+				Key:         key,
+				Type:        lockType,
+				ResourceID:  resourceID,
+				Subscribers: len(subscribers),
+			})
+		}
+		shard.mu.RUnlock()
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+// AdminNodes 按节点汇总持有的锁、排队的请求和订阅连接（包括已注册但没有任何锁的节点，按节点ID排序）
+// 只使用 NodeID 过滤
+func (lm *LockManager) AdminNodes(filter *AdminFilter) []*AdminNodeSummary {
+	summaries := make(map[string]*AdminNodeSummary)
+	summary := func(nodeID string) *AdminNodeSummary {
+		if summaries[nodeID] == nil {
+			summaries[nodeID] = &AdminNodeSummary{NodeID: nodeID}
+		}
+		return summaries[nodeID]
+	}
+
+	for _, shard := range lm.shards {
+		shard.mu.RLock()
+		for _, lockInfo := range shard.locks {
+			if !lockInfo.Completed {
+				summary(lockInfo.Request.NodeID).Held++
+			}
+		}
+		for _, holders := range shard.shared {
+			for _, holder := range holders {
+				summary(holder.Request.NodeID).Shared++
+			}
+		}
+		for _, queue := range shard.queues {
+			for _, request := range queue {
+				summary(request.NodeID).Queued++
+			}
+		}
+		shard.mu.RUnlock()
+	}
+
+	lm.members.mu.Lock()
+	for nodeID, node := range lm.members.nodes {
+		s := summary(nodeID)
+		s.State = node.State
+		s.LastSeen = node.LastSeen
+	}
+	for nodeID, subscriptions := range lm.members.subscriptions {
+		summary(nodeID).Subscriptions = len(subscriptions)
+	}
+	lm.members.mu.Unlock()
+
+	result := make([]*AdminNodeSummary, 0, len(summaries))
+	for nodeID, s := range summaries {
+		if filter.NodeID == "" || filter.NodeID == nodeID {
+			result = append(result, s)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].NodeID < result[j].NodeID })
+	return result
+}
+
+// parseAdminQuery 解析管理接口的过滤和分页参数
+// 返回：过滤条件，offset，limit，错误信息
+func parseAdminQuery(r *http.Request) (*AdminFilter, int, int, string) {
+	query := r.URL.Query()
+	filter := &AdminFilter{
+		Type:       query.Get("type"),
+		ResourceID: query.Get("resource_id"),
+		NodeID:     query.Get("node_id"),
+		Mode:       query.Get("mode"),
+	}
+	if filter.Mode != "" && !validLockMode(filter.Mode) {
+		return nil, 0, 0, "无效的锁模式: " + filter.Mode + "（应为 exclusive 或 shared）"
+	}
+
+	offset, limit := 0, defaultAdminPageLimit
+	if value := query.Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return nil, 0, 0, "无效的 offset: " + value
+		}
+		offset = parsed
+	}
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return nil, 0, 0, "无效的 limit: " + value
+		}
+		limit = parsed
+	}
+	if limit > maxAdminPageLimit {
+		limit = maxAdminPageLimit
+	}
+	return filter, offset, limit, ""
+}
+
+// pageBounds 计算分页的起止下标
+func pageBounds(total, offset, limit int) (int, int) {
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return offset, end
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// getJSON 发送 GET 请求并解析 JSON 响应
+func getJSON(t *testing.T, url string, result interface{}) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatalf("解析响应失败: %v", err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// TestAdminInspection 测试管理接口列出锁、队列、订阅者和节点汇总
+func TestAdminInspection(t *testing.T) {
+	lm := NewLockManager(true)
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:admin-a", NodeID: "node-1"})
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:admin-a", NodeID: "node-2"})
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:admin-a", NodeID: "node-3"})
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:admin-b", NodeID: "node-2", Mode: LockModeShared})
+	lm.TryLock(&LockRequest{Type: OperationTypeUpdate, ResourceID: "sha256:other", NodeID: "node-3"})
+	lm.Subscribe(OperationTypePull, "sha256:admin-a", &mockSubscriber{events: make([]OperationEvent, 0)})
+	lm.Subscribe(OperationTypePull, "sha256:admin-a", &mockSubscriber{events: make([]OperationEvent, 0)})
+
+	locks := lm.AdminLocks(&AdminFilter{ResourceID: "sha256:admin"})
+	if len(locks) != 2 || locks[0].NodeID != "node-1" || locks[1].Mode != LockModeShared {
+		t.Fatalf("期望按资源前缀列出2个锁: %+v", locks)
+	}
+	if locks := lm.AdminLocks(&AdminFilter{NodeID: "node-3"}); len(locks) != 1 || locks[0].Type != OperationTypeUpdate {
+		t.Errorf("按节点过滤失败: %+v", locks)
+	}
+
+	queues := lm.AdminQueues(&AdminFilter{NodeID: "node-3"})
+	if len(queues) != 1 || queues[0].Length != 2 || queues[0].Waiters[0].NodeID != "node-2" || queues[0].Waiters[1].Position != 1 {
+		t.Fatalf("应返回包含 node-3 的完整队列（按FIFO顺序）: %+v", queues)
+	}
+
+	subscribers := lm.AdminSubscribers(&AdminFilter{})
+	if len(subscribers) != 1 || subscribers[0].Subscribers != 2 {
+		t.Errorf("期望 pull:sha256:admin-a 有2个订阅者: %+v", subscribers)
+	}
+
+	nodes := lm.AdminNodes(&AdminFilter{})
+	if len(nodes) != 3 {
+		t.Fatalf("期望3个节点，实际 %d", len(nodes))
+	}
+	if node2 := nodes[1]; node2.NodeID != "node-2" || node2.Shared != 1 || node2.Queued != 1 || node2.Held != 0 {
+		t.Errorf("node-2 汇总不正确: %+v", node2)
+	}
+}
+
+// TestAdminHTTPPagination 测试管理接口的分页和参数校验
+func TestAdminHTTPPagination(t *testing.T) {
+	lm := NewLockManager(true)
+	server := newTestServer(t, lm)
+	for _, resourceID := range []string{"sha256:page-1", "sha256:page-2", "sha256:page-3"} {
+		lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"})
+	}
+
+	var page struct {
+		Locks  []*AdminLockEntry `json:"locks"`
+		Total  int               `json:"total"`
+		Offset int               `json:"offset"`
+		Limit  int               `json:"limit"`
+	}
+	if status := getJSON(t, server.URL+"/admin/locks?offset=1&limit=1", &page); status != http.StatusOK {
+		t.Fatalf("期望 200，实际 %d", status)
+	}
+	if page.Total != 3 || len(page.Locks) != 1 || page.Locks[0].ResourceID != "sha256:page-2" || page.Limit != 1 {
+		t.Errorf("第二页应只包含 sha256:page-2: %+v", page)
+	}
+
+	page.Locks = nil
+	getJSON(t, server.URL+"/admin/locks?offset=10", &page)
+	if page.Total != 3 || len(page.Locks) != 0 {
+		t.Errorf("超出范围的 offset 应返回空页: %+v", page)
+	}
+
+	var nodes struct {
+		Nodes []*AdminNodeSummary `json:"nodes"`
+	}
+	getJSON(t, server.URL+"/admin/nodes?node_id=node-1", &nodes)
+	if len(nodes.Nodes) != 1 || nodes.Nodes[0].Held != 3 {
+		t.Errorf("node-1 应持有3个锁: %+v", nodes.Nodes)
+	}
+
+	for _, query := range []string{"/admin/queues?limit=0", "/admin/subscribers?offset=-1", "/admin/locks?mode=read"} {
+		if status := getJSON(t, server.URL+query, &page); status != http.StatusBadRequest {
+			t.Errorf("%s 期望 400，实际 %d", query, status)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 授权策略
+//
+// 设置 LOCK_AUTHZ_POLICY 后按策略文件限制节点可以操作的类型和资源，例如只有 GC 节点可以获取 delete 锁，
+// 普通节点只能 pull。策略由角色组成，每个角色允许一组操作类型和资源模式；节点按 ID 模式绑定角色，
+// 请求的 (type, resource_id) 被节点的任一角色允许时放行。所有作用于锁和信号量的接口（加锁、解锁、续约、进度、升级/降级、
+// 取消等待、订阅、作废完成记录和信号量）在处理前检查，拒绝时返回 403 和错误码 forbidden（与 mTLS 身份不一致的 403 区分），
+// 每次判定都写审计日志。作废完成记录和修改信号量容量影响所有节点，角色还需要在 actions 中列出这些管理操作。
+// 节点注册和心跳不针对任何资源，但节点失效时它持有的锁会被回收，因此只允许策略中绑定了角色的节点加入成员表。
+// 节点ID未经 mTLS 认证时可以被伪造，授权策略应与 mTLS 一起使用。
+
+// Policy 授权策略文件
+//
+//	{
+//	  "roles": {
+//	    "gc":     {"types": ["delete", "pull"], "resources": ["*"], "actions": ["invalidate"]},
+//	    "puller": {"types": ["pull"], "resources": ["sha256:*"]}
+//	  },
+//	  "nodes": {"gc-*": ["gc"], "*": ["puller"]}
+//	}
+type Policy struct {
+	Roles map[string]PolicyRole `json:"roles"`
+	Nodes map[string][]string   `json:"nodes"` // 节点ID模式 -> 角色，一个节点匹配多个模式时拥有所有角色
+}
+
+// PolicyRole 角色允许的操作类型和资源
+type PolicyRole struct {
+	Types     []string `json:"types"`             // 操作类型（pull、update、delete、semaphore），"*" 表示所有类型
+	Resources []string `json:"resources"`         // 资源ID模式（"*" 匹配任意字符），为空表示所有资源
+	Actions   []string `json:"actions,omitempty"` // 允许的管理操作（invalidate、semaphore_capacity），"*" 表示所有管理操作
+}
+
+// 管理操作：影响所有节点（作废其他节点写入的完成记录、修改集群共享的信号量容量），
+// 角色除了允许类型和资源，还必须在 actions 中列出；其余操作只检查类型和资源
+const (
+	ActionInvalidate        = "invalidate"
+	ActionSemaphoreCapacity = "semaphore_capacity"
+)
+
+func isAdminAction(action string) bool {
+	return action == ActionInvalidate || action == ActionSemaphoreCapacity
+}
+
+// 成员操作：节点注册和心跳，不针对资源，节点拥有任一角色即可
+const (
+	ActionNodeRegister  = "node_register"
+	ActionNodeHeartbeat = "node_heartbeat"
+)
+
+func isMembershipAction(action string) bool {
+	return action == ActionNodeRegister || action == ActionNodeHeartbeat
+}
+
+// LoadPolicy 读取并校验策略文件
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取授权策略失败: %w", err)
+	}
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("解析授权策略失败: %w", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// Validate 检查节点绑定的角色都已定义
+func (p *Policy) Validate() error {
+	for pattern, roles := range p.Nodes {
+		for _, role := range roles {
+			if _, ok := p.Roles[role]; !ok {
+				return fmt.Errorf("授权策略中节点 %s 绑定了未定义的角色 %s", pattern, role)
+			}
+		}
+	}
+	return nil
+}
+
+// Allow 判断节点是否可以操作资源，返回允许该操作的角色（拒绝时为空）
+func (p *Policy) Allow(nodeID, opType, resourceID string) (bool, string) {
+	return p.AllowAction(nodeID, "", opType, resourceID)
+}
+
+// AllowAction 判断节点是否可以对资源执行 action，管理操作还要求角色的 actions 包含该操作，
+// 成员操作只要求节点拥有角色
+func (p *Policy) AllowAction(nodeID, action, opType, resourceID string) (bool, string) {
+	for _, role := range p.rolesOf(nodeID) {
+		if isMembershipAction(action) {
+			return true, role
+		}
+		r := p.Roles[role]
+		if isAdminAction(action) && !r.allowsAction(action) {
+			continue
+		}
+		if r.allows(opType, resourceID) {
+			return true, role
+		}
+	}
+	return false, ""
+}
+
+// rolesOf 节点拥有的角色（按名称排序，审计日志中的角色稳定）
+func (p *Policy) rolesOf(nodeID string) []string {
+	var roles []string
+	for pattern, bound := range p.Nodes {
+		if matchPattern(pattern, nodeID) {
+			roles = append(roles, bound...)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+func (r PolicyRole) allowsAction(action string) bool {
+	for _, allowed := range r.Actions {
+		if allowed == "*" || allowed == action {
+			return true
+		}
+	}
+	return false
+}
+
+func (r PolicyRole) allows(opType, resourceID string) bool {
+	typeAllowed := false
+	for _, allowed := range r.Types {
+		if allowed == "*" || allowed == opType {
+			typeAllowed = true
+			break
+		}
+	}
+	if !typeAllowed {
+		return false
+	}
+	if len(r.Resources) == 0 {
+		return true
+	}
+	for _, pattern := range r.Resources {
+		if matchPattern(pattern, resourceID) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern 简单通配：pattern 中的 "*" 匹配任意（含空）字符串，其余字符精确匹配
+func matchPattern(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		index := strings.Index(value, part)
+		if index < 0 {
+			return false
+		}
+		value = value[index+len(part):]
+	}
+	return strings.HasSuffix(value, parts[len(parts)-1])
+}
+
+// SetPolicy 设置授权策略（为nil表示不限制），应在开始处理请求之前调用
+func (h *Handler) SetPolicy(policy *Policy) {
+	h.policy = policy
+}
+
+// authorizationTarget 一次请求要操作的资源
+type authorizationTarget struct {
+	Type       string `json:"type"`
+	ResourceID string `json:"resource_id"`
+}
+
+// authorized 在处理函数之前按授权策略检查请求中的所有资源（批量加锁为每个资源，信号量的类型为 semaphore）
+// 作废完成记录时 type 为空表示所有类型，只有 types 包含 "*" 的角色允许
+// 拒绝时返回 403（code=forbidden），允许和拒绝都写审计日志
+func (h *Handler) authorized(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.policy == nil {
+			next(w, r)
+			return
+		}
+
+		var request struct {
+			authorizationTarget
+			NodeID    string                 `json:"node_id"`
+			Name      string                 `json:"name"`
+			Resources []*authorizationTarget `json:"resources"`
+		}
+		query := r.URL.Query()
+		request.Type = query.Get("type")
+		request.ResourceID = query.Get("resource_id")
+		request.NodeID = query.Get("node_id")
+		if err := peekJSON(r, &request); err != nil {
+			http.Error(w, "读取请求失败", http.StatusBadRequest)
+			return
+		}
+		nodeID := request.NodeID
+		if nodeID == "" && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			// 订阅的 node_id 是可选的：使用证书身份
+			nodeID = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+
+		targets := request.Resources
+		switch {
+		case strings.HasPrefix(action, "semaphore"):
+			targets = []*authorizationTarget{{Type: SemaphoreType, ResourceID: request.Name}}
+		case len(targets) == 0:
+			targets = []*authorizationTarget{&request.authorizationTarget}
+		}
+
+		for _, target := range targets {
+			if err := h.authorize(action, nodeID, target.Type, target.ResourceID, r.RemoteAddr); err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"message": err.Error(),
+					"error":   err.Error(),
+					"code":    ErrorCodeForbidden,
+				})
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// authorize 按策略判定一次操作并写审计日志，拒绝时返回错误
+func (h *Handler) authorize(action, nodeID, opType, resourceID, remote string) error {
+	if h.policy == nil {
+		return nil
+	}
+	allowed, role := h.policy.AllowAction(nodeID, action, opType, resourceID)
+	if !allowed {
+		log.Printf("[Audit] decision=deny action=%s node_id=%s type=%s resource_id=%s remote=%s",
+			action, nodeID, opType, resourceID, remote)
+		if isMembershipAction(action) {
+			return fmt.Errorf("授权策略没有为节点 %s 绑定角色，不允许执行 %s 操作", nodeID, action)
+		}
+		if isAdminAction(action) {
+			return fmt.Errorf("授权策略不允许节点 %s 对资源 %s 执行 %s 操作", nodeID, resourceID, action)
+		}
+		return fmt.Errorf("授权策略不允许节点 %s 对资源 %s 执行 %s 操作", nodeID, resourceID, opType)
+	}
+	log.Printf("[Audit] decision=allow action=%s node_id=%s type=%s resource_id=%s role=%s remote=%s",
+		action, nodeID, opType, resourceID, role, remote)
+	return nil
+}
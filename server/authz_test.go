@@ -0,0 +1,287 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"distributed-lock/lockpb"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testPolicy = `{
+  "roles": {
+    "gc":     {"types": ["delete", "pull"]},
+    "puller": {"types": ["pull", "semaphore"], "resources": ["sha256:*", "registry:*"]}
+  },
+  "nodes": {"gc-*": ["gc"], "*": ["puller"]}
+}`
+
+// writeTestPolicy 把策略写入临时文件并读取
+func writeTestPolicy(t *testing.T, content string) (*Policy, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("写入策略失败: %v", err)
+	}
+	return LoadPolicy(path)
+}
+
+// TestPolicyAllow 测试角色绑定、类型和资源模式的匹配
+func TestPolicyAllow(t *testing.T) {
+	policy, err := writeTestPolicy(t, testPolicy)
+	if err != nil {
+		t.Fatalf("读取策略失败: %v", err)
+	}
+
+	cases := []struct {
+		node, opType, resource string
+		allowed                bool
+		role                   string
+	}{
+		{"gc-1", "delete", "sha256:abc", true, "gc"},
+		{"gc-1", "pull", "anything", true, "gc"},
+		{"node-1", "pull", "sha256:abc", true, "puller"},
+		{"node-1", "delete", "sha256:abc", false, ""},
+		{"node-1", "pull", "blob-abc", false, ""},
+		{"node-1", "semaphore", "registry:docker.io", true, "puller"},
+		{"node-1", "update", "sha256:abc", false, ""},
+	}
+	for _, c := range cases {
+		allowed, role := policy.Allow(c.node, c.opType, c.resource)
+		if allowed != c.allowed || role != c.role {
+			t.Errorf("Allow(%s, %s, %s) = %v %q，期望 %v %q", c.node, c.opType, c.resource, allowed, role, c.allowed, c.role)
+		}
+	}
+
+	for pattern, matches := range map[string]map[string]bool{
+		"sha256:*":  {"sha256:abc": true, "sha256:": true, "sha512:abc": false},
+		"*-gc-*":    {"zone-a-gc-1": true, "gc-1": false},
+		"exact":     {"exact": true, "exactly": false},
+		"a*b*c":     {"abc": true, "aXbYc": true, "acb": false},
+		"*":         {"": true, "anything": true},
+		"registry:": {"registry:": true},
+	} {
+		for value, expected := range matches {
+			if matchPattern(pattern, value) != expected {
+				t.Errorf("matchPattern(%q, %q) 期望 %v", pattern, value, expected)
+			}
+		}
+	}
+
+	if _, err := writeTestPolicy(t, `{"roles": {}, "nodes": {"*": ["missing"]}}`); err == nil {
+		t.Error("绑定未定义的角色应返回错误")
+	}
+}
+
+// TestAuthorizationHTTP 测试加锁、批量加锁、订阅被策略拒绝时返回 403（code=forbidden）并写审计日志
+func TestAuthorizationHTTP(t *testing.T) {
+	policy, err := writeTestPolicy(t, testPolicy)
+	if err != nil {
+		t.Fatalf("读取策略失败: %v", err)
+	}
+	handler := NewHandler(NewLockManager(true))
+	handler.SetPolicy(policy)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	var audit bytes.Buffer
+	log.SetOutput(&audit)
+	defer log.SetOutput(os.Stderr)
+
+	status, resp := postJSON(t, server.URL+"/lock", map[string]interface{}{"type": "delete", "resource_id": "sha256:authz", "node_id": "node-1"})
+	if status != http.StatusForbidden || resp["code"] != ErrorCodeForbidden {
+		t.Errorf("普通节点获取 delete 锁应返回403 forbidden，实际 %d %v", status, resp)
+	}
+	status, resp = postJSON(t, server.URL+"/lock", map[string]interface{}{"type": "delete", "resource_id": "sha256:authz", "node_id": "gc-1"})
+	if status != http.StatusOK || resp["acquired"] != true {
+		t.Errorf("GC 节点获取 delete 锁应成功，实际 %d %v", status, resp)
+	}
+	status, resp = postJSON(t, server.URL+"/lock", map[string]interface{}{"type": "pull", "resource_id": "sha256:authz", "node_id": "node-1"})
+	if status != http.StatusOK {
+		t.Errorf("普通节点 pull 应被允许，实际 %d %v", status, resp)
+	}
+
+	// 批量加锁中任一资源不允许时整批拒绝
+	status, resp = postJSON(t, server.URL+"/lock/batch", map[string]interface{}{"node_id": "node-1", "resources": []map[string]string{
+		{"type": "pull", "resource_id": "sha256:batch-1"}, {"type": "delete", "resource_id": "sha256:batch-2"}}})
+	if status != http.StatusForbidden || resp["code"] != ErrorCodeForbidden {
+		t.Errorf("批量加锁包含不允许的资源应返回403，实际 %d %v", status, resp)
+	}
+
+	subscribe, err := http.Get(server.URL + "/lock/subscribe?type=delete&resource_id=sha256:authz&node_id=node-1")
+	if err != nil {
+		t.Fatalf("订阅请求失败: %v", err)
+	}
+	subscribe.Body.Close()
+	if subscribe.StatusCode != http.StatusForbidden {
+		t.Errorf("订阅不允许的类型应返回403，实际 %d", subscribe.StatusCode)
+	}
+
+	logs := audit.String()
+	for _, expected := range []string{
+		"[Audit] decision=deny action=lock node_id=node-1 type=delete resource_id=sha256:authz",
+		"[Audit] decision=allow action=lock node_id=gc-1 type=delete resource_id=sha256:authz role=gc",
+		"[Audit] decision=deny action=batch node_id=node-1 type=delete resource_id=sha256:batch-2",
+		"[Audit] decision=deny action=subscribe node_id=node-1 type=delete",
+	} {
+		if !strings.Contains(logs, expected) {
+			t.Errorf("审计日志缺少 %q", expected)
+		}
+	}
+}
+
+// TestAuthorizationAllRoutes 测试续约、取消等待、升级等接口同样按类型检查，作废完成记录和修改信号量容量还要求角色允许该管理操作
+func TestAuthorizationAllRoutes(t *testing.T) {
+	policy, err := writeTestPolicy(t, `{
+  "roles": {
+    "gc":     {"types": ["delete", "pull"], "actions": ["invalidate"]},
+    "ops":    {"types": ["semaphore"], "actions": ["semaphore_capacity"]},
+    "puller": {"types": ["pull", "semaphore"], "resources": ["sha256:*", "registry:*"]}
+  },
+  "nodes": {"gc-*": ["gc"], "ops-*": ["ops"], "*": ["puller"]}
+}`)
+	if err != nil {
+		t.Fatalf("读取策略失败: %v", err)
+	}
+	handler := NewHandler(NewLockManager(true))
+	handler.SetPolicy(policy)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	rpc := newTestGRPCClient(t, handler)
+
+	var audit bytes.Buffer
+	log.SetOutput(&audit)
+	defer log.SetOutput(os.Stderr)
+
+	for _, c := range []struct {
+		path   string
+		body   map[string]interface{}
+		status int
+	}{
+		// 只能 pull 的节点可以获取信号量，但不能作废完成记录或修改容量
+		{"/lock/invalidate", map[string]interface{}{"type": "pull", "resource_id": "sha256:authz", "node_id": "node-1"}, http.StatusForbidden},
+		{"/semaphore/capacity", map[string]interface{}{"name": "registry:docker.io", "capacity": 100, "node_id": "node-1"}, http.StatusForbidden},
+		{"/lock/invalidate", map[string]interface{}{"type": "delete", "resource_id": "sha256:authz", "node_id": "gc-1"}, http.StatusOK},
+		// type 为空表示所有类型，gc 角色没有 update
+		{"/lock/invalidate", map[string]interface{}{"resource_id": "sha256:authz", "node_id": "gc-1"}, http.StatusForbidden},
+		{"/semaphore/capacity", map[string]interface{}{"name": "registry:docker.io", "capacity": 8, "node_id": "ops-1"}, http.StatusOK},
+		{"/lock/keepalive", map[string]interface{}{"type": "delete", "resource_id": "sha256:authz", "node_id": "node-1"}, http.StatusForbidden},
+		{"/lock/progress", map[string]interface{}{"type": "delete", "resource_id": "sha256:authz", "node_id": "node-1", "bytes_done": 1}, http.StatusForbidden},
+		{"/lock/upgrade", map[string]interface{}{"type": "delete", "resource_id": "sha256:authz", "node_id": "node-1", "fencing_token": 1}, http.StatusForbidden},
+		{"/lock/downgrade", map[string]interface{}{"type": "delete", "resource_id": "sha256:authz", "node_id": "node-1", "fencing_token": 1}, http.StatusForbidden},
+		{"/lock/cancel", map[string]interface{}{"type": "delete", "resource_id": "sha256:authz", "node_id": "node-1"}, http.StatusForbidden},
+		{"/semaphore/keepalive", map[string]interface{}{"name": "global", "node_id": "node-1", "session_id": "s-1"}, http.StatusForbidden},
+	} {
+		status, resp := postJSON(t, server.URL+c.path, c.body)
+		if status != c.status {
+			t.Errorf("%s %v 期望 %d，实际 %d %v", c.path, c.body, c.status, status, resp)
+		}
+		if status == http.StatusForbidden && resp["code"] != ErrorCodeForbidden {
+			t.Errorf("%s 拒绝时应返回 code=forbidden，实际 %v", c.path, resp)
+		}
+	}
+
+	req, _ := http.NewRequest("DELETE", server.URL+"/lock/queue?type=delete&resource_id=sha256:authz&node_id=node-1", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("取消等待请求失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("DELETE /lock/queue 不允许的类型应返回403，实际 %d", resp.StatusCode)
+	}
+	if capacity := handler.lockManager.GetSemaphore("registry:docker.io").Capacity; capacity != 8 {
+		t.Errorf("信号量容量应只被 ops-1 修改为8，实际 %d", capacity)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := rpc.Keepalive(ctx, &lockpb.KeepaliveRequest{Type: "delete", ResourceId: "sha256:authz", NodeId: "node-1"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("gRPC Keepalive 不允许的类型应返回 PermissionDenied，实际 %v", err)
+	}
+	if _, err := rpc.Cancel(ctx, &lockpb.CancelRequest{Type: "delete", ResourceId: "sha256:authz", NodeId: "node-1"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("gRPC Cancel 不允许的类型应返回 PermissionDenied，实际 %v", err)
+	}
+	if _, err := rpc.Acquire(ctx, &lockpb.AcquireRequest{Type: "delete", ResourceId: "sha256:authz", NodeId: "node-1"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("gRPC Acquire 不允许的类型应返回 PermissionDenied，实际 %v", err)
+	}
+
+	logs := audit.String()
+	for _, expected := range []string{
+		"[Audit] decision=deny action=invalidate node_id=node-1 type=pull resource_id=sha256:authz",
+		"[Audit] decision=deny action=semaphore_capacity node_id=node-1 type=semaphore resource_id=registry:docker.io",
+		"[Audit] decision=allow action=invalidate node_id=gc-1 type=delete resource_id=sha256:authz role=gc",
+		"[Audit] decision=allow action=semaphore_capacity node_id=ops-1 type=semaphore resource_id=registry:docker.io role=ops",
+		"[Audit] decision=deny action=keepalive node_id=node-1 type=delete",
+		"[Audit] decision=deny action=progress node_id=node-1 type=delete",
+		"[Audit] decision=deny action=upgrade node_id=node-1 type=delete",
+		"[Audit] decision=deny action=downgrade node_id=node-1 type=delete",
+		"[Audit] decision=deny action=cancel node_id=node-1 type=delete",
+		"[Audit] decision=deny action=semaphore_keepalive node_id=node-1 type=semaphore resource_id=global",
+	} {
+		if !strings.Contains(logs, expected) {
+			t.Errorf("审计日志缺少 %q", expected)
+		}
+	}
+}
+
+// TestAuthorizationMembership 测试节点注册和心跳只允许策略中绑定了角色的节点
+func TestAuthorizationMembership(t *testing.T) {
+	policy, err := writeTestPolicy(t, `{
+  "roles": {"puller": {"types": ["pull"]}},
+  "nodes": {"node-*": ["puller"]}
+}`)
+	if err != nil {
+		t.Fatalf("读取策略失败: %v", err)
+	}
+	handler := NewHandler(NewLockManager(true))
+	handler.SetPolicy(policy)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	var audit bytes.Buffer
+	log.SetOutput(&audit)
+	defer log.SetOutput(os.Stderr)
+
+	for _, path := range []string{"/nodes/register", "/nodes/heartbeat"} {
+		status, resp := postJSON(t, server.URL+path, map[string]interface{}{"node_id": "rogue-1"})
+		if status != http.StatusForbidden || resp["code"] != ErrorCodeForbidden {
+			t.Errorf("%s 没有角色的节点应返回403 forbidden，实际 %d %v", path, status, resp)
+		}
+		if status, resp := postJSON(t, server.URL+path, map[string]interface{}{"node_id": "node-1"}); status != http.StatusOK {
+			t.Errorf("%s 绑定了角色的节点应被允许，实际 %d %v", path, status, resp)
+		}
+	}
+	for _, node := range handler.lockManager.ListNodes() {
+		if node.NodeID == "rogue-1" {
+			t.Error("被拒绝的节点不应加入成员表")
+		}
+	}
+
+	logs := audit.String()
+	for _, expected := range []string{
+		"[Audit] decision=deny action=node_register node_id=rogue-1",
+		"[Audit] decision=allow action=node_heartbeat node_id=node-1 type= resource_id= role=puller",
+	} {
+		if !strings.Contains(logs, expected) {
+			t.Errorf("审计日志缺少 %q", expected)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"log"
+	"sort"
+	"sync"
+)
+
+// 批量加锁
+//
+// 拉取整个镜像需要锁住多个镜像层。逐个加锁会出现只拿到一部分的情况，
+// 两个节点拉取有重叠的镜像时还会互相持有对方等待的层。批量加锁保证：
+//   - 按规范顺序（type:resource_id 字典序）获取所有资源锁，并发的批量请求之间不会互相等待
+//   - 全部资源都能获得时一次性授予；否则一个都不持有，被占用的资源加入等待队列
+//   - 排队期间某个资源先被分配给本会话、而其他资源仍被占用时，重新请求会让出该资源（交给下一个等待者），
+//     避免持有一部分资源等待另一部分
+//
+// 客户端在任一资源的 lock_assigned 事件或完成事件后用同一个会话ID重新请求。
+
+// batchKeyState 批量请求中单个资源的当前状态
+type batchKeyState int
+
+const (
+	batchKeyFree    batchKeyState = iota // 空闲，可以授予
+	batchKeyHeld                         // 已由本会话持有（排队后被分配）
+	batchKeyBlocked                      // 被其他持有者占用
+)
+
+// TryLockBatch 批量加锁：全部资源都能获得时一次性授予，否则都不持有并在被占用的资源上排队
+// batch.SessionID 为空时分配新的会话ID并写回
+// 返回：是否全部获得，每个资源的结果（按规范顺序），错误信息（有错误时不修改任何状态）
+func (lm *LockManager) TryLockBatch(batch *BatchLockRequest) (bool, []*BatchLockResult, string) {
+	if batch.SessionID == "" {
+		batch.SessionID = newSessionID()
+	}
+
+	now := lm.now()
+	requests := make([]*LockRequest, 0, len(batch.Resources))
+	for _, resource := range batch.Resources {
+		mode := resource.Mode
+		if mode == "" {
+			mode = LockModeExclusive
+		}
+		requests = append(requests, &LockRequest{
+			Type:          resource.Type,
+			ResourceID:    resource.ResourceID,
+			NodeID:        batch.NodeID,
+			Timestamp:     now,
+			Mode:          mode,
+			WaitTimeoutMs: batch.WaitTimeoutMs,
+			SessionID:     batch.SessionID,
+		})
+	}
+	if errMsg := lm.sortBatch(requests); errMsg != "" {
+		return false, nil, errMsg
+	}
+
+	unlock := lm.lockBatch(requests)
+	defer unlock()
+
+	aborted := false
+	for _, request := range requests {
+		if lm.consumeAbortLocked(lm.getShard(request.ResourceID), LockKey(request.Type, request.ResourceID), request.SessionID, now) {
+			aborted = true
+		}
+	}
+	if aborted {
+		return false, nil, deadlockAbortMessage
+	}
+
+	// ========== 检查所有资源 ==========
+	states := make([]batchKeyState, len(requests))
+	allFree := true
+	for i, request := range requests {
+		state, errMsg := lm.batchKeyStateLocked(request)
+		if errMsg != "" {
+			return false, nil, errMsg
+		}
+		states[i] = state
+		if state == batchKeyBlocked {
+			allFree = false
+		}
+	}
+
+	results := make([]*BatchLockResult, len(requests))
+	if allFree {
+		// ========== 全部授予 ==========
+		for i, request := range requests {
+			shard := lm.getShard(request.ResourceID)
+			fencingToken := lm.grantBatchKeyLocked(shard, request, states[i] == batchKeyHeld)
+			results[i] = &BatchLockResult{Type: request.Type, ResourceID: request.ResourceID, Mode: request.Mode,
+				Status: BatchStatusAcquired, FencingToken: fencingToken}
+		}
+		log.Printf("[TryLockBatch] 批量加锁成功: node=%s, session=%s, 资源数量=%d", batch.NodeID, batch.SessionID, len(requests))
+		return true, results, ""
+	}
+
+	if !lm.AllowMultiNodeDownload {
+		return false, nil, "多节点下载模式已关闭，部分资源已被其他节点占用"
+	}
+
+	// ========== 让出已分配的资源，在被占用的资源上排队 ==========
+	for i, request := range requests {
+		if states[i] == batchKeyHeld {
+			lm.yieldBatchKeyLocked(lm.getShard(request.ResourceID), request)
+		}
+	}
+	// 让出会把锁交给其他等待者，重新检查每个资源
+	for i, request := range requests {
+		shard := lm.getShard(request.ResourceID)
+		key := LockKey(request.Type, request.ResourceID)
+		status := BatchStatusAvailable
+		if state, _ := lm.batchKeyStateLocked(request); state == batchKeyBlocked {
+			status = BatchStatusQueued
+			if !queuedLocked(shard, key, request.SessionID) {
+				lm.addToQueue(shard, key, request)
+			}
+		}
+		results[i] = &BatchLockResult{Type: request.Type, ResourceID: request.ResourceID, Mode: request.Mode, Status: status}
+	}
+	log.Printf("[TryLockBatch] 部分资源被占用，整批未获得: node=%s, session=%s, 资源数量=%d", batch.NodeID, batch.SessionID, len(requests))
+	return false, results, ""
+}
+
+// sortBatch 把请求按规范顺序排序，并检查同一批中是否有重复或互斥的资源
+// 返回：错误信息
+func (lm *LockManager) sortBatch(requests []*LockRequest) string {
+	if len(requests) == 0 {
+		return "批量请求中没有资源"
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return LockKey(requests[i].Type, requests[i].ResourceID) < LockKey(requests[j].Type, requests[j].ResourceID)
+	})
+	for i, request := range requests {
+		for _, other := range requests[i+1:] {
+			if other.ResourceID != request.ResourceID {
+				continue
+			}
+			if other.Type == request.Type {
+				return "批量请求中有重复的资源: " + LockKey(request.Type, request.ResourceID)
+			}
+			if lm.Compatibility.Conflicts(request.Type, other.Type) {
+				return "批量请求中的操作互相冲突: " + request.Type + " 与 " + other.Type + " (" + request.ResourceID + ")"
+			}
+		}
+	}
+	return ""
+}
+
+// lockBatch 按规范顺序获取所有资源锁，再按分段下标顺序获取涉及的分段锁
+// 单key操作只持有一个资源锁，批量请求之间按同一顺序加锁，不会死锁
+// 返回：释放所有锁的函数
+func (lm *LockManager) lockBatch(requests []*LockRequest) func() {
+	resourceLocks := make([]*sync.Mutex, 0, len(requests))
+	involved := make(map[*resourceShard]bool)
+	for _, request := range requests {
+		key := LockKey(request.Type, request.ResourceID)
+		shard := lm.getShard(request.ResourceID)
+		involved[shard] = true
+
+		shard.mu.Lock()
+		resourceLock, exists := shard.resourceLocks[key]
+		if !exists {
+			resourceLock = &sync.Mutex{}
+			shard.resourceLocks[key] = resourceLock
+		}
+		shard.mu.Unlock()
+		resourceLocks = append(resourceLocks, resourceLock)
+	}
+
+	for _, resourceLock := range resourceLocks {
+		resourceLock.Lock()
+	}
+	var shards []*resourceShard
+	for _, shard := range lm.shards {
+		if involved[shard] {
+			shard.mu.Lock()
+			shards = append(shards, shard)
+		}
+	}
+
+	return func() {
+		for _, shard := range shards {
+			shard.mu.Unlock()
+		}
+		for _, resourceLock := range resourceLocks {
+			resourceLock.Unlock()
+		}
+	}
+}
+
+// batchKeyStateLocked 判断批量请求中的一个资源能否立即授予（判断规则与 TryLock 相同）
+// 注意：调用此函数时，资源锁和 shard.mu 都必须已经加锁
+func (lm *LockManager) batchKeyStateLocked(request *LockRequest) (batchKeyState, string) {
+	key := LockKey(request.Type, request.ResourceID)
+	shard := lm.getShard(request.ResourceID)
+
+	if request.shared() {
+		if _, holds := shard.shared[key][request.SessionID]; holds {
+			return batchKeyHeld, ""
+		}
+		if lockInfo, exists := shard.locks[key]; exists && lockInfo.Request.SessionID == request.SessionID {
+			return batchKeyBlocked, "已持有独占锁，请通过 /lock/downgrade 降级为共享锁"
+		}
+		lm.pruneQueueLocked(shard, key, request.Timestamp)
+		if lm.blockingLockLocked(shard, request) != nil || (len(shard.shared[key]) > 0 && len(shard.queues[key]) > 0) {
+			return batchKeyBlocked, ""
+		}
+		return batchKeyFree, ""
+	}
+
+	if _, holdsShared := shard.shared[key][request.SessionID]; holdsShared {
+		return batchKeyBlocked, "已持有共享锁，请通过 /lock/upgrade 升级为独占锁"
+	}
+	if lockInfo, exists := shard.locks[key]; exists {
+		if !lockInfo.Completed && lockInfo.Request.SessionID == request.SessionID {
+			return batchKeyHeld, ""
+		}
+		return batchKeyBlocked, ""
+	}
+	if lm.blockingLockLocked(shard, request) != nil {
+		return batchKeyBlocked, ""
+	}
+	return batchKeyFree, ""
+}
+
+// grantBatchKeyLocked 授予批量请求中的一个资源（held 为 true 时只刷新租约）
+// 注意：调用此函数时，资源锁和 shard.mu 都必须已经加锁
+// 返回：fencing token
+func (lm *LockManager) grantBatchKeyLocked(shard *resourceShard, request *LockRequest, held bool) uint64 {
+	key := LockKey(request.Type, request.ResourceID)
+	now := lm.now()
+
+	if held {
+		lockInfo := shard.locks[key]
+		if request.shared() {
+			lockInfo = shard.shared[key][request.SessionID]
+		}
+		lockInfo.LeaseExpiresAt = lm.leaseDeadline(now)
+		request.FencingToken = lockInfo.FencingToken
+		return lockInfo.FencingToken
+	}
+
+	request.FencingToken = lm.nextFencingToken(shard, key)
+	lockInfo := &LockInfo{
+		Request:        request,
+		AcquiredAt:     now,
+		FencingToken:   request.FencingToken,
+		LeaseExpiresAt: lm.leaseDeadline(now),
+	}
+	if request.shared() {
+		lm.addSharedHolderLocked(shard, key, lockInfo)
+	} else {
+		shard.locks[key] = lockInfo
+	}
+	lm.metrics.granted(request, false, now)
+	lm.appendWAL(&WALRecord{
+		Op:           WALOpGrant,
+		Type:         request.Type,
+		ResourceID:   request.ResourceID,
+		Request:      request,
+		FencingToken: request.FencingToken,
+		AcquiredAt:   now,
+	})
+	return request.FencingToken
+}
+
+// yieldBatchKeyLocked 让出排队后分配给本会话的资源：视为操作失败，把锁交给下一个等待者
+// 注意：调用此函数时，资源锁和 shard.mu 都必须已经加锁
+func (lm *LockManager) yieldBatchKeyLocked(shard *resourceShard, request *LockRequest) {
+	key := LockKey(request.Type, request.ResourceID)
+	log.Printf("[TryLockBatch] 其他资源仍被占用，让出已分配的资源: key=%s, node=%s, session=%s",
+		key, request.NodeID, request.SessionID)
+
+	if request.shared() {
+		lm.removeSharedHolderLocked(shard, key, request.SessionID, false)
+		lm.afterSharedReleaseLocked(shard, key)
+		return
+	}
+	lockInfo := shard.locks[key]
+	lockInfo.Completed = true
+	lockInfo.CompletedAt = lm.now()
+	lm.handOffLocked(shard, key)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func batchOf(nodeID string, resourceIDs ...string) *BatchLockRequest {
+	batch := &BatchLockRequest{NodeID: nodeID}
+	for _, resourceID := range resourceIDs {
+		batch.Resources = append(batch.Resources, &LockRequest{Type: OperationTypePull, ResourceID: resourceID})
+	}
+	return batch
+}
+
+// TestBatchAllOrNothing 测试部分资源被占用时整批都不持有，占用者失败后整批获得
+func TestBatchAllOrNothing(t *testing.T) {
+	lm := NewLockManager(true)
+
+	holder := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:batch-b", NodeID: "node-2"}
+	lm.TryLock(holder)
+
+	// 传入顺序与规范顺序不同，结果按规范顺序返回
+	batch := batchOf("node-1", "sha256:batch-c", "sha256:batch-b", "sha256:batch-a")
+	acquired, results, errMsg := lm.TryLockBatch(batch)
+	if acquired || errMsg != "" {
+		t.Fatalf("batch-b 被占用，整批不应获得: acquired=%v, error=%s", acquired, errMsg)
+	}
+	want := []string{BatchStatusAvailable, BatchStatusQueued, BatchStatusAvailable}
+	for i, result := range results {
+		if result.Status != want[i] {
+			t.Errorf("资源 %s 期望状态 %s，实际 %s", result.ResourceID, want[i], result.Status)
+		}
+	}
+	if results[0].ResourceID != "sha256:batch-a" || results[2].ResourceID != "sha256:batch-c" {
+		t.Errorf("结果应按规范顺序返回: %s, %s, %s", results[0].ResourceID, results[1].ResourceID, results[2].ResourceID)
+	}
+	if lm.GetLockInfo(OperationTypePull, "sha256:batch-a") != nil || lm.GetLockInfo(OperationTypePull, "sha256:batch-c") != nil {
+		t.Fatal("整批未获得时不应持有空闲的资源")
+	}
+
+	// 占用者失败：batch-b 分配给批量请求的会话，重新请求后整批获得
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: "sha256:batch-b", NodeID: "node-2",
+		FencingToken: holder.FencingToken, Error: "下载失败"})
+	acquired, results, _ = lm.TryLockBatch(batch)
+	if !acquired {
+		t.Fatalf("占用者释放后应整批获得: %+v", results)
+	}
+	for _, result := range results {
+		lockInfo := lm.GetLockInfo(OperationTypePull, result.ResourceID)
+		if result.Status != BatchStatusAcquired || lockInfo == nil || lockInfo.FencingToken != result.FencingToken ||
+			lockInfo.Request.SessionID != batch.SessionID {
+			t.Errorf("资源 %s 应由批量请求的会话持有: result=%+v, lock=%+v", result.ResourceID, result, lockInfo)
+		}
+	}
+
+	// 按会话逐个解锁
+	for _, result := range results {
+		if !lm.Unlock(&UnlockRequest{Type: result.Type, ResourceID: result.ResourceID, NodeID: "node-1",
+			SessionID: batch.SessionID, FencingToken: result.FencingToken}) {
+			t.Errorf("解锁 %s 失败", result.ResourceID)
+		}
+	}
+}
+
+// TestBatchYieldsAssignedKey 测试排队期间先分配到的资源在其他资源仍被占用时会被让出
+func TestBatchYieldsAssignedKey(t *testing.T) {
+	lm := NewLockManager(true)
+
+	holderA := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:yield-a", NodeID: "node-2"}
+	lm.TryLock(holderA)
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:yield-b", NodeID: "node-2"})
+
+	batch := batchOf("node-1", "sha256:yield-a", "sha256:yield-b")
+	lm.TryLockBatch(batch)
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:yield-a", NodeID: "node-3"})
+
+	// yield-a 分配给批量请求，但 yield-b 仍被占用
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: "sha256:yield-a", NodeID: "node-2",
+		FencingToken: holderA.FencingToken, Error: "下载失败"})
+	if lockInfo := lm.GetLockInfo(OperationTypePull, "sha256:yield-a"); lockInfo == nil || lockInfo.Request.SessionID != batch.SessionID {
+		t.Fatalf("yield-a 应先分配给批量请求，实际 %+v", lockInfo)
+	}
+
+	acquired, results, _ := lm.TryLockBatch(batch)
+	if acquired {
+		t.Fatal("yield-b 仍被占用，整批不应获得")
+	}
+	if lockInfo := lm.GetLockInfo(OperationTypePull, "sha256:yield-a"); lockInfo == nil || lockInfo.Request.NodeID != "node-3" {
+		t.Fatalf("yield-a 应让出给下一个等待者 node-3，实际 %+v", lockInfo)
+	}
+	for _, result := range results {
+		if result.Status != BatchStatusQueued {
+			t.Errorf("资源 %s 应重新排队，实际 %s", result.ResourceID, result.Status)
+		}
+	}
+}
+
+// TestBatchRejectsInvalidSet 测试同一批中重复或互斥的资源被拒绝，且不修改任何状态
+func TestBatchRejectsInvalidSet(t *testing.T) {
+	lm := NewLockManager(true)
+
+	conflicting := &BatchLockRequest{NodeID: "node-1", Resources: []*LockRequest{
+		{Type: OperationTypePull, ResourceID: "sha256:invalid"},
+		{Type: OperationTypeDelete, ResourceID: "sha256:invalid"},
+	}}
+	if _, _, errMsg := lm.TryLockBatch(conflicting); errMsg == "" {
+		t.Error("pull 与 delete 互斥，不能放在同一批")
+	}
+	if _, _, errMsg := lm.TryLockBatch(batchOf("node-1", "sha256:invalid", "sha256:invalid")); errMsg == "" {
+		t.Error("重复的资源应被拒绝")
+	}
+	if lm.GetLockInfo(OperationTypePull, "sha256:invalid") != nil {
+		t.Error("被拒绝的批量请求不应持有任何资源")
+	}
+}
+
+// TestLockBatchHTTP 测试 /lock/batch 接口
+func TestLockBatchHTTP(t *testing.T) {
+	lm := NewLockManager(true)
+	server := newTestServer(t, lm)
+
+	status, body := postJSON(t, server.URL+"/lock/batch", map[string]interface{}{
+		"node_id": "node-1",
+		"resources": []map[string]interface{}{
+			{"type": "pull", "resource_id": "sha256:http-b"},
+			{"type": "pull", "resource_id": "sha256:http-a", "mode": "shared"},
+		},
+	})
+	if status != http.StatusOK || body["acquired"] != true || body["session_id"] == "" {
+		t.Fatalf("批量加锁失败: status=%d, resp=%v", status, body)
+	}
+	results, _ := body["results"].([]interface{})
+	if len(results) != 2 {
+		t.Fatalf("期望2个资源的结果，实际 %v", body["results"])
+	}
+	first, _ := results[0].(map[string]interface{})
+	if first["resource_id"] != "sha256:http-a" || first["mode"] != LockModeShared || first["status"] != BatchStatusAcquired {
+		t.Errorf("结果应按规范顺序返回并带有模式: %v", first)
+	}
+
+	status, _ = postJSON(t, server.URL+"/lock/batch", map[string]interface{}{"node_id": "node-1"})
+	if status != http.StatusBadRequest {
+		t.Errorf("没有资源时应返回 400，实际 %d", status)
+	}
+	status, _ = postJSON(t, server.URL+"/lock/batch", map[string]interface{}{
+		"node_id": "node-2",
+		"resources": []map[string]interface{}{
+			{"type": "pull", "resource_id": "sha256:http-c"},
+			{"type": "delete", "resource_id": "sha256:http-c"},
+		},
+	})
+	if status != http.StatusForbidden {
+		t.Errorf("互斥的资源放在同一批应返回 403，实际 %d", status)
+	}
+}
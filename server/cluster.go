@@ -0,0 +1,572 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 集群模式（按一致性哈希水平扩展）
+//
+// 多个服务端进程组成一个哈希环（见 ring.go），每个进程只保存自己负责的资源的状态：
+//   - 带资源ID（或信号量名称）的请求如果不属于本进程，返回 307 重定向到 owner（请求体随重定向转发）
+//   - 客户端从任意成员获取环（GET /cluster/ring）后直接请求 owner，只有环过期时才会被重定向
+//   - 环变更（POST /cluster/ring）广播给新旧所有成员，每个成员把不再属于自己的资源交接给新的 owner
+//     （POST /cluster/handoff），新 owner 在收到前任 owner 的交接之前对这些资源返回 503，客户端稍后重试
+//
+// 集群模式与 Raft 复制模式互斥：每个资源只由一个进程保存，需要持久化时每个进程各自使用 LOCK_STATE_DIR。
+
+// DefaultHandoffTimeout 等待前任 owner 交接的最长时间，超时后不再等待（前任可能已经宕机）
+const DefaultHandoffTimeout = 10 * time.Second
+
+// ClusterConfig 集群配置
+type ClusterConfig struct {
+	ID           string            // 本进程的成员ID
+	Members      map[string]string // 成员ID -> URL（包含本进程）
+	VirtualNodes int               // 每个成员的虚拟节点数量（<= 0 时使用 DefaultRingVirtualNodes）
+
+	// HandoffTimeout 环变更后等待前任 owner 交接的最长时间（<= 0 时使用 DefaultHandoffTimeout）
+	HandoffTimeout time.Duration
+
+	// HTTPClient 成员之间通信使用的客户端（为nil时使用默认超时的客户端）
+	HTTPClient *http.Client
+}
+
+// Cluster 集群模式下本进程的成员状态
+type Cluster struct {
+	config      ClusterConfig
+	lockManager *LockManager
+	httpClient  *http.Client
+
+	mu       sync.RWMutex
+	ring     *HashRing
+	previous *HashRing            // 上一个环，用于判断资源的前任 owner
+	pending  map[string]time.Time // 尚未完成交接的前任 owner -> 等待截止时间
+
+	handoffMu sync.Mutex // 串行化交接
+}
+
+// RingUpdateRequest 修改环成员请求
+type RingUpdateRequest struct {
+	Version      uint64            `json:"version,omitempty"`       // 新环的版本，为0时使用当前版本+1
+	Members      map[string]string `json:"members"`                 // 成员ID -> URL
+	VirtualNodes int               `json:"virtual_nodes,omitempty"` // 为0时沿用当前环的配置
+	Forwarded    bool              `json:"forwarded,omitempty"`     // 由其他成员转发（不再继续广播）
+	Previous     *HashRing         `json:"previous,omitempty"`      // 转发时携带的上一个环
+}
+
+// HandoffRequest 前任 owner 发给新 owner 的资源状态
+type HandoffRequest struct {
+	From     string        `json:"from"`               // 前任 owner 的成员ID
+	Ring     *HashRing     `json:"ring"`               // 交接依据的环
+	Previous *HashRing     `json:"previous,omitempty"` // 交接依据的环的上一个环
+	State    *lockSnapshot `json:"state"`              // 交接的资源状态（可能为空）
+}
+
+// NewCluster 创建集群成员，初始环的版本为1（启动后调用 Sync 从其他成员获取更新的环）
+func NewCluster(config ClusterConfig, lockManager *LockManager) (*Cluster, error) {
+	if _, exists := config.Members[config.ID]; !exists {
+		return nil, fmt.Errorf("成员列表中没有本进程 %q", config.ID)
+	}
+	ring, err := NewHashRing(1, config.Members, config.VirtualNodes)
+	if err != nil {
+		return nil, err
+	}
+	if config.HandoffTimeout <= 0 {
+		config.HandoffTimeout = DefaultHandoffTimeout
+	}
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Cluster{
+		config:      config,
+		lockManager: lockManager,
+		httpClient:  httpClient,
+		ring:        ring,
+		pending:     make(map[string]time.Time),
+	}, nil
+}
+
+// ID 本进程的成员ID
+func (c *Cluster) ID() string {
+	return c.config.ID
+}
+
+// Ring 当前的环
+func (c *Cluster) Ring() *HashRing {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring
+}
+
+// route 返回负责 resourceID 的成员，以及资源是否正在从前任 owner 交接过来（返回前任的成员ID）
+func (c *Cluster) route(resourceID string) (RingMember, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	owner := c.ring.Owner(resourceID)
+	if owner.ID != c.config.ID || c.previous == nil {
+		return owner, ""
+	}
+	from := c.previous.Owner(resourceID).ID
+	if deadline, waiting := c.pending[from]; waiting && time.Now().Before(deadline) {
+		return owner, from
+	}
+	return owner, ""
+}
+
+// UpdateRing 切换到新的环（版本必须比当前环新），并在后台把不再属于本进程的资源交接给新的 owner
+// 返回：当前的环，是否切换
+func (c *Cluster) UpdateRing(version uint64, members map[string]string, virtualNodes int) (*HashRing, bool, error) {
+	return c.adoptRing(version, members, virtualNodes, nil)
+}
+
+// adoptRing 切换到新的环。previous 为其他成员转发的上一个环（刚加入环的成员本地没有），为nil时使用本地当前的环
+func (c *Cluster) adoptRing(version uint64, members map[string]string, virtualNodes int, previous *HashRing) (*HashRing, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version <= c.ring.Version {
+		return c.ring, false, nil
+	}
+	if virtualNodes <= 0 {
+		virtualNodes = c.ring.VirtualNodes
+	}
+	ring, err := NewHashRing(version, members, virtualNodes)
+	if err != nil {
+		return nil, false, err
+	}
+	if previous == nil || previous.Version <= c.ring.Version {
+		previous = c.ring
+	} else if previous, err = NewHashRing(previous.Version, previous.memberMap(), previous.VirtualNodes); err != nil {
+		return nil, false, err
+	}
+
+	// 新旧环上的其他成员都会发送交接（没有资源需要交接时发送空状态），在此之前前任 owner 的资源暂不受理
+	c.previous = previous
+	c.ring = ring
+	c.pending = make(map[string]time.Time)
+	deadline := time.Now().Add(c.config.HandoffTimeout)
+	for _, member := range previous.Members {
+		if member.ID != c.config.ID {
+			c.pending[member.ID] = deadline
+		}
+	}
+
+	log.Printf("[Cluster] 切换到新的环: version=%d, 成员=%v", ring.Version, ring.memberMap())
+	go c.handOff()
+	return ring, true, nil
+}
+
+// handOff 把不再属于本进程的资源交接给当前环上的 owner，并通知其他成员本进程的交接已完成
+func (c *Cluster) handOff() {
+	c.handoffMu.Lock()
+	defer c.handoffMu.Unlock()
+
+	c.mu.RLock()
+	ring, previous := c.ring, c.previous
+	c.mu.RUnlock()
+	state := c.lockManager.extractResources(func(resourceID string) bool {
+		return ring.Owner(resourceID).ID == c.config.ID
+	})
+	parts := partitionSnapshot(state, func(resourceID string) string { return ring.Owner(resourceID).ID })
+
+	for _, member := range ring.Members {
+		if member.ID == c.config.ID {
+			continue
+		}
+		part := parts[member.ID]
+		if part == nil {
+			part = &lockSnapshot{}
+		}
+		request := &HandoffRequest{From: c.config.ID, Ring: ring, Previous: previous, State: part}
+		if err := c.sendHandoff(member, request); err != nil {
+			log.Printf("[Cluster] 交接失败，这些资源的状态已丢失（持有者续约失败后重新加锁）: to=%s, 锁数量=%d, 队列数量=%d, error=%v",
+				member.ID, len(part.Locks)+len(part.Shared), len(part.Queues), err)
+			continue
+		}
+		if len(part.Locks)+len(part.Shared)+len(part.Queues)+len(part.Semaphores) > 0 {
+			log.Printf("[Cluster] 交接完成: to=%s, 锁数量=%d, 队列数量=%d, 信号量数量=%d",
+				member.ID, len(part.Locks)+len(part.Shared), len(part.Queues), len(part.Semaphores))
+		}
+	}
+
+	if err := c.lockManager.Snapshot(); err != nil {
+		log.Printf("[Cluster] 交接后快照失败: %v", err)
+	}
+}
+
+// sendHandoff 把交接状态发送给成员（失败时重试几次）
+func (c *Cluster) sendHandoff(member RingMember, request *HandoffRequest) error {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+		}
+		var reply map[string]interface{}
+		if err = c.post(member.URL, "/cluster/handoff", request, &reply); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// receiveHandoff 合并前任 owner 交接来的资源，之后受理它交接的资源
+// 返回：合并的持有者数量
+func (c *Cluster) receiveHandoff(request *HandoffRequest) (int, error) {
+	if request.Ring != nil {
+		// 交接可能比环变更的广播先到达
+		if _, _, err := c.adoptRing(request.Ring.Version, request.Ring.memberMap(), request.Ring.VirtualNodes, request.Previous); err != nil {
+			return 0, err
+		}
+	}
+
+	merged := 0
+	misplaced := false
+	if request.State != nil {
+		merged = c.lockManager.mergeResources(request.State)
+		ring := c.Ring()
+		for _, resourceID := range snapshotResources(request.State) {
+			if ring.Owner(resourceID).ID != c.config.ID {
+				misplaced = true
+				break
+			}
+		}
+	}
+
+	c.mu.Lock()
+	if request.Ring == nil || request.Ring.Version == c.ring.Version {
+		delete(c.pending, request.From)
+	}
+	c.mu.Unlock()
+
+	if misplaced {
+		// 交接期间环再次变更：继续交给现在的 owner
+		go c.handOff()
+	} else if err := c.lockManager.Snapshot(); err != nil {
+		log.Printf("[Cluster] 合并交接状态后快照失败: %v", err)
+	}
+	return merged, nil
+}
+
+// Sync 从其他成员获取环，采用版本最新的一个（启动时调用，进程重启后追上期间的成员变更）
+func (c *Cluster) Sync() {
+	for _, member := range c.Ring().Members {
+		if member.ID == c.config.ID {
+			continue
+		}
+		resp, err := c.httpClient.Get(member.URL + "/cluster/ring")
+		if err != nil {
+			continue
+		}
+		var ring HashRing
+		err = json.NewDecoder(resp.Body).Decode(&ring)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if _, _, err := c.UpdateRing(ring.Version, ring.memberMap(), ring.VirtualNodes); err != nil {
+			log.Printf("[Cluster] 采用 %s 的环失败: %v", member.ID, err)
+		}
+	}
+}
+
+// broadcastRing 把新环转发给新旧环上的所有其他成员
+func (c *Cluster) broadcastRing(ring, previous *HashRing) {
+	targets := ring.memberMap()
+	for id, memberURL := range previous.memberMap() {
+		targets[id] = memberURL
+	}
+	delete(targets, c.config.ID)
+
+	request := &RingUpdateRequest{Version: ring.Version, Members: ring.memberMap(), VirtualNodes: ring.VirtualNodes, Forwarded: true, Previous: previous}
+	for id, memberURL := range targets {
+		var reply HashRing
+		if err := c.post(memberURL, "/cluster/ring", request, &reply); err != nil {
+			log.Printf("[Cluster] 转发环变更失败: to=%s, error=%v", id, err)
+		}
+	}
+}
+
+// post 向其他成员发送 POST JSON 请求
+func (c *Cluster) post(memberURL, path string, request, reply interface{}) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Post(memberURL+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s 返回状态码 %d: %s", path, resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// partitionSnapshot 按 owner 拆分资源状态
+func partitionSnapshot(state *lockSnapshot, ownerOf func(resourceID string) string) map[string]*lockSnapshot {
+	parts := make(map[string]*lockSnapshot)
+	part := func(resourceID string) *lockSnapshot {
+		owner := ownerOf(resourceID)
+		if parts[owner] == nil {
+			parts[owner] = &lockSnapshot{
+				Locks:         make(map[string]*LockInfo),
+				Queues:        make(map[string][]*LockRequest),
+				FencingTokens: make(map[string]uint64),
+				Shared:        make(map[string][]*LockInfo),
+				Upgrades:      make(map[string]string),
+				Completions:   make(map[string]*CompletionRecord),
+				Semaphores:    make(map[string]*Semaphore),
+				CreatedAt:     state.CreatedAt,
+			}
+		}
+		return parts[owner]
+	}
+	resourceOf := func(key string) string {
+		_, resourceID, _ := splitLockKey(key)
+		return resourceID
+	}
+
+	for key, lockInfo := range state.Locks {
+		part(lockInfo.Request.ResourceID).Locks[key] = lockInfo
+	}
+	for key, queue := range state.Queues {
+		part(resourceOf(key)).Queues[key] = queue
+	}
+	for key, token := range state.FencingTokens {
+		part(resourceOf(key)).FencingTokens[key] = token
+	}
+	for key, holders := range state.Shared {
+		part(resourceOf(key)).Shared[key] = holders
+	}
+	for key, sessionID := range state.Upgrades {
+		part(resourceOf(key)).Upgrades[key] = sessionID
+	}
+	for key, record := range state.Completions {
+		part(record.ResourceID).Completions[key] = record
+	}
+	for name, sem := range state.Semaphores {
+		part(name).Semaphores[name] = sem
+	}
+	return parts
+}
+
+// snapshotResources 资源状态中出现的所有资源ID（信号量为名称）
+func snapshotResources(state *lockSnapshot) []string {
+	seen := make(map[string]bool)
+	var resources []string
+	add := func(resourceID string) {
+		if !seen[resourceID] {
+			seen[resourceID] = true
+			resources = append(resources, resourceID)
+		}
+	}
+	for key := range state.FencingTokens {
+		if _, resourceID, ok := splitLockKey(key); ok {
+			add(resourceID)
+		}
+	}
+	for key := range state.Queues {
+		if _, resourceID, ok := splitLockKey(key); ok {
+			add(resourceID)
+		}
+	}
+	for _, record := range state.Completions {
+		add(record.ResourceID)
+	}
+	for name := range state.Semaphores {
+		add(name)
+	}
+	return resources
+}
+
+// ========== HTTP ==========
+
+// ownerOnly 集群模式下只受理本进程负责的资源
+// 其他成员的资源返回 307 重定向到 owner，正在从前任 owner 交接的资源返回 503（Retry-After: 1）
+// 批量加锁的所有资源必须属于同一个 owner（跨 owner 无法原子地授予）
+func (h *Handler) ownerOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.cluster == nil {
+			next(w, r)
+			return
+		}
+		resourceIDs := requestResourceIDs(r)
+		if len(resourceIDs) == 0 {
+			// 缺少资源ID：交给处理函数返回 400
+			next(w, r)
+			return
+		}
+
+		owner, migratingFrom := h.cluster.route(resourceIDs[0])
+		for _, resourceID := range resourceIDs[1:] {
+			other, from := h.cluster.route(resourceID)
+			if other.ID != owner.ID {
+				http.Error(w, "批量加锁的资源属于不同的服务端，集群模式下请按 owner 分别加锁", http.StatusBadRequest)
+				return
+			}
+			if migratingFrom == "" {
+				migratingFrom = from
+			}
+		}
+
+		if owner.ID != h.cluster.ID() {
+			http.Redirect(w, r, owner.URL+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+		if migratingFrom != "" {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "资源正在从 "+migratingFrom+" 迁移，请稍后重试", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requestResourceIDs 请求涉及的资源ID：查询参数或请求体中的 resource_id（信号量为 name），批量加锁为每个资源的 resource_id
+// 读取后恢复请求体，处理函数可以再次解析
+func requestResourceIDs(r *http.Request) []string {
+	if r.Method == http.MethodGet {
+		for _, param := range []string{"resource_id", "name"} {
+			if value := r.URL.Query().Get(param); value != "" {
+				return []string{value}
+			}
+		}
+		return nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	var keyed struct {
+		ResourceID string `json:"resource_id"`
+		Name       string `json:"name"`
+		Resources  []*struct {
+			ResourceID string `json:"resource_id"`
+		} `json:"resources"`
+	}
+	if err := json.Unmarshal(body, &keyed); err != nil {
+		return nil
+	}
+	var resourceIDs []string
+	for _, resource := range keyed.Resources {
+		if resource != nil && resource.ResourceID != "" {
+			resourceIDs = append(resourceIDs, resource.ResourceID)
+		}
+	}
+	switch {
+	case len(resourceIDs) > 0:
+		return resourceIDs
+	case keyed.ResourceID != "":
+		return []string{keyed.ResourceID}
+	case keyed.Name != "":
+		return []string{keyed.Name}
+	}
+	return nil
+}
+
+// RegisterRoutes 注册集群路由：环查询和变更、资源交接
+func (c *Cluster) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/cluster/ring", c.serveRing).Methods("GET")
+	// 启用 mTLS 时只接受证书身份为成员ID的调用方（管理员修改环时同样使用成员的证书）
+	router.HandleFunc("/cluster/ring", peerOnly(c.memberIDs, c.serveUpdateRing)).Methods("POST")
+	router.HandleFunc("/cluster/handoff", peerOnly(c.memberIDs, c.serveHandoff)).Methods("POST")
+}
+
+// memberIDs 启动配置、当前环和上一个环中的成员ID（被移出环的前任 owner 仍需交接资源）
+func (c *Cluster) memberIDs() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var ids []string
+	for id := range c.config.Members {
+		ids = append(ids, id)
+	}
+	for _, ring := range []*HashRing{c.ring, c.previous} {
+		if ring == nil {
+			continue
+		}
+		for _, member := range ring.Members {
+			ids = append(ids, member.ID)
+		}
+	}
+	return ids
+}
+
+// serveRing 返回当前的环（客户端据此直接请求 owner）
+func (c *Cluster) serveRing(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Ring())
+}
+
+// serveUpdateRing 修改环成员：切换到新的环并广播给新旧所有成员
+func (c *Cluster) serveUpdateRing(w http.ResponseWriter, r *http.Request) {
+	var request RingUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+	if len(request.Members) == 0 {
+		http.Error(w, "缺少必要参数: members", http.StatusBadRequest)
+		return
+	}
+
+	previous := c.Ring()
+	version := request.Version
+	if version == 0 {
+		version = previous.Version + 1
+	}
+	ring, changed, err := c.adoptRing(version, request.Members, request.VirtualNodes, request.Previous)
+	if err != nil {
+		http.Error(w, "无效的成员配置: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !changed && !request.Forwarded {
+		http.Error(w, fmt.Sprintf("环版本 %d 不比当前版本 %d 新", version, ring.Version), http.StatusConflict)
+		return
+	}
+	if changed && !request.Forwarded {
+		c.broadcastRing(ring, previous)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ring)
+}
+
+// serveHandoff 接收前任 owner 交接的资源状态
+func (c *Cluster) serveHandoff(w http.ResponseWriter, r *http.Request) {
+	var request HandoffRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+	if request.From == "" {
+		http.Error(w, "缺少必要参数: from", http.StatusBadRequest)
+		return
+	}
+
+	merged, err := c.receiveHandoff(&request)
+	if err != nil {
+		http.Error(w, "合并交接状态失败: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if merged > 0 {
+		log.Printf("[Cluster] 收到交接: from=%s, 持有者数量=%d", request.From, merged)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"merged": merged})
+}
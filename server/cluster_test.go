@@ -0,0 +1,273 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// testMember 进程内的一个集群成员
+type testMember struct {
+	lm      *LockManager
+	cluster *Cluster
+	server  *httptest.Server
+}
+
+// newTestClusterMembers 创建并启动 ids 对应的成员，初始环包含 ringIDs（为空时为全部成员）
+func newTestClusterMembers(t *testing.T, ids []string, ringIDs ...string) map[string]*testMember {
+	t.Helper()
+	members := make(map[string]*testMember)
+	urls := make(map[string]string)
+	for _, id := range ids {
+		server := httptest.NewUnstartedServer(nil)
+		members[id] = &testMember{server: server}
+		urls[id] = "http://" + server.Listener.Addr().String()
+	}
+	if len(ringIDs) == 0 {
+		ringIDs = ids
+	}
+	ringMembers := make(map[string]string)
+	for _, id := range ringIDs {
+		ringMembers[id] = urls[id]
+	}
+
+	for _, id := range ids {
+		member := members[id]
+		member.lm = NewLockManager(true)
+		initial := ringMembers
+		if _, inRing := ringMembers[id]; !inRing {
+			// 尚未加入环的成员只认识自己
+			initial = map[string]string{id: urls[id]}
+		}
+		cluster, err := NewCluster(ClusterConfig{ID: id, Members: initial, HandoffTimeout: 2 * time.Second}, member.lm)
+		if err != nil {
+			t.Fatalf("创建集群成员失败: %v", err)
+		}
+		member.cluster = cluster
+		router := mux.NewRouter()
+		NewClusteredHandler(member.lm, cluster).RegisterRoutes(router)
+		member.server.Config.Handler = router
+		member.server.Start()
+		t.Cleanup(member.server.Close)
+	}
+	return members
+}
+
+// resourceOwnedBy 找一个哈希环上属于 owner 的资源ID
+func resourceOwnedBy(t *testing.T, ring *HashRing, owner, prefix string) string {
+	t.Helper()
+	for i := 0; i < 10000; i++ {
+		resourceID := fmt.Sprintf("%s%d", prefix, i)
+		if ring.Owner(resourceID).ID == owner {
+			return resourceID
+		}
+	}
+	t.Fatalf("找不到属于 %s 的资源", owner)
+	return ""
+}
+
+// TestHashRingOwnership 测试环的划分是确定的、大致均衡，并且增加成员时只有移到新成员的资源换 owner
+func TestHashRingOwnership(t *testing.T) {
+	members := map[string]string{"lock-1": "http://a", "lock-2": "http://b", "lock-3": "http://c"}
+	ring, err := NewHashRing(1, members, 0)
+	if err != nil {
+		t.Fatalf("创建环失败: %v", err)
+	}
+
+	// 客户端（client.HashRing）使用相同的向量，两边的算法必须保持一致
+	for _, expected := range []struct{ resourceID, owner string }{
+		{"sha256:a", "lock-2"},
+		{"sha256:b", "lock-3"},
+		{"sha256:d", "lock-1"},
+		{"global", "lock-3"},
+	} {
+		if owner := ring.Owner(expected.resourceID).ID; owner != expected.owner {
+			t.Errorf("%s 的 owner 应为 %s，实际 %s", expected.resourceID, expected.owner, owner)
+		}
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		counts[ring.Owner(fmt.Sprintf("sha256:%d", i)).ID]++
+	}
+	for id := range members {
+		if counts[id] < 600 {
+			t.Errorf("资源分布不均匀: %v", counts)
+		}
+	}
+
+	members["lock-4"] = "http://d"
+	grown, _ := NewHashRing(2, members, 0)
+	for i := 0; i < 3000; i++ {
+		resourceID := fmt.Sprintf("sha256:%d", i)
+		if before, after := ring.Owner(resourceID).ID, grown.Owner(resourceID).ID; before != after && after != "lock-4" {
+			t.Fatalf("增加成员后 %s 从 %s 移到了 %s", resourceID, before, after)
+		}
+	}
+
+	if _, err := NewHashRing(1, nil, 0); err == nil {
+		t.Error("空成员列表应返回错误")
+	}
+}
+
+// TestClusterRedirectsToOwner 测试不属于本进程的资源被重定向到 owner，跨 owner 的批量加锁被拒绝
+func TestClusterRedirectsToOwner(t *testing.T) {
+	members := newTestClusterMembers(t, []string{"lock-1", "lock-2"})
+	ring := members["lock-1"].cluster.Ring()
+	remote := resourceOwnedBy(t, ring, "lock-2", "sha256:remote-")
+	local := resourceOwnedBy(t, ring, "lock-1", "sha256:local-")
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Post(members["lock-1"].server.URL+"/lock", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"type":"pull","resource_id":%q,"node_id":"node-1"}`, remote)))
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTemporaryRedirect || resp.Header.Get("Location") != members["lock-2"].server.URL+"/lock" {
+		t.Fatalf("应重定向到 lock-2: status=%d, location=%s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	// 跟随重定向后由 owner 授予
+	status, body := postJSON(t, members["lock-1"].server.URL+"/lock", map[string]interface{}{"type": "pull", "resource_id": remote, "node_id": "node-1"})
+	if status != http.StatusOK || body["acquired"] != true {
+		t.Fatalf("跟随重定向后应获得锁: status=%d, resp=%v", status, body)
+	}
+	if members["lock-2"].lm.GetLockInfo("pull", remote) == nil || members["lock-1"].lm.GetLockInfo("pull", remote) != nil {
+		t.Error("锁应只保存在 owner lock-2 上")
+	}
+
+	status, _ = postJSON(t, members["lock-1"].server.URL+"/lock/batch", map[string]interface{}{
+		"node_id":   "node-1",
+		"resources": []map[string]string{{"type": "pull", "resource_id": local}, {"type": "pull", "resource_id": remote}},
+	})
+	if status != http.StatusBadRequest {
+		t.Errorf("跨 owner 的批量加锁应返回 400，实际 %d", status)
+	}
+
+	var served HashRing
+	if status := getJSON(t, members["lock-2"].server.URL+"/cluster/ring", &served); status != http.StatusOK || served.Version != 1 || len(served.Members) != 2 {
+		t.Errorf("环查询结果不正确: status=%d, ring=%+v", status, served)
+	}
+}
+
+// TestClusterHandoffOnRingChange 测试增加成员后持有中的锁、等待队列和 fencing token 交接给新的 owner
+func TestClusterHandoffOnRingChange(t *testing.T) {
+	members := newTestClusterMembers(t, []string{"lock-1", "lock-2", "lock-3"}, "lock-1", "lock-2")
+	urls := map[string]string{}
+	for id, member := range members {
+		urls[id] = member.server.URL
+	}
+	grown, _ := NewHashRing(2, urls, 0)
+	oldRing := members["lock-1"].cluster.Ring()
+	// 之前属于 lock-1、加入 lock-3 之后属于 lock-3 的资源
+	var resourceID string
+	for i := 0; i < 10000 && resourceID == ""; i++ {
+		candidate := fmt.Sprintf("sha256:moved-%d", i)
+		if oldRing.Owner(candidate).ID == "lock-1" && grown.Owner(candidate).ID == "lock-3" {
+			resourceID = candidate
+		}
+	}
+
+	holder := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	members["lock-1"].lm.TryLock(holder)
+	waiter := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2"}
+	members["lock-1"].lm.TryLock(waiter)
+
+	status, _ := postJSON(t, urls["lock-1"]+"/cluster/ring", map[string]interface{}{"members": urls})
+	if status != http.StatusOK {
+		t.Fatalf("修改环失败: status=%d", status)
+	}
+	waitFor(t, "lock-3 收到交接", func() bool {
+		return members["lock-3"].lm.GetLockInfo(OperationTypePull, resourceID) != nil
+	})
+	for id, member := range members {
+		if version := member.cluster.Ring().Version; version != 2 {
+			t.Errorf("%s 的环版本应为2，实际 %d", id, version)
+		}
+	}
+	if members["lock-1"].lm.GetLockInfo(OperationTypePull, resourceID) != nil {
+		t.Error("交接后前任 owner 不应再保存该锁")
+	}
+	if n := members["lock-3"].lm.GetQueueLength(OperationTypePull, resourceID); n != 1 {
+		t.Errorf("等待队列应随锁交接，实际长度 %d", n)
+	}
+
+	// 持有者用原来的 fencing token 向任意成员解锁（被重定向到新的 owner），锁交给等待者
+	waitFor(t, "lock-3 受理交接来的资源", func() bool {
+		_, from := members["lock-3"].cluster.route(resourceID)
+		return from == ""
+	})
+	status, body := postJSON(t, urls["lock-2"]+"/unlock", map[string]interface{}{
+		"type": "pull", "resource_id": resourceID, "node_id": "node-1", "fencing_token": holder.FencingToken, "error": "failed",
+	})
+	if status != http.StatusOK || body["released"] != true {
+		t.Fatalf("交接后持有者应能解锁: status=%d, resp=%v", status, body)
+	}
+	lockInfo := members["lock-3"].lm.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.SessionID != waiter.SessionID || lockInfo.FencingToken <= holder.FencingToken {
+		t.Errorf("锁应交给等待者且 fencing token 继续递增: %+v", lockInfo)
+	}
+
+	if status, _ := postJSON(t, urls["lock-2"]+"/cluster/ring", map[string]interface{}{"version": 1, "members": urls}); status != http.StatusConflict {
+		t.Errorf("旧版本的环应返回 409，实际 %d", status)
+	}
+}
+
+// TestMergeGrantsQueueWithoutHolder 测试交接来的队列所在的key没有持有者时，合并后立即把锁分配给队头并通知
+func TestMergeGrantsQueueWithoutHolder(t *testing.T) {
+	previous := NewLockManager(true)
+	resourceID := "sha256:merge-orphan"
+	holder := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	previous.TryLock(holder)
+	waiter := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2"}
+	previous.TryLock(waiter)
+	snapshot := previous.extractResources(func(string) bool { return false })
+	// 持有者在交接期间释放：只交接了等待队列
+	delete(snapshot.Locks, LockKey(OperationTypePull, resourceID))
+
+	lm := NewLockManager(true)
+	sub := &mockSubscriber{events: make([]OperationEvent, 0)}
+	lm.Subscribe(OperationTypePull, resourceID, sub)
+	lm.mergeResources(snapshot)
+
+	lockInfo := lm.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.SessionID != waiter.SessionID || lockInfo.FencingToken <= holder.FencingToken {
+		t.Fatalf("合并后锁应分配给队头且 fencing token 继续递增: %+v", lockInfo)
+	}
+	if n := lm.GetQueueLength(OperationTypePull, resourceID); n != 0 {
+		t.Errorf("队头获得锁后队列应为空，实际长度 %d", n)
+	}
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if len(sub.events) != 1 || sub.events[0].Event != EventTypeLockAssigned || sub.events[0].SessionID != waiter.SessionID {
+		t.Errorf("队头应收到 lock_assigned 事件: %+v", sub.events)
+	}
+}
+
+// TestClusterWaitsForHandoff 测试新 owner 在收到前任交接之前对其资源返回 503，超时后不再等待
+func TestClusterWaitsForHandoff(t *testing.T) {
+	members := newTestClusterMembers(t, []string{"lock-1"})
+	cluster := members["lock-1"].cluster
+	cluster.config.HandoffTimeout = 200 * time.Millisecond
+
+	// lock-2 已经宕机，无法交接
+	withDead := map[string]string{"lock-1": members["lock-1"].server.URL, "lock-2": "http://127.0.0.1:1"}
+	cluster.UpdateRing(2, withDead, 0)
+	cluster.UpdateRing(3, map[string]string{"lock-1": members["lock-1"].server.URL}, 0)
+	resourceID := resourceOwnedBy(t, cluster.previous, "lock-2", "sha256:orphan-")
+
+	status, _ := postJSON(t, members["lock-1"].server.URL+"/lock", map[string]interface{}{"type": "pull", "resource_id": resourceID, "node_id": "node-1"})
+	if status != http.StatusServiceUnavailable {
+		t.Fatalf("等待交接期间应返回 503，实际 %d", status)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if status, body := postJSON(t, members["lock-1"].server.URL+"/lock", map[string]interface{}{"type": "pull", "resource_id": resourceID, "node_id": "node-1"}); status != http.StatusOK || body["acquired"] != true {
+		t.Errorf("等待超时后应受理请求: status=%d, resp=%v", status, body)
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CompatibilityMatrix 同一资源上不同操作类型之间的兼容关系
+// key 为受矩阵约束的操作类型，matrix[a][b] 为 true 表示 a 和 b 可以在同一资源上并发执行
+//
+// 受约束的不同类型之间默认互斥：冲突的请求进入自己 type:resource 的等待队列，
+// 资源上冲突的操作结束后再按请求顺序分配锁。
+// 同一类型的请求不经过矩阵：仍按 type:resource 的锁和队列合并（例如多个节点拉取同一层只有一个节点下载）。
+// 不在矩阵中的操作类型不参与跨类型互斥。
+type CompatibilityMatrix map[string]map[string]bool
+
+// DefaultCompatibilityMatrix 默认兼容矩阵
+//   - pull 与 update 可以并发（热更新不影响正在拉取的节点）
+//   - delete 与其他所有操作互斥
+//   - update 与 delete 互斥
+func DefaultCompatibilityMatrix() CompatibilityMatrix {
+	return CompatibilityMatrix{
+		OperationTypePull:   {OperationTypeUpdate: true},
+		OperationTypeUpdate: {OperationTypePull: true},
+		OperationTypeDelete: {},
+	}
+}
+
+// ParseCompatibilityMatrix 解析兼容矩阵配置
+// 格式：逗号分隔的兼容类型对，例如 "pull+update"；"none" 表示所有操作类型两两互斥
+// pull、update、delete 总是受矩阵约束，配置中出现的其他类型也会加入矩阵
+func ParseCompatibilityMatrix(spec string) (CompatibilityMatrix, error) {
+	matrix := CompatibilityMatrix{
+		OperationTypePull:   {},
+		OperationTypeUpdate: {},
+		OperationTypeDelete: {},
+	}
+	spec = strings.TrimSpace(spec)
+	if spec == "none" {
+		return matrix, nil
+	}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		a, b, ok := strings.Cut(item, "+")
+		a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+		if !ok || a == "" || b == "" {
+			return nil, fmt.Errorf("无效的兼容类型对: %q（格式应为 a+b）", item)
+		}
+		if a == b {
+			return nil, fmt.Errorf("无效的兼容类型对: %q（同一类型的请求总是合并处理）", item)
+		}
+		for _, lockType := range []string{a, b} {
+			if _, exists := matrix[lockType]; !exists {
+				matrix[lockType] = make(map[string]bool)
+			}
+		}
+		matrix[a][b] = true
+		matrix[b][a] = true
+	}
+	return matrix, nil
+}
+
+// Conflicts 判断两个操作类型在同一资源上是否互斥（同一类型不算冲突，由 type:resource 的队列合并处理）
+func (m CompatibilityMatrix) Conflicts(a, b string) bool {
+	if a == b {
+		return false
+	}
+	rowA, governedA := m[a]
+	rowB, governedB := m[b]
+	if !governedA || !governedB {
+		return false
+	}
+	return !rowA[b] && !rowB[a]
+}
+
+// types 受矩阵约束的操作类型（排序后返回，保证遍历顺序确定，复制模式下各副本结果一致）
+func (m CompatibilityMatrix) types() []string {
+	types := make([]string, 0, len(m))
+	for lockType := range m {
+		types = append(types, lockType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// String 以 ParseCompatibilityMatrix 的格式输出兼容类型对
+func (m CompatibilityMatrix) String() string {
+	var pairs []string
+	types := m.types()
+	for i, a := range types {
+		for _, b := range types[i+1:] {
+			if !m.Conflicts(a, b) {
+				pairs = append(pairs, a+"+"+b)
+			}
+		}
+	}
+	if len(pairs) == 0 {
+		return "none"
+	}
+	return strings.Join(pairs, ",")
+}
+
+// blockingLockLocked 返回阻止 request 立即获得锁的锁（没有则返回nil）
+//   - 同一个key已经有独占持有者（例如在读取锁状态之后被分配给了因类型冲突而等待的请求）
+//   - 独占请求：同一个key有共享持有者；共享请求：同一个key有共享持有者在等待升级
+//   - 同一资源上有与 request 类型冲突的操作正在进行（无论独占还是共享）
+//
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) blockingLockLocked(shard *resourceShard, request *LockRequest) *LockInfo {
+	key := LockKey(request.Type, request.ResourceID)
+	if lockInfo, exists := shard.locks[key]; exists {
+		return lockInfo
+	}
+	if request.shared() {
+		if nodeID, pending := shard.upgrades[key]; pending {
+			return shard.shared[key][nodeID]
+		}
+	} else if holder := firstSharedHolder(shard.shared[key]); holder != nil {
+		return holder
+	}
+
+	for _, otherType := range lm.Compatibility.types() {
+		if !lm.Compatibility.Conflicts(request.Type, otherType) {
+			continue
+		}
+		otherKey := LockKey(otherType, request.ResourceID)
+		if lockInfo, exists := shard.locks[otherKey]; exists {
+			return lockInfo
+		}
+		if holder := firstSharedHolder(shard.shared[otherKey]); holder != nil {
+			return holder
+		}
+	}
+	return nil
+}
+
+// dispatchBlockedLocked 资源上的操作结束后，把锁分配给因类型冲突而等待的请求
+// 按各队列队头的请求时间先后分配，已分配的锁会阻止后续冲突的队头（例如 delete 先到则 pull 继续等待）
+// skipKey：不处理的key（操作成功完成的key，其队列中的节点通过订阅事件自行重新检查资源）
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) dispatchBlockedLocked(shard *resourceShard, resourceID string, skipKey string) {
+	var candidates []string
+	for _, lockType := range lm.Compatibility.types() {
+		key := LockKey(lockType, resourceID)
+		if key == skipKey {
+			continue
+		}
+		if lm.pruneQueueLocked(shard, key, lm.now()); len(shard.queues[key]) == 0 {
+			continue
+		}
+		if _, held := shard.locks[key]; held {
+			continue
+		}
+		if len(shard.shared[key]) > 0 && !shard.queues[key][0].shared() {
+			continue
+		}
+		candidates = append(candidates, key)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return shard.queues[candidates[i]][0].Timestamp.Before(shard.queues[candidates[j]][0].Timestamp)
+	})
+
+	for _, key := range candidates {
+		head := shard.queues[key][0]
+		if blocking := lm.blockingLockLocked(shard, head); blocking != nil {
+			continue
+		}
+
+		log.Printf("[dispatchBlocked] 冲突操作已结束，分配锁给等待中的请求: key=%s, node=%s", key, head.NodeID)
+		// 资源锁可能已在该key上一次操作成功时被删除
+		if _, exists := shard.resourceLocks[key]; !exists {
+			shard.resourceLocks[key] = &sync.Mutex{}
+		}
+		for _, sessionID := range lm.processQueue(shard, key) {
+			lm.notifyLockAssigned(shard, key, sessionID)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import "testing"
+
+// TestDeleteWaitsForInFlightPull 测试同一资源上 pull 进行中时 delete 排队，pull 完成后 delete 获得锁
+func TestDeleteWaitsForInFlightPull(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:matrix1"
+
+	sub := &mockSubscriber{events: make([]OperationEvent, 0)}
+	lm.Subscribe(OperationTypeDelete, resourceID, sub)
+
+	pullReq := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	if acquired, _, _ := lm.TryLock(pullReq); !acquired {
+		t.Fatal("node-1 应该获得pull锁")
+	}
+
+	// delete 与 pull 互斥：排队等待
+	acquired, _, errMsg := lm.TryLock(&LockRequest{Type: OperationTypeDelete, ResourceID: resourceID, NodeID: "node-2"})
+	if acquired || errMsg != "" {
+		t.Fatalf("pull 进行中 delete 应该排队，实际 acquired=%v, error=%s", acquired, errMsg)
+	}
+	if n := lm.GetQueueLength(OperationTypeDelete, resourceID); n != 1 {
+		t.Fatalf("delete 队列长度应为1，实际 %d", n)
+	}
+
+	// pull 成功完成后 delete 获得锁
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", FencingToken: pullReq.FencingToken})
+	lockInfo := lm.GetLockInfo(OperationTypeDelete, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-2" {
+		t.Fatalf("pull 完成后 delete 应分配给 node-2，实际 %+v", lockInfo)
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if len(sub.events) != 1 || sub.events[0].Event != EventTypeLockAssigned || sub.events[0].NodeID != "node-2" {
+		t.Errorf("delete 订阅者应收到分配给 node-2 的 lock_assigned 事件，实际 %+v", sub.events)
+	}
+}
+
+// TestDeleteExclusiveAgainstPullAndUpdate 测试 delete 进行中 pull 和 update 都排队，pull 与 update 可以并发
+func TestDeleteExclusiveAgainstPullAndUpdate(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:matrix2"
+
+	deleteReq := &LockRequest{Type: OperationTypeDelete, ResourceID: resourceID, NodeID: "node-1"}
+	if acquired, _, _ := lm.TryLock(deleteReq); !acquired {
+		t.Fatal("node-1 应该获得delete锁")
+	}
+
+	for _, req := range []*LockRequest{
+		{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2"},
+		{Type: OperationTypeUpdate, ResourceID: resourceID, NodeID: "node-3"},
+		{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-4"},
+	} {
+		if acquired, _, _ := lm.TryLock(req); acquired {
+			t.Fatalf("delete 进行中 %s(%s) 不应该获得锁", req.Type, req.NodeID)
+		}
+	}
+
+	// delete 失败：pull 队头和 update 队头都获得锁（pull 与 update 兼容），第二个 pull 仍合并在 pull 队列中
+	lm.Unlock(&UnlockRequest{Type: OperationTypeDelete, ResourceID: resourceID, NodeID: "node-1",
+		FencingToken: deleteReq.FencingToken, Error: "删除失败"})
+
+	if lockInfo := lm.GetLockInfo(OperationTypePull, resourceID); lockInfo == nil || lockInfo.Request.NodeID != "node-2" {
+		t.Errorf("期望 node-2 获得pull锁，实际 %+v", lockInfo)
+	}
+	if lockInfo := lm.GetLockInfo(OperationTypeUpdate, resourceID); lockInfo == nil || lockInfo.Request.NodeID != "node-3" {
+		t.Errorf("期望 node-3 获得update锁，实际 %+v", lockInfo)
+	}
+	if n := lm.GetQueueLength(OperationTypePull, resourceID); n != 1 {
+		t.Errorf("node-4 应继续在pull队列中等待，实际队列长度 %d", n)
+	}
+	if lm.GetLockInfo(OperationTypeDelete, resourceID) != nil {
+		t.Error("delete 锁应该已释放")
+	}
+
+	// pull 和 update 进行中时新的 delete 排队
+	if acquired, _, _ := lm.TryLock(&LockRequest{Type: OperationTypeDelete, ResourceID: resourceID, NodeID: "node-5"}); acquired {
+		t.Error("pull/update 进行中 delete 不应该获得锁")
+	}
+}
+
+// TestUpdateWaitsForDeleteInQueueOrder 测试冲突操作结束后按请求先后分配锁
+func TestUpdateWaitsForDeleteInQueueOrder(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:matrix3"
+
+	updateReq := &LockRequest{Type: OperationTypeUpdate, ResourceID: resourceID, NodeID: "node-1"}
+	lm.TryLock(updateReq)
+	// 先到的 delete 排队，后到的 pull 与 update 兼容，直接获得锁
+	lm.TryLock(&LockRequest{Type: OperationTypeDelete, ResourceID: resourceID, NodeID: "node-2"})
+	pullReq := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-3"}
+	if acquired, _, _ := lm.TryLock(pullReq); !acquired {
+		t.Fatal("pull 与 update 兼容，应该直接获得锁")
+	}
+
+	// update 完成后 pull 仍在进行，delete 继续等待
+	lm.Unlock(&UnlockRequest{Type: OperationTypeUpdate, ResourceID: resourceID, NodeID: "node-1", FencingToken: updateReq.FencingToken})
+	if lm.GetLockInfo(OperationTypeDelete, resourceID) != nil {
+		t.Fatal("pull 仍在进行，delete 不应该获得锁")
+	}
+
+	// pull 也完成后 delete 获得锁
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-3", FencingToken: pullReq.FencingToken})
+	lockInfo := lm.GetLockInfo(OperationTypeDelete, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-2" {
+		t.Fatalf("期望 node-2 获得delete锁，实际 %+v", lockInfo)
+	}
+
+	// 分配给等待者的锁可以正常释放
+	if !lm.Unlock(&UnlockRequest{Type: OperationTypeDelete, ResourceID: resourceID, NodeID: "node-2", FencingToken: lockInfo.FencingToken}) {
+		t.Error("node-2 应该能释放delete锁")
+	}
+}
+
+// TestConflictWithoutMultiNodeDownload 测试关闭多节点下载时冲突的请求直接返回失败
+func TestConflictWithoutMultiNodeDownload(t *testing.T) {
+	lm := NewLockManager(false)
+	resourceID := "sha256:matrix4"
+
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"})
+	acquired, _, errMsg := lm.TryLock(&LockRequest{Type: OperationTypeDelete, ResourceID: resourceID, NodeID: "node-2"})
+	if acquired || errMsg == "" {
+		t.Errorf("期望返回冲突错误，实际 acquired=%v, error=%q", acquired, errMsg)
+	}
+	if n := lm.GetQueueLength(OperationTypeDelete, resourceID); n != 0 {
+		t.Errorf("关闭多节点下载时不应加入队列，实际队列长度 %d", n)
+	}
+}
+
+// TestParseCompatibilityMatrix 测试兼容矩阵配置解析
+func TestParseCompatibilityMatrix(t *testing.T) {
+	matrix, err := ParseCompatibilityMatrix("none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !matrix.Conflicts(OperationTypePull, OperationTypeUpdate) {
+		t.Error("none：pull 与 update 应该互斥")
+	}
+	if matrix.Conflicts(OperationTypePull, OperationTypePull) {
+		t.Error("同一类型不应视为冲突")
+	}
+
+	matrix, err = ParseCompatibilityMatrix("pull+update, pull+delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matrix.Conflicts(OperationTypeDelete, OperationTypePull) || !matrix.Conflicts(OperationTypeDelete, OperationTypeUpdate) {
+		t.Errorf("解析结果不正确: %s", matrix)
+	}
+	if got := DefaultCompatibilityMatrix().String(); got != "pull+update" {
+		t.Errorf("默认矩阵应为 pull+update，实际 %s", got)
+	}
+
+	for _, spec := range []string{"pull", "pull+", "pull+pull"} {
+		if _, err := ParseCompatibilityMatrix(spec); err == nil {
+			t.Errorf("%q 应该解析失败", spec)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"log"
+	"time"
+)
+
+// 完成记录
+//
+// 操作成功后 Unlock 删除锁和资源锁，稍后才请求的节点会重新获得锁、重复下载同一个镜像层。
+// 因此成功完成的独占操作会在所在分段保留一条完成记录（谁完成、何时完成、可选的结果），
+// 在过期（CompletionTTL）或被显式作废（/lock/invalidate）之前，TryLock 直接返回 skip。
+// 记录数量有上限（MaxCompletions，按分段平均分配），超出时淘汰该分段中最早的记录。
+
+const (
+	// DefaultCompletionTTL 完成记录的默认保留时长
+	DefaultCompletionTTL = 10 * time.Minute
+
+	// DefaultMaxCompletions 默认最多保留的完成记录数量
+	DefaultMaxCompletions = 10000
+)
+
+// CompletionRecord 一次成功完成的操作
+type CompletionRecord struct {
+	Type         string    `json:"type"`
+	ResourceID   string    `json:"resource_id"`
+	NodeID       string    `json:"node_id"`             // 完成操作的节点
+	SessionID    string    `json:"session_id"`          // 完成操作的会话
+	FencingToken uint64    `json:"fencing_token"`       // 完成操作时持有的token
+	CompletedAt  time.Time `json:"completed_at"`        // 完成时间
+	ExpiresAt    time.Time `json:"expires_at"`          // 过期时间，之后的请求重新获得锁
+	Result       string    `json:"result,omitempty"`    // 持有者解锁时携带的操作结果（可选）
+	PeerAddr     string    `json:"peer_addr,omitempty"` // 持有者的 blob 服务地址（可选），跳过操作的节点从这里拉取
+}
+
+// InvalidateRequest 作废完成记录请求（例如镜像层被删除或发现损坏后，需要重新执行操作）
+type InvalidateRequest struct {
+	Type       string `json:"type"`        // 为空时作废该资源上所有操作类型的记录
+	ResourceID string `json:"resource_id"` // 镜像层的digest
+	NodeID     string `json:"node_id,omitempty"`
+}
+
+// recordCompletionLocked 保存一条完成记录（CompletionTTL <= 0 时不保存）
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) recordCompletionLocked(shard *resourceShard, key string, lockInfo *LockInfo, unlock *UnlockRequest, now time.Time) {
+	if lm.CompletionTTL <= 0 {
+		return
+	}
+	request := lockInfo.Request
+	record := &CompletionRecord{
+		Type:         request.Type,
+		ResourceID:   request.ResourceID,
+		NodeID:       request.NodeID,
+		SessionID:    request.SessionID,
+		FencingToken: lockInfo.FencingToken,
+		CompletedAt:  now,
+		ExpiresAt:    now.Add(lm.CompletionTTL),
+		Result:       unlock.Result,
+		PeerAddr:     unlock.PeerAddr,
+	}
+	lm.storeCompletionLocked(shard, key, record)
+	lm.appendWAL(&WALRecord{Op: WALOpComplete, Type: request.Type, ResourceID: request.ResourceID, Completion: record})
+}
+
+// storeCompletionLocked 写入完成记录（也用于重放WAL）
+// 同一资源上与之冲突的操作类型的记录被作废：例如 delete 完成后，之前的 pull 结果已不再成立
+// 超出分段上限时先清理过期记录，仍超出则淘汰最早的记录
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) storeCompletionLocked(shard *resourceShard, key string, record *CompletionRecord) {
+	delete(shard.completions, key)
+	for otherKey, other := range shard.completions {
+		if other.ResourceID == record.ResourceID && lm.Compatibility.Conflicts(record.Type, other.Type) {
+			delete(shard.completions, otherKey)
+		}
+	}
+
+	limit := lm.completionsPerShard()
+	if len(shard.completions) >= limit {
+		for otherKey, other := range shard.completions {
+			if !record.CompletedAt.Before(other.ExpiresAt) {
+				delete(shard.completions, otherKey)
+			}
+		}
+	}
+	for len(shard.completions) >= limit {
+		oldestKey := ""
+		for otherKey, other := range shard.completions {
+			oldest := shard.completions[oldestKey]
+			if oldest == nil || other.CompletedAt.Before(oldest.CompletedAt) ||
+				(other.CompletedAt.Equal(oldest.CompletedAt) && otherKey < oldestKey) {
+				oldestKey = otherKey
+			}
+		}
+		delete(shard.completions, oldestKey)
+	}
+	shard.completions[key] = record
+}
+
+// completionsPerShard 每个分段最多保留的完成记录数量
+func (lm *LockManager) completionsPerShard() int {
+	if lm.MaxCompletions <= 0 {
+		return 1
+	}
+	return (lm.MaxCompletions + shardCount - 1) / shardCount
+}
+
+// completionLocked 返回key上仍然有效的完成记录（过期的记录被删除）
+// 注意：调用此函数时，shard.mu 必须已经加锁（写锁）
+func (lm *LockManager) completionLocked(shard *resourceShard, key string, now time.Time) *CompletionRecord {
+	record, exists := shard.completions[key]
+	if !exists {
+		return nil
+	}
+	if !now.Before(record.ExpiresAt) {
+		delete(shard.completions, key)
+		return nil
+	}
+	return record
+}
+
+// GetCompletion 查询key上仍然有效的完成记录（没有时返回nil）
+func (lm *LockManager) GetCompletion(lockType, resourceID string) *CompletionRecord {
+	key := LockKey(lockType, resourceID)
+	shard := lm.getShard(resourceID) // 获取对应的分段（只根据resourceID分段）
+
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	record, exists := shard.completions[key]
+	if !exists || !lm.now().Before(record.ExpiresAt) {
+		return nil
+	}
+	recordCopy := *record
+	return &recordCopy
+}
+
+// InvalidateCompletion 作废资源上的完成记录，之后的请求重新获得锁并执行操作
+// 返回：作废的记录数量
+func (lm *LockManager) InvalidateCompletion(request *InvalidateRequest) int {
+	shard := lm.getShard(request.ResourceID)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	invalidated := 0
+	for key, record := range shard.completions {
+		if record.ResourceID != request.ResourceID || (request.Type != "" && record.Type != request.Type) {
+			continue
+		}
+		delete(shard.completions, key)
+		invalidated++
+	}
+	if invalidated > 0 {
+		log.Printf("[InvalidateCompletion] 作废完成记录: type=%s, resource_id=%s, node=%s, 数量=%d",
+			request.Type, request.ResourceID, request.NodeID, invalidated)
+		lm.appendWAL(&WALRecord{Op: WALOpInvalidate, Type: request.Type, ResourceID: request.ResourceID, NodeID: request.NodeID})
+	}
+	return invalidated
+}
+
+// skipCompletedLocked 独占请求到达时key上有有效的完成记录：调用方跳过操作
+// 会话仍在该key的等待队列中时（等待的操作已由其他节点完成）移出队列，避免之后把锁分配给已经离开的会话
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) skipCompletedLocked(shard *resourceShard, key string, request *LockRequest) bool {
+	if request.shared() {
+		// 共享请求需要实际持有锁（例如使用期间阻止删除）
+		return false
+	}
+	if _, held := shard.locks[key]; held {
+		return false
+	}
+	record := lm.completionLocked(shard, key, request.Timestamp)
+	if record == nil {
+		return false
+	}
+
+	queue := shard.queues[key]
+	for i, queued := range queue {
+		if queued.SessionID != request.SessionID {
+			continue
+		}
+		shard.queues[key] = append(queue[:i:i], queue[i+1:]...)
+		if len(shard.queues[key]) == 0 {
+			delete(shard.queues, key)
+		}
+		lm.appendWAL(&WALRecord{Op: WALOpDequeue, Type: queued.Type, ResourceID: queued.ResourceID, Request: queued})
+		traceQueueWait(queued, queueOutcomeCompleted, lm.now())
+		break
+	}
+	log.Printf("[TryLock] 操作已由其他节点完成，跳过: key=%s, node=%s, 完成节点=%s, 完成时间=%s",
+		key, request.NodeID, record.NodeID, record.CompletedAt.Format(time.RFC3339))
+	return true
+}
+
+// dropCompletedWaitersLocked 操作成功后把该key上排队的独占请求移出队列：它们等待的操作已经完成，
+// 之后重新请求时由完成记录返回 skip（共享请求仍然排队，需要实际持有锁）
+// 注意：调用此函数时，资源锁和 shard.mu 都必须已经加锁
+func (lm *LockManager) dropCompletedWaitersLocked(shard *resourceShard, key string) {
+	queue := shard.queues[key]
+	remaining := queue[:0:0]
+	for _, queued := range queue {
+		if queued.shared() {
+			remaining = append(remaining, queued)
+			continue
+		}
+		lm.appendWAL(&WALRecord{Op: WALOpDequeue, Type: queued.Type, ResourceID: queued.ResourceID, Request: queued})
+		traceQueueWait(queued, queueOutcomeCompleted, lm.now())
+	}
+	if len(remaining) == 0 {
+		delete(shard.queues, key)
+	} else {
+		shard.queues[key] = remaining
+	}
+}
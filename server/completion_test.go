@@ -0,0 +1,155 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// completeOnce 获得锁并成功释放（可选携带结果）
+func completeOnce(t *testing.T, lm *LockManager, lockType, resourceID, nodeID, result string) {
+	t.Helper()
+	request := &LockRequest{Type: lockType, ResourceID: resourceID, NodeID: nodeID}
+	if acquired, _, _ := lm.TryLock(request); !acquired {
+		t.Fatalf("%s 应该获得 %s:%s 的锁", nodeID, lockType, resourceID)
+	}
+	lm.Unlock(&UnlockRequest{Type: lockType, ResourceID: resourceID, NodeID: nodeID, FencingToken: request.FencingToken, Result: result})
+}
+
+// TestCompletionSkipsLateArrivals 测试操作成功后排队的和之后到达的请求都跳过操作，记录过期后重新获得锁
+func TestCompletionSkipsLateArrivals(t *testing.T) {
+	lm := NewLockManager(true)
+	now := time.Now()
+	lm.clock = func() time.Time { return now }
+
+	holder := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:done", NodeID: "node-1"}
+	lm.TryLock(holder)
+	waiter := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:done", NodeID: "node-2"}
+	lm.TryLock(waiter)
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: "sha256:done", NodeID: "node-1",
+		FencingToken: holder.FencingToken, Result: "/layers/done"})
+
+	if n := lm.GetQueueLength(OperationTypePull, "sha256:done"); n != 0 {
+		t.Errorf("操作成功后排队的独占请求应被移出队列，实际队列长度 %d", n)
+	}
+	record := lm.GetCompletion(OperationTypePull, "sha256:done")
+	if record == nil || record.NodeID != "node-1" || record.Result != "/layers/done" || !record.ExpiresAt.Equal(now.Add(lm.CompletionTTL)) {
+		t.Fatalf("完成记录不正确: %+v", record)
+	}
+
+	status := lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:done", NodeID: "node-2", SessionID: waiter.SessionID})
+	if !status.Completed || !status.Success || status.Completion == nil {
+		t.Errorf("等待者查询状态应看到已完成: %+v", status)
+	}
+	for _, request := range []*LockRequest{
+		{Type: OperationTypePull, ResourceID: "sha256:done", NodeID: "node-2", SessionID: waiter.SessionID},
+		{Type: OperationTypePull, ResourceID: "sha256:done", NodeID: "node-3"},
+	} {
+		if acquired, skip, _ := lm.TryLock(request); acquired || !skip {
+			t.Errorf("%s 应跳过操作，acquired=%v, skip=%v", request.NodeID, acquired, skip)
+		}
+	}
+	shared := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:done", NodeID: "node-3", Mode: LockModeShared}
+	if acquired, skip, _ := lm.TryLock(shared); !acquired || skip {
+		t.Errorf("共享请求需要实际持有锁，不应跳过: acquired=%v, skip=%v", acquired, skip)
+	}
+
+	now = now.Add(lm.CompletionTTL)
+	if acquired, skip, _ := lm.TryLock(&LockRequest{Type: OperationTypeUpdate, ResourceID: "sha256:done", NodeID: "node-4"}); !acquired || skip {
+		t.Errorf("没有完成记录的操作类型应正常获得锁: acquired=%v, skip=%v", acquired, skip)
+	}
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: "sha256:done", NodeID: "node-3", FencingToken: shared.FencingToken})
+	if acquired, skip, _ := lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:done", NodeID: "node-5"}); !acquired || skip {
+		t.Errorf("完成记录过期后应重新获得锁: acquired=%v, skip=%v", acquired, skip)
+	}
+}
+
+// TestCompletionBoundAndConflicts 测试记录数量上限，以及冲突操作完成后作废之前的记录
+func TestCompletionBoundAndConflicts(t *testing.T) {
+	lm := NewLockManager(true)
+	lm.MaxCompletions = 1 // 每个分段最多1条
+
+	completeOnce(t, lm, OperationTypePull, "sha256:bound", "node-1", "")
+	completeOnce(t, lm, OperationTypeUpdate, "sha256:bound", "node-2", "")
+	if lm.GetCompletion(OperationTypePull, "sha256:bound") != nil {
+		t.Error("超出上限时应淘汰最早的记录")
+	}
+	if lm.GetCompletion(OperationTypeUpdate, "sha256:bound") == nil {
+		t.Error("最新的记录应保留")
+	}
+
+	lm.MaxCompletions = DefaultMaxCompletions
+	completeOnce(t, lm, OperationTypePull, "sha256:conflict", "node-1", "")
+	completeOnce(t, lm, OperationTypeDelete, "sha256:conflict", "node-2", "")
+	if lm.GetCompletion(OperationTypePull, "sha256:conflict") != nil {
+		t.Error("delete 完成后之前的 pull 记录应被作废")
+	}
+	if n := lm.InvalidateCompletion(&InvalidateRequest{ResourceID: "sha256:conflict"}); n != 1 {
+		t.Errorf("期望作废1条记录（delete），实际 %d", n)
+	}
+}
+
+// TestCompletionRecoveredFromWAL 测试完成记录和作废操作在重启后恢复
+func TestCompletionRecoveredFromWAL(t *testing.T) {
+	dir := t.TempDir()
+	lm, store := openTestLockManager(t, dir)
+	completeOnce(t, lm, OperationTypePull, "sha256:wal-done", "node-1", "result-1")
+	completeOnce(t, lm, OperationTypePull, "sha256:wal-invalidated", "node-1", "")
+	lm.InvalidateCompletion(&InvalidateRequest{Type: OperationTypePull, ResourceID: "sha256:wal-invalidated"})
+	store.Close()
+
+	lm2, store2 := openTestLockManager(t, dir)
+	defer store2.Close()
+	if record := lm2.GetCompletion(OperationTypePull, "sha256:wal-done"); record == nil || record.Result != "result-1" {
+		t.Errorf("重启后完成记录应恢复: %+v", record)
+	}
+	if lm2.GetCompletion(OperationTypePull, "sha256:wal-invalidated") != nil {
+		t.Error("已作废的记录重启后不应恢复")
+	}
+}
+
+// TestCompletionHTTP 测试 /lock 返回 skip 和完成记录，以及 /lock/invalidate
+func TestCompletionHTTP(t *testing.T) {
+	lm := NewLockManager(true)
+	server := newTestServer(t, lm)
+	completeOnce(t, lm, OperationTypePull, "sha256:http-done", "node-1", "/layers/http-done")
+
+	lockReq := map[string]interface{}{"type": "pull", "resource_id": "sha256:http-done", "node_id": "node-2"}
+	status, resp := postJSON(t, server.URL+"/lock", lockReq)
+	completion, _ := resp["completion"].(map[string]interface{})
+	if status != http.StatusOK || resp["skip"] != true || resp["acquired"] != false || completion["result"] != "/layers/http-done" {
+		t.Fatalf("期望跳过操作并返回完成记录: status=%d, resp=%v", status, resp)
+	}
+
+	status, resp = postJSON(t, server.URL+"/lock/invalidate", map[string]interface{}{"resource_id": "sha256:http-done"})
+	if status != http.StatusOK || resp["invalidated"] != float64(1) {
+		t.Fatalf("作废失败: status=%d, resp=%v", status, resp)
+	}
+	if _, resp = postJSON(t, server.URL+"/lock", lockReq); resp["acquired"] != true || resp["skip"] != false {
+		t.Errorf("作废后应重新获得锁: %v", resp)
+	}
+	if status, _ = postJSON(t, server.URL+"/lock/invalidate", map[string]interface{}{"type": "pull"}); status != http.StatusBadRequest {
+		t.Errorf("缺少 resource_id 应返回 400，实际 %d", status)
+	}
+}
+
+// TestCompletionCarriesPeerAddr 测试持有者解锁时携带的 blob 服务地址随 completed 事件和完成记录发给等待者
+func TestCompletionCarriesPeerAddr(t *testing.T) {
+	lm := NewLockManager(true)
+	holder := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:peer", NodeID: "node-1"}
+	lm.TryLock(holder)
+	sub := &mockSubscriber{events: make([]OperationEvent, 0)}
+	lm.Subscribe(OperationTypePull, "sha256:peer", sub)
+
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: "sha256:peer", NodeID: "node-1",
+		FencingToken: holder.FencingToken, PeerAddr: "10.0.0.1:8087"})
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if len(sub.events) != 1 || !sub.events[0].Success || sub.events[0].PeerAddr != "10.0.0.1:8087" {
+		t.Errorf("completed 事件应携带持有者地址: %+v", sub.events)
+	}
+	if record := lm.GetCompletion(OperationTypePull, "sha256:peer"); record == nil || record.PeerAddr != "10.0.0.1:8087" {
+		t.Errorf("完成记录应携带持有者地址: %+v", record)
+	}
+}
@@ -0,0 +1,428 @@
+package server
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 死锁检测
+//
+// 一个节点可能持有一个key的同时在另一个key上排队（例如先获得了层A的锁，再请求层B），
+// 每次授予都使用新的会话，所以等待图（wait-for graph）的顶点是节点（NodeID），而不是会话：
+// 边 W -> H 表示节点 W 的某个请求在某个key上排队，而阻止它获得锁的是节点 H 的请求：
+//   - 该key的独占持有者（未完成）
+//   - 独占请求：该key的共享持有者；共享请求：该key上等待升级的共享持有者
+//   - 同一资源上与该key类型冲突的持有者
+//   - 同一队列中排在前面的等待者（FIFO，两者都是共享请求时除外）
+//
+// 同一节点上请求之间的等待不加边（节点内部的并发由调用方自己协调）。
+// 图中的环就是死锁。打破死锁的策略：中止环上最年轻（排队时间最晚）的排队请求，
+// 把它的会话移出所有等待队列并发送 aborted 事件（code=deadlock），已持有的锁不受影响。
+
+const (
+	// maxDeadlockReports 保留的死锁记录数量（用于管理接口）
+	maxDeadlockReports = 100
+
+	// abortMarkTTL 中止标记的有效期：被中止的会话在此期间内重新请求该key时返回死锁错误
+	abortMarkTTL = time.Minute
+
+	// deadlockAbortMessage 被中止的等待者收到的错误信息
+	deadlockAbortMessage = "检测到死锁，等待已被中止"
+)
+
+// DeadlockEdge 等待图中的一条边：WaiterNode 的会话 WaiterSession 在 Key 上等待 HolderNode 的会话 HolderSession
+type DeadlockEdge struct {
+	WaiterSession string `json:"waiter_session"`
+	WaiterNode    string `json:"waiter_node"`
+	Key           string `json:"key"`
+	HolderSession string `json:"holder_session"`
+	HolderNode    string `json:"holder_node"`
+
+	queued bool // 阻止者是排在前面的等待者（而不是持有者）
+}
+
+// DeadlockReport 一次检测到的死锁
+type DeadlockReport struct {
+	DetectedAt    time.Time      `json:"detected_at"`
+	Cycle         []DeadlockEdge `json:"cycle"`          // 环上的边，首尾相接
+	VictimSession string         `json:"victim_session"` // 被中止的等待者（环上最年轻的等待者）
+	VictimNode    string         `json:"victim_node"`
+	VictimKeys    []string       `json:"victim_keys"`       // 被中止的等待者所在的队列
+	Aborted       int            `json:"aborted,omitempty"` // 实际移出队列的请求数量
+}
+
+// waitForGraph 等待图：节点 -> 出边（按key、持有者排序，保证检测结果确定）
+type waitForGraph struct {
+	edges map[string][]DeadlockEdge
+	// waits 会话在各key上排队的请求（用于选择被中止的等待者）
+	waits map[string]map[string]*LockRequest
+}
+
+// DetectDeadlocks 构建等待图并找出所有的环
+// 每找到一个环，就把环上最年轻的排队会话从图中移除后继续查找，返回的每条记录对应一个需要中止的会话
+// 只检测，不修改锁状态（见 BreakDeadlock）
+func (lm *LockManager) DetectDeadlocks() []*DeadlockReport {
+	lm.lockAllShards()
+	graph := lm.buildWaitForGraphLocked()
+	lm.unlockAllShards()
+
+	var reports []*DeadlockReport
+	for {
+		cycle := graph.findCycle()
+		if cycle == nil {
+			break
+		}
+		victim := graph.youngestWaiter(cycle)
+		report := &DeadlockReport{
+			DetectedAt:    lm.now(),
+			Cycle:         cycle,
+			VictimSession: victim,
+		}
+		for key, request := range graph.waits[victim] {
+			report.VictimNode = request.NodeID
+			report.VictimKeys = append(report.VictimKeys, key)
+		}
+		sort.Strings(report.VictimKeys)
+		reports = append(reports, report)
+		graph.remove(victim)
+	}
+	return reports
+}
+
+// buildWaitForGraphLocked 根据所有分段的持有者和等待队列构建等待图
+// 注意：调用此函数时，所有分段锁都必须已经加锁
+func (lm *LockManager) buildWaitForGraphLocked() *waitForGraph {
+	graph := &waitForGraph{
+		edges: make(map[string][]DeadlockEdge),
+		waits: make(map[string]map[string]*LockRequest),
+	}
+	for _, shard := range lm.shards {
+		keys := make([]string, 0, len(shard.queues))
+		for key := range shard.queues {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			queue := shard.queues[key]
+			for i, waiter := range queue {
+				if waiter.SessionID == "" {
+					continue
+				}
+				if graph.waits[waiter.SessionID] == nil {
+					graph.waits[waiter.SessionID] = make(map[string]*LockRequest)
+				}
+				graph.waits[waiter.SessionID][key] = waiter
+
+				for _, holder := range lm.blockersLocked(shard, key, waiter) {
+					graph.addEdge(waiter, key, holder, false)
+				}
+				for _, ahead := range queue[:i] {
+					if !(ahead.shared() && waiter.shared()) {
+						graph.addEdge(waiter, key, ahead, true)
+					}
+				}
+			}
+		}
+	}
+	return graph
+}
+
+// blockersLocked 返回阻止 waiter 获得锁的所有持有者（判断规则与 blockingLockLocked 相同，但返回全部）
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) blockersLocked(shard *resourceShard, key string, waiter *LockRequest) []*LockRequest {
+	var blockers []*LockRequest
+	if lockInfo, exists := shard.locks[key]; exists && !lockInfo.Completed {
+		blockers = append(blockers, lockInfo.Request)
+	}
+	if waiter.shared() {
+		if sessionID, pending := shard.upgrades[key]; pending {
+			if holder, ok := shard.shared[key][sessionID]; ok {
+				blockers = append(blockers, holder.Request)
+			}
+		}
+	} else {
+		for _, holder := range shard.shared[key] {
+			blockers = append(blockers, holder.Request)
+		}
+	}
+
+	for _, otherType := range lm.Compatibility.types() {
+		if otherType == waiter.Type || !lm.Compatibility.Conflicts(waiter.Type, otherType) {
+			continue
+		}
+		otherKey := LockKey(otherType, waiter.ResourceID)
+		if lockInfo, exists := shard.locks[otherKey]; exists && !lockInfo.Completed {
+			blockers = append(blockers, lockInfo.Request)
+		}
+		for _, holder := range shard.shared[otherKey] {
+			blockers = append(blockers, holder.Request)
+		}
+	}
+	return blockers
+}
+
+// addEdge 添加一条边（忽略同一节点内部的等待和没有节点ID的请求）
+func (g *waitForGraph) addEdge(waiter *LockRequest, key string, holder *LockRequest, queued bool) {
+	if waiter.NodeID == "" || holder.NodeID == "" || holder.NodeID == waiter.NodeID {
+		return
+	}
+	g.edges[waiter.NodeID] = append(g.edges[waiter.NodeID], DeadlockEdge{
+		WaiterSession: waiter.SessionID,
+		WaiterNode:    waiter.NodeID,
+		Key:           key,
+		HolderSession: holder.SessionID,
+		HolderNode:    holder.NodeID,
+		queued:        queued,
+	})
+}
+
+// findCycle 深度优先搜索查找一个环，没有环时返回nil
+// 按节点ID顺序遍历，相同的等待图总是找到相同的环
+func (g *waitForGraph) findCycle() []DeadlockEdge {
+	nodes := make([]string, 0, len(g.edges))
+	for node := range g.edges {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []DeadlockEdge
+
+	var visit func(node string) []DeadlockEdge
+	visit = func(node string) []DeadlockEdge {
+		state[node] = visiting
+		for _, edge := range g.edges[node] {
+			switch state[edge.HolderNode] {
+			case visiting:
+				// 找到环：从 path 中截取以 edge.HolderNode 开始的部分
+				path = append(path, edge)
+				for i, step := range path {
+					if step.WaiterNode == edge.HolderNode {
+						return append([]DeadlockEdge(nil), path[i:]...)
+					}
+				}
+			case unvisited:
+				path = append(path, edge)
+				if cycle := visit(edge.HolderNode); cycle != nil {
+					return cycle
+				}
+				path = path[:len(path)-1]
+			}
+		}
+		state[node] = visited
+		return nil
+	}
+
+	for _, node := range nodes {
+		if state[node] == unvisited {
+			if cycle := visit(node); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// youngestWaiter 环上最年轻的等待者：排队时间最晚的会话（相同时按会话ID）
+// 只中止这一个会话：节点的其他请求不受影响
+func (g *waitForGraph) youngestWaiter(cycle []DeadlockEdge) string {
+	victim := ""
+	var victimAt time.Time
+	for _, edge := range cycle {
+		at := g.waits[edge.WaiterSession][edge.Key].Timestamp
+		if victim == "" || at.After(victimAt) || (at.Equal(victimAt) && edge.WaiterSession > victim) {
+			victim = edge.WaiterSession
+			victimAt = at
+		}
+	}
+	return victim
+}
+
+// remove 从图中移除一个会话的排队请求：它的出边，以及排在它后面的等待者指向它的边
+// 会话持有的锁仍然阻止其他节点，指向持有者的边保留
+func (g *waitForGraph) remove(session string) {
+	for node, edges := range g.edges {
+		remaining := edges[:0]
+		for _, edge := range edges {
+			if edge.WaiterSession != session && !(edge.queued && edge.HolderSession == session) {
+				remaining = append(remaining, edge)
+			}
+		}
+		g.edges[node] = remaining
+	}
+}
+
+// BreakDeadlock 中止死锁中的等待者：把 VictimSession 移出 VictimKeys 的等待队列，
+// 标记中止（该会话重新请求时返回死锁错误）并发送 aborted 事件，然后记录到死锁列表
+// 等待者在检测之后已经离开队列时不做修改（复制模式下检测与应用之间状态可能已经变化）
+// 返回：移出队列的请求数量
+func (lm *LockManager) BreakDeadlock(report *DeadlockReport) int {
+	aborted := 0
+	for _, key := range report.VictimKeys {
+		aborted += lm.abortWaiter(key, report.VictimSession)
+	}
+	report.Aborted = aborted
+
+	steps := make([]string, 0, len(report.Cycle))
+	for _, edge := range report.Cycle {
+		steps = append(steps, edge.WaiterSession+"@"+edge.WaiterNode+" -["+edge.Key+"]-> "+edge.HolderSession)
+	}
+	log.Printf("[Deadlock] 检测到死锁，中止最年轻的等待者: victim=%s, node=%s, keys=%v, 移出队列=%d, 环=%s",
+		report.VictimSession, report.VictimNode, report.VictimKeys, aborted, strings.Join(steps, ", "))
+
+	lm.deadlocksMu.Lock()
+	lm.deadlocks = append(lm.deadlocks, report)
+	if len(lm.deadlocks) > maxDeadlockReports {
+		lm.deadlocks = lm.deadlocks[len(lm.deadlocks)-maxDeadlockReports:]
+	}
+	lm.deadlocksMu.Unlock()
+	return aborted
+}
+
+// abortWaiter 把会话移出一个key的等待队列并通知
+// 加锁顺序与 TryLock/Unlock 保持一致：资源锁 -> 分段锁
+func (lm *LockManager) abortWaiter(key, sessionID string) int {
+	lockType, resourceID, ok := splitLockKey(key)
+	if !ok {
+		return 0
+	}
+	shard := lm.getShard(resourceID)
+
+	shard.mu.Lock()
+	resourceLock, exists := shard.resourceLocks[key]
+	shard.mu.Unlock()
+	if !exists {
+		return 0
+	}
+
+	resourceLock.Lock()
+	defer resourceLock.Unlock()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	removed := 0
+	nodeID := ""
+	queue := shard.queues[key]
+	remaining := queue[:0:0]
+	for _, queued := range queue {
+		if queued.SessionID != sessionID {
+			remaining = append(remaining, queued)
+			continue
+		}
+		removed++
+		nodeID = queued.NodeID
+		lm.appendWAL(&WALRecord{Op: WALOpDequeue, Type: queued.Type, ResourceID: queued.ResourceID, Request: queued})
+		traceQueueWait(queued, queueOutcomeDeadlock, lm.now())
+	}
+	if removed == 0 {
+		return 0
+	}
+	if len(remaining) == 0 {
+		delete(shard.queues, key)
+	} else {
+		shard.queues[key] = remaining
+	}
+
+	now := lm.now()
+	for session, abortedAt := range shard.aborted[key] {
+		if now.Sub(abortedAt) > abortMarkTTL {
+			delete(shard.aborted[key], session)
+		}
+	}
+	if shard.aborted[key] == nil {
+		shard.aborted[key] = make(map[string]time.Time)
+	}
+	shard.aborted[key][sessionID] = now
+
+	lm.broadcastEvent(shard, key, &OperationEvent{
+		Event:       EventTypeAborted,
+		Type:        lockType,
+		ResourceID:  resourceID,
+		NodeID:      nodeID,
+		Success:     false,
+		Error:       deadlockAbortMessage,
+		CompletedAt: lm.now(),
+		SessionID:   sessionID,
+		Code:        ErrorCodeDeadlock,
+	})
+
+	// 被中止的请求可能排在队头，而锁已经空闲
+	lm.dispatchIdleLocked(shard, key)
+	return removed
+}
+
+// consumeAbortLocked 检查会话在该key上的等待是否因死锁被中止，是则清除标记并返回true
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) consumeAbortLocked(shard *resourceShard, key, sessionID string, now time.Time) bool {
+	if sessionID == "" {
+		return false
+	}
+	abortedAt, marked := shard.aborted[key][sessionID]
+	if !marked {
+		return false
+	}
+	delete(shard.aborted[key], sessionID)
+	if len(shard.aborted[key]) == 0 {
+		delete(shard.aborted, key)
+	}
+	return now.Sub(abortedAt) <= abortMarkTTL
+}
+
+// errorCode 返回错误信息对应的错误码（没有错误码时返回空）
+func errorCode(errMsg string) string {
+	if errMsg == deadlockAbortMessage {
+		return ErrorCodeDeadlock
+	}
+	return ""
+}
+
+// ResolveDeadlocks 检测并打破所有死锁
+// 返回：检测到的死锁数量
+func (lm *LockManager) ResolveDeadlocks() int {
+	reports := lm.DetectDeadlocks()
+	for _, report := range reports {
+		lm.BreakDeadlock(report)
+	}
+	return len(reports)
+}
+
+// StartDeadlockDetector 启动后台死锁检测协程，每隔 interval 检测并打破一次死锁
+// 返回 stop 函数，用于停止检测协程
+func (lm *LockManager) StartDeadlockDetector(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				lm.ResolveDeadlocks()
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// GetDeadlocks 返回最近检测到的死锁（按检测时间先后）
+func (lm *LockManager) GetDeadlocks() []*DeadlockReport {
+	lm.deadlocksMu.Lock()
+	defer lm.deadlocksMu.Unlock()
+	return append([]*DeadlockReport(nil), lm.deadlocks...)
+}
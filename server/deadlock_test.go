@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// crossWait 构造死锁：node-1 持有 res-a 并等待 res-b，node-2 持有 res-b 并等待 res-a
+// 与默认客户端相同，请求都不携带会话ID（每次授予和排队都是新的会话），返回 node-2 最后排队的会话
+func crossWait(t *testing.T, lm *LockManager) string {
+	t.Helper()
+	for _, request := range []*LockRequest{
+		{Type: OperationTypePull, ResourceID: "sha256:res-a", NodeID: "node-1"},
+		{Type: OperationTypePull, ResourceID: "sha256:res-b", NodeID: "node-2"},
+	} {
+		if acquired, _, _ := lm.TryLock(request); !acquired {
+			t.Fatalf("%s 应该获得 %s", request.NodeID, request.ResourceID)
+		}
+	}
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:res-b", NodeID: "node-1"})
+	waiter := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:res-a", NodeID: "node-2"}
+	lm.TryLock(waiter)
+	return waiter.SessionID
+}
+
+// TestDeadlockAbortsYoungestWaiter 测试检测到环后中止最年轻的等待者，并在其重新请求时返回死锁错误
+func TestDeadlockAbortsYoungestWaiter(t *testing.T) {
+	lm := NewLockManager(true)
+	victim := crossWait(t, lm)
+
+	reports := lm.DetectDeadlocks()
+	if len(reports) != 1 {
+		t.Fatalf("期望检测到1个死锁，实际 %d", len(reports))
+	}
+	report := reports[0]
+	if report.VictimSession != victim || report.VictimNode != "node-2" || len(report.Cycle) != 2 {
+		t.Fatalf("应中止 node-2 最后排队的会话 %s: %+v", victim, report)
+	}
+	for _, edge := range report.Cycle {
+		if edge.WaiterSession == edge.HolderSession {
+			t.Errorf("等待的会话与持有的会话不同，边仍应按节点相连: %+v", edge)
+		}
+	}
+	if len(report.VictimKeys) != 1 || report.VictimKeys[0] != "pull:sha256:res-a" {
+		t.Errorf("被中止的队列应为 pull:sha256:res-a，实际 %v", report.VictimKeys)
+	}
+
+	subscriber := &mockSubscriber{events: make([]OperationEvent, 0)}
+	lm.Subscribe(OperationTypePull, "sha256:res-a", subscriber)
+	if n := lm.BreakDeadlock(report); n != 1 {
+		t.Fatalf("期望移出1个排队请求，实际 %d", n)
+	}
+	if n := lm.GetQueueLength(OperationTypePull, "sha256:res-a"); n != 0 {
+		t.Errorf("被中止的等待者应移出队列，实际队列长度 %d", n)
+	}
+	if n := lm.GetQueueLength(OperationTypePull, "sha256:res-b"); n != 1 {
+		t.Errorf("其他等待者不受影响，期望队列长度1，实际 %d", n)
+	}
+	if reports := lm.DetectDeadlocks(); len(reports) != 0 {
+		t.Errorf("死锁打破后不应再检测到环: %+v", reports)
+	}
+
+	subscriber.mu.Lock()
+	events := subscriber.events
+	subscriber.mu.Unlock()
+	if len(events) != 1 || events[0].Event != EventTypeAborted || events[0].Code != ErrorCodeDeadlock ||
+		events[0].SessionID != victim {
+		t.Errorf("应发送 aborted 事件（code=deadlock）: %+v", events)
+	}
+
+	// 被中止的会话重新请求时返回一次死锁错误
+	retry := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:res-a", NodeID: "node-2", SessionID: victim}
+	if acquired, _, errMsg := lm.TryLock(retry); acquired || errMsg != deadlockAbortMessage {
+		t.Fatalf("被中止的会话重新请求应返回死锁错误: acquired=%v, error=%s", acquired, errMsg)
+	}
+	if _, _, errMsg := lm.TryLock(retry); errMsg != "" {
+		t.Errorf("中止标记只生效一次，实际 %s", errMsg)
+	}
+	if n := len(lm.GetDeadlocks()); n != 1 {
+		t.Errorf("期望记录1个死锁，实际 %d", n)
+	}
+}
+
+// TestNoDeadlockForPlainQueue 测试普通排队（没有环）不会被当作死锁
+func TestNoDeadlockForPlainQueue(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:plain"
+
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"})
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2"})
+	lm.TryLock(&LockRequest{Type: OperationTypeDelete, ResourceID: resourceID, NodeID: "node-3"})
+
+	if n := lm.ResolveDeadlocks(); n != 0 {
+		t.Fatalf("没有环时不应检测到死锁，实际 %d", n)
+	}
+	if n := lm.GetQueueLength(OperationTypePull, resourceID); n != 1 {
+		t.Errorf("排队的请求不应被中止，实际队列长度 %d", n)
+	}
+}
+
+// TestDeadlocksHTTP 测试 /admin/deadlocks 接口和 /lock 返回的错误码
+func TestDeadlocksHTTP(t *testing.T) {
+	lm := NewLockManager(true)
+	server := newTestServer(t, lm)
+
+	// 默认客户端不携带会话ID：持有的锁和排队的请求属于不同的会话
+	postJSON(t, server.URL+"/lock", map[string]interface{}{"type": "pull", "resource_id": "sha256:res-a", "node_id": "node-1"})
+	postJSON(t, server.URL+"/lock", map[string]interface{}{"type": "pull", "resource_id": "sha256:res-b", "node_id": "node-2"})
+	postJSON(t, server.URL+"/lock", map[string]interface{}{"type": "pull", "resource_id": "sha256:res-b", "node_id": "node-1"})
+	_, queued := postJSON(t, server.URL+"/lock", map[string]interface{}{"type": "pull", "resource_id": "sha256:res-a", "node_id": "node-2"})
+	victim, _ := queued["session_id"].(string)
+	if queued["acquired"] != false || victim == "" {
+		t.Fatalf("node-2 应在 res-a 上排队: %v", queued)
+	}
+	if n := lm.ResolveDeadlocks(); n != 1 {
+		t.Fatalf("期望检测到1个死锁，实际 %d", n)
+	}
+
+	status, body := postJSON(t, server.URL+"/lock", map[string]interface{}{
+		"type": "pull", "resource_id": "sha256:res-a", "node_id": "node-2", "session_id": victim,
+	})
+	if status != http.StatusForbidden || body["code"] != ErrorCodeDeadlock {
+		t.Errorf("被中止的会话重新请求应返回 403 和 code=deadlock: status=%d, resp=%v", status, body)
+	}
+
+	resp, err := http.Get(server.URL + "/admin/deadlocks")
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Deadlocks []*DeadlockReport `json:"deadlocks"`
+		Total     int               `json:"total"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	if result.Total != 1 || result.Deadlocks[0].VictimSession != victim || result.Deadlocks[0].Aborted != 1 {
+		t.Errorf("期望列出1个死锁: %+v", result)
+	}
+}
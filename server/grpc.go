@@ -0,0 +1,412 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"distributed-lock/lockpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC 接口
+//
+// 与 HTTP 接口共用同一个 Handler（同一个 LockManager，复制模式下同样通过 Raft 提交）。
+// 等待锁时客户端先建立 Watch 流，收到第一条 subscribed 事件后以同一会话再 Acquire 一次：
+// 之后的 lock_assigned/completed 事件都会送达，不需要像 SSE 客户端那样定期重新请求锁。
+
+// EventTypeSubscribed Watch 流建立后的第一条事件，之后的事件不会丢失
+const EventTypeSubscribed = "subscribed"
+
+// grpcWatchBuffer Watch 流的事件缓冲，缓冲满时（客户端读取过慢）结束该流，客户端重新订阅
+const grpcWatchBuffer = 64
+
+// GRPCServer 锁服务的 gRPC 实现
+type GRPCServer struct {
+	lockpb.UnimplementedLockServiceServer
+	handler *Handler
+}
+
+// NewGRPCServer 创建 gRPC 服务，handler 决定单机、复制或集群模式
+func NewGRPCServer(handler *Handler) *GRPCServer {
+	return &GRPCServer{handler: handler}
+}
+
+// Register 把锁服务注册到 gRPC 服务器
+func (s *GRPCServer) Register(registrar grpc.ServiceRegistrar) {
+	lockpb.RegisterLockServiceServer(registrar, s)
+}
+
+// checkRoute 复制模式下只有leader受理，集群模式下只受理属于本进程且不在迁移中的资源
+// gRPC 没有重定向，返回 Unavailable（或 FailedPrecondition）并在错误信息中给出应该访问的地址
+func (s *GRPCServer) checkRoute(resourceID string) error {
+	h := s.handler
+	if h.raft != nil && !h.raft.IsLeader() {
+		leaderID, leaderURL := h.raft.Leader()
+		if leaderURL == "" {
+			return status.Error(codes.Unavailable, "当前没有可用的leader，请稍后重试")
+		}
+		return status.Errorf(codes.Unavailable, "当前副本不是leader，leader=%s（%s）", leaderID, leaderURL)
+	}
+	if h.cluster != nil {
+		owner, migratingFrom := h.cluster.route(resourceID)
+		if owner.ID != h.cluster.ID() {
+			return status.Errorf(codes.FailedPrecondition, "资源属于 %s（%s）", owner.ID, owner.URL)
+		}
+		if migratingFrom != "" {
+			return status.Errorf(codes.Unavailable, "资源正在从 %s 迁移，请稍后重试", migratingFrom)
+		}
+	}
+	return nil
+}
+
+// authorize 按授权策略判定 gRPC 请求（与 HTTP 接口相同，写审计日志）
+func (s *GRPCServer) authorize(ctx context.Context, action, nodeID, opType, resourceID string) error {
+	remote := ""
+	if caller, ok := peer.FromContext(ctx); ok {
+		remote = caller.Addr.String()
+	}
+	return s.handler.authorize(action, nodeID, opType, resourceID, remote)
+}
+
+// Acquire 加锁（不阻塞）
+func (s *GRPCServer) Acquire(ctx context.Context, in *lockpb.AcquireRequest) (*lockpb.AcquireResponse, error) {
+	if in.Type == "" || in.ResourceId == "" || in.NodeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "缺少必要参数")
+	}
+	if !validLockMode(in.Mode) {
+		return nil, status.Error(codes.InvalidArgument, "无效的锁模式: "+in.Mode+"（应为 exclusive 或 shared）")
+	}
+	if in.WaitTimeoutMs < 0 {
+		return nil, status.Error(codes.InvalidArgument, "无效的等待时间: wait_timeout_ms 不能为负数")
+	}
+	if err := checkGRPCIdentity(ctx, in.NodeId, in.SessionId); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, "lock", in.NodeId, in.Type, in.ResourceId); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err := s.checkRoute(in.ResourceId); err != nil {
+		return nil, err
+	}
+
+	request := &LockRequest{
+		Type:          in.Type,
+		ResourceID:    in.ResourceId,
+		NodeID:        in.NodeId,
+		SessionID:     in.SessionId,
+		Mode:          in.Mode,
+		WaitTimeoutMs: in.WaitTimeoutMs,
+	}
+	if request.Mode == "" {
+		request.Mode = LockModeExclusive
+	}
+	log.Printf("[GRPCAcquire] 收到加锁请求: type=%s, resource_id=%s, node_id=%s, mode=%s",
+		request.Type, request.ResourceID, request.NodeID, request.Mode)
+
+	acquired, skip, errMsg, err := s.handler.tracedTryLock(ctx, request)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, "加锁失败: "+err.Error())
+	}
+
+	response := &lockpb.AcquireResponse{
+		Acquired:  acquired,
+		Skip:      skip,
+		Mode:      request.Mode,
+		SessionId: request.SessionID,
+	}
+	switch {
+	case errMsg != "":
+		response.Message = errMsg
+		response.Error = errMsg
+		response.Code = errorCode(errMsg)
+	case skip:
+		response.Message = "操作已由其他节点完成，跳过操作"
+		response.Completion = completionToProto(s.handler.lockManager.GetCompletion(request.Type, request.ResourceID))
+	case acquired:
+		response.Message = "成功获得锁"
+		response.FencingToken = request.FencingToken
+		response.LeaseTtlMs = s.handler.lockManager.LeaseTTL.Milliseconds()
+	default:
+		response.Message = "锁已被占用，已加入等待队列"
+	}
+	return response, nil
+}
+
+// Release 解锁
+func (s *GRPCServer) Release(ctx context.Context, in *lockpb.ReleaseRequest) (*lockpb.ReleaseResponse, error) {
+	if in.Type == "" || in.ResourceId == "" || in.NodeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "缺少必要参数")
+	}
+	if in.FencingToken == 0 {
+		return nil, status.Error(codes.InvalidArgument, "缺少必要参数: fencing_token")
+	}
+	if err := checkGRPCIdentity(ctx, in.NodeId, in.SessionId); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, "unlock", in.NodeId, in.Type, in.ResourceId); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err := s.checkRoute(in.ResourceId); err != nil {
+		return nil, err
+	}
+
+	released, err := s.handler.tracedUnlock(ctx, &UnlockRequest{
+		Type:         in.Type,
+		ResourceID:   in.ResourceId,
+		NodeID:       in.NodeId,
+		SessionID:    in.SessionId,
+		FencingToken: in.FencingToken,
+		Error:        in.Error,
+		Result:       in.Result,
+		PeerAddr:     in.PeerAddr,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, "解锁失败: "+err.Error())
+	}
+	if !released {
+		return &lockpb.ReleaseResponse{Message: "释放锁失败：锁不存在、不是锁的持有者或 fencing token 已过期"}, nil
+	}
+	return &lockpb.ReleaseResponse{Released: true, Message: "成功释放锁"}, nil
+}
+
+// Keepalive 续约（复制模式下同样不写入Raft日志）
+func (s *GRPCServer) Keepalive(ctx context.Context, in *lockpb.KeepaliveRequest) (*lockpb.KeepaliveResponse, error) {
+	if in.Type == "" || in.ResourceId == "" || in.NodeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "缺少必要参数")
+	}
+	if err := checkGRPCIdentity(ctx, in.NodeId, in.SessionId); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, "keepalive", in.NodeId, in.Type, in.ResourceId); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err := s.checkRoute(in.ResourceId); err != nil {
+		return nil, err
+	}
+
+	renewed := s.handler.lockManager.KeepAlive(&KeepAliveRequest{
+		Type:         in.Type,
+		ResourceID:   in.ResourceId,
+		NodeID:       in.NodeId,
+		SessionID:    in.SessionId,
+		FencingToken: in.FencingToken,
+	})
+	if !renewed {
+		return &lockpb.KeepaliveResponse{Message: "续约失败：锁不存在或不是锁的持有者"}, nil
+	}
+	return &lockpb.KeepaliveResponse{
+		Renewed:    true,
+		LeaseTtlMs: s.handler.lockManager.LeaseTTL.Milliseconds(),
+		Message:    "续约成功",
+	}, nil
+}
+
+// Status 查询调用方在资源上的状态
+func (s *GRPCServer) Status(ctx context.Context, in *lockpb.StatusRequest) (*lockpb.StatusResponse, error) {
+	if in.Type == "" || in.ResourceId == "" || (in.NodeId == "" && in.SessionId == "") {
+		return nil, status.Error(codes.InvalidArgument, "缺少必要参数: type, resource_id, node_id 或 session_id")
+	}
+	if err := checkGRPCIdentity(ctx, in.NodeId, in.SessionId); err != nil {
+		return nil, err
+	}
+	if err := s.checkRoute(in.ResourceId); err != nil {
+		return nil, err
+	}
+
+	lockStatus := s.handler.lockManager.GetStatus(&LockRequest{
+		Type:       in.Type,
+		ResourceID: in.ResourceId,
+		NodeID:     in.NodeId,
+		SessionID:  in.SessionId,
+	})
+	response := &lockpb.StatusResponse{
+		Acquired:      lockStatus.Acquired,
+		Completed:     lockStatus.Completed,
+		Success:       lockStatus.Success,
+		Error:         lockStatus.Error,
+		Code:          lockStatus.Code,
+		FencingToken:  lockStatus.FencingToken,
+		Mode:          lockStatus.Mode,
+		Queued:        lockStatus.Queued,
+		QueuePosition: int32(lockStatus.QueuePosition),
+		QueueLength:   int32(lockStatus.QueueLength),
+		Holder:        lockStatus.Holder,
+		SharedHolders: int32(lockStatus.SharedHolders),
+		Completion:    completionToProto(lockStatus.Completion),
+	}
+	if progress := lockStatus.Progress; progress != nil {
+		response.Progress = &lockpb.Progress{
+			BytesDone:       progress.BytesDone,
+			BytesTotal:      progress.BytesTotal,
+			Phase:           progress.Phase,
+			UpdatedAtUnixMs: unixMs(progress.UpdatedAt),
+		}
+	}
+	return response, nil
+}
+
+// Cancel 取消等待
+func (s *GRPCServer) Cancel(ctx context.Context, in *lockpb.CancelRequest) (*lockpb.CancelResponse, error) {
+	if in.Type == "" || in.ResourceId == "" || in.NodeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "缺少必要参数")
+	}
+	if err := checkGRPCIdentity(ctx, in.NodeId, in.SessionId); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, "cancel", in.NodeId, in.Type, in.ResourceId); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err := s.checkRoute(in.ResourceId); err != nil {
+		return nil, err
+	}
+
+	removed, released, err := s.handler.cancelWait(&CancelWaitRequest{
+		Type:       in.Type,
+		ResourceID: in.ResourceId,
+		NodeID:     in.NodeId,
+		SessionID:  in.SessionId,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, "取消等待失败: "+err.Error())
+	}
+	return &lockpb.CancelResponse{Cancelled: removed > 0 || released, Removed: int32(removed), Released: released}, nil
+}
+
+// Watch 订阅资源上的事件，直到客户端取消或服务端关闭订阅（例如节点失效、资源迁移）
+func (s *GRPCServer) Watch(in *lockpb.WatchRequest, stream grpc.ServerStreamingServer[lockpb.Event]) error {
+	if in.Type == "" || in.ResourceId == "" {
+		return status.Error(codes.InvalidArgument, "缺少必要参数: type 和 resource_id")
+	}
+	if err := checkGRPCIdentity(stream.Context(), in.NodeId, ""); err != nil {
+		return err
+	}
+	if err := s.authorize(stream.Context(), "subscribe", in.NodeId, in.Type, in.ResourceId); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err := s.checkRoute(in.ResourceId); err != nil {
+		return err
+	}
+
+	lm := s.handler.lockManager
+	subscriber := newGRPCSubscriber()
+	lm.Subscribe(in.Type, in.ResourceId, subscriber)
+	defer lm.Unsubscribe(in.Type, in.ResourceId, subscriber)
+	if in.NodeId != "" {
+		lm.trackNodeSubscription(in.NodeId, in.Type, in.ResourceId, subscriber)
+		defer lm.untrackNodeSubscription(in.NodeId, subscriber)
+	}
+	log.Printf("[GRPCWatch] 收到订阅请求: type=%s, resource_id=%s, node_id=%s", in.Type, in.ResourceId, in.NodeId)
+
+	subscribed := &lockpb.Event{Event: EventTypeSubscribed, Type: in.Type, ResourceId: in.ResourceId, CompletedAtUnixMs: unixMs(time.Now())}
+	if err := stream.Send(subscribed); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-subscriber.done:
+			// 缓冲区中剩余的事件仍然送达
+			for {
+				select {
+				case event := <-subscriber.events:
+					if err := stream.Send(eventToProto(event)); err != nil {
+						return err
+					}
+				default:
+					return status.Error(codes.Unavailable, "订阅已被服务端关闭")
+				}
+			}
+		case event := <-subscriber.events:
+			if err := stream.Send(eventToProto(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// grpcSubscriber Watch 流的订阅者：SendEvent 在持有分段锁时调用，不能阻塞，事件先放入缓冲
+type grpcSubscriber struct {
+	events    chan *OperationEvent
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newGRPCSubscriber() *grpcSubscriber {
+	return &grpcSubscriber{
+		events: make(chan *OperationEvent, grpcWatchBuffer),
+		done:   make(chan struct{}),
+	}
+}
+
+// SendEvent 发送事件给订阅者（缓冲区满时返回错误，LockManager 随后关闭该订阅者）
+func (s *grpcSubscriber) SendEvent(event *OperationEvent) error {
+	select {
+	case <-s.done:
+		return fmt.Errorf("订阅者已关闭")
+	default:
+	}
+	select {
+	case s.events <- event:
+		return nil
+	default:
+		return fmt.Errorf("订阅者缓冲区已满")
+	}
+}
+
+// Close 关闭订阅者
+func (s *grpcSubscriber) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+func eventToProto(event *OperationEvent) *lockpb.Event {
+	return &lockpb.Event{
+		Event:             event.Event,
+		Type:              event.Type,
+		ResourceId:        event.ResourceID,
+		NodeId:            event.NodeID,
+		Success:           event.Success,
+		Error:             event.Error,
+		CompletedAtUnixMs: unixMs(event.CompletedAt),
+		FencingToken:      event.FencingToken,
+		Mode:              event.Mode,
+		SessionId:         event.SessionID,
+		Code:              event.Code,
+		BytesDone:         event.BytesDone,
+		BytesTotal:        event.BytesTotal,
+		Phase:             event.Phase,
+		PeerAddr:          event.PeerAddr,
+	}
+}
+
+func completionToProto(record *CompletionRecord) *lockpb.Completion {
+	if record == nil {
+		return nil
+	}
+	return &lockpb.Completion{
+		Type:              record.Type,
+		ResourceId:        record.ResourceID,
+		NodeId:            record.NodeID,
+		SessionId:         record.SessionID,
+		FencingToken:      record.FencingToken,
+		CompletedAtUnixMs: unixMs(record.CompletedAt),
+		ExpiresAtUnixMs:   unixMs(record.ExpiresAt),
+		Result:            record.Result,
+		PeerAddr:          record.PeerAddr,
+	}
+}
+
+// unixMs 时间的毫秒时间戳（零值为0）
+func unixMs(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
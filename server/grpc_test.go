@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"distributed-lock/lockpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestGRPCClient 在内存连接上启动 gRPC 服务并返回客户端
+func newTestGRPCClient(t *testing.T, handler *Handler) lockpb.LockServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	NewGRPCServer(handler).Register(server)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("创建 gRPC 连接失败: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return lockpb.NewLockServiceClient(conn)
+}
+
+// TestGRPCAcquireWatchRelease 测试 gRPC 加锁、Watch 流收到 subscribed 和 lock_assigned 事件、解锁
+func TestGRPCAcquireWatchRelease(t *testing.T) {
+	rpc := newTestGRPCClient(t, NewHandler(NewLockManager(true)))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	holder, err := rpc.Acquire(ctx, &lockpb.AcquireRequest{Type: "pull", ResourceId: "sha256:grpc", NodeId: "node-1"})
+	if err != nil || !holder.Acquired || holder.FencingToken == 0 {
+		t.Fatalf("node-1 应获得锁: resp=%v, err=%v", holder, err)
+	}
+	waiter, err := rpc.Acquire(ctx, &lockpb.AcquireRequest{Type: "pull", ResourceId: "sha256:grpc", NodeId: "node-2"})
+	if err != nil || waiter.Acquired || waiter.SessionId == "" {
+		t.Fatalf("node-2 应进入等待队列: resp=%v, err=%v", waiter, err)
+	}
+
+	stream, err := rpc.Watch(ctx, &lockpb.WatchRequest{Type: "pull", ResourceId: "sha256:grpc", NodeId: "node-2"})
+	if err != nil {
+		t.Fatalf("订阅失败: %v", err)
+	}
+	if event, err := stream.Recv(); err != nil || event.Event != EventTypeSubscribed {
+		t.Fatalf("第一条事件应为 subscribed: event=%v, err=%v", event, err)
+	}
+
+	statusResp, err := rpc.Status(ctx, &lockpb.StatusRequest{Type: "pull", ResourceId: "sha256:grpc", NodeId: "node-2", SessionId: waiter.SessionId})
+	if err != nil || !statusResp.Queued || statusResp.QueuePosition != 0 {
+		t.Fatalf("node-2 应排在队头: resp=%v, err=%v", statusResp, err)
+	}
+
+	// 持有者操作失败：锁分配给 node-2
+	released, err := rpc.Release(ctx, &lockpb.ReleaseRequest{Type: "pull", ResourceId: "sha256:grpc", NodeId: "node-1",
+		SessionId: holder.SessionId, FencingToken: holder.FencingToken, Error: "下载失败"})
+	if err != nil || !released.Released {
+		t.Fatalf("node-1 解锁失败: resp=%v, err=%v", released, err)
+	}
+	event, err := stream.Recv()
+	if err != nil || event.Event != EventTypeLockAssigned || event.SessionId != waiter.SessionId {
+		t.Fatalf("应收到分配给 node-2 的 lock_assigned 事件: event=%v, err=%v", event, err)
+	}
+
+	granted, err := rpc.Acquire(ctx, &lockpb.AcquireRequest{Type: "pull", ResourceId: "sha256:grpc", NodeId: "node-2", SessionId: waiter.SessionId})
+	if err != nil || !granted.Acquired || granted.FencingToken <= holder.FencingToken {
+		t.Fatalf("node-2 以同一会话重新请求应获得锁: resp=%v, err=%v", granted, err)
+	}
+	renewed, err := rpc.Keepalive(ctx, &lockpb.KeepaliveRequest{Type: "pull", ResourceId: "sha256:grpc", NodeId: "node-2",
+		SessionId: granted.SessionId, FencingToken: granted.FencingToken})
+	if err != nil || !renewed.Renewed {
+		t.Errorf("持有者续约失败: resp=%v, err=%v", renewed, err)
+	}
+}
+
+// TestGRPCErrors 测试参数校验和取消等待
+func TestGRPCErrors(t *testing.T) {
+	rpc := newTestGRPCClient(t, NewHandler(NewLockManager(true)))
+	ctx := context.Background()
+
+	if _, err := rpc.Acquire(ctx, &lockpb.AcquireRequest{Type: "pull", NodeId: "node-1"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("缺少 resource_id 应返回 InvalidArgument，实际 %v", err)
+	}
+	if _, err := rpc.Acquire(ctx, &lockpb.AcquireRequest{Type: "pull", ResourceId: "sha256:grpc-err", NodeId: "node-1", Mode: "bogus"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("无效的锁模式应返回 InvalidArgument，实际 %v", err)
+	}
+
+	rpc.Acquire(ctx, &lockpb.AcquireRequest{Type: "pull", ResourceId: "sha256:grpc-err", NodeId: "node-1"})
+	waiter, _ := rpc.Acquire(ctx, &lockpb.AcquireRequest{Type: "pull", ResourceId: "sha256:grpc-err", NodeId: "node-2"})
+	cancelled, err := rpc.Cancel(ctx, &lockpb.CancelRequest{Type: "pull", ResourceId: "sha256:grpc-err", NodeId: "node-2", SessionId: waiter.SessionId})
+	if err != nil || cancelled.Removed != 1 {
+		t.Errorf("取消等待应移出1个请求: resp=%v, err=%v", cancelled, err)
+	}
+	released, err := rpc.Release(ctx, &lockpb.ReleaseRequest{Type: "pull", ResourceId: "sha256:grpc-err", NodeId: "node-2", FencingToken: 1})
+	if err != nil || released.Released {
+		t.Errorf("非持有者解锁应被拒绝: resp=%v, err=%v", released, err)
+	}
+}
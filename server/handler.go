@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 
@@ -11,6 +12,17 @@ import (
 // Handler HTTP请求处理器
 type Handler struct {
 	lockManager *LockManager
+
+	// raft 复制模式下的本地副本，为nil表示单机模式
+	// 复制模式下加锁/解锁通过Raft提交，follower 把客户端请求重定向到leader
+	raft *RaftNode
+
+	// cluster 集群模式下本进程的成员状态，为nil表示不分片
+	// 集群模式下只受理哈希环上属于本进程的资源，其他资源重定向到 owner
+	cluster *Cluster
+
+	// policy 授权策略，为nil表示不限制节点可以操作的类型和资源
+	policy *Policy
 }
 
 // NewHandler 创建新的处理器
@@ -20,6 +32,22 @@ func NewHandler(lockManager *LockManager) *Handler {
 	}
 }
 
+// NewReplicatedHandler 创建复制模式的处理器，lockManager 必须是 raft 的状态机
+func NewReplicatedHandler(lockManager *LockManager, raft *RaftNode) *Handler {
+	return &Handler{
+		lockManager: lockManager,
+		raft:        raft,
+	}
+}
+
+// NewClusteredHandler 创建集群模式的处理器，lockManager 只保存 cluster 负责的资源
+func NewClusteredHandler(lockManager *LockManager, cluster *Cluster) *Handler {
+	return &Handler{
+		lockManager: lockManager,
+		cluster:     cluster,
+	}
+}
+
 // Lock 加锁处理
 func (h *Handler) Lock(w http.ResponseWriter, r *http.Request) {
 	var request LockRequest
@@ -33,27 +61,61 @@ func (h *Handler) Lock(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "缺少必要参数", http.StatusBadRequest)
 		return
 	}
+	if !validLockMode(request.Mode) {
+		http.Error(w, "无效的锁模式: "+request.Mode+"（应为 exclusive 或 shared）", http.StatusBadRequest)
+		return
+	}
+	if request.Mode == "" {
+		request.Mode = LockModeExclusive
+	}
+	if request.WaitTimeoutMs < 0 {
+		http.Error(w, "无效的等待时间: wait_timeout_ms 不能为负数", http.StatusBadRequest)
+		return
+	}
 
 	// 尝试获取锁
-	log.Printf("[Lock] 收到加锁请求: type=%s, resource_id=%s, node_id=%s",
-		request.Type, request.ResourceID, request.NodeID)
+	log.Printf("[Lock] 收到加锁请求: type=%s, resource_id=%s, node_id=%s, mode=%s",
+		request.Type, request.ResourceID, request.NodeID, request.Mode)
 
-	acquired, _, errMsg := h.lockManager.TryLock(&request)
+	acquired, skip, errMsg, err := h.tracedTryLock(r.Context(), &request)
+	if err != nil {
+		log.Printf("[Lock] 提交加锁命令失败: resource_id=%s, node_id=%s, error=%v",
+			request.ResourceID, request.NodeID, err)
+		http.Error(w, "加锁失败: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 
 	response := map[string]interface{}{
 		"acquired": acquired,
-		"skip":     false, // 不再使用 skip，上层已经检查过资源是否存在
+		"skip":     skip, // 操作已由其他节点完成（完成记录仍然有效），调用方跳过操作
+		"mode":     request.Mode,
+		// 会话ID：排队后重新请求、续约、升级/降级、取消等待和解锁时必须携带
+		"session_id": request.SessionID,
 	}
 
 	if errMsg != "" {
 		// 有错误信息（例如delete操作时引用计数不为0）
 		response["message"] = errMsg
 		response["error"] = errMsg
+		if code := errorCode(errMsg); code != "" {
+			response["code"] = code
+		}
 		log.Printf("[Lock] 加锁失败: resource_id=%s, node_id=%s, error=%s",
 			request.ResourceID, request.NodeID, errMsg)
 		w.WriteHeader(http.StatusForbidden)
+	} else if skip {
+		response["message"] = "操作已由其他节点完成，跳过操作"
+		if completion := h.lockManager.GetCompletion(request.Type, request.ResourceID); completion != nil {
+			response["completion"] = completion
+		}
+		log.Printf("[Lock] 操作已完成，跳过: resource_id=%s, node_id=%s",
+			request.ResourceID, request.NodeID)
 	} else if acquired {
 		response["message"] = "成功获得锁"
+		// fencing token：解锁时必须携带，锁被重新分配后旧token失效
+		response["fencing_token"] = request.FencingToken
+		// 告知持有者租约时长，持有者需要在到期前调用 /lock/keepalive 续约
+		response["lease_ttl_ms"] = h.lockManager.LeaseTTL.Milliseconds()
 		log.Printf("[Lock] 成功加锁: resource_id=%s, node_id=%s",
 			request.ResourceID, request.NodeID)
 	} else {
@@ -79,14 +141,24 @@ func (h *Handler) Unlock(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "缺少必要参数", http.StatusBadRequest)
 		return
 	}
+	if request.FencingToken == 0 {
+		http.Error(w, "缺少必要参数: fencing_token", http.StatusBadRequest)
+		return
+	}
 
 	// 释放锁
 	// Success 根据 Error 自动推断：没有 error 就是 success
 	success := (request.Error == "")
-	log.Printf("[Unlock] 收到解锁请求: type=%s, resource_id=%s, node_id=%s, success=%v, error=%s",
-		request.Type, request.ResourceID, request.NodeID, success, request.Error)
+	log.Printf("[Unlock] 收到解锁请求: type=%s, resource_id=%s, node_id=%s, fencing_token=%d, success=%v, error=%s",
+		request.Type, request.ResourceID, request.NodeID, request.FencingToken, success, request.Error)
 
-	released := h.lockManager.Unlock(&request)
+	released, err := h.tracedUnlock(r.Context(), &request)
+	if err != nil {
+		log.Printf("[Unlock] 提交解锁命令失败: resource_id=%s, node_id=%s, error=%v",
+			request.ResourceID, request.NodeID, err)
+		http.Error(w, "解锁失败: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 
 	response := map[string]interface{}{
 		"released": released,
@@ -97,7 +169,7 @@ func (h *Handler) Unlock(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[Unlock] 成功释放锁: resource_id=%s, node_id=%s, success=%v",
 			request.ResourceID, request.NodeID, success)
 	} else {
-		response["message"] = "释放锁失败：锁不存在或不是锁的持有者"
+		response["message"] = "释放锁失败：锁不存在、不是锁的持有者或 fencing token 已过期"
 		log.Printf("[Unlock] 释放锁失败: resource_id=%s, node_id=%s",
 			request.ResourceID, request.NodeID)
 		w.WriteHeader(http.StatusForbidden)
@@ -107,6 +179,675 @@ func (h *Handler) Unlock(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// KeepAlive 续约处理（持有者心跳）
+// 复制模式下租约只在leader上计时，续约不写入Raft日志（新leader上任时会重新计算所有租约）
+func (h *Handler) KeepAlive(w http.ResponseWriter, r *http.Request) {
+	var request KeepAliveRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	// 验证请求参数
+	if request.Type == "" || request.ResourceID == "" || request.NodeID == "" {
+		http.Error(w, "缺少必要参数", http.StatusBadRequest)
+		return
+	}
+
+	renewed := h.lockManager.KeepAlive(&request)
+
+	response := map[string]interface{}{
+		"renewed": renewed,
+	}
+
+	if renewed {
+		response["message"] = "续约成功"
+		response["lease_ttl_ms"] = h.lockManager.LeaseTTL.Milliseconds()
+	} else {
+		// 锁已过期被回收或已被其他节点持有，持有者应停止操作
+		response["message"] = "续约失败：锁不存在或不是锁的持有者"
+		log.Printf("[KeepAlive] 续约失败: type=%s, resource_id=%s, node_id=%s",
+			request.Type, request.ResourceID, request.NodeID)
+		w.WriteHeader(http.StatusForbidden)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Progress 持有者上报进度，广播给订阅者（progress 事件）并续约
+// 复制模式下进度不写入Raft日志（订阅者只连接在leader上）
+func (h *Handler) Progress(w http.ResponseWriter, r *http.Request) {
+	var request ProgressRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	// 验证请求参数
+	if request.Type == "" || request.ResourceID == "" || request.NodeID == "" {
+		http.Error(w, "缺少必要参数", http.StatusBadRequest)
+		return
+	}
+	if request.BytesDone < 0 || request.BytesTotal < 0 {
+		http.Error(w, "无效的进度: bytes_done/bytes_total 不能为负数", http.StatusBadRequest)
+		return
+	}
+
+	accepted, errMsg := h.lockManager.ReportProgress(&request)
+	response := map[string]interface{}{
+		"accepted": accepted,
+	}
+	if accepted {
+		response["message"] = "进度已广播"
+		response["lease_ttl_ms"] = h.lockManager.LeaseTTL.Milliseconds()
+	} else {
+		// 锁已被回收或已被其他节点持有，持有者应停止操作
+		response["message"] = "上报进度失败：" + errMsg
+		log.Printf("[Progress] 上报进度失败: type=%s, resource_id=%s, node_id=%s, error=%s",
+			request.Type, request.ResourceID, request.NodeID, errMsg)
+		w.WriteHeader(http.StatusForbidden)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Upgrade 共享锁升级为独占锁
+// 其他共享持有者尚未释放时返回 pending=true，调用方可以重复调用（幂等）或等待 lock_assigned 事件（mode=exclusive）
+func (h *Handler) Upgrade(w http.ResponseWriter, r *http.Request) {
+	var request ModeChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	// 验证请求参数
+	if request.Type == "" || request.ResourceID == "" || request.NodeID == "" {
+		http.Error(w, "缺少必要参数", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[Upgrade] 收到升级请求: type=%s, resource_id=%s, node_id=%s, fencing_token=%d",
+		request.Type, request.ResourceID, request.NodeID, request.FencingToken)
+
+	upgraded, fencingToken, errMsg, err := h.upgrade(&request)
+	if err != nil {
+		log.Printf("[Upgrade] 提交升级命令失败: resource_id=%s, node_id=%s, error=%v",
+			request.ResourceID, request.NodeID, err)
+		http.Error(w, "升级失败: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	response := map[string]interface{}{
+		"upgraded": upgraded,
+		"pending":  !upgraded && errMsg == "",
+	}
+
+	if errMsg != "" {
+		response["message"] = errMsg
+		response["error"] = errMsg
+		log.Printf("[Upgrade] 升级失败: resource_id=%s, node_id=%s, error=%s",
+			request.ResourceID, request.NodeID, errMsg)
+		w.WriteHeader(http.StatusForbidden)
+	} else if upgraded {
+		response["message"] = "已升级为独占锁"
+		response["fencing_token"] = fencingToken
+		response["mode"] = LockModeExclusive
+	} else {
+		response["message"] = "等待其他共享持有者释放"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Downgrade 独占锁降级为共享锁
+func (h *Handler) Downgrade(w http.ResponseWriter, r *http.Request) {
+	var request ModeChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	// 验证请求参数
+	if request.Type == "" || request.ResourceID == "" || request.NodeID == "" {
+		http.Error(w, "缺少必要参数", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[Downgrade] 收到降级请求: type=%s, resource_id=%s, node_id=%s, fencing_token=%d",
+		request.Type, request.ResourceID, request.NodeID, request.FencingToken)
+
+	downgraded, fencingToken, errMsg, err := h.downgrade(&request)
+	if err != nil {
+		log.Printf("[Downgrade] 提交降级命令失败: resource_id=%s, node_id=%s, error=%v",
+			request.ResourceID, request.NodeID, err)
+		http.Error(w, "降级失败: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	response := map[string]interface{}{
+		"downgraded": downgraded,
+	}
+
+	if downgraded {
+		response["message"] = "已降级为共享锁"
+		response["fencing_token"] = fencingToken
+		response["mode"] = LockModeShared
+	} else {
+		response["message"] = errMsg
+		response["error"] = errMsg
+		log.Printf("[Downgrade] 降级失败: resource_id=%s, node_id=%s, error=%s",
+			request.ResourceID, request.NodeID, errMsg)
+		w.WriteHeader(http.StatusForbidden)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// CancelWait 取消等待（客户端放弃等待时调用）
+// 取消是幂等的：节点不在等待队列中时返回 cancelled=false，状态码仍为 200
+func (h *Handler) CancelWait(w http.ResponseWriter, r *http.Request) {
+	var request CancelWaitRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	// 验证请求参数
+	if request.Type == "" || request.ResourceID == "" || request.NodeID == "" {
+		http.Error(w, "缺少必要参数", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[CancelWait] 收到取消等待请求: type=%s, resource_id=%s, node_id=%s",
+		request.Type, request.ResourceID, request.NodeID)
+
+	removed, released, err := h.cancelWait(&request)
+	if err != nil {
+		log.Printf("[CancelWait] 提交取消命令失败: resource_id=%s, node_id=%s, error=%v",
+			request.ResourceID, request.NodeID, err)
+		http.Error(w, "取消等待失败: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	response := map[string]interface{}{
+		"cancelled": removed > 0 || released,
+		"removed":   removed,
+		"released":  released,
+	}
+	if removed > 0 || released {
+		response["message"] = "已取消等待"
+	} else {
+		response["message"] = "不在等待队列中"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Invalidate 作废完成记录（例如镜像层被删除或发现损坏），之后的请求重新获得锁并执行操作
+func (h *Handler) Invalidate(w http.ResponseWriter, r *http.Request) {
+	var request InvalidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+	if request.ResourceID == "" {
+		http.Error(w, "缺少必要参数: resource_id", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[Invalidate] 收到作废完成记录请求: type=%s, resource_id=%s, node_id=%s",
+		request.Type, request.ResourceID, request.NodeID)
+
+	invalidated, err := h.invalidateCompletion(&request)
+	if err != nil {
+		log.Printf("[Invalidate] 提交作废命令失败: resource_id=%s, error=%v", request.ResourceID, err)
+		http.Error(w, "作废完成记录失败: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	response := map[string]interface{}{
+		"invalidated": invalidated,
+	}
+	if invalidated > 0 {
+		response["message"] = "已作废完成记录"
+	} else {
+		response["message"] = "没有有效的完成记录"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SemaphoreAcquire 获取信号量许可：剩余许可足够时立即分配，否则按FIFO排队
+// 排队的会话在许可分配后收到 permits_granted 事件（订阅 type=semaphore&resource_id=<name>）
+func (h *Handler) SemaphoreAcquire(w http.ResponseWriter, r *http.Request) {
+	var request SemaphoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+	if request.Name == "" || request.NodeID == "" {
+		http.Error(w, "缺少必要参数: name 或 node_id", http.StatusBadRequest)
+		return
+	}
+	if request.Permits < 0 {
+		http.Error(w, "无效的许可数量: permits 不能为负数", http.StatusBadRequest)
+		return
+	}
+
+	acquired, errMsg, err := h.acquireSemaphore(&request)
+	if err != nil {
+		log.Printf("[SemaphoreAcquire] 提交获取许可命令失败: name=%s, node_id=%s, error=%v", request.Name, request.NodeID, err)
+		http.Error(w, "获取许可失败: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	info := h.lockManager.GetSemaphore(request.Name)
+	response := map[string]interface{}{
+		"acquired":   acquired,
+		"session_id": request.SessionID,
+		"permits":    request.Permits,
+		"capacity":   info.Capacity,
+		"available":  info.Available,
+	}
+	if errMsg != "" {
+		response["error"] = errMsg
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	if acquired {
+		response["message"] = "成功获得许可"
+		response["lease_ttl_ms"] = h.lockManager.LeaseTTL.Milliseconds()
+	} else {
+		response["message"] = "许可不足，已加入等待队列"
+		response["queue_position"] = -1
+		for i, waiter := range info.Waiters {
+			if waiter.SessionID == request.SessionID {
+				response["queue_position"] = i
+				break
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SemaphoreRelease 释放会话持有的许可，或放弃等待（幂等）
+func (h *Handler) SemaphoreRelease(w http.ResponseWriter, r *http.Request) {
+	var request SemaphoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+	if request.Name == "" || request.NodeID == "" || request.SessionID == "" {
+		http.Error(w, "缺少必要参数: name, node_id 或 session_id", http.StatusBadRequest)
+		return
+	}
+
+	released, dequeued, err := h.releaseSemaphore(&request)
+	if err != nil {
+		log.Printf("[SemaphoreRelease] 提交释放许可命令失败: name=%s, node_id=%s, error=%v", request.Name, request.NodeID, err)
+		http.Error(w, "释放许可失败: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	response := map[string]interface{}{
+		"released": released,
+		"dequeued": dequeued,
+	}
+	switch {
+	case released:
+		response["message"] = "已释放许可"
+	case dequeued:
+		response["message"] = "已放弃等待"
+	default:
+		response["message"] = "会话没有持有许可也不在等待队列中"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SemaphoreKeepAlive 续约会话持有的许可（复制模式下与锁的续约一样只在leader上记录）
+func (h *Handler) SemaphoreKeepAlive(w http.ResponseWriter, r *http.Request) {
+	var request SemaphoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+	if request.Name == "" || request.NodeID == "" || request.SessionID == "" {
+		http.Error(w, "缺少必要参数: name, node_id 或 session_id", http.StatusBadRequest)
+		return
+	}
+
+	renewed := h.lockManager.KeepAliveSemaphore(&request)
+	response := map[string]interface{}{
+		"renewed": renewed,
+	}
+	if renewed {
+		response["message"] = "续约成功"
+		response["lease_ttl_ms"] = h.lockManager.LeaseTTL.Milliseconds()
+	} else {
+		// 许可已被回收（租约过期或节点失效），持有者应停止操作
+		response["message"] = "续约失败：会话没有持有许可"
+		log.Printf("[SemaphoreKeepAlive] 续约失败: name=%s, node_id=%s", request.Name, request.NodeID)
+		w.WriteHeader(http.StatusForbidden)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SemaphoreCapacity 修改信号量容量（覆盖启动配置），扩容后立即分配给等待者
+func (h *Handler) SemaphoreCapacity(w http.ResponseWriter, r *http.Request) {
+	var request SemaphoreCapacityRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+	if request.Name == "" {
+		http.Error(w, "缺少必要参数: name", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[SemaphoreCapacity] 收到修改容量请求: name=%s, capacity=%d, node_id=%s", request.Name, request.Capacity, request.NodeID)
+	if err := h.setSemaphoreCapacity(&request); err != nil {
+		http.Error(w, "修改容量失败: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.lockManager.GetSemaphore(request.Name))
+}
+
+// SemaphoreStatus 查询信号量状态：GET /semaphore?name=<name>
+func (h *Handler) SemaphoreStatus(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "缺少必要参数: name", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.lockManager.GetSemaphore(name))
+}
+
+// AdminSemaphores 列出所有信号量的容量、持有者和等待者，支持分页
+func (h *Handler) AdminSemaphores(w http.ResponseWriter, r *http.Request) {
+	_, offset, limit, errMsg := parseAdminQuery(r)
+	if errMsg != "" {
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	semaphores := h.lockManager.ListSemaphores()
+	start, end := pageBounds(len(semaphores), offset, limit)
+	writeAdminPage(w, "semaphores", semaphores[start:end], len(semaphores), offset, limit)
+}
+
+// LockBatch 批量加锁处理：全部资源都能获得时一次性授予，否则都不持有并在被占用的资源上排队
+func (h *Handler) LockBatch(w http.ResponseWriter, r *http.Request) {
+	var request BatchLockRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	// 验证请求参数
+	if request.NodeID == "" || len(request.Resources) == 0 {
+		http.Error(w, "缺少必要参数", http.StatusBadRequest)
+		return
+	}
+	for _, resource := range request.Resources {
+		if resource == nil || resource.Type == "" || resource.ResourceID == "" {
+			http.Error(w, "缺少必要参数: 每个资源都需要 type 和 resource_id", http.StatusBadRequest)
+			return
+		}
+		if !validLockMode(resource.Mode) {
+			http.Error(w, "无效的锁模式: "+resource.Mode+"（应为 exclusive 或 shared）", http.StatusBadRequest)
+			return
+		}
+	}
+	if request.WaitTimeoutMs < 0 {
+		http.Error(w, "无效的等待时间: wait_timeout_ms 不能为负数", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[LockBatch] 收到批量加锁请求: node_id=%s, 资源数量=%d", request.NodeID, len(request.Resources))
+
+	acquired, results, errMsg, err := h.tryLockBatch(&request)
+	if err != nil {
+		log.Printf("[LockBatch] 提交批量加锁命令失败: node_id=%s, error=%v", request.NodeID, err)
+		http.Error(w, "批量加锁失败: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	response := map[string]interface{}{
+		"acquired":   acquired,
+		"session_id": request.SessionID,
+		"results":    results,
+	}
+	if errMsg != "" {
+		response["message"] = errMsg
+		response["error"] = errMsg
+		if code := errorCode(errMsg); code != "" {
+			response["code"] = code
+		}
+		log.Printf("[LockBatch] 批量加锁失败: node_id=%s, error=%s", request.NodeID, errMsg)
+		w.WriteHeader(http.StatusForbidden)
+	} else if acquired {
+		response["message"] = "成功获得全部资源的锁"
+		response["lease_ttl_ms"] = h.lockManager.LeaseTTL.Milliseconds()
+	} else {
+		response["message"] = "部分资源已被占用，已加入等待队列"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RegisterNode 节点注册
+func (h *Handler) RegisterNode(w http.ResponseWriter, r *http.Request) {
+	var request NodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+	if request.NodeID == "" {
+		http.Error(w, "缺少必要参数: node_id", http.StatusBadRequest)
+		return
+	}
+
+	node := h.lockManager.RegisterNode(&request)
+	response := map[string]interface{}{
+		"registered":      true,
+		"node":            node,
+		"node_timeout_ms": h.lockManager.NodeTimeout.Milliseconds(),
+		"message":         "节点已注册，请在失效判定时长内定期发送心跳",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// NodeHeartbeat 节点心跳
+func (h *Handler) NodeHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var request NodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+	if request.NodeID == "" {
+		http.Error(w, "缺少必要参数: node_id", http.StatusBadRequest)
+		return
+	}
+
+	node := h.lockManager.Heartbeat(request.NodeID)
+	response := map[string]interface{}{
+		"alive":           true,
+		"node":            node,
+		"node_timeout_ms": h.lockManager.NodeTimeout.Milliseconds(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ListNodes 列出已注册的节点及其状态和最后心跳时间（可选参数 state 按状态过滤）
+func (h *Handler) ListNodes(w http.ResponseWriter, r *http.Request) {
+	stateParam := r.URL.Query().Get("state")
+
+	nodes := make([]*NodeInfo, 0)
+	for _, node := range h.lockManager.ListNodes() {
+		if stateParam == "" || node.State == stateParam {
+			nodes = append(nodes, node)
+		}
+	}
+	response := map[string]interface{}{
+		"nodes": nodes,
+		"total": len(nodes),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// AdminLocks 列出持有中的锁（持有者、持有时长、类型），支持过滤和分页
+func (h *Handler) AdminLocks(w http.ResponseWriter, r *http.Request) {
+	filter, offset, limit, errMsg := parseAdminQuery(r)
+	if errMsg != "" {
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	locks := h.lockManager.AdminLocks(filter)
+	start, end := pageBounds(len(locks), offset, limit)
+	writeAdminPage(w, "locks", locks[start:end], len(locks), offset, limit)
+}
+
+// AdminQueues 列出每个key的完整等待队列（按FIFO顺序），支持过滤和分页
+func (h *Handler) AdminQueues(w http.ResponseWriter, r *http.Request) {
+	filter, offset, limit, errMsg := parseAdminQuery(r)
+	if errMsg != "" {
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	queues := h.lockManager.AdminQueues(filter)
+	start, end := pageBounds(len(queues), offset, limit)
+	writeAdminPage(w, "queues", queues[start:end], len(queues), offset, limit)
+}
+
+// AdminSubscribers 列出每个key的订阅者数量，支持过滤和分页
+func (h *Handler) AdminSubscribers(w http.ResponseWriter, r *http.Request) {
+	filter, offset, limit, errMsg := parseAdminQuery(r)
+	if errMsg != "" {
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	subscribers := h.lockManager.AdminSubscribers(filter)
+	start, end := pageBounds(len(subscribers), offset, limit)
+	writeAdminPage(w, "subscribers", subscribers[start:end], len(subscribers), offset, limit)
+}
+
+// AdminNodes 按节点汇总持有的锁、排队的请求和订阅连接，支持过滤和分页
+func (h *Handler) AdminNodes(w http.ResponseWriter, r *http.Request) {
+	filter, offset, limit, errMsg := parseAdminQuery(r)
+	if errMsg != "" {
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	nodes := h.lockManager.AdminNodes(filter)
+	start, end := pageBounds(len(nodes), offset, limit)
+	writeAdminPage(w, "nodes", nodes[start:end], len(nodes), offset, limit)
+}
+
+// writeAdminPage 返回一页管理接口数据
+func writeAdminPage(w http.ResponseWriter, name string, items interface{}, total, offset, limit int) {
+	response := map[string]interface{}{
+		name:     items,
+		"total":  total,
+		"offset": offset,
+		"limit":  limit,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Status 查询调用方在key上的状态：是否持有锁、排队位置、最近一次操作是否完成及其结果
+// 支持 POST（JSON 请求体，与 /lock 相同）和 GET（查询参数 type、resource_id、node_id、session_id）
+func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
+	var request LockRequest
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		request.Type = query.Get("type")
+		request.ResourceID = query.Get("resource_id")
+		request.NodeID = query.Get("node_id")
+		request.SessionID = query.Get("session_id")
+	} else if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+	if request.Type == "" || request.ResourceID == "" || (request.NodeID == "" && request.SessionID == "") {
+		http.Error(w, "缺少必要参数: type, resource_id, node_id 或 session_id", http.StatusBadRequest)
+		return
+	}
+
+	status := h.lockManager.GetStatus(&request)
+	response := map[string]interface{}{
+		"acquired":       status.Acquired,
+		"completed":      status.Completed,
+		"success":        status.Success,
+		"queued":         status.Queued,
+		"queue_position": status.QueuePosition,
+		"queue_length":   status.QueueLength,
+		"shared_holders": status.SharedHolders,
+	}
+	if status.Acquired {
+		response["fencing_token"] = status.FencingToken
+		response["mode"] = status.Mode
+	}
+	if status.Holder != "" {
+		response["holder"] = status.Holder
+	}
+	if status.Progress != nil {
+		response["progress"] = status.Progress
+	}
+	if status.Completion != nil {
+		response["completion"] = status.Completion
+	}
+	if status.Error != "" {
+		response["error"] = status.Error
+		response["code"] = status.Code
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Deadlocks 列出最近检测到的死锁（被中止的等待者和等待环）
+func (h *Handler) Deadlocks(w http.ResponseWriter, r *http.Request) {
+	deadlocks := h.lockManager.GetDeadlocks()
+	response := map[string]interface{}{
+		"deadlocks": deadlocks,
+		"total":     len(deadlocks),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 // Subscribe 订阅资源操作完成事件（SSE）
 func (h *Handler) Subscribe(w http.ResponseWriter, r *http.Request) {
 	// 解析查询参数
@@ -118,7 +859,10 @@ func (h *Handler) Subscribe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("[Subscribe] 收到订阅请求: type=%s, resource_id=%s", typeParam, resourceIDParam)
+	// 可选：订阅者所在的节点，节点失效时服务端关闭它的订阅连接
+	nodeIDParam := r.URL.Query().Get("node_id")
+
+	log.Printf("[Subscribe] 收到订阅请求: type=%s, resource_id=%s, node_id=%s", typeParam, resourceIDParam, nodeIDParam)
 
 	// 设置 SSE 响应头
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -132,18 +876,194 @@ func (h *Handler) Subscribe(w http.ResponseWriter, r *http.Request) {
 
 	// 注册订阅者
 	h.lockManager.Subscribe(typeParam, resourceIDParam, subscriber)
+	if nodeIDParam != "" {
+		h.lockManager.trackNodeSubscription(nodeIDParam, typeParam, resourceIDParam, subscriber)
+		defer h.lockManager.untrackNodeSubscription(nodeIDParam, subscriber)
+	}
 
-	// 等待连接关闭
-	<-r.Context().Done()
+	// 等待连接关闭（客户端断开，或服务端关闭订阅者，例如节点失效）
+	select {
+	case <-r.Context().Done():
+	case <-subscriber.Done():
+	}
 
 	// 取消订阅
 	h.lockManager.Unsubscribe(typeParam, resourceIDParam, subscriber)
 	log.Printf("[Subscribe] 订阅者断开连接: type=%s, resource_id=%s", typeParam, resourceIDParam)
 }
 
+// durable 单机模式下执行一次状态变更：启用持久化时，之前的WAL写入失败尚未被快照修复则不执行，
+// 执行期间WAL写入失败时返回错误（状态变更没有落盘，不能确认给客户端），与复制模式下提交失败一样返回 503
+func (h *Handler) durable(apply func()) error {
+	store := h.lockManager.store
+	if store == nil {
+		apply()
+		return nil
+	}
+	if err := store.Err(); err != nil {
+		return fmt.Errorf("锁状态持久化失败，暂停处理变更请求: %w", err)
+	}
+	failures := store.failureCount()
+	apply()
+	if store.failureCount() != failures {
+		return fmt.Errorf("状态变更写入WAL失败，请稍后重试")
+	}
+	return nil
+}
+
+// tryLock 加锁：单机模式直接调用 LockManager，复制模式通过Raft提交
+func (h *Handler) tryLock(request *LockRequest) (acquired, skip bool, errMsg string, err error) {
+	if h.raft == nil {
+		err = h.durable(func() { acquired, skip, errMsg = h.lockManager.TryLock(request) })
+		return acquired, skip, errMsg, err
+	}
+	return h.raft.TryLock(request)
+}
+
+// tryLockBatch 批量加锁：单机模式直接调用 LockManager，复制模式通过Raft提交
+func (h *Handler) tryLockBatch(request *BatchLockRequest) (acquired bool, results []*BatchLockResult, errMsg string, err error) {
+	if h.raft == nil {
+		err = h.durable(func() { acquired, results, errMsg = h.lockManager.TryLockBatch(request) })
+		return acquired, results, errMsg, err
+	}
+	return h.raft.TryLockBatch(request)
+}
+
+// unlock 解锁：单机模式直接调用 LockManager，复制模式通过Raft提交
+func (h *Handler) unlock(request *UnlockRequest) (released bool, err error) {
+	if h.raft == nil {
+		err = h.durable(func() { released = h.lockManager.Unlock(request) })
+		return released, err
+	}
+	return h.raft.Unlock(request)
+}
+
+// upgrade 升级：单机模式直接调用 LockManager，复制模式通过Raft提交
+func (h *Handler) upgrade(request *ModeChangeRequest) (upgraded bool, fencingToken uint64, errMsg string, err error) {
+	if h.raft == nil {
+		err = h.durable(func() { upgraded, fencingToken, errMsg = h.lockManager.Upgrade(request) })
+		return upgraded, fencingToken, errMsg, err
+	}
+	return h.raft.Upgrade(request)
+}
+
+// downgrade 降级：单机模式直接调用 LockManager，复制模式通过Raft提交
+func (h *Handler) downgrade(request *ModeChangeRequest) (downgraded bool, fencingToken uint64, errMsg string, err error) {
+	if h.raft == nil {
+		err = h.durable(func() { downgraded, fencingToken, errMsg = h.lockManager.Downgrade(request) })
+		return downgraded, fencingToken, errMsg, err
+	}
+	return h.raft.Downgrade(request)
+}
+
+// cancelWait 取消等待：单机模式直接调用 LockManager，复制模式通过Raft提交
+func (h *Handler) cancelWait(request *CancelWaitRequest) (removed int, released bool, err error) {
+	if h.raft == nil {
+		err = h.durable(func() { removed, released = h.lockManager.CancelWait(request) })
+		return removed, released, err
+	}
+	return h.raft.CancelWait(request)
+}
+
+// invalidateCompletion 作废完成记录：单机模式直接调用 LockManager，复制模式通过Raft提交
+func (h *Handler) invalidateCompletion(request *InvalidateRequest) (invalidated int, err error) {
+	if h.raft == nil {
+		err = h.durable(func() { invalidated = h.lockManager.InvalidateCompletion(request) })
+		return invalidated, err
+	}
+	return h.raft.InvalidateCompletion(request)
+}
+
+// acquireSemaphore 获取许可：单机模式直接调用 LockManager，复制模式通过Raft提交
+func (h *Handler) acquireSemaphore(request *SemaphoreRequest) (acquired bool, errMsg string, err error) {
+	if h.raft == nil {
+		err = h.durable(func() { acquired, errMsg = h.lockManager.AcquireSemaphore(request) })
+		return acquired, errMsg, err
+	}
+	return h.raft.AcquireSemaphore(request)
+}
+
+// releaseSemaphore 释放许可：单机模式直接调用 LockManager，复制模式通过Raft提交
+func (h *Handler) releaseSemaphore(request *SemaphoreRequest) (released, dequeued bool, err error) {
+	if h.raft == nil {
+		err = h.durable(func() { released, dequeued = h.lockManager.ReleaseSemaphore(request) })
+		return released, dequeued, err
+	}
+	return h.raft.ReleaseSemaphore(request)
+}
+
+// setSemaphoreCapacity 修改容量：单机模式直接调用 LockManager，复制模式通过Raft提交
+func (h *Handler) setSemaphoreCapacity(request *SemaphoreCapacityRequest) error {
+	if h.raft == nil {
+		return h.durable(func() { h.lockManager.SetSemaphoreCapacity(request.Name, request.Capacity) })
+	}
+	return h.raft.SetSemaphoreCapacity(request)
+}
+
+// leaderOnly 复制模式下只有leader处理客户端请求
+// follower 返回 307 重定向到leader（保留请求方法和请求体），leader 未知时返回 503
+func (h *Handler) leaderOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.raft == nil || h.raft.IsLeader() {
+			next(w, r)
+			return
+		}
+
+		leaderID, leaderURL := h.raft.Leader()
+		if leaderURL == "" {
+			http.Error(w, "当前没有可用的leader，请稍后重试", http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("[leaderOnly] 重定向到leader: path=%s, leader=%s", r.URL.Path, leaderID)
+		http.Redirect(w, r, leaderURL+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	}
+}
+
+// routed 带资源ID的请求：复制模式下重定向到leader，集群模式下重定向到资源的 owner
+func (h *Handler) routed(next http.HandlerFunc) http.HandlerFunc {
+	return h.leaderOnly(h.ownerOnly(next))
+}
+
 // RegisterRoutes 注册路由
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/lock", h.Lock).Methods("POST")
-	router.HandleFunc("/unlock", h.Unlock).Methods("POST")
-	router.HandleFunc("/lock/subscribe", h.Subscribe).Methods("GET")
+	// 按路由统计错误状态码（含复制和集群模式的内部路由），从请求头继续调用方的追踪
+	router.Use(h.countErrors, traced)
+
+	// 启用 mTLS 时校验请求中的 node_id 与客户端证书的身份一致（管理接口的 node_id 是查询条件，不校验）；
+	// 设置授权策略时除状态查询外的锁和信号量接口先按策略检查（作废完成记录和修改信号量容量还要求角色允许该管理操作）
+	router.HandleFunc("/lock", h.authenticated(h.authorized("lock", h.routed(h.Lock)))).Methods("POST")
+	router.HandleFunc("/unlock", h.authenticated(h.authorized("unlock", h.routed(h.Unlock)))).Methods("POST")
+	router.HandleFunc("/lock/keepalive", h.authenticated(h.authorized("keepalive", h.routed(h.KeepAlive)))).Methods("POST")
+	router.HandleFunc("/lock/progress", h.authenticated(h.authorized("progress", h.routed(h.Progress)))).Methods("POST")
+	router.HandleFunc("/lock/upgrade", h.authenticated(h.authorized("upgrade", h.routed(h.Upgrade)))).Methods("POST")
+	router.HandleFunc("/lock/downgrade", h.authenticated(h.authorized("downgrade", h.routed(h.Downgrade)))).Methods("POST")
+	router.HandleFunc("/lock/queue", h.authenticated(h.authorized("cancel", h.routed(h.CancelWait)))).Methods("DELETE")
+	router.HandleFunc("/lock/cancel", h.authenticated(h.authorized("cancel", h.routed(h.CancelWait)))).Methods("POST")
+	router.HandleFunc("/lock/batch", h.authenticated(h.authorized("batch", h.routed(h.LockBatch)))).Methods("POST")
+	router.HandleFunc("/lock/status", h.authenticated(h.routed(h.Status))).Methods("GET", "POST")
+	router.HandleFunc("/lock/invalidate", h.authenticated(h.authorized(ActionInvalidate, h.routed(h.Invalidate)))).Methods("POST")
+	router.HandleFunc("/lock/subscribe", h.authenticated(h.authorized("subscribe", h.routed(h.Subscribe)))).Methods("GET")
+	router.HandleFunc("/semaphore", h.authenticated(h.routed(h.SemaphoreStatus))).Methods("GET")
+	router.HandleFunc("/semaphore/acquire", h.authenticated(h.authorized("semaphore_acquire", h.routed(h.SemaphoreAcquire)))).Methods("POST")
+	router.HandleFunc("/semaphore/release", h.authenticated(h.authorized("semaphore_release", h.routed(h.SemaphoreRelease)))).Methods("POST")
+	router.HandleFunc("/semaphore/keepalive", h.authenticated(h.authorized("semaphore_keepalive", h.routed(h.SemaphoreKeepAlive)))).Methods("POST")
+	router.HandleFunc("/semaphore/capacity", h.authenticated(h.authorized(ActionSemaphoreCapacity, h.routed(h.SemaphoreCapacity)))).Methods("POST")
+	router.HandleFunc("/admin/deadlocks", h.Deadlocks).Methods("GET")
+	router.HandleFunc("/admin/locks", h.AdminLocks).Methods("GET")
+	router.HandleFunc("/admin/queues", h.AdminQueues).Methods("GET")
+	router.HandleFunc("/admin/subscribers", h.AdminSubscribers).Methods("GET")
+	router.HandleFunc("/admin/nodes", h.AdminNodes).Methods("GET")
+	router.HandleFunc("/admin/semaphores", h.AdminSemaphores).Methods("GET")
+	router.HandleFunc("/metrics", h.Metrics).Methods("GET")
+	// 成员表只保存在leader上（与租约计时相同），复制模式下重定向到leader
+	router.HandleFunc("/nodes", h.authenticated(h.leaderOnly(h.ListNodes))).Methods("GET")
+	router.HandleFunc("/nodes/register", h.authenticated(h.authorized(ActionNodeRegister, h.leaderOnly(h.RegisterNode)))).Methods("POST")
+	router.HandleFunc("/nodes/heartbeat", h.authenticated(h.authorized(ActionNodeHeartbeat, h.leaderOnly(h.NodeHeartbeat)))).Methods("POST")
+
+	if h.raft != nil {
+		h.raft.RegisterRoutes(router)
+	}
+	if h.cluster != nil {
+		h.cluster.RegisterRoutes(router)
+	}
 }
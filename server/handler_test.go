@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestServer 创建带完整路由的测试服务器
+func newTestServer(t *testing.T, lm *LockManager) *httptest.Server {
+	t.Helper()
+	router := mux.NewRouter()
+	NewHandler(lm).RegisterRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// postJSON 发送 JSON POST 请求并解析 JSON 响应
+func postJSON(t *testing.T, url string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("序列化请求失败: %v", err)
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	result := make(map[string]interface{})
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+// TestUnlockRequiresFencingToken 测试 /unlock 必须携带当前的 fencing token
+func TestUnlockRequiresFencingToken(t *testing.T) {
+	lm := NewLockManager(true)
+	server := newTestServer(t, lm)
+
+	lockReq := map[string]interface{}{"type": "pull", "resource_id": "sha256:http1", "node_id": "node-1"}
+	status, resp := postJSON(t, server.URL+"/lock", lockReq)
+	if status != http.StatusOK || resp["acquired"] != true {
+		t.Fatalf("加锁失败: status=%d, resp=%v", status, resp)
+	}
+	token, ok := resp["fencing_token"].(float64)
+	if !ok || token == 0 {
+		t.Fatalf("加锁响应应包含 fencing_token，实际 %v", resp)
+	}
+
+	// 缺少 fencing_token
+	status, _ = postJSON(t, server.URL+"/unlock", map[string]interface{}{
+		"type": "pull", "resource_id": "sha256:http1", "node_id": "node-1",
+	})
+	if status != http.StatusBadRequest {
+		t.Errorf("缺少 fencing_token 应返回 400，实际 %d", status)
+	}
+
+	// 错误的 fencing_token
+	status, resp = postJSON(t, server.URL+"/unlock", map[string]interface{}{
+		"type": "pull", "resource_id": "sha256:http1", "node_id": "node-1", "fencing_token": token + 1,
+	})
+	if status != http.StatusForbidden || resp["released"] != false {
+		t.Errorf("错误的 fencing_token 应返回 403，实际 status=%d, resp=%v", status, resp)
+	}
+
+	// 正确的 fencing_token
+	status, resp = postJSON(t, server.URL+"/unlock", map[string]interface{}{
+		"type": "pull", "resource_id": "sha256:http1", "node_id": "node-1", "fencing_token": token,
+	})
+	if status != http.StatusOK || resp["released"] != true {
+		t.Errorf("正确的 fencing_token 应释放成功，实际 status=%d, resp=%v", status, resp)
+	}
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"log"
+	"sync"
+)
+
+// 资源状态交接
+//
+// 集群的哈希环变更后，原 owner 把不再属于自己的资源的全部状态（持有中的锁、共享持有者、等待队列、
+// fencing token 计数器、等待中的升级、完成记录、信号量）从分段中移出，发送给新的 owner 合并。
+// 持有者和等待者的会话、fencing token 都保持不变，持有者继续向新 owner 续约和解锁即可。
+
+// extractResources 把 keep 返回 false 的资源的状态从分段中移出并返回，这些资源上的订阅连接被关闭
+// （客户端重新订阅时被重定向到新的 owner）。因死锁被中止的记录只在本地有意义，直接丢弃
+func (lm *LockManager) extractResources(keep func(resourceID string) bool) *lockSnapshot {
+	snapshot := &lockSnapshot{
+		Locks:         make(map[string]*LockInfo),
+		Queues:        make(map[string][]*LockRequest),
+		FencingTokens: make(map[string]uint64),
+		Shared:        make(map[string][]*LockInfo),
+		Upgrades:      make(map[string]string),
+		Completions:   make(map[string]*CompletionRecord),
+		Semaphores:    make(map[string]*Semaphore),
+		CreatedAt:     lm.now(),
+	}
+	moved := func(key string) bool {
+		_, resourceID, ok := splitLockKey(key)
+		return ok && !keep(resourceID)
+	}
+
+	var subscribers []Subscriber
+	lm.lockAllShards()
+	for _, shard := range lm.shards {
+		for key, lockInfo := range shard.locks {
+			if moved(key) {
+				snapshot.Locks[key] = lockInfo
+				delete(shard.locks, key)
+			}
+		}
+		for key, queue := range shard.queues {
+			if moved(key) {
+				snapshot.Queues[key] = queue
+				delete(shard.queues, key)
+			}
+		}
+		for key, token := range shard.fencingTokens {
+			if moved(key) {
+				snapshot.FencingTokens[key] = token
+				delete(shard.fencingTokens, key)
+			}
+		}
+		for key, holders := range shard.shared {
+			if !moved(key) {
+				continue
+			}
+			for _, holder := range holders {
+				snapshot.Shared[key] = append(snapshot.Shared[key], holder)
+			}
+			delete(shard.shared, key)
+		}
+		for key, sessionID := range shard.upgrades {
+			if moved(key) {
+				snapshot.Upgrades[key] = sessionID
+				delete(shard.upgrades, key)
+			}
+		}
+		for key, record := range shard.completions {
+			if !keep(record.ResourceID) {
+				snapshot.Completions[key] = record
+				delete(shard.completions, key)
+			}
+		}
+		for name, sem := range shard.semaphores {
+			if !keep(name) {
+				snapshot.Semaphores[name] = sem
+				delete(shard.semaphores, name)
+			}
+		}
+		for key := range shard.aborted {
+			if moved(key) {
+				delete(shard.aborted, key)
+			}
+		}
+		for key := range shard.outcomes {
+			if moved(key) {
+				delete(shard.outcomes, key)
+			}
+		}
+		for key := range shard.resourceLocks {
+			if moved(key) {
+				delete(shard.resourceLocks, key)
+			}
+		}
+		for key, subs := range shard.subscribers {
+			if moved(key) {
+				subscribers = append(subscribers, subs...)
+				delete(shard.subscribers, key)
+			}
+		}
+	}
+	lm.unlockAllShards()
+
+	for _, sub := range subscribers {
+		sub.Close()
+	}
+	return snapshot
+}
+
+// mergeResources 合并其他服务端交接来的资源状态，持有者和信号量持有者的租约从现在开始重新计算
+// 本地已有持有者的key（交接期间在本地授予的锁）保留本地持有者，交接来的持有者被丢弃（续约会失败）；
+// 交接来的排队请求排在本地排队请求之前，fencing token 计数器取较大值，保证token仍然递增
+// 启用持久化时合并后立即生成快照：合并的状态没有写入WAL，否则重启后交接来的持有者和等待者丢失，
+// 之后针对这些key的WAL记录也会在不同的队列上重放
+// 返回：合并的持有者数量
+func (lm *LockManager) mergeResources(snapshot *lockSnapshot) int {
+	lm.lockAllShards()
+	merged := lm.mergeResourcesLocked(snapshot)
+	var persisted *lockSnapshot
+	if lm.store != nil {
+		// 在释放分段锁之前复制状态并切换WAL分段，之后的WAL记录都在快照之后重放
+		var err error
+		if persisted, err = lm.beginSnapshotLocked(); err != nil {
+			log.Printf("[Handoff] 错误: 合并后快照失败，重启后交接来的资源状态会丢失: %v", err)
+		}
+	}
+	lm.unlockAllShards()
+
+	if persisted != nil {
+		if err := lm.finishSnapshot(persisted); err != nil {
+			log.Printf("[Handoff] 错误: 合并后写入快照失败，重启后交接来的资源状态会丢失: %v", err)
+		}
+	}
+	return merged
+}
+
+// mergeResourcesLocked 把交接来的资源状态合并到分段（见 mergeResources）
+// 注意：调用此函数时，所有分段的 shard.mu 都必须已经加锁
+func (lm *LockManager) mergeResourcesLocked(snapshot *lockSnapshot) int {
+	now := lm.now()
+	merged := 0
+
+	ensureResourceLock := func(shard *resourceShard, key string) {
+		if _, exists := shard.resourceLocks[key]; !exists {
+			shard.resourceLocks[key] = &sync.Mutex{}
+		}
+	}
+	for key, token := range snapshot.FencingTokens {
+		_, resourceID, ok := splitLockKey(key)
+		if !ok {
+			continue
+		}
+		shard := lm.getShard(resourceID)
+		if token > shard.fencingTokens[key] {
+			shard.fencingTokens[key] = token
+		}
+	}
+	for key, lockInfo := range snapshot.Locks {
+		shard := lm.getShard(lockInfo.Request.ResourceID)
+		if _, held := shard.locks[key]; held || len(shard.shared[key]) > 0 {
+			log.Printf("[Handoff] 本地已有持有者，丢弃交接来的锁: key=%s, node=%s", key, lockInfo.Request.NodeID)
+			continue
+		}
+		lockInfo.LeaseExpiresAt = lm.leaseDeadline(now)
+		shard.locks[key] = lockInfo
+		ensureResourceLock(shard, key)
+		merged++
+	}
+	for key, holders := range snapshot.Shared {
+		for _, holder := range holders {
+			shard := lm.getShard(holder.Request.ResourceID)
+			if _, held := shard.locks[key]; held {
+				log.Printf("[Handoff] 本地已有独占持有者，丢弃交接来的共享锁: key=%s, node=%s", key, holder.Request.NodeID)
+				continue
+			}
+			holder.LeaseExpiresAt = lm.leaseDeadline(now)
+			lm.addSharedHolderLocked(shard, key, holder)
+			ensureResourceLock(shard, key)
+			merged++
+		}
+	}
+	for key, queue := range snapshot.Queues {
+		if len(queue) == 0 {
+			continue
+		}
+		shard := lm.getShard(queue[0].ResourceID)
+		combined := append([]*LockRequest{}, queue...)
+		for _, local := range shard.queues[key] {
+			duplicate := false
+			for _, imported := range queue {
+				if imported.SessionID == local.SessionID {
+					duplicate = true
+					break
+				}
+			}
+			if !duplicate {
+				combined = append(combined, local)
+			}
+		}
+		shard.queues[key] = combined
+		ensureResourceLock(shard, key)
+	}
+	for key, sessionID := range snapshot.Upgrades {
+		_, resourceID, ok := splitLockKey(key)
+		if !ok {
+			continue
+		}
+		shard := lm.getShard(resourceID)
+		if _, exists := shard.upgrades[key]; !exists {
+			shard.upgrades[key] = sessionID
+		}
+	}
+	for key, record := range snapshot.Completions {
+		shard := lm.getShard(record.ResourceID)
+		if local, exists := shard.completions[key]; !exists || local.CompletedAt.Before(record.CompletedAt) {
+			lm.storeCompletionLocked(shard, key, record)
+		}
+	}
+	// 交接来的队列所在的key没有独占持有者时（例如持有者在交接期间释放），与操作失败后相同：把锁分配给队头并通知，
+	// 否则队头要等到该key上的下一次解锁才会被分配
+	for key, queue := range snapshot.Queues {
+		if len(queue) == 0 {
+			continue
+		}
+		shard := lm.getShard(queue[0].ResourceID)
+		if _, held := shard.locks[key]; !held {
+			lm.handOffLocked(shard, key)
+		}
+	}
+	for name, imported := range snapshot.Semaphores {
+		shard := lm.getShard(name)
+		sem := imported.copy()
+		for _, holder := range sem.Holders {
+			holder.LeaseExpiresAt = lm.leaseDeadline(now)
+		}
+		if local, exists := shard.semaphores[name]; exists {
+			// 交接期间本地创建的信号量：合并持有者，交接来的等待者排在前面
+			for sessionID, holder := range local.Holders {
+				if _, duplicate := sem.Holders[sessionID]; !duplicate {
+					sem.Holders[sessionID] = holder
+				}
+			}
+			for _, waiter := range local.Waiters {
+				if _, holding := sem.Holders[waiter.SessionID]; !holding {
+					sem.Waiters = append(sem.Waiters, waiter)
+				}
+			}
+			if local.Override && !sem.Override {
+				sem.Capacity, sem.Override = local.Capacity, true
+			}
+		}
+		shard.semaphores[name] = sem
+		merged += len(imported.Holders)
+		lm.grantWaitersLocked(shard, sem, now)
+	}
+	return merged
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// StartLeaseReaper 启动后台租约回收协程
+// 每隔 interval 扫描所有分段，回收租约已过期的锁（视为持有者操作失败）
+// 返回 stop 函数，用于停止回收协程
+func (lm *LockManager) StartLeaseReaper(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				lm.reapExpiredLeases(lm.now())
+				lm.reapExpiredWaiters(lm.now())
+				lm.reapExpiredSemaphores(lm.now())
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// reapExpiredLeases 扫描所有分段，回收在 now 之前到期的租约
+// 返回：被回收的锁数量
+func (lm *LockManager) reapExpiredLeases(now time.Time) int {
+	if lm.LeaseTTL <= 0 {
+		return 0
+	}
+
+	reaped := 0
+	for _, shard := range lm.shards {
+		// 先在读锁下收集候选key，避免长时间持有分段锁
+		shard.mu.RLock()
+		var expiredKeys []string
+		for key, lockInfo := range shard.locks {
+			if lm.leaseExpired(lockInfo, now) {
+				expiredKeys = append(expiredKeys, key)
+			}
+		}
+		for key, holders := range shard.shared {
+			for _, holder := range holders {
+				if lm.leaseExpired(holder, now) {
+					expiredKeys = append(expiredKeys, key)
+					break
+				}
+			}
+		}
+		shard.mu.RUnlock()
+
+		for _, key := range expiredKeys {
+			reaped += lm.expireLease(shard, key, now)
+		}
+	}
+	return reaped
+}
+
+// expireLease 回收一个key上的过期租约（独占持有者，或租约过期的共享持有者）
+// 加锁顺序与 TryLock/Unlock 保持一致：资源锁 -> 分段锁，并在加锁后重新检查是否仍然过期
+// （持有者可能在收集候选key之后完成了续约或解锁）
+// 返回：被回收的持有者数量
+func (lm *LockManager) expireLease(shard *resourceShard, key string, now time.Time) int {
+	shard.mu.Lock()
+	resourceLock, exists := shard.resourceLocks[key]
+	shard.mu.Unlock()
+	if !exists {
+		return 0
+	}
+
+	resourceLock.Lock()
+	defer resourceLock.Unlock()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if lockInfo, exists := shard.locks[key]; exists {
+		if !lm.leaseExpired(lockInfo, now) {
+			return 0
+		}
+		log.Printf("[LeaseReaper] 租约过期，回收锁: key=%s, node=%s, lease_expires_at=%s",
+			key, lockInfo.Request.NodeID, lockInfo.LeaseExpiresAt.Format(time.RFC3339Nano))
+		lm.revokeLocked(shard, key, lockInfo, now, "锁持有者租约过期，视为操作失败")
+		return 1
+	}
+
+	var expired []*LockInfo
+	for _, holder := range shard.shared[key] {
+		if lm.leaseExpired(holder, now) {
+			expired = append(expired, holder)
+		}
+	}
+	for _, holder := range expired {
+		log.Printf("[LeaseReaper] 租约过期，回收共享锁: key=%s, node=%s, lease_expires_at=%s",
+			key, holder.Request.NodeID, holder.LeaseExpiresAt.Format(time.RFC3339Nano))
+		lm.revokeSharedLocked(shard, key, holder, now, "共享锁持有者租约过期")
+	}
+	return len(expired)
+}
+
+// RevokeLease 回收指定 fencing token 对应的锁（视为持有者操作失败），不检查租约是否到期
+// 用于复制模式：leader 判断租约过期后通过 Raft 日志提交回收命令，各副本按token确定性地执行
+// 返回：是否回收成功（锁不存在、已完成或token不一致时返回false）
+func (lm *LockManager) RevokeLease(lockType, resourceID string, fencingToken uint64) bool {
+	key := LockKey(lockType, resourceID)
+	shard := lm.getShard(resourceID)
+
+	shard.mu.Lock()
+	resourceLock, exists := shard.resourceLocks[key]
+	shard.mu.Unlock()
+	if !exists {
+		return false
+	}
+
+	resourceLock.Lock()
+	defer resourceLock.Unlock()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	lockInfo, exists := shard.locks[key]
+	if !exists {
+		// 共享持有者：按token查找
+		for _, holder := range shard.shared[key] {
+			if holder.FencingToken == fencingToken {
+				log.Printf("[RevokeLease] 回收共享锁: key=%s, node=%s, fencing_token=%d", key, holder.Request.NodeID, fencingToken)
+				lm.revokeSharedLocked(shard, key, holder, lm.now(), "共享锁持有者租约过期")
+				return true
+			}
+		}
+		return false
+	}
+	if lockInfo.Completed || lockInfo.FencingToken != fencingToken {
+		return false
+	}
+
+	log.Printf("[RevokeLease] 回收锁: key=%s, node=%s, fencing_token=%d", key, lockInfo.Request.NodeID, fencingToken)
+	lm.revokeLocked(shard, key, lockInfo, lm.now(), "锁持有者租约过期，视为操作失败")
+	return true
+}
+
+// revokeLocked 把持有者视为操作失败：通知订阅者持有者已丢失，并把锁交给队头节点
+// reason：holder_lost 事件中的错误信息（租约过期、节点失效等）
+// 注意：调用此函数时，资源锁和 shard.mu 都必须已经加锁
+func (lm *LockManager) revokeLocked(shard *resourceShard, key string, lockInfo *LockInfo, now time.Time, reason string) {
+	// 视为操作失败
+	lockInfo.Completed = true
+	lockInfo.Success = false
+	lockInfo.CompletedAt = now
+	lm.recordOutcomeLocked(shard, key, lockInfo, reason)
+
+	// 通知订阅者：持有者已丢失
+	lm.broadcastEvent(shard, key, &OperationEvent{
+		Event:        EventTypeHolderLost,
+		Type:         lockInfo.Request.Type,
+		ResourceID:   lockInfo.Request.ResourceID,
+		NodeID:       lockInfo.Request.NodeID,
+		SessionID:    lockInfo.Request.SessionID,
+		Success:      false,
+		Error:        reason,
+		CompletedAt:  now,
+		FencingToken: lockInfo.FencingToken,
+	})
+
+	// 与操作失败相同：把锁交给队头节点
+	lm.handOffLocked(shard, key)
+}
+
+// revokeSharedLocked 移除已丢失的共享持有者：通知订阅者持有者已丢失，其余共享持有者不受影响
+// 注意：调用此函数时，资源锁和 shard.mu 都必须已经加锁
+func (lm *LockManager) revokeSharedLocked(shard *resourceShard, key string, holder *LockInfo, now time.Time, reason string) {
+	lm.broadcastEvent(shard, key, &OperationEvent{
+		Event:        EventTypeHolderLost,
+		Type:         holder.Request.Type,
+		ResourceID:   holder.Request.ResourceID,
+		NodeID:       holder.Request.NodeID,
+		SessionID:    holder.Request.SessionID,
+		Success:      false,
+		Error:        reason,
+		CompletedAt:  now,
+		FencingToken: holder.FencingToken,
+		Mode:         LockModeShared,
+	})
+
+	lm.removeSharedHolderLocked(shard, key, holder.Request.SessionID, false)
+	lm.afterSharedReleaseLocked(shard, key)
+}
+
+// expiredLease 一个已到期的租约（复制模式下 leader 据此提交回收命令）
+type expiredLease struct {
+	Type         string `json:"type"`
+	ResourceID   string `json:"resource_id"`
+	FencingToken uint64 `json:"fencing_token"`
+}
+
+// expiredLeases 收集在 now 之前到期的租约（只读，不修改锁状态）
+func (lm *LockManager) expiredLeases(now time.Time) []expiredLease {
+	if lm.LeaseTTL <= 0 {
+		return nil
+	}
+
+	var expired []expiredLease
+	for _, shard := range lm.shards {
+		shard.mu.RLock()
+		for _, lockInfo := range shard.locks {
+			if lm.leaseExpired(lockInfo, now) {
+				expired = append(expired, expiredLease{
+					Type:         lockInfo.Request.Type,
+					ResourceID:   lockInfo.Request.ResourceID,
+					FencingToken: lockInfo.FencingToken,
+				})
+			}
+		}
+		for _, holders := range shard.shared {
+			for _, holder := range holders {
+				if lm.leaseExpired(holder, now) {
+					expired = append(expired, expiredLease{
+						Type:         holder.Request.Type,
+						ResourceID:   holder.Request.ResourceID,
+						FencingToken: holder.FencingToken,
+					})
+				}
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	return expired
+}
+
+// leaseExpired 判断锁的租约在 now 时是否已过期
+// 注意：调用此函数时，shard.mu 必须已经加锁（读锁或写锁）
+func (lm *LockManager) leaseExpired(lockInfo *LockInfo, now time.Time) bool {
+	if lockInfo.Completed || lockInfo.LeaseExpiresAt.IsZero() {
+		return false
+	}
+	return now.After(lockInfo.LeaseExpiresAt)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLeaseExpiryHandsOffToQueue 测试持有者租约过期后锁被回收并交给队头节点
+func TestLeaseExpiryHandsOffToQueue(t *testing.T) {
+	lm := NewLockManager(true)
+	lm.LeaseTTL = 50 * time.Millisecond
+	resourceID := "sha256:lease1"
+
+	sub := &mockSubscriber{events: make([]OperationEvent, 0)}
+	lm.Subscribe(OperationTypePull, resourceID, sub)
+
+	// node-1 获得锁后崩溃（不再续约也不解锁）
+	holder := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	acquired, _, _ := lm.TryLock(holder)
+	if !acquired {
+		t.Fatal("node-1 应该获得锁")
+	}
+	// node-2 加入等待队列
+	acquired, _, _ = lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2"})
+	if acquired {
+		t.Fatal("node-2 不应该立即获得锁")
+	}
+
+	// 租约未到期时不回收
+	if n := lm.reapExpiredLeases(time.Now()); n != 0 {
+		t.Fatalf("租约未到期，不应回收，实际回收 %d", n)
+	}
+
+	// 租约到期后回收
+	if n := lm.reapExpiredLeases(time.Now().Add(100 * time.Millisecond)); n != 1 {
+		t.Fatalf("期望回收1个锁，实际 %d", n)
+	}
+
+	lockInfo := lm.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-2" {
+		t.Fatalf("租约过期后锁应交给 node-2，实际 %+v", lockInfo)
+	}
+	if lm.GetQueueLength(OperationTypePull, resourceID) != 0 {
+		t.Error("队列应该已清空")
+	}
+
+	// 订阅者应先收到持有者丢失事件，再收到锁分配事件
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if len(sub.events) != 2 {
+		t.Fatalf("期望收到2个事件，实际 %d: %+v", len(sub.events), sub.events)
+	}
+	if sub.events[0].Event != EventTypeHolderLost || sub.events[0].NodeID != "node-1" || sub.events[0].Success {
+		t.Errorf("第一个事件应为 node-1 的 holder_lost 事件，实际 %+v", sub.events[0])
+	}
+	if sub.events[1].Event != EventTypeLockAssigned || sub.events[1].NodeID != "node-2" {
+		t.Errorf("第二个事件应为分配给 node-2 的 lock_assigned 事件，实际 %+v", sub.events[1])
+	}
+
+	// 原持有者已丢失锁，续约和解锁都应失败
+	if lm.KeepAlive(&KeepAliveRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", FencingToken: holder.FencingToken}) {
+		t.Error("node-1 的租约已被回收，续约应该失败")
+	}
+	if lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", FencingToken: holder.FencingToken}) {
+		t.Error("node-1 的租约已被回收，解锁应该失败")
+	}
+}
+
+// TestKeepAliveExtendsLease 测试续约会延长租约，reaper 不会回收仍在心跳的持有者
+func TestKeepAliveExtendsLease(t *testing.T) {
+	lm := NewLockManager(true)
+	lm.LeaseTTL = 80 * time.Millisecond
+	resourceID := "sha256:lease2"
+
+	holder := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	acquired, _, _ := lm.TryLock(holder)
+	if !acquired {
+		t.Fatal("node-1 应该获得锁")
+	}
+
+	stop := lm.StartLeaseReaper(10 * time.Millisecond)
+	defer stop()
+
+	// 持续心跳，总时长超过租约
+	for i := 0; i < 6; i++ {
+		time.Sleep(30 * time.Millisecond)
+		if !lm.KeepAlive(&KeepAliveRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", FencingToken: holder.FencingToken}) {
+			t.Fatalf("第 %d 次续约失败", i+1)
+		}
+	}
+
+	// 其他节点不能为该锁续约
+	if lm.KeepAlive(&KeepAliveRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2", FencingToken: holder.FencingToken}) {
+		t.Error("非持有者续约应该失败")
+	}
+
+	// 停止心跳后租约过期，锁被回收
+	time.Sleep(150 * time.Millisecond)
+	if lockInfo := lm.GetLockInfo(OperationTypePull, resourceID); lockInfo != nil {
+		t.Errorf("停止心跳后锁应被回收，实际仍被 %s 持有", lockInfo.Request.NodeID)
+	}
+}
+
+// TestLeaseUsesInjectedClock 测试获得锁时间、租约到期时间和续约都使用 LockManager 的时钟
+func TestLeaseUsesInjectedClock(t *testing.T) {
+	lm := NewLockManager(true)
+	lm.LeaseTTL = time.Minute
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	lm.clock = func() time.Time { return now }
+	resourceID := "sha256:lease-clock"
+
+	holder := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	if acquired, _, _ := lm.TryLock(holder); !acquired {
+		t.Fatal("node-1 应该获得锁")
+	}
+	lockInfo := lm.GetLockInfo(OperationTypePull, resourceID)
+	if !lockInfo.AcquiredAt.Equal(now) || !lockInfo.LeaseExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("获得锁时间和租约到期时间应使用注入的时钟: %v, %v", lockInfo.AcquiredAt, lockInfo.LeaseExpiresAt)
+	}
+
+	waiter := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2"}
+	lm.TryLock(waiter)
+	now = now.Add(10 * time.Second)
+	if !lm.KeepAlive(&KeepAliveRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", FencingToken: holder.FencingToken}) {
+		t.Fatal("node-1 续约失败")
+	}
+	if lockInfo := lm.GetLockInfo(OperationTypePull, resourceID); !lockInfo.LeaseExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("续约应从注入的时钟计算租约到期时间: %v", lockInfo.LeaseExpiresAt)
+	}
+
+	// 从队列分配锁时同样使用注入的时钟
+	now = now.Add(10 * time.Second)
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", FencingToken: holder.FencingToken, Error: "操作失败"})
+	lockInfo = lm.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-2" {
+		t.Fatalf("锁应交给 node-2，实际 %+v", lockInfo)
+	}
+	if !lockInfo.AcquiredAt.Equal(now) || !lockInfo.LeaseExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("从队列获得锁的时间应使用注入的时钟: %v, %v", lockInfo.AcquiredAt, lockInfo.LeaseExpiresAt)
+	}
+}
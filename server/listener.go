@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 监听地址
+//
+// 除 TCP 端口（PORT、GRPC_PORT）外，服务端可以监听 unix socket（LOCK_SOCKET），也可以接收 systemd socket 激活
+// 传入的监听 socket：重启服务端时 socket 由 systemd 持有，重启期间到达的连接在队列中等待新进程 accept，不会被拒绝。
+// 激活的 socket 按 FileDescriptorName 区分：名称为 grpc 的提供 gRPC 接口，其余的提供 HTTP 接口。
+
+// listenFdsStart systemd 传入的第一个文件描述符（SD_LISTEN_FDS_START）
+const listenFdsStart = 3
+
+// GRPCListenerName socket 激活时提供 gRPC 接口的 socket 名称（.socket 单元中的 FileDescriptorName）
+const GRPCListenerName = "grpc"
+
+// ActivationListener socket 激活传入的监听 socket
+type ActivationListener struct {
+	Name     string // FileDescriptorName（未设置时为 systemd 的默认名称）
+	Listener net.Listener
+}
+
+// ActivationListeners 读取 systemd socket 激活传入的监听 socket（LISTEN_PID、LISTEN_FDS、LISTEN_FDNAMES）
+// 没有激活时返回空；读取后清除这些环境变量，避免子进程误用
+func ActivationListeners() ([]ActivationListener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	var names []string
+	if value := os.Getenv("LISTEN_FDNAMES"); value != "" {
+		names = strings.Split(value, ":")
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]ActivationListener, 0, count)
+	for i := 0; i < count; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(listenFdsStart+i), name)
+		listener, err := net.FileListener(file)
+		file.Close() // FileListener 复制了文件描述符
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Listener.Close()
+			}
+			return nil, fmt.Errorf("socket 激活传入的文件描述符 %d（%s）不是监听 socket: %w", listenFdsStart+i, name, err)
+		}
+		listeners = append(listeners, ActivationListener{Name: name, Listener: listener})
+	}
+	return listeners, nil
+}
+
+// ListenUnix 在 path 上监听 unix socket：创建所在目录，删除上次运行残留的 socket 文件，设置权限
+func ListenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建 socket 目录失败: %w", err)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s 已存在且不是 socket", path)
+		}
+		log.Printf("[ListenUnix] 删除残留的 socket 文件: %s", path)
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("监听 socket %s 失败: %w", path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("设置 socket 权限失败: %w", err)
+	}
+	return listener, nil
+}
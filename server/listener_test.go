@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestListenUnix 测试监听 unix socket：删除残留的 socket 文件、设置权限、拒绝覆盖普通文件
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "run", "lock.sock")
+
+	first, err := ListenUnix(path, 0600)
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	// 模拟进程被杀死后残留的 socket 文件
+	first.(*net.UnixListener).SetUnlinkOnClose(false)
+	first.Close()
+
+	listener, err := ListenUnix(path, 0600)
+	if err != nil {
+		t.Fatalf("应删除残留的 socket 文件后重新监听: %v", err)
+	}
+	defer listener.Close()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("socket 权限应为 0600: info=%v, err=%v", info, err)
+	}
+
+	regular := filepath.Join(dir, "regular")
+	os.WriteFile(regular, []byte("data"), 0644)
+	if _, err := ListenUnix(regular, 0600); err == nil {
+		t.Error("路径是普通文件时应拒绝监听")
+	}
+}
+
+// TestActivationListeners 测试读取 socket 激活传入的监听 socket
+// 子进程通过 sh 设置 LISTEN_PID 为自己的 pid 后 exec 测试程序（与 systemd 的行为相同）
+func TestActivationListeners(t *testing.T) {
+	if os.Getenv("LOCK_TEST_ACTIVATION_CHILD") == "1" {
+		listeners, err := ActivationListeners()
+		if err != nil || len(listeners) != 2 {
+			fmt.Printf("ERROR listeners=%v err=%v\n", listeners, err)
+			os.Exit(1)
+		}
+		if os.Getenv("LISTEN_FDS") != "" {
+			fmt.Println("ERROR 环境变量未清除")
+			os.Exit(1)
+		}
+		for _, listener := range listeners {
+			fmt.Printf("%s=%s\n", listener.Name, listener.Listener.Addr())
+		}
+		os.Exit(0)
+	}
+
+	if listeners, err := ActivationListeners(); err != nil || len(listeners) != 0 {
+		t.Fatalf("没有 socket 激活时应返回空: listeners=%v, err=%v", listeners, err)
+	}
+
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer httpListener.Close()
+	grpcListener, err := ListenUnix(filepath.Join(t.TempDir(), "grpc.sock"), 0600)
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer grpcListener.Close()
+	httpFile, _ := httpListener.(*net.TCPListener).File()
+	defer httpFile.Close()
+	grpcFile, _ := grpcListener.(*net.UnixListener).File()
+	defer grpcFile.Close()
+
+	cmd := exec.Command("sh", "-c", `LISTEN_PID=$$ exec "$0" -test.run=^TestActivationListeners$`, os.Args[0])
+	cmd.Env = append(os.Environ(), "LOCK_TEST_ACTIVATION_CHILD=1", "LISTEN_FDS=2", "LISTEN_FDNAMES=http:"+GRPCListenerName)
+	cmd.ExtraFiles = []*os.File{httpFile, grpcFile} // 子进程中为文件描述符 3、4
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("子进程失败: %v\n%s", err, output)
+	}
+	expected := fmt.Sprintf("http=%s\n%s=%s\n", httpListener.Addr(), GRPCListenerName, grpcListener.Addr())
+	if string(output) != expected {
+		t.Errorf("期望:\n%s实际:\n%s", expected, output)
+	}
+}
@@ -3,8 +3,8 @@ package server
 import (
 	"hash/fnv"
 	"log"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,6 +12,13 @@ const (
 	// shardCount 分段锁的数量，建议使用2的幂次方
 	// 可以根据实际并发需求调整，例如：16, 32, 64, 128
 	shardCount = 32
+
+	// DefaultLeaseTTL 锁租约的默认时长
+	// 持有者需要在租约到期前通过 /lock/keepalive 续约，否则锁会被 reaper 回收
+	DefaultLeaseTTL = 30 * time.Second
+
+	// DefaultDeadlockCheckInterval 死锁检测的默认间隔
+	DefaultDeadlockCheckInterval = 5 * time.Second
 )
 
 // resourceShard 资源分段，每个分段有自己的锁和数据结构
@@ -33,6 +40,30 @@ type resourceShard struct {
 	// 订阅者：key -> []Subscriber
 	// key = lockType:resourceID
 	subscribers map[string][]Subscriber
+
+	// fencing token 计数器：key -> 最近一次授予的token
+	// 锁释放后也保留，保证同一个key上的token严格递增
+	fencingTokens map[string]uint64
+
+	// 共享持有者：key -> sessionID -> LockInfo
+	// 与 locks 中的独占持有者互斥：同一个key要么没有持有者，要么一个独占持有者，要么若干共享持有者
+	shared map[string]map[string]*LockInfo
+
+	// 等待升级：key -> sessionID（共享持有者请求升级为独占，等待其他共享持有者释放）
+	upgrades map[string]string
+
+	// 因死锁被中止的等待：key -> sessionID -> 中止时间
+	// 该会话下一次在这个key上加锁时返回死锁错误（客户端可能在收到 aborted 事件之前重新请求）
+	aborted map[string]map[string]time.Time
+
+	// 完成记录：key -> 最近一次成功完成的独占操作（有效期内的独占请求直接跳过操作）
+	completions map[string]*CompletionRecord
+
+	// 最近一次结束的独占操作：key -> 结果（成功或失败，/lock/status 据此报告 completed/success）
+	outcomes map[string]*lockOutcome
+
+	// 信号量：name -> 信号量（按名称分段）
+	semaphores map[string]*Semaphore
 }
 
 // LockManager 锁管理器
@@ -44,6 +75,52 @@ type LockManager struct {
 	// true:  允许多节点下载，锁被占用时加入等待队列
 	// false: 禁止多节点下载，锁被占用时直接返回失败
 	AllowMultiNodeDownload bool
+
+	// LeaseTTL 每次授予锁（以及每次续约）时租约的有效时长
+	// <= 0 表示不启用租约，锁只能通过 /unlock 释放
+	LeaseTTL time.Duration
+
+	// Compatibility 同一资源上不同操作类型之间的兼容矩阵（默认 DefaultCompatibilityMatrix）
+	// 冲突的请求排队等待正在进行的操作结束；复制模式下所有副本必须使用相同的矩阵
+	Compatibility CompatibilityMatrix
+
+	// store 持久化存储（WAL + 快照），为nil表示不持久化
+	store *StateStore
+	// repairing WAL写入失败后正在后台生成快照
+	repairing atomic.Bool
+
+	// clock 请求时间戳和等待期限使用的时钟，为nil时使用 time.Now
+	// 复制模式下为正在应用的日志条目中leader提议时的时间，保证各副本对等待期限的判断一致
+	clock func() time.Time
+
+	// DeadlockCheckInterval 死锁检测间隔（<= 0 表示不检测）
+	DeadlockCheckInterval time.Duration
+
+	// deadlocks 最近检测到的死锁（最多 maxDeadlockReports 条，用于管理接口）
+	deadlocksMu sync.Mutex
+	deadlocks   []*DeadlockReport
+
+	// NodeTimeout 节点超过此时长没有心跳即判定为失效（<= 0 表示不检测）
+	NodeTimeout time.Duration
+
+	// members 已注册的节点及其订阅连接
+	members membership
+
+	// CompletionTTL 完成记录的保留时长（<= 0 表示不保留，操作成功后的请求重新获得锁）
+	CompletionTTL time.Duration
+
+	// MaxCompletions 最多保留的完成记录数量（按分段平均分配）
+	MaxCompletions int
+
+	// SemaphoreCapacities 各信号量的容量（未列出的信号量使用 DefaultSemaphoreCapacity，<= 0 表示不限制）
+	// 可以通过 /semaphore/capacity 在运行时覆盖；复制模式下所有副本必须使用相同的配置
+	SemaphoreCapacities map[string]int
+
+	// DefaultSemaphoreCapacity 未配置的信号量的容量（<= 0 表示不限制）
+	DefaultSemaphoreCapacity int
+
+	// metrics 监控指标（/metrics）
+	metrics *lockMetrics
 }
 
 // getShard 根据resourceID获取对应的分段
@@ -60,9 +137,23 @@ func (lm *LockManager) getShard(resourceID string) *resourceShard {
 // allowMultiNodeDownload: 是否允许多节点下载模式
 //   - true:  允许多节点下载，锁被占用时加入等待队列
 //   - false: 禁止多节点下载，锁被占用时直接返回失败
-func NewLockManager(allowMultiNodeDownload bool) *LockManager {
+//
+// opts: 可选配置，例如 WithStateStore 启用持久化并从快照和WAL恢复锁状态
+func NewLockManager(allowMultiNodeDownload bool, opts ...LockManagerOption) *LockManager {
 	lm := &LockManager{
 		AllowMultiNodeDownload: allowMultiNodeDownload,
+		LeaseTTL:               DefaultLeaseTTL,
+		Compatibility:          DefaultCompatibilityMatrix(),
+		DeadlockCheckInterval:  DefaultDeadlockCheckInterval,
+		NodeTimeout:            DefaultNodeTimeout,
+		CompletionTTL:          DefaultCompletionTTL,
+		MaxCompletions:         DefaultMaxCompletions,
+		SemaphoreCapacities:    make(map[string]int),
+		metrics:                newLockMetrics(),
+		members: membership{
+			nodes:         make(map[string]*NodeInfo),
+			subscriptions: make(map[string][]nodeSubscription),
+		},
 	}
 	// 初始化所有分段
 	for i := 0; i < shardCount; i++ {
@@ -71,8 +162,24 @@ func NewLockManager(allowMultiNodeDownload bool) *LockManager {
 			locks:         make(map[string]*LockInfo),
 			queues:        make(map[string][]*LockRequest),
 			subscribers:   make(map[string][]Subscriber),
+			fencingTokens: make(map[string]uint64),
+			shared:        make(map[string]map[string]*LockInfo),
+			upgrades:      make(map[string]string),
+			aborted:       make(map[string]map[string]time.Time),
+			completions:   make(map[string]*CompletionRecord),
+			outcomes:      make(map[string]*lockOutcome),
+			semaphores:    make(map[string]*Semaphore),
 		}
 	}
+	for _, opt := range opts {
+		opt(lm)
+	}
+
+	// 启用持久化时，从快照和WAL重建分段状态
+	if lm.store != nil {
+		snapshot, records := lm.store.takeRecovered()
+		lm.restoreState(snapshot, records)
+	}
 	return lm
 }
 
@@ -80,7 +187,8 @@ func NewLockManager(allowMultiNodeDownload bool) *LockManager {
 // 仲裁逻辑：
 // 1. 检查是否有其他节点在操作（锁是否被占用）
 // 2. 如果锁被占用，检查是否是同一节点重新请求（队列场景）
-// 3. 如果锁未被占用，创建新的资源锁
+// 3. 如果锁未被占用，检查同一资源上是否有冲突的操作（Compatibility），有则排队等待
+// 4. 没有冲突时创建新的资源锁
 //
 // 注意：引用计数检查应该在客户端进行（ShouldSkipOperation），
 // 服务端只负责锁的分配和队列管理，不检查引用计数。
@@ -91,10 +199,23 @@ func (lm *LockManager) TryLock(request *LockRequest) (bool, bool, string) {
 	key := LockKey(request.Type, request.ResourceID)
 	shard := lm.getShard(request.ResourceID) // 获取对应的分段（只根据resourceID分段，确保同一镜像层的所有操作类型互斥）
 
-	request.Timestamp = time.Now()
+	request.Timestamp = lm.now()
+	request.FencingToken = 0 // 由服务端在授予锁时填写
+	request.WaitDeadline = time.Time{}
+	if request.SessionID == "" {
+		request.SessionID = newSessionID()
+	}
 
 	// ========== 阶段1：获取分段锁，检查/创建资源锁 ==========
 	shard.mu.Lock()
+	if lm.consumeAbortLocked(shard, key, request.SessionID, request.Timestamp) {
+		shard.mu.Unlock()
+		return false, false, deadlockAbortMessage
+	}
+	if lm.skipCompletedLocked(shard, key, request) {
+		shard.mu.Unlock()
+		return false, true, ""
+	}
 
 	var resourceLock *sync.Mutex
 	if existingLock, exists := shard.resourceLocks[key]; exists {
@@ -112,6 +233,13 @@ func (lm *LockManager) TryLock(request *LockRequest) (bool, bool, string) {
 	resourceLock.Lock()
 	defer resourceLock.Unlock()
 
+	// 共享模式：检查持有者集合
+	if request.shared() {
+		shard.mu.Lock()
+		defer shard.mu.Unlock()
+		return lm.tryLockSharedLocked(shard, key, request)
+	}
+
 	// ========== 阶段3：重新获取分段锁访问locks map ==========
 	shard.mu.RLock()
 	lockInfo, exists := shard.locks[key]
@@ -128,67 +256,116 @@ func (lm *LockManager) TryLock(request *LockRequest) (bool, bool, string) {
 			} else {
 				// 操作已完成但失败：清理锁并分配锁给队列中的下一个节点
 				log.Printf("[TryLock] 操作已完成但失败: key=%s, 处理队列", key)
-				shard.mu.Lock()
-				nextNodeID := lm.processQueue(shard, key)
-				shard.mu.Unlock()
-				if nextNodeID != "" {
-					shard.mu.Lock()
-					lm.notifyLockAssigned(shard, key, nextNodeID)
-					shard.mu.Unlock()
-				}
 			}
 			shard.mu.Lock()
-			delete(shard.locks, key)
+			lm.handOffLocked(shard, key)
 			shard.mu.Unlock()
 			return false, false, ""
 		} else {
 			// 锁被占用但操作未完成
-			if lockInfo.Request.NodeID == request.NodeID {
-				// 同一节点重新请求（队列场景）
-				log.Printf("[TryLock] 同一节点重新请求: key=%s, node=%s, 更新锁信息",
-					key, request.NodeID)
+			if lockInfo.Request.SessionID == request.SessionID {
+				// 同一会话重新请求（队列场景：锁已分配给排队的会话）
+				log.Printf("[TryLock] 同一会话重新请求: key=%s, node=%s, session=%s, 更新锁信息",
+					key, request.NodeID, request.SessionID)
 				shard.mu.Lock()
+				request.FencingToken = lockInfo.FencingToken
 				lockInfo.Request = request
-				lockInfo.AcquiredAt = time.Now()
+				lockInfo.AcquiredAt = lm.now()
+				lockInfo.LeaseExpiresAt = lm.leaseDeadline(lockInfo.AcquiredAt)
+				lm.appendWAL(&WALRecord{
+					Op:           WALOpGrant,
+					Type:         request.Type,
+					ResourceID:   request.ResourceID,
+					Request:      request,
+					FencingToken: lockInfo.FencingToken,
+					AcquiredAt:   lockInfo.AcquiredAt,
+				})
 				shard.mu.Unlock()
 				return true, false, ""
 			} else {
-				// 其他节点持有锁
+				// 其他会话持有锁（可能是其他节点，也可能是同一节点上的其他会话）
 				if !lm.AllowMultiNodeDownload {
 					// 多节点下载模式关闭：直接返回失败，不加入队列
 					log.Printf("[TryLock] 多节点下载已关闭，锁被占用: key=%s, node=%s, 当前持有者=%s",
 						key, request.NodeID, lockInfo.Request.NodeID)
 					return false, false, "多节点下载模式已关闭，锁已被其他节点占用"
 				}
-				// 多节点下载模式开启：加入等待队列
+				// 多节点下载模式开启：加入等待队列（已在队列中的会话重新请求时不重复加入）
+				shard.mu.Lock()
+				if queuedLocked(shard, key, request.SessionID) {
+					shard.mu.Unlock()
+					return false, false, ""
+				}
 				log.Printf("[TryLock] 加入等待队列: key=%s, node=%s, 当前持有者=%s",
 					key, request.NodeID, lockInfo.Request.NodeID)
-				shard.mu.Lock()
 				lm.addToQueue(shard, key, request)
 				shard.mu.Unlock()
 				return false, false, ""
 			}
 		}
 	} else {
-		// 锁不存在，创建新的资源锁
-		log.Printf("[TryLock] 直接获取锁成功: key=%s, node=%s", key, request.NodeID)
+		// 锁不存在：检查同一资源上是否有冲突的操作正在进行
+		// 注意：冲突检查和授予锁必须在同一次分段锁内完成，不同key的授予之间没有资源锁保护
 		shard.mu.Lock()
+		if _, holdsShared := shard.shared[key][request.SessionID]; holdsShared {
+			shard.mu.Unlock()
+			return false, false, "已持有共享锁，请通过 /lock/upgrade 升级为独占锁"
+		}
+		if blocking := lm.blockingLockLocked(shard, request); blocking != nil {
+			if !lm.AllowMultiNodeDownload {
+				shard.mu.Unlock()
+				log.Printf("[TryLock] 多节点下载已关闭，资源被冲突的操作占用: key=%s, node=%s, 冲突操作=%s, 持有者=%s",
+					key, request.NodeID, blocking.Request.Type, blocking.Request.NodeID)
+				return false, false, "多节点下载模式已关闭，资源正在被冲突的操作占用"
+			}
+			if queuedLocked(shard, key, request.SessionID) {
+				shard.mu.Unlock()
+				return false, false, ""
+			}
+			log.Printf("[TryLock] 资源正在执行冲突的操作，加入等待队列: key=%s, node=%s, 冲突操作=%s, 持有者=%s",
+				key, request.NodeID, blocking.Request.Type, blocking.Request.NodeID)
+			lm.addToQueue(shard, key, request)
+			shard.mu.Unlock()
+			return false, false, ""
+		}
+
+		// 没有冲突，创建新的资源锁
+		log.Printf("[TryLock] 直接获取锁成功: key=%s, node=%s", key, request.NodeID)
+		now := lm.now()
+		request.FencingToken = lm.nextFencingToken(shard, key)
 		shard.locks[key] = &LockInfo{
-			Request:    request,
-			AcquiredAt: time.Now(),
-			Completed:  false,
-			Success:    false,
+			Request:        request,
+			AcquiredAt:     now,
+			Completed:      false,
+			Success:        false,
+			FencingToken:   request.FencingToken,
+			LeaseExpiresAt: lm.leaseDeadline(now),
 		}
+		lm.metrics.granted(request, false, now)
+		lm.appendWAL(&WALRecord{
+			Op:           WALOpGrant,
+			Type:         request.Type,
+			ResourceID:   request.ResourceID,
+			Request:      request,
+			FencingToken: request.FencingToken,
+			AcquiredAt:   now,
+		})
 		shard.mu.Unlock()
 		return true, false, ""
 	}
 }
 
 // Unlock 释放锁
+// 必须携带 fencing token：没有 token 的请求无法证明锁没有被重新分配，与过期的 token 一样被拒绝
 func (lm *LockManager) Unlock(request *UnlockRequest) bool {
 	key := LockKey(request.Type, request.ResourceID)
 	shard := lm.getShard(request.ResourceID) // 获取对应的分段（只根据resourceID分段，确保同一镜像层的所有操作类型互斥）
 
+	if request.FencingToken == 0 {
+		log.Printf("[Unlock] 缺少 fencing token，拒绝解锁: key=%s, node=%s", key, request.NodeID)
+		return false
+	}
+
 	// ========== 阶段1：获取分段锁，获取资源锁引用 ==========
 	shard.mu.Lock()
 	resourceLock, exists := shard.resourceLocks[key]
@@ -206,14 +383,17 @@ func (lm *LockManager) Unlock(request *UnlockRequest) bool {
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
 
-	// 检查锁是否存在
+	// 检查锁是否存在，以及是否是锁的持有者（按会话判断）
+	// 不是独占持有者时检查是否是共享持有者
 	lockInfo, exists := shard.locks[key]
-	if !exists {
-		return false
+	if !exists || !lockInfo.ownedBy(request.SessionID, request.NodeID) {
+		return lm.unlockSharedLocked(shard, key, request)
 	}
 
-	// 检查是否是锁的持有者
-	if lockInfo.Request.NodeID != request.NodeID {
+	// 检查fencing token：锁被重新分配后，旧持有者（例如暂停后恢复的节点）的token已失效
+	if request.FencingToken != lockInfo.FencingToken {
+		log.Printf("[Unlock] fencing token 已过期，拒绝解锁: key=%s, node=%s, token=%d, 当前token=%d",
+			key, request.NodeID, request.FencingToken, lockInfo.FencingToken)
 		return false
 	}
 
@@ -221,7 +401,8 @@ func (lm *LockManager) Unlock(request *UnlockRequest) bool {
 	lockInfo.Completed = true
 	// Success 根据 Error 自动推断：没有 error 就是 success
 	lockInfo.Success = (request.Error == "")
-	lockInfo.CompletedAt = time.Now()
+	lockInfo.CompletedAt = lm.now()
+	lm.recordOutcomeLocked(shard, key, lockInfo, request.Error)
 
 	if lockInfo.Success {
 		// ========== 操作成功：删除锁和资源锁 ==========
@@ -229,82 +410,205 @@ func (lm *LockManager) Unlock(request *UnlockRequest) bool {
 
 		// 触发订阅消息广播（在删除锁之前，确保订阅者能收到事件）
 		lm.broadcastEvent(shard, key, &OperationEvent{
-			Type:        request.Type,
-			ResourceID:  request.ResourceID,
-			NodeID:      request.NodeID,
-			Success:     true,
-			Error:       request.Error,
-			CompletedAt: lockInfo.CompletedAt,
+			Event:        EventTypeCompleted,
+			Type:         request.Type,
+			ResourceID:   request.ResourceID,
+			NodeID:       request.NodeID,
+			Success:      true,
+			Error:        request.Error,
+			CompletedAt:  lockInfo.CompletedAt,
+			FencingToken: lockInfo.FencingToken,
+			PeerAddr:     request.PeerAddr,
 		})
 
 		// 删除锁和资源锁
 		delete(shard.locks, key)
 		delete(shard.resourceLocks, key)
+		lm.metrics.released(lockInfo, true, lockInfo.CompletedAt)
+		lm.appendWAL(&WALRecord{Op: WALOpRelease, Type: request.Type, ResourceID: request.ResourceID, Success: true})
+
+		// 保留完成记录：之后到达的独占请求直接跳过操作，不再重新获得锁
+		// 排队的独占请求也被移出队列（重新请求或查询状态时由完成记录告知结果）
+		lm.recordCompletionLocked(shard, key, lockInfo, request, lm.now())
+		if lm.CompletionTTL > 0 {
+			lm.dropCompletedWaitersLocked(shard, key)
+		}
 
 		// 注意：不调用 processQueue，因为：
 		// 1. 操作成功，资源已存在，队列中的节点不应该继续操作
 		// 2. 队列中的节点通过SSE收到事件后，会重新检查资源
-		// 3. 如果资源存在，不会请求锁；如果资源不存在，会重新请求锁（此时锁已被清理）
+		// 3. 如果资源存在，不会请求锁；如果资源不存在，会重新请求锁（完成记录有效期内返回 skip）
+		// 共享请求需要实际持有锁（不是等待同一份结果），队头的共享请求仍然分配锁
+		if queue := shard.queues[key]; len(queue) > 0 && queue[0].shared() {
+			if _, exists := shard.resourceLocks[key]; !exists {
+				shard.resourceLocks[key] = resourceLock
+			}
+			for _, sessionID := range lm.processQueue(shard, key) {
+				lm.notifyLockAssigned(shard, key, sessionID)
+			}
+		}
+		// 但其他操作类型中因与本操作冲突而等待的请求可以继续了
+		lm.dispatchBlockedLocked(shard, request.ResourceID, key)
 	} else {
 		// ========== 操作失败：保留资源锁，分配锁给队列中的下一个节点 ==========
 		log.Printf("[Unlock] 操作失败，唤醒队列: key=%s, node=%s", key, request.NodeID)
+		lm.handOffLocked(shard, key)
+	}
+
+	return true
+}
 
-		// 删除锁状态（但保留资源锁）
+// handOffLocked 操作失败后移交锁：删除锁状态，把锁分配给队头节点并通知
+// 注意：调用此函数时，资源锁和 shard.mu 都必须已经加锁
+func (lm *LockManager) handOffLocked(shard *resourceShard, key string) {
+	// 删除锁状态（但保留资源锁）
+	if lockInfo, exists := shard.locks[key]; exists {
 		delete(shard.locks, key)
+		lm.metrics.released(lockInfo, false, lm.now())
+		lm.appendWAL(&WALRecord{Op: WALOpRelease, Type: lockInfo.Request.Type, ResourceID: lockInfo.Request.ResourceID})
+	}
 
-		// 分配锁给队列中的下一个节点
-		nextNodeID := lm.processQueue(shard, key)
+	// 分配锁给队列中的下一个节点，并通过SSE通知
+	for _, sessionID := range lm.processQueue(shard, key) {
+		lm.notifyLockAssigned(shard, key, sessionID)
+	}
 
-		// 通过SSE通知队头节点锁已被分配
-		if nextNodeID != "" {
-			lm.notifyLockAssigned(shard, key, nextNodeID)
-		}
+	// 队列为空时该key已空闲：其他操作类型中因冲突而等待的请求可以继续了
+	if _, resourceID, ok := splitLockKey(key); ok {
+		lm.dispatchBlockedLocked(shard, resourceID, "")
+	}
+
+	// 注意：资源锁保留，下一个节点使用同一个资源锁
+}
 
-		// 注意：资源锁保留，下一个节点使用同一个资源锁
+// KeepAlive 续约：持有者心跳，延长租约到期时间
+// 返回：是否续约成功（锁不存在、已完成、不是持有者或 fencing token 缺失/过期时返回false）
+func (lm *LockManager) KeepAlive(request *KeepAliveRequest) bool {
+	key := LockKey(request.Type, request.ResourceID)
+	shard := lm.getShard(request.ResourceID)
+
+	if request.FencingToken == 0 {
+		log.Printf("[KeepAlive] 缺少 fencing token，拒绝续约: key=%s, node=%s", key, request.NodeID)
+		return false
 	}
 
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	lockInfo, exists := shard.locks[key]
+	if !exists || !lockInfo.ownedBy(request.SessionID, request.NodeID) {
+		// 共享持有者续约
+		lockInfo = findSharedHolder(shard.shared[key], request.SessionID, request.NodeID, request.FencingToken)
+	}
+	if lockInfo == nil || lockInfo.Completed {
+		return false
+	}
+	if request.FencingToken != lockInfo.FencingToken {
+		return false
+	}
+
+	lockInfo.LeaseExpiresAt = lm.leaseDeadline(lm.now())
 	return true
 }
 
+// nextFencingToken 为key生成下一个fencing token（严格递增）
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) nextFencingToken(shard *resourceShard, key string) uint64 {
+	shard.fencingTokens[key]++
+	return shard.fencingTokens[key]
+}
+
+// now 当前时间（见 LockManager.clock）
+func (lm *LockManager) now() time.Time {
+	if lm.clock != nil {
+		return lm.clock()
+	}
+	return time.Now()
+}
+
+// leaseDeadline 计算从 from 开始的租约到期时间，未启用租约时返回零值
+func (lm *LockManager) leaseDeadline(from time.Time) time.Time {
+	if lm.LeaseTTL <= 0 {
+		return time.Time{}
+	}
+	return from.Add(lm.LeaseTTL)
+}
+
 // addToQueue 添加请求到等待队列（FIFO）
 // 注意：调用此函数时，shard.mu 必须已经加锁
 func (lm *LockManager) addToQueue(shard *resourceShard, key string, request *LockRequest) {
 	if _, exists := shard.queues[key]; !exists {
 		shard.queues[key] = make([]*LockRequest, 0)
 	}
+	if request.WaitTimeoutMs > 0 {
+		request.WaitDeadline = request.Timestamp.Add(time.Duration(request.WaitTimeoutMs) * time.Millisecond)
+	}
 	shard.queues[key] = append(shard.queues[key], request)
+	lm.metrics.queued.Add(1)
+	lm.appendWAL(&WALRecord{Op: WALOpEnqueue, Type: request.Type, ResourceID: request.ResourceID, Request: request})
 }
 
 // processQueue 处理等待队列（FIFO）
+// 队头是独占请求时只分配给队头；队头是共享请求时，连续的共享请求一起获得锁
+// 队头仍被阻塞时（例如共享持有者未全部释放、同一资源上有冲突的操作）不分配
+// 已超过等待期限的请求先被移出队列，不会被分配锁
 // 注意：调用此函数时，shard.mu 必须已经加锁
-// 返回：分配锁的节点ID，如果没有队列则返回空字符串
-func (lm *LockManager) processQueue(shard *resourceShard, key string) string {
-	queue, exists := shard.queues[key]
-	if !exists || len(queue) == 0 {
-		return ""
-	}
+// 返回：分配锁的会话ID列表，没有分配时返回空
+func (lm *LockManager) processQueue(shard *resourceShard, key string) []string {
+	lm.pruneQueueLocked(shard, key, lm.now())
+
+	var granted []string
+	for len(shard.queues[key]) > 0 {
+		queue := shard.queues[key]
+		nextRequest := queue[0]
+		if blocking := lm.blockingLockLocked(shard, nextRequest); blocking != nil {
+			break
+		}
 
-	// FIFO：取出队列中的第一个请求
-	nextRequest := queue[0]
-	shard.queues[key] = queue[1:]
+		// FIFO：取出队列中的第一个请求
+		shard.queues[key] = queue[1:]
 
-	log.Printf("[processQueue] 从队列分配锁: key=%s, node=%s, 剩余队列长度=%d",
-		key, nextRequest.NodeID, len(shard.queues[key]))
+		log.Printf("[processQueue] 从队列分配锁: key=%s, node=%s, mode=%s, 剩余队列长度=%d",
+			key, nextRequest.NodeID, nextRequest.Mode, len(shard.queues[key]))
 
-	// 如果队列为空，删除队列
-	if len(shard.queues[key]) == 0 {
-		delete(shard.queues, key)
-	}
+		// 如果队列为空，删除队列
+		if len(shard.queues[key]) == 0 {
+			delete(shard.queues, key)
+		}
 
-	// 分配锁给下一个请求
-	shard.locks[key] = &LockInfo{
-		Request:    nextRequest,
-		AcquiredAt: time.Now(),
-		Completed:  false,
-		Success:    false,
-	}
+		// 分配锁给下一个请求（新的fencing token，旧持有者的token随之失效）
+		now := lm.now()
+		nextRequest.FencingToken = lm.nextFencingToken(shard, key)
+		lockInfo := &LockInfo{
+			Request:        nextRequest,
+			AcquiredAt:     now,
+			Completed:      false,
+			Success:        false,
+			FencingToken:   nextRequest.FencingToken,
+			LeaseExpiresAt: lm.leaseDeadline(now),
+		}
+		if nextRequest.shared() {
+			lm.addSharedHolderLocked(shard, key, lockInfo)
+		} else {
+			shard.locks[key] = lockInfo
+		}
+		lm.metrics.granted(nextRequest, true, now)
+		traceQueueWait(nextRequest, queueOutcomeGranted, now)
+		lm.appendWAL(&WALRecord{
+			Op:           WALOpReassign,
+			Type:         nextRequest.Type,
+			ResourceID:   nextRequest.ResourceID,
+			Request:      nextRequest,
+			FencingToken: nextRequest.FencingToken,
+			AcquiredAt:   now,
+		})
 
-	return nextRequest.NodeID
+		granted = append(granted, nextRequest.SessionID)
+		if !nextRequest.shared() {
+			break
+		}
+	}
+	return granted
 }
 
 // GetQueueLength 获取队列长度（用于监控）
@@ -412,38 +716,54 @@ func (lm *LockManager) broadcastEvent(shard *resourceShard, key string, event *O
 	}
 }
 
-// notifyLockAssigned 通知队头节点锁已被分配
+// notifyLockAssigned 通知队头会话锁已被分配
 // 注意：调用此函数时，shard.mu 必须已经加锁
-func (lm *LockManager) notifyLockAssigned(shard *resourceShard, key string, nodeID string) {
+func (lm *LockManager) notifyLockAssigned(shard *resourceShard, key string, sessionID string) {
 	subscribers, exists := shard.subscribers[key]
 	if !exists || len(subscribers) == 0 {
 		return
 	}
 
 	// 解析key获取type和resourceID
-	parts := strings.Split(key, ":")
-	if len(parts) < 2 {
+	lockType, resourceID, ok := splitLockKey(key)
+	if !ok {
+		return
+	}
+
+	// 新分配的持有者（独占持有者或共享持有者）
+	var holder *LockInfo
+	mode := LockModeExclusive
+	if lockInfo, ok := shard.locks[key]; ok && lockInfo.Request.SessionID == sessionID {
+		holder = lockInfo
+	} else if lockInfo, ok := shard.shared[key][sessionID]; ok {
+		holder = lockInfo
+		mode = LockModeShared
+	}
+	if holder == nil {
 		return
 	}
-	lockType := parts[0]
-	resourceID := strings.Join(parts[1:], ":")
+	nodeID := holder.Request.NodeID
 
 	// 创建"锁已分配"事件
-	// 注意：Success=false 表示操作失败，但通过NodeID匹配，客户端可以知道锁已被分配给自己
+	// 注意：Success=false 表示操作失败，但通过SessionID（旧客户端通过NodeID）匹配，客户端可以知道锁已被分配给自己
 	event := &OperationEvent{
-		Type:        lockType,
-		ResourceID:  resourceID,
-		NodeID:      nodeID, // 队头节点的NodeID
-		Success:     false,  // 操作失败
-		Error:       "",     // 没有错误，只是通知锁已分配
-		CompletedAt: time.Now(),
+		Event:        EventTypeLockAssigned,
+		Type:         lockType,
+		ResourceID:   resourceID,
+		NodeID:       nodeID, // 队头节点的NodeID
+		Success:      false,  // 操作失败
+		Error:        "",     // 没有错误，只是通知锁已分配
+		CompletedAt:  lm.now(),
+		FencingToken: holder.FencingToken,
+		Mode:         mode,
+		SessionID:    sessionID,
 	}
 
-	log.Printf("[notifyLockAssigned] 通知队头节点锁已分配: key=%s, node=%s, 订阅者数量=%d",
-		key, nodeID, len(subscribers))
+	log.Printf("[notifyLockAssigned] 通知队头节点锁已分配: key=%s, node=%s, session=%s, 订阅者数量=%d",
+		key, nodeID, sessionID, len(subscribers))
 
 	// 发送事件给所有订阅者
-	// 客户端收到事件后，检查NodeID是否匹配，如果匹配则重新请求锁
+	// 客户端收到事件后，检查SessionID是否匹配，如果匹配则重新请求锁
 	validSubscribers := make([]Subscriber, 0, len(subscribers))
 
 	for _, sub := range subscribers {
@@ -46,10 +46,11 @@ func TestConcurrentPullOperations(t *testing.T) {
 
 				// 释放锁（操作成功）
 				unlockReq := &UnlockRequest{
-					Type:       OperationTypePull,
-					ResourceID: resourceID,
-					NodeID:     nodeID,
-					Error:      "", // 空字符串表示操作成功
+					Type:         OperationTypePull,
+					ResourceID:   resourceID,
+					NodeID:       nodeID,
+					FencingToken: request.FencingToken,
+					Error:        "", // 空字符串表示操作成功
 				}
 				lm.Unlock(unlockReq)
 			}
@@ -84,14 +85,15 @@ func TestPullSkipWhenRefCountNotZero(t *testing.T) {
 
 	// 释放锁并标记成功（增加引用计数）
 	unlockReq1 := &UnlockRequest{
-		Type:       OperationTypePull,
-		ResourceID: resourceID,
-		NodeID:     "node-1",
-		Error:      "", // 空字符串表示操作成功
+		Type:         OperationTypePull,
+		ResourceID:   resourceID,
+		NodeID:       "node-1",
+		FencingToken: pullReq1.FencingToken,
+		Error:        "", // 空字符串表示操作成功
 	}
 	lm.Unlock(unlockReq1)
 
-	// 节点2尝试pull操作，应该排队并最终获得锁
+	// 节点2尝试pull操作：完成记录仍然有效，应该跳过操作
 	pullReq2 := &LockRequest{
 		Type:       OperationTypePull,
 		ResourceID: resourceID,
@@ -101,11 +103,8 @@ func TestPullSkipWhenRefCountNotZero(t *testing.T) {
 	if errMsg2 != "" {
 		t.Errorf("不应该有错误: %s", errMsg2)
 	}
-	if skip2 {
-		t.Error("节点2不应跳过操作（skip 现在总是 false）")
-	}
-	if !acquired2 {
-		t.Error("节点2应能获得锁（排队后）")
+	if !skip2 || acquired2 {
+		t.Errorf("节点2应跳过操作（节点1已完成），acquired=%v, skip=%v", acquired2, skip2)
 	}
 }
 
@@ -127,10 +126,11 @@ func TestDeleteWithReferences(t *testing.T) {
 
 	// 释放pull锁并标记成功（增加引用计数）
 	unlockReq := &UnlockRequest{
-		Type:       OperationTypePull,
-		ResourceID: resourceID,
-		NodeID:     "node-1",
-		Error:      "", // 空字符串表示操作成功
+		Type:         OperationTypePull,
+		ResourceID:   resourceID,
+		NodeID:       "node-1",
+		FencingToken: pullReq.FencingToken,
+		Error:        "", // 空字符串表示操作成功
 	}
 	lm.Unlock(unlockReq)
 
@@ -176,10 +176,11 @@ func TestDeleteWithoutReferences(t *testing.T) {
 
 	// 释放锁并标记成功
 	unlockReq := &UnlockRequest{
-		Type:       OperationTypeDelete,
-		ResourceID: resourceID,
-		NodeID:     "node-1",
-		Error:      "", // 空字符串表示操作成功
+		Type:         OperationTypeDelete,
+		ResourceID:   resourceID,
+		NodeID:       "node-1",
+		FencingToken: deleteReq.FencingToken,
+		Error:        "", // 空字符串表示操作成功
 	}
 	lm.Unlock(unlockReq)
 
@@ -211,10 +212,11 @@ func TestDeleteWhenRefCountZero(t *testing.T) {
 
 	// 释放锁并标记成功
 	unlockReq := &UnlockRequest{
-		Type:       OperationTypeDelete,
-		ResourceID: resourceID,
-		NodeID:     "node-1",
-		Error:      "", // 空字符串表示操作成功
+		Type:         OperationTypeDelete,
+		ResourceID:   resourceID,
+		NodeID:       "node-1",
+		FencingToken: deleteReq.FencingToken,
+		Error:        "", // 空字符串表示操作成功
 	}
 	lm.Unlock(unlockReq)
 }
@@ -236,10 +238,11 @@ func TestUpdateWithReferences(t *testing.T) {
 	}
 
 	unlockReq := &UnlockRequest{
-		Type:       OperationTypePull,
-		ResourceID: resourceID,
-		NodeID:     "node-1",
-		Error:      "", // 空字符串表示操作成功
+		Type:         OperationTypePull,
+		ResourceID:   resourceID,
+		NodeID:       "node-1",
+		FencingToken: pullReq.FencingToken,
+		Error:        "", // 空字符串表示操作成功
 	}
 	lm.Unlock(unlockReq)
 
@@ -278,10 +281,11 @@ func TestUpdateWithoutReferencesRequired(t *testing.T) {
 	}
 
 	unlockReq := &UnlockRequest{
-		Type:       OperationTypePull,
-		ResourceID: resourceID,
-		NodeID:     "node-1",
-		Error:      "", // 空字符串表示操作成功
+		Type:         OperationTypePull,
+		ResourceID:   resourceID,
+		NodeID:       "node-1",
+		FencingToken: pullReq.FencingToken,
+		Error:        "", // 空字符串表示操作成功
 	}
 	lm.Unlock(unlockReq)
 
@@ -343,10 +347,11 @@ func TestFIFOQueue(t *testing.T) {
 
 	// 释放第一个请求的锁
 	unlockReq := &UnlockRequest{
-		Type:       OperationTypePull,
-		ResourceID: resourceID,
-		NodeID:     "node-1",
-		Error:      "操作失败", // 非空字符串表示操作失败，锁应该转交给队列中的下一个
+		Type:         OperationTypePull,
+		ResourceID:   resourceID,
+		NodeID:       "node-1",
+		FencingToken: req1.FencingToken,
+		Error:        "操作失败", // 非空字符串表示操作失败，锁应该转交给队列中的下一个
 	}
 	lm.Unlock(unlockReq)
 
@@ -394,10 +399,11 @@ func TestConcurrentDifferentResources(t *testing.T) {
 				time.Sleep(10 * time.Millisecond)
 
 				unlockReq := &UnlockRequest{
-					Type:       OperationTypePull,
-					ResourceID: resourceID,
-					NodeID:     nodeID,
-					Error:      "", // 空字符串表示操作成功
+					Type:         OperationTypePull,
+					ResourceID:   resourceID,
+					NodeID:       nodeID,
+					FencingToken: req.FencingToken,
+					Error:        "", // 空字符串表示操作成功
 				}
 				lm.Unlock(unlockReq)
 			}
@@ -422,10 +428,11 @@ func TestConcurrentDifferentResources(t *testing.T) {
 				time.Sleep(10 * time.Millisecond)
 
 				unlockReq := &UnlockRequest{
-					Type:       OperationTypePull,
-					ResourceID: resourceID,
-					NodeID:     nodeID,
-					Error:      "", // 空字符串表示操作成功
+					Type:         OperationTypePull,
+					ResourceID:   resourceID,
+					NodeID:       nodeID,
+					FencingToken: req.FencingToken,
+					Error:        "", // 空字符串表示操作成功
 				}
 				lm.Unlock(unlockReq)
 			}
@@ -514,10 +521,11 @@ func TestNodeConcurrentDifferentResources(t *testing.T) {
 
 	// 步骤4: 节点B完成layer2的操作，释放锁
 	unlockB2 := &UnlockRequest{
-		Type:       OperationTypePull,
-		ResourceID: layer2,
-		NodeID:     nodeB,
-		Error:      "", // 空字符串表示操作成功
+		Type:         OperationTypePull,
+		ResourceID:   layer2,
+		NodeID:       nodeB,
+		FencingToken: reqB2.FencingToken,
+		Error:        "", // 空字符串表示操作成功
 	}
 	releasedB2 := lm.Unlock(unlockB2)
 	if !releasedB2 {
@@ -533,10 +541,11 @@ func TestNodeConcurrentDifferentResources(t *testing.T) {
 
 	// 步骤5: 节点A完成layer1的操作，释放锁
 	unlockA1 := &UnlockRequest{
-		Type:       OperationTypePull,
-		ResourceID: layer1,
-		NodeID:     nodeA,
-		Error:      "操作失败", // 非空字符串表示操作失败，锁应该转交给队列中的节点B
+		Type:         OperationTypePull,
+		ResourceID:   layer1,
+		NodeID:       nodeA,
+		FencingToken: reqA1.FencingToken,
+		Error:        "操作失败", // 非空字符串表示操作失败，锁应该转交给队列中的节点B
 	}
 	releasedA1 := lm.Unlock(unlockA1)
 	if !releasedA1 {
@@ -585,14 +594,15 @@ func TestReferenceCountAccuracy(t *testing.T) {
 	}
 
 	unlockReq := &UnlockRequest{
-		Type:       OperationTypePull,
-		ResourceID: resourceID,
-		NodeID:     node1,
-		Error:      "", // 空字符串表示操作成功
+		Type:         OperationTypePull,
+		ResourceID:   resourceID,
+		NodeID:       node1,
+		FencingToken: req.FencingToken,
+		Error:        "", // 空字符串表示操作成功
 	}
 	lm.Unlock(unlockReq)
 
-	// 后续节点应该可以排队并获得锁
+	// 后续节点在完成记录有效期内跳过操作
 	node2 := "node-2"
 	req2 := &LockRequest{
 		Type:       OperationTypePull,
@@ -600,10 +610,129 @@ func TestReferenceCountAccuracy(t *testing.T) {
 		NodeID:     node2,
 	}
 	acquired2, skip2, _ := lm.TryLock(req2)
-	if skip2 {
-		t.Error("后续节点不应跳过操作（skip 现在总是 false，上层已检查资源是否存在）")
+	if !skip2 || acquired2 {
+		t.Errorf("后续节点应跳过操作，acquired=%v, skip=%v", acquired2, skip2)
+	}
+
+	// 完成记录被作废后（例如层被删除），后续节点重新获得锁
+	lm.InvalidateCompletion(&InvalidateRequest{Type: OperationTypePull, ResourceID: resourceID})
+	req3 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-3"}
+	if acquired3, skip3, _ := lm.TryLock(req3); !acquired3 || skip3 {
+		t.Errorf("作废完成记录后应能获得锁，acquired=%v, skip=%v", acquired3, skip3)
+	}
+}
+
+// TestFencingTokenMonotonic 测试同一个key上的fencing token严格递增，锁释放后也不回退
+func TestFencingTokenMonotonic(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:fencing1"
+
+	req1 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	if acquired, _, _ := lm.TryLock(req1); !acquired {
+		t.Fatal("node-1 应该获得锁")
+	}
+	req2 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2"}
+	if acquired, _, _ := lm.TryLock(req2); acquired {
+		t.Fatal("node-2 应该进入等待队列")
+	}
+	if req2.FencingToken != 0 {
+		t.Errorf("未获得锁时不应返回fencing token，实际 %d", req2.FencingToken)
+	}
+
+	// node-1 操作失败，锁交给 node-2，token 递增
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1",
+		FencingToken: req1.FencingToken, Error: "下载失败"})
+	lockInfo := lm.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.FencingToken <= req1.FencingToken {
+		t.Fatalf("重新分配后的token应大于 %d，实际 %+v", req1.FencingToken, lockInfo)
+	}
+
+	// node-2 以同一会话重新请求（队列场景），拿到分配给它的token
+	req2Again := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2", SessionID: req2.SessionID}
+	if acquired, _, _ := lm.TryLock(req2Again); !acquired {
+		t.Fatal("node-2 应该获得分配给它的锁")
+	}
+	if req2Again.FencingToken != lockInfo.FencingToken {
+		t.Errorf("期望token %d，实际 %d", lockInfo.FencingToken, req2Again.FencingToken)
+	}
+
+	// 成功释放后锁被删除，（作废完成记录后）再次获取的token仍然递增
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2",
+		FencingToken: req2Again.FencingToken})
+	lm.InvalidateCompletion(&InvalidateRequest{ResourceID: resourceID})
+	req3 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-3"}
+	if acquired, _, _ := lm.TryLock(req3); !acquired {
+		t.Fatal("node-3 应该获得锁")
+	}
+	if req3.FencingToken <= req2Again.FencingToken {
+		t.Errorf("释放后再次授予的token应大于 %d，实际 %d", req2Again.FencingToken, req3.FencingToken)
+	}
+}
+
+// TestFencingTokenRejectsStaleHolder 测试暂停后恢复的旧持有者不能用过期token解锁
+// 场景：node-1 获得锁后暂停，租约过期被回收；node-1 的新请求重新获得锁；
+// 暂停恢复的旧流程用旧token解锁应被拒绝，新token解锁成功
+func TestFencingTokenRejectsStaleHolder(t *testing.T) {
+	lm := NewLockManager(true)
+	lm.LeaseTTL = 10 * time.Millisecond
+	resourceID := "sha256:fencing2"
+
+	staleReq := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	if acquired, _, _ := lm.TryLock(staleReq); !acquired {
+		t.Fatal("node-1 应该获得锁")
+	}
+	if n := lm.reapExpiredLeases(time.Now().Add(time.Second)); n != 1 {
+		t.Fatalf("期望回收1个锁，实际 %d", n)
+	}
+
+	freshReq := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	if acquired, _, _ := lm.TryLock(freshReq); !acquired {
+		t.Fatal("node-1 的新请求应该获得锁")
+	}
+
+	if lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1",
+		FencingToken: staleReq.FencingToken}) {
+		t.Fatal("使用过期token解锁应该被拒绝")
+	}
+	if lm.GetLockInfo(OperationTypePull, resourceID) == nil {
+		t.Fatal("过期token解锁失败后，锁应该仍然存在")
+	}
+
+	// 不携带token时无法确认锁没有被重新分配，与过期token一样被拒绝
+	if lm.KeepAlive(&KeepAliveRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}) {
+		t.Error("不携带token续约应该被拒绝")
+	}
+	if lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}) {
+		t.Fatal("不携带token解锁应该被拒绝")
+	}
+	if lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", SessionID: freshReq.SessionID}) {
+		t.Fatal("携带会话ID但不携带token解锁也应该被拒绝")
+	}
+	if !lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1",
+		FencingToken: freshReq.FencingToken}) {
+		t.Fatal("使用当前token解锁应该成功")
+	}
+}
+
+// TestFencingTokenRequiredForShared 测试共享持有者续约和解锁同样必须携带token
+func TestFencingTokenRequiredForShared(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:fencing-shared"
+
+	shared := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", Mode: LockModeShared}
+	if acquired, _, _ := lm.TryLock(shared); !acquired {
+		t.Fatal("node-1 应该获得共享锁")
+	}
+	if lm.KeepAlive(&KeepAliveRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}) {
+		t.Error("不携带token续约共享锁应该被拒绝")
+	}
+	if lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}) {
+		t.Fatal("不携带token释放共享锁应该被拒绝")
+	}
+	if !lm.KeepAlive(&KeepAliveRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", FencingToken: shared.FencingToken}) {
+		t.Error("携带token续约共享锁应该成功")
 	}
-	if !acquired2 {
-		t.Error("后续节点应能获得锁（排队后）")
+	if !lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", FencingToken: shared.FencingToken}) {
+		t.Error("携带token释放共享锁应该成功")
 	}
 }
@@ -1,15 +1,43 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"distributed-lock/tracing"
 
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
+// shutdownTimeout 停止服务时等待进行中的请求结束的最长时间
+const shutdownTimeout = 5 * time.Second
+
 func main() {
+	// 可选：设置 LOCK_TRACE_EXPORTER 后导出追踪（otlp、stdout、file，见 tracing 包）
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.ConfigFromEnv("distributed-lock-server"))
+	if err != nil {
+		log.Fatalf("初始化追踪失败: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("导出追踪失败: %v", err)
+		}
+	}()
+
 	// 读取多节点下载模式配置（默认开启）
 	allowMultiNodeDownload := true
 	if envValue := os.Getenv("ALLOW_MULTI_NODE_DOWNLOAD"); envValue != "" {
@@ -25,23 +53,358 @@ func main() {
 		log.Printf("注意: 多节点下载模式已关闭，锁被占用时将直接返回失败，不进入等待队列")
 	}
 
-	// 创建锁管理器
-	lockManager := NewLockManager(allowMultiNodeDownload)
+	// 锁管理器的配置作为构造选项传入：启用持久化时 NewLockManager 在应用所有选项之后才从快照和WAL恢复，
+	// 恢复时重置租约、清理完成记录和计算信号量容量都要使用这里的配置
+	opts := []LockManagerOption{func(lockManager *LockManager) {
+		// 读取锁租约时长配置（默认 DefaultLeaseTTL，设置为 0 表示不启用租约）
+		if envValue := os.Getenv("LOCK_LEASE_TTL"); envValue != "" {
+			if parsed, err := time.ParseDuration(envValue); err == nil {
+				lockManager.LeaseTTL = parsed
+			} else {
+				log.Printf("警告: 无法解析环境变量 LOCK_LEASE_TTL=%s，使用默认值 %v", envValue, DefaultLeaseTTL)
+			}
+		}
+
+		// 读取跨操作类型兼容矩阵（默认 pull 与 update 兼容，delete 与其他操作互斥）
+		// LOCK_COMPATIBLE_OPERATIONS 格式：pull+update；none 表示所有操作类型两两互斥
+		if envValue := os.Getenv("LOCK_COMPATIBLE_OPERATIONS"); envValue != "" {
+			if parsed, err := ParseCompatibilityMatrix(envValue); err == nil {
+				lockManager.Compatibility = parsed
+			} else {
+				log.Printf("警告: 无法解析环境变量 LOCK_COMPATIBLE_OPERATIONS=%s，使用默认值: %v", envValue, err)
+			}
+		}
+		log.Printf("兼容的操作类型: %s", lockManager.Compatibility)
+
+		// 读取死锁检测间隔（默认 DefaultDeadlockCheckInterval，设置为 0 表示不检测）
+		if envValue := os.Getenv("LOCK_DEADLOCK_CHECK_INTERVAL"); envValue != "" {
+			if parsed, err := time.ParseDuration(envValue); err == nil {
+				lockManager.DeadlockCheckInterval = parsed
+			} else {
+				log.Printf("警告: 无法解析环境变量 LOCK_DEADLOCK_CHECK_INTERVAL=%s，使用默认值 %v", envValue, DefaultDeadlockCheckInterval)
+			}
+		}
+
+		// 读取节点失效判定时长（默认 DefaultNodeTimeout，设置为 0 表示不检测）
+		// 必须在启动 Raft 之前设置：RaftNode 的失效检测协程启动后就会读取
+		if envValue := os.Getenv("LOCK_NODE_TIMEOUT"); envValue != "" {
+			if parsed, err := time.ParseDuration(envValue); err == nil {
+				lockManager.NodeTimeout = parsed
+			} else {
+				log.Printf("警告: 无法解析环境变量 LOCK_NODE_TIMEOUT=%s，使用默认值 %v", envValue, DefaultNodeTimeout)
+			}
+		}
+
+		// 读取完成记录的保留时长和数量上限（设置为 0 表示不保留，操作成功后的请求重新获得锁）
+		// 复制模式下所有副本必须使用相同的配置，并且必须在启动 Raft 之前设置（应用日志时就会使用）
+		if envValue := os.Getenv("LOCK_COMPLETION_TTL"); envValue != "" {
+			if parsed, err := time.ParseDuration(envValue); err == nil {
+				lockManager.CompletionTTL = parsed
+			} else {
+				log.Printf("警告: 无法解析环境变量 LOCK_COMPLETION_TTL=%s，使用默认值 %v", envValue, DefaultCompletionTTL)
+			}
+		}
+		if envValue := os.Getenv("LOCK_COMPLETION_MAX"); envValue != "" {
+			if parsed, err := strconv.Atoi(envValue); err == nil && parsed > 0 {
+				lockManager.MaxCompletions = parsed
+			} else {
+				log.Printf("警告: 无法解析环境变量 LOCK_COMPLETION_MAX=%s，使用默认值 %d", envValue, DefaultMaxCompletions)
+			}
+		}
+
+		// 读取信号量容量：LOCK_SEMAPHORES=global=8,registry:docker.io=4（未列出的信号量使用 LOCK_SEMAPHORE_DEFAULT，默认不限制）
+		// 复制模式下所有副本必须使用相同的配置，并且必须在启动 Raft 之前设置（应用日志时就会使用）
+		if envValue := os.Getenv("LOCK_SEMAPHORES"); envValue != "" {
+			for _, entry := range strings.Split(envValue, ",") {
+				separator := strings.LastIndex(entry, "=")
+				if separator <= 0 {
+					log.Printf("警告: 无法解析环境变量 LOCK_SEMAPHORES 中的 %q，忽略", entry)
+					continue
+				}
+				capacity, err := strconv.Atoi(strings.TrimSpace(entry[separator+1:]))
+				if err != nil {
+					log.Printf("警告: 无法解析环境变量 LOCK_SEMAPHORES 中的 %q，忽略", entry)
+					continue
+				}
+				lockManager.SemaphoreCapacities[strings.TrimSpace(entry[:separator])] = capacity
+			}
+			log.Printf("信号量容量: %v", lockManager.SemaphoreCapacities)
+		}
+		if envValue := os.Getenv("LOCK_SEMAPHORE_DEFAULT"); envValue != "" {
+			if parsed, err := strconv.Atoi(envValue); err == nil {
+				lockManager.DefaultSemaphoreCapacity = parsed
+			} else {
+				log.Printf("警告: 无法解析环境变量 LOCK_SEMAPHORE_DEFAULT=%s，未配置的信号量不限制", envValue)
+			}
+		}
+	}}
+
+	// 读取持久化配置：设置 LOCK_STATE_DIR 后锁状态写入WAL并定期快照，重启后自动恢复
+	// 复制模式下锁状态由Raft日志重建，LOCK_STATE_DIR 用于保存Raft的任期、投票和日志（见下方）
+	stateDir := os.Getenv("LOCK_STATE_DIR")
+	if stateDir != "" && os.Getenv("RAFT_ID") == "" {
+		store, err := OpenStateStore(stateDir)
+		if err != nil {
+			log.Fatalf("打开锁状态目录失败: %v", err)
+		}
+		defer store.Close()
+		if envValue := os.Getenv("LOCK_WAL_SYNC"); envValue != "" {
+			if parsed, err := strconv.ParseBool(envValue); err == nil {
+				store.SyncWrites = parsed
+			} else {
+				log.Printf("警告: 无法解析环境变量 LOCK_WAL_SYNC=%s，使用默认值 true", envValue)
+			}
+		}
+		log.Printf("锁状态持久化目录: %s", stateDir)
+		opts = append(opts, WithStateStore(store))
+	}
+
+	// 创建锁管理器（启用持久化时会从快照和WAL恢复锁状态）
+	lockManager := NewLockManager(allowMultiNodeDownload, opts...)
+
+	// 定期快照，控制WAL长度（默认5分钟）
+	if lockManager.store != nil {
+		snapshotInterval := 5 * time.Minute
+		if envValue := os.Getenv("LOCK_SNAPSHOT_INTERVAL"); envValue != "" {
+			if parsed, err := time.ParseDuration(envValue); err == nil && parsed > 0 {
+				snapshotInterval = parsed
+			} else {
+				log.Printf("警告: 无法解析环境变量 LOCK_SNAPSHOT_INTERVAL=%s，使用默认值 %v", envValue, snapshotInterval)
+			}
+		}
+		stopSnapshotter := lockManager.StartSnapshotter(snapshotInterval)
+		defer stopSnapshotter()
+	}
+
+	// 读取 TLS 配置：设置 LOCK_TLS_CERT 和 LOCK_TLS_KEY 后 TCP 监听使用 TLS，
+	// 同时设置 LOCK_TLS_CA 时要求客户端证书（mTLS），请求中的 node_id 必须与证书身份一致
+	var tlsConfig *tls.Config
+	var peerClient func(timeout time.Duration) *http.Client
+	if certFile, keyFile := os.Getenv("LOCK_TLS_CERT"), os.Getenv("LOCK_TLS_KEY"); certFile != "" || keyFile != "" {
+		var err error
+		tlsConfig, err = LoadServerTLSConfig(certFile, keyFile, os.Getenv("LOCK_TLS_CA"))
+		if err != nil {
+			log.Fatalf("读取 TLS 配置失败: %v", err)
+		}
+		log.Printf("TLS: cert=%s, 校验客户端证书=%v", certFile, tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert)
+		// 副本、集群成员之间用本进程的证书互相访问（成员地址应为 https://）
+		peerClient = func(timeout time.Duration) *http.Client {
+			return &http.Client{Timeout: timeout, Transport: &http.Transport{TLSClientConfig: PeerTLSConfig(tlsConfig)}}
+		}
+	}
+
+	// 读取复制配置：设置 RAFT_ID 和 RAFT_PEERS 后作为 Raft 副本运行（3或5个副本组成高可用集群）
+	// RAFT_PEERS 格式：id1=http://host1:8086,id2=http://host2:8086,id3=http://host3:8086
+	var raftNode *RaftNode
+	if raftID := os.Getenv("RAFT_ID"); raftID != "" {
+		peers, err := ParseRaftPeers(os.Getenv("RAFT_PEERS"))
+		if err != nil {
+			log.Fatalf("解析环境变量 RAFT_PEERS 失败: %v", err)
+		}
+		raftConfig := RaftConfig{ID: raftID, Peers: peers}
+		if peerClient != nil {
+			raftConfig.HTTPClient = peerClient(DefaultRaftElectionTimeout)
+		}
+		// 设置 LOCK_STATE_DIR 后任期、投票和日志写入磁盘（每次写入都 fsync），副本重启后从磁盘恢复
+		if stateDir != "" {
+			storage, err := OpenRaftStorage(stateDir)
+			if err != nil {
+				log.Fatalf("打开Raft状态目录失败: %v", err)
+			}
+			defer storage.Close()
+			raftConfig.Storage = storage
+			log.Printf("Raft状态持久化目录: %s", stateDir)
+		}
+		raftNode = NewRaftNode(raftConfig, lockManager)
+		raftNode.Start()
+		defer raftNode.Stop()
+		log.Printf("复制模式: id=%s, 成员=%v", raftID, peers)
+	}
+
+	// 读取集群配置：设置 CLUSTER_ID 和 CLUSTER_MEMBERS 后按一致性哈希把资源分给多个服务端进程
+	// CLUSTER_MEMBERS 格式与 RAFT_PEERS 相同；每个进程只保存自己负责的资源，其他资源重定向到 owner
+	var cluster *Cluster
+	if clusterID := os.Getenv("CLUSTER_ID"); clusterID != "" {
+		if raftNode != nil {
+			log.Fatalf("集群模式不能与复制模式（RAFT_ID）同时使用")
+		}
+		members, err := ParseRaftPeers(os.Getenv("CLUSTER_MEMBERS"))
+		if err != nil {
+			log.Fatalf("解析环境变量 CLUSTER_MEMBERS 失败: %v", err)
+		}
+		config := ClusterConfig{ID: clusterID, Members: members}
+		if peerClient != nil {
+			config.HTTPClient = peerClient(30 * time.Second)
+		}
+		if envValue := os.Getenv("CLUSTER_VIRTUAL_NODES"); envValue != "" {
+			if parsed, err := strconv.Atoi(envValue); err == nil && parsed > 0 {
+				config.VirtualNodes = parsed
+			} else {
+				log.Printf("警告: 无法解析环境变量 CLUSTER_VIRTUAL_NODES=%s，使用默认值 %d", envValue, DefaultRingVirtualNodes)
+			}
+		}
+		cluster, err = NewCluster(config, lockManager)
+		if err != nil {
+			log.Fatalf("创建集群成员失败: %v", err)
+		}
+		log.Printf("集群模式: id=%s, 成员=%v", clusterID, members)
+	}
+
+	// 启动租约回收协程：持有者崩溃后锁不会永久占用
+	// 复制模式下由 RaftNode 在leader上回收（通过Raft提交回收命令）
+	if lockManager.LeaseTTL > 0 {
+		log.Printf("锁租约时长: %v", lockManager.LeaseTTL)
+		if raftNode == nil {
+			stopReaper := lockManager.StartLeaseReaper(lockManager.LeaseTTL / 4)
+			defer stopReaper()
+		}
+	}
+
+	// 启动死锁检测协程：中止等待图中环上最年轻的等待者
+	// 复制模式下由 RaftNode 在leader上检测（通过Raft提交中止命令）
+	if lockManager.DeadlockCheckInterval > 0 {
+		log.Printf("死锁检测间隔: %v", lockManager.DeadlockCheckInterval)
+		if raftNode == nil {
+			stopDetector := lockManager.StartDeadlockDetector(lockManager.DeadlockCheckInterval)
+			defer stopDetector()
+		}
+	}
+
+	// 启动节点失效检测协程：已注册的节点停止心跳后清理它的锁、排队请求和订阅连接
+	// 复制模式下由 RaftNode 在leader上检测（通过Raft提交清理命令）
+	if lockManager.NodeTimeout > 0 {
+		log.Printf("节点失效判定时长: %v", lockManager.NodeTimeout)
+		if raftNode == nil {
+			stopFailureDetector := lockManager.StartFailureDetector(lockManager.NodeTimeout / 4)
+			defer stopFailureDetector()
+		}
+	}
 
 	// 创建HTTP处理器
 	handler := NewHandler(lockManager)
+	if raftNode != nil {
+		handler = NewReplicatedHandler(lockManager, raftNode)
+	}
+	if cluster != nil {
+		handler = NewClusteredHandler(lockManager, cluster)
+		// 重启期间环可能已经变更：从其他成员获取最新的环
+		go cluster.Sync()
+	}
+
+	// 可选：设置 LOCK_AUTHZ_POLICY 后按策略文件限制节点可以操作的类型和资源
+	if policyPath := os.Getenv("LOCK_AUTHZ_POLICY"); policyPath != "" {
+		policy, err := LoadPolicy(policyPath)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		handler.SetPolicy(policy)
+		log.Printf("授权策略: %s, 角色=%d, 节点绑定=%d", policyPath, len(policy.Roles), len(policy.Nodes))
+	}
 
 	// 创建路由
 	router := mux.NewRouter()
 	handler.RegisterRoutes(router)
 
-	// 获取端口号（默认8086）
+	// 收集监听地址：systemd socket 激活传入的 socket、unix socket（LOCK_SOCKET）、TCP 端口（PORT、GRPC_PORT）
+	var httpListeners, grpcListeners []net.Listener
+	activated, err := ActivationListeners()
+	if err != nil {
+		log.Fatalf("读取 socket 激活的监听 socket 失败: %v", err)
+	}
+	for _, activation := range activated {
+		log.Printf("socket 激活: name=%s, addr=%s", activation.Name, activation.Listener.Addr())
+		if activation.Name == GRPCListenerName {
+			grpcListeners = append(grpcListeners, activation.Listener)
+		} else {
+			httpListeners = append(httpListeners, activation.Listener)
+		}
+	}
+
+	// 可选：设置 LOCK_SOCKET 后在 unix socket 上提供 HTTP 接口（权限由 LOCK_SOCKET_MODE 设置，默认 0660）
+	if socketPath := os.Getenv("LOCK_SOCKET"); socketPath != "" {
+		socketMode := os.FileMode(0660)
+		if envValue := os.Getenv("LOCK_SOCKET_MODE"); envValue != "" {
+			if parsed, err := strconv.ParseUint(envValue, 8, 32); err == nil {
+				socketMode = os.FileMode(parsed)
+			} else {
+				log.Printf("警告: 无法解析环境变量 LOCK_SOCKET_MODE=%s，使用默认值 0660", envValue)
+			}
+		}
+		listener, err := ListenUnix(socketPath, socketMode)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		httpListeners = append(httpListeners, listener)
+	}
+
+	// 获取端口号：未设置 PORT 且没有其他监听地址时使用默认端口 8086
 	port := os.Getenv("PORT")
-	if port == "" {
+	if port == "" && len(httpListeners) == 0 {
 		port = "8086"
 	}
+	if port != "" {
+		listener, err := net.Listen("tcp", ":"+port)
+		if err != nil {
+			log.Fatalf("监听端口 %s 失败: %v", port, err)
+		}
+		httpListeners = append(httpListeners, listener)
+	}
 
-	// 启动HTTP服务器
-	log.Printf("锁服务端启动在端口 %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, router))
+	// 可选：设置 GRPC_PORT 后同时提供 gRPC 接口（与 HTTP 接口共用同一个 LockManager）
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		listener, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatalf("监听 gRPC 端口 %s 失败: %v", grpcPort, err)
+		}
+		grpcListeners = append(grpcListeners, listener)
+	}
+	if len(grpcListeners) > 0 {
+		// 从 metadata 继续调用方的追踪
+		grpcOptions := []grpc.ServerOption{grpc.StatsHandler(otelgrpc.NewServerHandler())}
+		if tlsConfig != nil {
+			// unix socket 上的 gRPC 同样使用 TLS（gRPC 的凭据作用于整个服务）
+			grpcOptions = append(grpcOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		grpcServer := grpc.NewServer(grpcOptions...)
+		NewGRPCServer(handler).Register(grpcServer)
+		for _, listener := range grpcListeners {
+			go func(listener net.Listener) {
+				log.Printf("gRPC 服务启动在 %s", listener.Addr())
+				if err := grpcServer.Serve(listener); err != nil {
+					log.Fatalf("gRPC 服务退出: %v", err)
+				}
+			}(listener)
+		}
+		defer grpcServer.Stop()
+	}
+
+	// 启动HTTP服务器（TCP 监听使用 TLS，unix socket 依靠文件权限控制访问）
+	httpServer := &http.Server{Handler: router}
+	serveErrors := make(chan error, len(httpListeners))
+	for _, listener := range httpListeners {
+		if tlsConfig != nil && listener.Addr().Network() == "tcp" {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		go func(listener net.Listener) {
+			log.Printf("锁服务端启动在 %s", listener.Addr())
+			serveErrors <- httpServer.Serve(listener)
+		}(listener)
+	}
+
+	// 收到 SIGTERM/SIGINT 时停止接受新连接，等待进行中的请求结束（最多 shutdownTimeout）后退出，
+	// 执行上面注册的清理（关闭持久化存储等）。socket 激活时监听 socket 由 systemd 持有，重启期间的连接不会被拒绝
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-serveErrors:
+		log.Fatalf("HTTP 服务退出: %v", err)
+	case sig := <-signals:
+		log.Printf("收到信号 %v，停止服务", sig)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		// SSE 订阅不会自行结束：超时后直接关闭
+		httpServer.Close()
+	}
 }
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 节点成员与失效检测
+//
+// 内容节点通过 /nodes/register 注册，之后定期发送 /nodes/heartbeat。
+// 超过 NodeTimeout/2 没有心跳的节点标记为 suspect，超过 NodeTimeout 标记为 dead：
+// 它持有的锁视为操作失败（交给队头节点），排队的请求移出队列，它的 SSE 订阅连接被关闭。
+// 没有注册过的节点（旧客户端）不参与失效检测，只依赖租约回收。
+//
+// 成员表只保存在处理客户端请求的服务端上（复制模式下为leader，与租约计时相同），
+// 新leader上任后节点的下一次心跳会重新注册。
+
+// 节点状态
+const (
+	NodeStateAlive   = "alive"   // 心跳正常
+	NodeStateSuspect = "suspect" // 超过 NodeTimeout/2 没有心跳
+	NodeStateDead    = "dead"    // 超过 NodeTimeout 没有心跳，锁和排队请求已被清理
+)
+
+// DefaultNodeTimeout 节点失效的默认判定时长
+const DefaultNodeTimeout = 30 * time.Second
+
+// NodeInfo 节点成员信息
+type NodeInfo struct {
+	NodeID       string    `json:"node_id"`
+	Address      string    `json:"address,omitempty"` // 节点地址（注册时提供，可选）
+	State        string    `json:"state"`
+	RegisteredAt time.Time `json:"registered_at"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// NodeRequest 节点注册/心跳请求
+type NodeRequest struct {
+	NodeID  string `json:"node_id"`
+	Address string `json:"address,omitempty"`
+}
+
+// nodeSubscription 节点的一个订阅连接（节点失效时关闭）
+type nodeSubscription struct {
+	lockType   string
+	resourceID string
+	subscriber Subscriber
+}
+
+// membership 节点成员表
+type membership struct {
+	mu            sync.Mutex
+	nodes         map[string]*NodeInfo
+	subscriptions map[string][]nodeSubscription // nodeID -> 订阅连接
+}
+
+// RegisterNode 注册节点（已注册的节点刷新心跳；已失效的节点重新加入）
+func (lm *LockManager) RegisterNode(request *NodeRequest) *NodeInfo {
+	now := lm.now()
+	lm.members.mu.Lock()
+	defer lm.members.mu.Unlock()
+
+	node, exists := lm.members.nodes[request.NodeID]
+	if !exists || node.State == NodeStateDead {
+		node = &NodeInfo{NodeID: request.NodeID, RegisteredAt: now}
+		lm.members.nodes[request.NodeID] = node
+		log.Printf("[Membership] 节点加入: node=%s, address=%s", request.NodeID, request.Address)
+	}
+	if request.Address != "" {
+		node.Address = request.Address
+	}
+	node.State = NodeStateAlive
+	node.LastSeen = now
+
+	copied := *node
+	return &copied
+}
+
+// Heartbeat 节点心跳：刷新最后心跳时间，未注册的节点自动注册（例如leader切换后）
+func (lm *LockManager) Heartbeat(nodeID string) *NodeInfo {
+	return lm.RegisterNode(&NodeRequest{NodeID: nodeID})
+}
+
+// ListNodes 返回所有节点（按节点ID排序）
+func (lm *LockManager) ListNodes() []*NodeInfo {
+	lm.members.mu.Lock()
+	defer lm.members.mu.Unlock()
+
+	nodes := make([]*NodeInfo, 0, len(lm.members.nodes))
+	for _, node := range lm.members.nodes {
+		copied := *node
+		nodes = append(nodes, &copied)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeID < nodes[j].NodeID })
+	return nodes
+}
+
+// detectFailedNodes 根据最后心跳时间更新节点状态
+// 返回：本次新判定为 dead 的节点（每个节点只返回一次，重新注册后才会再次参与检测）
+func (lm *LockManager) detectFailedNodes(now time.Time) []string {
+	if lm.NodeTimeout <= 0 {
+		return nil
+	}
+
+	lm.members.mu.Lock()
+	defer lm.members.mu.Unlock()
+
+	var failed []string
+	for nodeID, node := range lm.members.nodes {
+		if node.State == NodeStateDead {
+			continue
+		}
+		silent := now.Sub(node.LastSeen)
+		switch {
+		case silent > lm.NodeTimeout:
+			node.State = NodeStateDead
+			failed = append(failed, nodeID)
+			log.Printf("[Membership] 节点失效: node=%s, last_seen=%s", nodeID, node.LastSeen.Format(time.RFC3339Nano))
+		case silent > lm.NodeTimeout/2:
+			if node.State != NodeStateSuspect {
+				log.Printf("[Membership] 节点心跳超时，标记为 suspect: node=%s, last_seen=%s",
+					nodeID, node.LastSeen.Format(time.RFC3339Nano))
+			}
+			node.State = NodeStateSuspect
+		default:
+			node.State = NodeStateAlive
+		}
+	}
+	sort.Strings(failed)
+	return failed
+}
+
+// EvictNode 清理失效节点：持有的锁视为操作失败并交给队头节点，排队的请求移出队列，关闭订阅连接
+// 返回：释放的锁数量，移出队列的请求数量
+func (lm *LockManager) EvictNode(nodeID string) (int, int) {
+	released, purged := 0, 0
+	for _, shard := range lm.shards {
+		// 先在读锁下收集候选key，避免长时间持有分段锁
+		shard.mu.RLock()
+		candidates := make(map[string]bool)
+		for key, lockInfo := range shard.locks {
+			if !lockInfo.Completed && lockInfo.Request.NodeID == nodeID {
+				candidates[key] = true
+			}
+		}
+		for key, holders := range shard.shared {
+			for _, holder := range holders {
+				if holder.Request.NodeID == nodeID {
+					candidates[key] = true
+				}
+			}
+		}
+		for key, queue := range shard.queues {
+			for _, request := range queue {
+				if request.NodeID == nodeID {
+					candidates[key] = true
+				}
+			}
+		}
+		shard.mu.RUnlock()
+
+		keys := make([]string, 0, len(candidates))
+		for key := range candidates {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			r, p := lm.evictNodeFromKey(shard, key, nodeID)
+			released += r
+			purged += p
+		}
+	}
+
+	semReleased, semPurged := lm.evictNodeFromSemaphores(nodeID)
+	released += semReleased
+	purged += semPurged
+
+	closed := lm.closeNodeSubscriptions(nodeID)
+	log.Printf("[Membership] 清理失效节点: node=%s, 释放锁=%d, 移出队列=%d, 关闭订阅=%d", nodeID, released, purged, closed)
+	return released, purged
+}
+
+// evictNodeFromKey 清理失效节点在一个key上的持有和排队
+// 加锁顺序与 TryLock/Unlock 保持一致：资源锁 -> 分段锁，并在加锁后重新检查
+func (lm *LockManager) evictNodeFromKey(shard *resourceShard, key, nodeID string) (int, int) {
+	shard.mu.Lock()
+	resourceLock, exists := shard.resourceLocks[key]
+	shard.mu.Unlock()
+	if !exists {
+		return 0, 0
+	}
+
+	resourceLock.Lock()
+	defer resourceLock.Unlock()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	// 先移出排队的请求，避免锁被交给失效节点自己
+	purged := 0
+	queue := shard.queues[key]
+	remaining := queue[:0:0]
+	for _, queued := range queue {
+		if queued.NodeID != nodeID {
+			remaining = append(remaining, queued)
+			continue
+		}
+		purged++
+		lm.appendWAL(&WALRecord{Op: WALOpDequeue, Type: queued.Type, ResourceID: queued.ResourceID, Request: queued})
+		traceQueueWait(queued, queueOutcomeNodeLost, lm.now())
+	}
+	if len(remaining) == 0 {
+		delete(shard.queues, key)
+	} else {
+		shard.queues[key] = remaining
+	}
+
+	now := lm.now()
+	released := 0
+	if lockInfo, held := shard.locks[key]; held && !lockInfo.Completed && lockInfo.Request.NodeID == nodeID {
+		lm.revokeLocked(shard, key, lockInfo, now, "锁持有者节点失效，视为操作失败")
+		released++
+	}
+	var sharedHolders []*LockInfo
+	for _, holder := range shard.shared[key] {
+		if holder.Request.NodeID == nodeID {
+			sharedHolders = append(sharedHolders, holder)
+		}
+	}
+	for _, holder := range sharedHolders {
+		lm.revokeSharedLocked(shard, key, holder, now, "共享锁持有者节点失效")
+		released++
+	}
+
+	if released == 0 && purged > 0 {
+		// 被移出的请求可能排在队头，而锁已经空闲
+		lm.dispatchIdleLocked(shard, key)
+	}
+	return released, purged
+}
+
+// trackNodeSubscription 记录节点的订阅连接（节点失效时关闭）
+func (lm *LockManager) trackNodeSubscription(nodeID, lockType, resourceID string, subscriber Subscriber) {
+	lm.members.mu.Lock()
+	defer lm.members.mu.Unlock()
+	lm.members.subscriptions[nodeID] = append(lm.members.subscriptions[nodeID],
+		nodeSubscription{lockType: lockType, resourceID: resourceID, subscriber: subscriber})
+}
+
+// untrackNodeSubscription 订阅连接断开后移除记录
+func (lm *LockManager) untrackNodeSubscription(nodeID string, subscriber Subscriber) {
+	lm.members.mu.Lock()
+	defer lm.members.mu.Unlock()
+
+	subscriptions := lm.members.subscriptions[nodeID]
+	for i, subscription := range subscriptions {
+		if subscription.subscriber == subscriber {
+			subscriptions = append(subscriptions[:i], subscriptions[i+1:]...)
+			break
+		}
+	}
+	if len(subscriptions) == 0 {
+		delete(lm.members.subscriptions, nodeID)
+	} else {
+		lm.members.subscriptions[nodeID] = subscriptions
+	}
+}
+
+// closeNodeSubscriptions 关闭节点的所有订阅连接
+// 返回：关闭的连接数量
+func (lm *LockManager) closeNodeSubscriptions(nodeID string) int {
+	lm.members.mu.Lock()
+	subscriptions := lm.members.subscriptions[nodeID]
+	delete(lm.members.subscriptions, nodeID)
+	lm.members.mu.Unlock()
+
+	for _, subscription := range subscriptions {
+		lm.Unsubscribe(subscription.lockType, subscription.resourceID, subscription.subscriber)
+		subscription.subscriber.Close()
+	}
+	return len(subscriptions)
+}
+
+// StartFailureDetector 启动后台失效检测协程，每隔 interval 检查一次节点心跳并清理失效节点
+// 返回 stop 函数，用于停止检测协程
+func (lm *LockManager) StartFailureDetector(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				for _, nodeID := range lm.detectFailedNodes(lm.now()) {
+					lm.EvictNode(nodeID)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestEvictFailedNode 测试节点失效后锁交给队头节点、排队请求被移出、订阅连接被关闭
+func TestEvictFailedNode(t *testing.T) {
+	lm := NewLockManager(true)
+	lm.RegisterNode(&NodeRequest{NodeID: "node-1"})
+	lm.RegisterNode(&NodeRequest{NodeID: "node-2"})
+
+	held := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:evict-a", NodeID: "node-1"}
+	lm.TryLock(held)
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:evict-a", NodeID: "node-2"})
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:evict-b", NodeID: "node-3"})
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:evict-b", NodeID: "node-1"})
+
+	watcher := &mockSubscriber{events: make([]OperationEvent, 0)}
+	lm.Subscribe(OperationTypePull, "sha256:evict-a", watcher)
+	nodeSubscriber := &mockSubscriber{events: make([]OperationEvent, 0)}
+	lm.Subscribe(OperationTypePull, "sha256:evict-b", nodeSubscriber)
+	lm.trackNodeSubscription("node-1", OperationTypePull, "sha256:evict-b", nodeSubscriber)
+
+	// node-1 停止心跳
+	lm.members.mu.Lock()
+	lm.members.nodes["node-1"].LastSeen = time.Now().Add(-2 * lm.NodeTimeout)
+	lm.members.mu.Unlock()
+
+	failed := lm.detectFailedNodes(time.Now())
+	if len(failed) != 1 || failed[0] != "node-1" {
+		t.Fatalf("期望 node-1 失效，实际 %v", failed)
+	}
+	if again := lm.detectFailedNodes(time.Now()); len(again) != 0 {
+		t.Errorf("同一节点只应判定失效一次，实际 %v", again)
+	}
+
+	released, purged := lm.EvictNode("node-1")
+	if released != 1 || purged != 1 {
+		t.Fatalf("期望释放1个锁、移出1个排队请求，实际 %d, %d", released, purged)
+	}
+	if lockInfo := lm.GetLockInfo(OperationTypePull, "sha256:evict-a"); lockInfo == nil || lockInfo.Request.NodeID != "node-2" {
+		t.Errorf("失效节点的锁应交给队头节点 node-2，实际 %+v", lockInfo)
+	}
+	if n := lm.GetQueueLength(OperationTypePull, "sha256:evict-b"); n != 0 {
+		t.Errorf("失效节点的排队请求应被移出，实际队列长度 %d", n)
+	}
+
+	watcher.mu.Lock()
+	if len(watcher.events) == 0 || watcher.events[0].Event != EventTypeHolderLost {
+		t.Errorf("应广播 holder_lost 事件: %+v", watcher.events)
+	}
+	watcher.mu.Unlock()
+	nodeSubscriber.mu.Lock()
+	if !nodeSubscriber.closed {
+		t.Error("失效节点的订阅连接应被关闭")
+	}
+	nodeSubscriber.mu.Unlock()
+
+	nodes := lm.ListNodes()
+	if len(nodes) != 2 || nodes[0].State != NodeStateDead || nodes[1].State != NodeStateAlive {
+		t.Errorf("期望 node-1 dead、node-2 alive: %+v, %+v", nodes[0], nodes[1])
+	}
+
+	// 失效节点重新注册后恢复为 alive
+	if node := lm.Heartbeat("node-1"); node.State != NodeStateAlive {
+		t.Errorf("重新注册后应为 alive，实际 %s", node.State)
+	}
+}
+
+// TestSuspectNode 测试心跳超过一半判定时长时标记为 suspect，不清理任何状态
+func TestSuspectNode(t *testing.T) {
+	lm := NewLockManager(true)
+	lm.RegisterNode(&NodeRequest{NodeID: "node-1", Address: "10.0.0.1"})
+
+	lm.members.mu.Lock()
+	lm.members.nodes["node-1"].LastSeen = time.Now().Add(-lm.NodeTimeout * 3 / 4)
+	lm.members.mu.Unlock()
+
+	if failed := lm.detectFailedNodes(time.Now()); len(failed) != 0 {
+		t.Fatalf("suspect 节点不应判定失效: %v", failed)
+	}
+	nodes := lm.ListNodes()
+	if nodes[0].State != NodeStateSuspect || nodes[0].Address != "10.0.0.1" {
+		t.Errorf("期望 suspect 并保留地址: %+v", nodes[0])
+	}
+}
+
+// TestNodesHTTP 测试 /nodes 接口，以及节点失效后服务端关闭它的 SSE 订阅连接
+func TestNodesHTTP(t *testing.T) {
+	lm := NewLockManager(true)
+	server := newTestServer(t, lm)
+
+	status, body := postJSON(t, server.URL+"/nodes/register", map[string]interface{}{"node_id": "node-1"})
+	if status != http.StatusOK || body["registered"] != true {
+		t.Fatalf("注册失败: status=%d, resp=%v", status, body)
+	}
+	status, body = postJSON(t, server.URL+"/nodes/heartbeat", map[string]interface{}{"node_id": "node-2"})
+	if status != http.StatusOK || body["alive"] != true {
+		t.Fatalf("心跳失败: status=%d, resp=%v", status, body)
+	}
+	if status, _ = postJSON(t, server.URL+"/nodes/heartbeat", map[string]interface{}{}); status != http.StatusBadRequest {
+		t.Errorf("缺少 node_id 应返回 400，实际 %d", status)
+	}
+
+	// 订阅响应在第一个事件之前不会返回，在协程中读取直到连接关闭
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		resp, err := http.Get(server.URL + "/lock/subscribe?type=pull&resource_id=sha256:nodes-http&node_id=node-1")
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+	}()
+	waitFor(t, "订阅建立", func() bool {
+		lm.members.mu.Lock()
+		defer lm.members.mu.Unlock()
+		return len(lm.members.subscriptions["node-1"]) == 1
+	})
+
+	lm.EvictNode("node-1")
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("节点失效后订阅连接应被关闭")
+	}
+
+	listResp, err := http.Get(server.URL + "/nodes?state=alive")
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	defer listResp.Body.Close()
+	var list struct {
+		Nodes []*NodeInfo `json:"nodes"`
+		Total int         `json:"total"`
+	}
+	if err := json.NewDecoder(listResp.Body).Decode(&list); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	if list.Total != 2 {
+		t.Errorf("期望2个 alive 节点，实际 %+v", list)
+	}
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 监控指标（Prometheus 文本格式，GET /metrics）
+//
+// 计数器和直方图在加锁/解锁路径上只做原子加法，不加额外的锁；持有中的锁、等待队列长度和订阅者数量
+// 在抓取时按分段统计（每个分段只持有读锁），不在热路径上维护。复制模式下每个副本统计自己应用的命令，
+// 路由错误在处理请求的节点上计数。
+
+// metricsNamespace 指标名称前缀
+const metricsNamespace = "distributed_lock"
+
+// durationBuckets 持有时长和等待时长直方图的桶（秒）
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// lockMetrics LockManager 的计数器和直方图
+type lockMetrics struct {
+	grants        atomic.Uint64 // 授予锁（直接获得或从队列分配）
+	queued        atomic.Uint64 // 加入等待队列
+	successes     atomic.Uint64 // 持有者操作成功释放
+	failures      atomic.Uint64 // 持有者操作失败释放（含租约过期、节点失效）
+	reassignments atomic.Uint64 // 从等待队列分配锁
+
+	holdDuration *histogram // 从获得锁到释放
+	waitDuration *histogram // 从加入等待队列到获得锁
+
+	routeErrors sync.Map // "路由 状态码" -> *atomic.Uint64
+}
+
+func newLockMetrics() *lockMetrics {
+	return &lockMetrics{
+		holdDuration: newHistogram(durationBuckets),
+		waitDuration: newHistogram(durationBuckets),
+	}
+}
+
+// granted 记录一次授予；fromQueue 表示从等待队列分配，记录等待时长
+func (m *lockMetrics) granted(request *LockRequest, fromQueue bool, now time.Time) {
+	m.grants.Add(1)
+	if fromQueue {
+		m.reassignments.Add(1)
+		if !request.Timestamp.IsZero() {
+			m.waitDuration.observe(now.Sub(request.Timestamp))
+		}
+	}
+}
+
+// released 记录一次释放（持有结束），success 为持有者的操作结果
+func (m *lockMetrics) released(holder *LockInfo, success bool, now time.Time) {
+	if success {
+		m.successes.Add(1)
+	} else {
+		m.failures.Add(1)
+	}
+	if !holder.AcquiredAt.IsZero() {
+		m.holdDuration.observe(now.Sub(holder.AcquiredAt))
+	}
+}
+
+// routeError 记录一次路由返回的错误状态码
+func (m *lockMetrics) routeError(route string, status int) {
+	key := route + " " + strconv.Itoa(status)
+	counter, ok := m.routeErrors.Load(key)
+	if !ok {
+		counter, _ = m.routeErrors.LoadOrStore(key, new(atomic.Uint64))
+	}
+	counter.(*atomic.Uint64).Add(1)
+}
+
+// histogram 固定桶的直方图，各字段独立原子更新（抓取时 count 与桶之和可能有瞬时偏差）
+type histogram struct {
+	bounds []float64
+	counts []atomic.Uint64 // 每个桶（不累加），最后一个为 +Inf
+	count  atomic.Uint64
+	sumNs  atomic.Int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	seconds := d.Seconds()
+	h.counts[sort.SearchFloat64s(h.bounds, seconds)].Add(1)
+	h.count.Add(1)
+	h.sumNs.Add(int64(d))
+}
+
+// write 以 Prometheus 文本格式输出直方图（桶为累加值）
+func (h *histogram) write(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	cumulative += h.counts[len(h.bounds)].Load()
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(time.Duration(h.sumNs.Load()).Seconds(), 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, cumulative)
+}
+
+// shardGauges 一个分段在抓取时的统计
+type shardGauges struct {
+	held        int
+	queued      int
+	subscribers int
+}
+
+// shardGauges 按分段统计持有中的锁（独占和共享持有者）、等待中的请求和订阅者
+func (lm *LockManager) shardGauges() [shardCount]shardGauges {
+	var gauges [shardCount]shardGauges
+	for i, shard := range lm.shards {
+		shard.mu.RLock()
+		gauges[i].held = len(shard.locks)
+		for _, holders := range shard.shared {
+			gauges[i].held += len(holders)
+		}
+		for _, queue := range shard.queues {
+			gauges[i].queued += len(queue)
+		}
+		for _, subscribers := range shard.subscribers {
+			gauges[i].subscribers += len(subscribers)
+		}
+		shard.mu.RUnlock()
+	}
+	return gauges
+}
+
+// WriteMetrics 以 Prometheus 文本格式输出所有指标
+func (lm *LockManager) WriteMetrics(w io.Writer) {
+	m := lm.metrics
+	counters := []struct {
+		name, help string
+		value      uint64
+	}{
+		{"grants_total", "授予锁的次数（直接获得或从等待队列分配）", m.grants.Load()},
+		{"queued_total", "加入等待队列的请求数", m.queued.Load()},
+		{"successes_total", "持有者操作成功后释放锁的次数", m.successes.Load()},
+		{"failures_total", "持有者操作失败后释放锁的次数（含租约过期、节点失效）", m.failures.Load()},
+		{"reassignments_total", "从等待队列把锁分配给下一个请求的次数", m.reassignments.Load()},
+	}
+	for _, counter := range counters {
+		name := metricsNamespace + "_" + counter.name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, counter.help, name, name, counter.value)
+	}
+
+	gauges := lm.shardGauges()
+	for _, gauge := range []struct {
+		name, help string
+		value      func(g shardGauges) int
+	}{
+		{"held_locks", "每个分段持有中的锁（共享锁按持有者计数）", func(g shardGauges) int { return g.held }},
+		{"queue_depth", "每个分段等待队列中的请求数", func(g shardGauges) int { return g.queued }},
+		{"subscribers", "每个分段的订阅者数（SSE 和 gRPC Watch）", func(g shardGauges) int { return g.subscribers }},
+	} {
+		name := metricsNamespace + "_" + gauge.name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, gauge.help, name)
+		for i := range gauges {
+			fmt.Fprintf(w, "%s{shard=\"%d\"} %d\n", name, i, gauge.value(gauges[i]))
+		}
+	}
+
+	m.holdDuration.write(w, metricsNamespace+"_hold_duration_seconds", "从获得锁到释放的时长")
+	m.waitDuration.write(w, metricsNamespace+"_wait_duration_seconds", "从加入等待队列到获得锁的时长")
+
+	name := metricsNamespace + "_http_errors_total"
+	fmt.Fprintf(w, "# HELP %s 各路由返回错误状态码（>= 400）的次数\n# TYPE %s counter\n", name, name)
+	var keys []string
+	m.routeErrors.Range(func(key, _ interface{}) bool {
+		keys = append(keys, key.(string))
+		return true
+	})
+	sort.Strings(keys)
+	for _, key := range keys {
+		counter, _ := m.routeErrors.Load(key)
+		separator := len(key) - 4 // 状态码固定3位
+		fmt.Fprintf(w, "%s{route=%q,code=\"%s\"} %d\n", name, key[:separator], key[separator+1:], counter.(*atomic.Uint64).Load())
+	}
+}
+
+// Metrics GET /metrics
+func (h *Handler) Metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	h.lockManager.WriteMetrics(w)
+}
+
+// statusRecorder 记录响应状态码（保留 Flush，SSE 订阅需要）
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// countErrors 路由中间件：按路由模板统计返回错误状态码的请求
+func (h *Handler) countErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status >= http.StatusBadRequest {
+			h.lockManager.metrics.routeError(routeTemplate(r), recorder.status)
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// metricValue 对指标输出中以 prefix 开头的所有序列求和（prefix 以空格或 "{" 结尾，避免匹配同前缀的其他指标）
+func metricValue(t *testing.T, output, prefix string) float64 {
+	t.Helper()
+	var sum float64
+	found := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		fields := strings.Fields(line)
+		value, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+		if err != nil {
+			t.Fatalf("无法解析指标行 %q: %v", line, err)
+		}
+		sum += value
+		found = true
+	}
+	if !found {
+		t.Fatalf("指标输出中没有 %s:\n%s", prefix, output)
+	}
+	return sum
+}
+
+// scrapeMetrics 输出 LockManager 的所有指标
+func scrapeMetrics(lm *LockManager) string {
+	var output strings.Builder
+	lm.WriteMetrics(&output)
+	return output.String()
+}
+
+// TestLockMetricsCounters 测试授予、排队、成功、失败和重新分配计数以及时长直方图
+func TestLockMetricsCounters(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:metrics1"
+
+	req1 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	if acquired, _, _ := lm.TryLock(req1); !acquired {
+		t.Fatal("第一个请求应该获得锁")
+	}
+	req2 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2"}
+	if acquired, _, _ := lm.TryLock(req2); acquired {
+		t.Fatal("第二个请求应该进入队列")
+	}
+
+	output := scrapeMetrics(lm)
+	if v := metricValue(t, output, "distributed_lock_held_locks{"); v != 1 {
+		t.Errorf("held_locks 期望1，实际 %v", v)
+	}
+	if v := metricValue(t, output, "distributed_lock_queue_depth{"); v != 1 {
+		t.Errorf("queue_depth 期望1，实际 %v", v)
+	}
+
+	// node-1 失败，锁重新分配给 node-2；node-2 成功
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", FencingToken: req1.FencingToken, Error: "操作失败"})
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2", FencingToken: lm.GetLockInfo(OperationTypePull, resourceID).FencingToken})
+
+	output = scrapeMetrics(lm)
+	expected := map[string]float64{
+		"distributed_lock_grants_total ":                             2,
+		"distributed_lock_queued_total ":                             1,
+		"distributed_lock_successes_total ":                          1,
+		"distributed_lock_failures_total ":                           1,
+		"distributed_lock_reassignments_total ":                      1,
+		"distributed_lock_hold_duration_seconds_count ":              2,
+		"distributed_lock_wait_duration_seconds_count ":              1,
+		"distributed_lock_wait_duration_seconds_bucket{le=\"+Inf\"}": 1,
+		"distributed_lock_held_locks{":                               0,
+		"distributed_lock_queue_depth{":                              0,
+	}
+	for prefix, want := range expected {
+		if v := metricValue(t, output, prefix); v != want {
+			t.Errorf("%s期望 %v，实际 %v", prefix, want, v)
+		}
+	}
+}
+
+// TestSharedLockMetrics 测试共享锁按持有者计数
+func TestSharedLockMetrics(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:metrics-shared"
+
+	for _, node := range []string{"node-1", "node-2"} {
+		request := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: node, Mode: LockModeShared}
+		if acquired, _, errMsg := lm.TryLock(request); !acquired {
+			t.Fatalf("%s 应该获得共享锁: %s", node, errMsg)
+		}
+	}
+
+	output := scrapeMetrics(lm)
+	if v := metricValue(t, output, "distributed_lock_held_locks{"); v != 2 {
+		t.Errorf("held_locks 期望2，实际 %v", v)
+	}
+	if v := metricValue(t, output, "distributed_lock_grants_total "); v != 2 {
+		t.Errorf("grants_total 期望2，实际 %v", v)
+	}
+}
+
+// TestHistogramBuckets 测试直方图按上界分桶并输出累加值
+func TestHistogramBuckets(t *testing.T) {
+	h := newHistogram([]float64{0.1, 1})
+	h.observe(50 * time.Millisecond)
+	h.observe(100 * time.Millisecond) // 等于上界，计入该桶
+	h.observe(500 * time.Millisecond)
+	h.observe(5 * time.Second)
+
+	var output strings.Builder
+	h.write(&output, "test_seconds", "test")
+	for _, line := range []string{
+		`test_seconds_bucket{le="0.1"} 2`,
+		`test_seconds_bucket{le="1"} 3`,
+		`test_seconds_bucket{le="+Inf"} 4`,
+		`test_seconds_sum 5.65`,
+		`test_seconds_count 4`,
+	} {
+		if !strings.Contains(output.String(), line+"\n") {
+			t.Errorf("直方图输出中没有 %q:\n%s", line, output.String())
+		}
+	}
+}
+
+// TestMetricsEndpoint 测试 /metrics 的格式和按路由统计的错误状态码
+func TestMetricsEndpoint(t *testing.T) {
+	lm := NewLockManager(true)
+	server := newTestServer(t, lm)
+
+	// 缺少必要参数：400
+	for i := 0; i < 2; i++ {
+		if status, _ := postJSON(t, server.URL+"/lock", map[string]interface{}{"type": "pull"}); status != http.StatusBadRequest {
+			t.Fatalf("期望400，实际 %d", status)
+		}
+	}
+	if status, resp := postJSON(t, server.URL+"/lock", map[string]interface{}{
+		"type": "pull", "resource_id": "sha256:metrics-http", "node_id": "node-1",
+	}); status != http.StatusOK || resp["acquired"] != true {
+		t.Fatalf("加锁失败: status=%d, resp=%v", status, resp)
+	}
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("请求 /metrics 失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("期望200，实际 %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Content-Type 期望 text/plain，实际 %s", contentType)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	output := string(body)
+
+	if !strings.Contains(output, "distributed_lock_http_errors_total{route=\"/lock\",code=\"400\"} 2\n") {
+		t.Errorf("缺少 /lock 的400计数:\n%s", output)
+	}
+	if strings.Contains(output, "code=\"200\"") {
+		t.Errorf("成功的请求不应计入错误:\n%s", output)
+	}
+	if v := metricValue(t, output, "distributed_lock_grants_total "); v != 1 {
+		t.Errorf("grants_total 期望1，实际 %v", v)
+	}
+	if !strings.Contains(output, "# TYPE distributed_lock_held_locks gauge\n") {
+		t.Errorf("缺少 held_locks 的类型说明:\n%s", output)
+	}
+	if !strings.Contains(output, "distributed_lock_subscribers{shard=\"0\"} 0\n") {
+		t.Errorf("每个分段都应输出订阅者数:\n%s", output)
+	}
+}
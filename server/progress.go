@@ -0,0 +1,73 @@
+package server
+
+import (
+	"time"
+)
+
+// ProgressRequest 持有者上报操作进度（例如下载一个很大的镜像层）
+// 等待者据此区分"慢"和"卡住"：进度在 /lock/subscribe 上以 progress 事件广播
+type ProgressRequest struct {
+	Type         string `json:"type"` // 操作类型：pull, update, delete
+	ResourceID   string `json:"resource_id"`
+	NodeID       string `json:"node_id"`
+	SessionID    string `json:"session_id,omitempty"`    // 持有者的会话ID
+	FencingToken uint64 `json:"fencing_token,omitempty"` // 可选：携带时必须与当前授予的token一致
+
+	BytesDone  int64  `json:"bytes_done"`            // 已完成的字节数
+	BytesTotal int64  `json:"bytes_total,omitempty"` // 总字节数（0 表示未知）
+	Phase      string `json:"phase,omitempty"`       // 阶段，例如 downloading、extracting、verifying
+}
+
+// LockProgress 持有者最近一次上报的进度
+type LockProgress struct {
+	BytesDone  int64     `json:"bytes_done"`
+	BytesTotal int64     `json:"bytes_total,omitempty"`
+	Phase      string    `json:"phase,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ReportProgress 记录持有者的进度并广播给该key的订阅者，同时续约（持有者仍在工作）
+// 进度只在本地记录和广播，复制模式下不写入Raft日志（订阅者和租约计时都只在leader上）
+// 返回：是否接受，拒绝原因
+func (lm *LockManager) ReportProgress(request *ProgressRequest) (bool, string) {
+	if request.BytesDone < 0 || request.BytesTotal < 0 {
+		return false, "无效的进度: bytes_done/bytes_total 不能为负数"
+	}
+
+	key := LockKey(request.Type, request.ResourceID)
+	shard := lm.getShard(request.ResourceID)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	lockInfo, exists := shard.locks[key]
+	if !exists || !lockInfo.ownedBy(request.SessionID, request.NodeID) {
+		lockInfo = findSharedHolder(shard.shared[key], request.SessionID, request.NodeID, request.FencingToken)
+	}
+	if lockInfo == nil || lockInfo.Completed ||
+		(request.FencingToken != 0 && request.FencingToken != lockInfo.FencingToken) {
+		return false, "锁不存在或不是锁的持有者"
+	}
+
+	now := lm.now()
+	lockInfo.Progress = &LockProgress{
+		BytesDone:  request.BytesDone,
+		BytesTotal: request.BytesTotal,
+		Phase:      request.Phase,
+		UpdatedAt:  now,
+	}
+	lockInfo.LeaseExpiresAt = lm.leaseDeadline(now)
+
+	lm.broadcastEvent(shard, key, &OperationEvent{
+		Event:        EventTypeProgress,
+		Type:         request.Type,
+		ResourceID:   request.ResourceID,
+		NodeID:       lockInfo.Request.NodeID,
+		SessionID:    lockInfo.Request.SessionID,
+		FencingToken: lockInfo.FencingToken,
+		BytesDone:    request.BytesDone,
+		BytesTotal:   request.BytesTotal,
+		Phase:        request.Phase,
+	})
+	return true, ""
+}
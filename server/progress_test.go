@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestReportProgress 测试持有者上报的进度广播给订阅者、记录在状态中，非持有者被拒绝
+func TestReportProgress(t *testing.T) {
+	lm := NewLockManager(true)
+	holder := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:progress", NodeID: "node-1"}
+	lm.TryLock(holder)
+	waiter := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:progress", NodeID: "node-2"}
+	lm.TryLock(waiter)
+	sub := &mockSubscriber{events: make([]OperationEvent, 0)}
+	lm.Subscribe(OperationTypePull, "sha256:progress", sub)
+
+	accepted, errMsg := lm.ReportProgress(&ProgressRequest{Type: OperationTypePull, ResourceID: "sha256:progress", NodeID: "node-1",
+		SessionID: holder.SessionID, FencingToken: holder.FencingToken, BytesDone: 512, BytesTotal: 2048, Phase: "downloading"})
+	if !accepted {
+		t.Fatalf("持有者上报进度应被接受: %s", errMsg)
+	}
+
+	sub.mu.Lock()
+	if len(sub.events) != 1 {
+		t.Fatalf("期望1个进度事件，实际 %d", len(sub.events))
+	}
+	event := sub.events[0]
+	sub.mu.Unlock()
+	if event.Event != EventTypeProgress || event.Success || event.NodeID != "node-1" || event.FencingToken != holder.FencingToken ||
+		event.BytesDone != 512 || event.BytesTotal != 2048 || event.Phase != "downloading" {
+		t.Errorf("进度事件不正确: %+v", event)
+	}
+
+	status := lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:progress", NodeID: "node-2", SessionID: waiter.SessionID})
+	if status.Progress == nil || status.Progress.BytesDone != 512 || status.Progress.Phase != "downloading" {
+		t.Errorf("等待者查询状态应看到持有者的进度: %+v", status.Progress)
+	}
+
+	for _, request := range []*ProgressRequest{
+		{Type: OperationTypePull, ResourceID: "sha256:progress", NodeID: "node-2", SessionID: waiter.SessionID, BytesDone: 1},
+		{Type: OperationTypePull, ResourceID: "sha256:progress", NodeID: "node-1", FencingToken: holder.FencingToken + 1, BytesDone: 1},
+		{Type: OperationTypePull, ResourceID: "sha256:progress", NodeID: "node-1", BytesDone: -1},
+	} {
+		if accepted, _ := lm.ReportProgress(request); accepted {
+			t.Errorf("上报应被拒绝: %+v", request)
+		}
+	}
+}
+
+// TestProgressHTTP 测试 /lock/progress 的成功、拒绝和参数校验
+func TestProgressHTTP(t *testing.T) {
+	lm := NewLockManager(true)
+	server := newTestServer(t, lm)
+
+	_, lockResp := postJSON(t, server.URL+"/lock", map[string]interface{}{"type": "pull", "resource_id": "sha256:progress-http", "node_id": "node-1"})
+	progressReq := map[string]interface{}{"type": "pull", "resource_id": "sha256:progress-http", "node_id": "node-1",
+		"session_id": lockResp["session_id"], "bytes_done": 100, "bytes_total": 1000}
+	status, resp := postJSON(t, server.URL+"/lock/progress", progressReq)
+	if status != http.StatusOK || resp["accepted"] != true {
+		t.Fatalf("持有者上报进度失败: status=%d, resp=%v", status, resp)
+	}
+
+	status, resp = postJSON(t, server.URL+"/lock/progress", map[string]interface{}{"type": "pull", "resource_id": "sha256:progress-http", "node_id": "node-2", "bytes_done": 1})
+	if status != http.StatusForbidden || resp["accepted"] != false {
+		t.Errorf("非持有者上报应返回 403: status=%d, resp=%v", status, resp)
+	}
+	if status, _ := postJSON(t, server.URL+"/lock/progress", map[string]interface{}{"type": "pull", "bytes_done": 1}); status != http.StatusBadRequest {
+		t.Errorf("缺少参数应返回 400，实际 %d", status)
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"log"
+	"time"
+)
+
+// pruneQueueLocked 把已超过等待期限的请求移出队列
+// 注意：调用此函数时，shard.mu 必须已经加锁
+// 返回：被移出的请求数量
+func (lm *LockManager) pruneQueueLocked(shard *resourceShard, key string, now time.Time) int {
+	queue := shard.queues[key]
+	if len(queue) == 0 {
+		return 0
+	}
+
+	remaining := queue[:0:0]
+	for _, request := range queue {
+		if !request.waitExpired(now) {
+			remaining = append(remaining, request)
+			continue
+		}
+		log.Printf("[pruneQueue] 等待超时，移出队列: key=%s, node=%s, wait_deadline=%s",
+			key, request.NodeID, request.WaitDeadline.Format(time.RFC3339Nano))
+		lm.appendWAL(&WALRecord{Op: WALOpDequeue, Type: request.Type, ResourceID: request.ResourceID, Request: request})
+		traceQueueWait(request, queueOutcomeExpired, now)
+	}
+
+	removed := len(queue) - len(remaining)
+	if removed == 0 {
+		return 0
+	}
+	if len(remaining) == 0 {
+		delete(shard.queues, key)
+	} else {
+		shard.queues[key] = remaining
+	}
+	return removed
+}
+
+// reapExpiredWaiters 扫描所有分段，移出已超过等待期限的请求
+// 队头被移出后锁可能已经空闲（例如持有者已经释放，而排在前面的请求超时），因此同时尝试分配锁
+// 返回：被移出的请求数量
+func (lm *LockManager) reapExpiredWaiters(now time.Time) int {
+	reaped := 0
+	for _, shard := range lm.shards {
+		shard.mu.RLock()
+		var expiredKeys []string
+		for key, queue := range shard.queues {
+			for _, request := range queue {
+				if request.waitExpired(now) {
+					expiredKeys = append(expiredKeys, key)
+					break
+				}
+			}
+		}
+		shard.mu.RUnlock()
+
+		for _, key := range expiredKeys {
+			reaped += lm.expireWaiters(shard, key, now)
+		}
+	}
+	return reaped
+}
+
+// expireWaiters 移出一个key上超过等待期限的请求
+// 加锁顺序与 TryLock/Unlock 保持一致：资源锁 -> 分段锁
+func (lm *LockManager) expireWaiters(shard *resourceShard, key string, now time.Time) int {
+	shard.mu.Lock()
+	resourceLock, exists := shard.resourceLocks[key]
+	shard.mu.Unlock()
+	if !exists {
+		return 0
+	}
+
+	resourceLock.Lock()
+	defer resourceLock.Unlock()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	removed := lm.pruneQueueLocked(shard, key, now)
+	if removed > 0 {
+		lm.dispatchIdleLocked(shard, key)
+	}
+	return removed
+}
+
+// CancelWait 取消等待：携带会话ID时把该会话的排队请求移出队列；
+// 否则把节点在该key上的所有排队请求移出队列（会话之前的客户端）
+// 携带会话ID且锁在取消之前已经分配给该会话（lock_assigned 与取消竞争）时，会话已经放弃，视为操作失败并把锁交给下一个等待者；
+// 不携带会话ID时无法区分同一节点上的其他会话，不释放已分配的锁
+// 返回：移出的排队请求数量，是否释放了已分配给该会话的锁
+func (lm *LockManager) CancelWait(request *CancelWaitRequest) (int, bool) {
+	key := LockKey(request.Type, request.ResourceID)
+	shard := lm.getShard(request.ResourceID)
+
+	shard.mu.Lock()
+	resourceLock, exists := shard.resourceLocks[key]
+	shard.mu.Unlock()
+	if !exists {
+		return 0, false
+	}
+
+	resourceLock.Lock()
+	defer resourceLock.Unlock()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	removed := 0
+	queue := shard.queues[key]
+	remaining := queue[:0:0]
+	for _, queued := range queue {
+		if !request.matches(queued) {
+			remaining = append(remaining, queued)
+			continue
+		}
+		removed++
+		lm.appendWAL(&WALRecord{Op: WALOpDequeue, Type: queued.Type, ResourceID: queued.ResourceID, Request: queued})
+		traceQueueWait(queued, queueOutcomeCanceled, lm.now())
+	}
+	if len(remaining) == 0 {
+		delete(shard.queues, key)
+	} else {
+		shard.queues[key] = remaining
+	}
+
+	released := false
+	if lockInfo, held := shard.locks[key]; held && !lockInfo.Completed && request.SessionID != "" && lockInfo.Request.SessionID == request.SessionID {
+		log.Printf("[CancelWait] 锁已分配给取消等待的会话，交给下一个等待者: key=%s, node=%s, session=%s", key, request.NodeID, request.SessionID)
+		lockInfo.Completed = true
+		lockInfo.CompletedAt = lm.now()
+		lm.handOffLocked(shard, key)
+		released = true
+	} else if _, held := shard.shared[key][request.SessionID]; held && request.SessionID != "" {
+		log.Printf("[CancelWait] 共享锁已分配给取消等待的会话，释放: key=%s, node=%s, session=%s", key, request.NodeID, request.SessionID)
+		lm.removeSharedHolderLocked(shard, key, request.SessionID, false)
+		lm.afterSharedReleaseLocked(shard, key)
+		released = true
+	} else if removed > 0 {
+		// 被取消的请求可能排在队头，而锁已经空闲
+		lm.dispatchIdleLocked(shard, key)
+	}
+
+	log.Printf("[CancelWait] 取消等待: key=%s, node=%s, 移出队列=%d, 释放锁=%v", key, request.NodeID, removed, released)
+	return removed, released
+}
+
+// dispatchIdleLocked 队列中的请求被移出后，如果该key上没有持有者，把锁分配给新的队头
+// 注意：调用此函数时，资源锁和 shard.mu 都必须已经加锁
+func (lm *LockManager) dispatchIdleLocked(shard *resourceShard, key string) {
+	if _, held := shard.locks[key]; held || len(shard.shared[key]) > 0 {
+		return
+	}
+	for _, sessionID := range lm.processQueue(shard, key) {
+		lm.notifyLockAssigned(shard, key, sessionID)
+	}
+	if _, resourceID, ok := splitLockKey(key); ok {
+		lm.dispatchBlockedLocked(shard, resourceID, "")
+	}
+}
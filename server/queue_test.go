@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestExpiredWaiterIsSkipped 测试超过等待期限的请求不会被分配锁
+func TestExpiredWaiterIsSkipped(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:queue1"
+
+	holder := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	lm.TryLock(holder)
+	impatient := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2", WaitTimeoutMs: 50}
+	lm.TryLock(impatient)
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-3"})
+
+	if impatient.WaitDeadline.IsZero() {
+		t.Fatal("加入队列时应根据 wait_timeout_ms 设置等待期限")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// 持有者失败：node-2 已超过等待期限，锁分配给 node-3
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1",
+		FencingToken: holder.FencingToken, Error: "下载失败"})
+	lockInfo := lm.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-3" {
+		t.Fatalf("期望跳过超时的 node-2，分配给 node-3，实际 %+v", lockInfo)
+	}
+	if n := lm.GetQueueLength(OperationTypePull, resourceID); n != 0 {
+		t.Errorf("超时的请求应被移出队列，实际队列长度 %d", n)
+	}
+}
+
+// TestReapExpiredWaiters 测试后台扫描移出超时的请求
+func TestReapExpiredWaiters(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:queue2"
+
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"})
+	waiter := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2", WaitTimeoutMs: 1000}
+	lm.TryLock(waiter)
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-3"})
+
+	if reaped := lm.reapExpiredWaiters(waiter.WaitDeadline.Add(-time.Millisecond)); reaped != 0 {
+		t.Fatalf("未到期限不应移出，实际移出 %d", reaped)
+	}
+	if reaped := lm.reapExpiredWaiters(waiter.WaitDeadline.Add(time.Millisecond)); reaped != 1 {
+		t.Fatalf("期望移出1个超时请求，实际 %d", reaped)
+	}
+	if n := lm.GetQueueLength(OperationTypePull, resourceID); n != 1 {
+		t.Errorf("期望队列中只剩 node-3，实际队列长度 %d", n)
+	}
+	if lockInfo := lm.GetLockInfo(OperationTypePull, resourceID); lockInfo == nil || lockInfo.Request.NodeID != "node-1" {
+		t.Errorf("持有者不应受影响，实际 %+v", lockInfo)
+	}
+}
+
+// TestCancelWait 测试取消等待后请求不会被分配锁，以及锁已分配时取消会交给下一个等待者
+func TestCancelWait(t *testing.T) {
+	dir := t.TempDir()
+	resourceID := "sha256:queue3"
+
+	lm, store := openTestLockManager(t, dir)
+	holder := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	lm.TryLock(holder)
+	waiters := make(map[string]*LockRequest)
+	for _, nodeID := range []string{"node-2", "node-3", "node-4"} {
+		waiters[nodeID] = &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: nodeID}
+		lm.TryLock(waiters[nodeID])
+	}
+
+	removed, released := lm.CancelWait(&CancelWaitRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2"})
+	if removed != 1 || released {
+		t.Fatalf("期望移出 node-2 的排队请求，实际 removed=%d, released=%v", removed, released)
+	}
+	if removed, _ := lm.CancelWait(&CancelWaitRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2"}); removed != 0 {
+		t.Errorf("重复取消不应移出请求，实际 %d", removed)
+	}
+
+	// 重启后取消仍然生效
+	store.Close()
+	lm, store = openTestLockManager(t, dir)
+	defer store.Close()
+	if n := lm.GetQueueLength(OperationTypePull, resourceID); n != 2 {
+		t.Fatalf("恢复后队列长度应为2，实际 %d", n)
+	}
+
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1",
+		FencingToken: holder.FencingToken, Error: "下载失败"})
+	lockInfo := lm.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-3" {
+		t.Fatalf("期望跳过已取消的 node-2，分配给 node-3，实际 %+v", lockInfo)
+	}
+
+	// 不携带会话ID时只移出排队请求，不释放已分配的锁
+	if _, released := lm.CancelWait(&CancelWaitRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-3"}); released {
+		t.Fatal("未携带会话ID时不应释放已分配的锁")
+	}
+
+	// node-3 在收到 lock_assigned 之前已经放弃：锁交给 node-4
+	if _, released := lm.CancelWait(&CancelWaitRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-3",
+		SessionID: waiters["node-3"].SessionID}); !released {
+		t.Fatal("锁已分配给取消等待的会话，应该释放")
+	}
+	lockInfo = lm.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-4" {
+		t.Errorf("期望锁交给 node-4，实际 %+v", lockInfo)
+	}
+}
+
+// TestCancelWaitHTTP 测试 DELETE /lock/queue 接口
+func TestCancelWaitHTTP(t *testing.T) {
+	lm := NewLockManager(true)
+	server := newTestServer(t, lm)
+	resourceID := "sha256:queue4"
+
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"})
+	status, body := postJSON(t, server.URL+"/lock", map[string]interface{}{
+		"type": "pull", "resource_id": resourceID, "node_id": "node-2", "wait_timeout_ms": 60000,
+	})
+	if status != http.StatusOK || body["acquired"] != false {
+		t.Fatalf("node-2 应该进入等待队列: status=%d, resp=%v", status, body)
+	}
+
+	status, _ = postJSON(t, server.URL+"/lock", map[string]interface{}{
+		"type": "pull", "resource_id": resourceID, "node_id": "node-3", "wait_timeout_ms": -1,
+	})
+	if status != http.StatusBadRequest {
+		t.Errorf("负数的等待时间应返回 400，实际 %d", status)
+	}
+
+	cancel := func() map[string]interface{} {
+		data, _ := json.Marshal(map[string]interface{}{"type": "pull", "resource_id": resourceID, "node_id": "node-2"})
+		req, _ := http.NewRequest(http.MethodDelete, server.URL+"/lock/queue", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("取消等待应返回 200，实际 %d", resp.StatusCode)
+		}
+		result := make(map[string]interface{})
+		json.NewDecoder(resp.Body).Decode(&result)
+		return result
+	}
+	if result := cancel(); result["cancelled"] != true || result["removed"] != float64(1) {
+		t.Errorf("取消等待失败: %v", result)
+	}
+	if result := cancel(); result["cancelled"] != false {
+		t.Errorf("重复取消应返回 cancelled=false: %v", result)
+	}
+	if n := lm.GetQueueLength(OperationTypePull, resourceID); n != 0 {
+		t.Errorf("取消后队列应为空，实际 %d", n)
+	}
+}
@@ -0,0 +1,1048 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Raft 节点角色
+const (
+	RaftRoleFollower  = "follower"
+	RaftRoleCandidate = "candidate"
+	RaftRoleLeader    = "leader"
+)
+
+const (
+	// DefaultRaftElectionTimeout 选举超时基准，实际超时在 [T, 2T) 之间随机
+	DefaultRaftElectionTimeout = 300 * time.Millisecond
+	// DefaultRaftHeartbeatInterval leader 发送心跳（空 AppendEntries）的间隔
+	DefaultRaftHeartbeatInterval = 75 * time.Millisecond
+	// DefaultRaftSnapshotThreshold 已应用但未压缩的日志条数超过该值时生成快照并截断日志
+	DefaultRaftSnapshotThreshold = 4096
+
+	// raftMaxAppendEntries 单次 AppendEntries 最多携带的日志条数
+	raftMaxAppendEntries = 256
+	// raftProposeTimeout 等待提议被提交并应用的最长时间
+	raftProposeTimeout = 5 * time.Second
+)
+
+var (
+	// ErrNotLeader 当前节点不是leader，请求应发往leader
+	ErrNotLeader = errors.New("当前节点不是leader")
+	// ErrRaftStopped 节点已停止
+	ErrRaftStopped = errors.New("raft节点已停止")
+	// ErrProposalDropped 提议的日志在提交前被新leader覆盖（结果未知，客户端可以重试）
+	ErrProposalDropped = errors.New("日志在提交前被覆盖")
+	// ErrProposalTimeout 提议在超时时间内没有被提交
+	ErrProposalTimeout = errors.New("等待日志提交超时")
+)
+
+// RaftConfig Raft 副本配置
+type RaftConfig struct {
+	// ID 本副本的唯一标识
+	ID string
+	// Peers 集群所有成员：副本ID -> HTTP地址（例如 http://10.0.0.1:8086），可以包含本副本
+	Peers map[string]string
+
+	ElectionTimeout   time.Duration // 为0时使用 DefaultRaftElectionTimeout
+	HeartbeatInterval time.Duration // 为0时使用 DefaultRaftHeartbeatInterval
+	SnapshotThreshold int           // 为0时使用 DefaultRaftSnapshotThreshold
+
+	// HTTPClient 副本间RPC使用的客户端，为nil时使用超时为选举超时的默认客户端
+	HTTPClient *http.Client
+
+	// Storage 任期、投票、日志和快照的持久化存储，为nil时只保存在内存中
+	Storage *RaftStorage
+}
+
+// raftEntry Raft 日志条目，Command 为序列化后的 raftCommand（为空表示leader上任时的空操作）
+type raftEntry struct {
+	Index   uint64          `json:"index"`
+	Term    uint64          `json:"term"`
+	Command json.RawMessage `json:"command,omitempty"`
+}
+
+// requestVoteArgs / requestVoteReply 选举RPC
+type requestVoteArgs struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidate_id"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+type requestVoteReply struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"vote_granted"`
+}
+
+// appendEntriesArgs / appendEntriesReply 日志复制（以及心跳）RPC
+type appendEntriesArgs struct {
+	Term         uint64      `json:"term"`
+	LeaderID     string      `json:"leader_id"`
+	PrevLogIndex uint64      `json:"prev_log_index"`
+	PrevLogTerm  uint64      `json:"prev_log_term"`
+	Entries      []raftEntry `json:"entries,omitempty"`
+	LeaderCommit uint64      `json:"leader_commit"`
+}
+
+type appendEntriesReply struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+	// ConflictIndex 失败时leader应重试的下一个日志下标（加速回退）
+	ConflictIndex uint64 `json:"conflict_index,omitempty"`
+}
+
+// installSnapshotArgs / installSnapshotReply follower 落后于leader已压缩的日志时发送快照
+type installSnapshotArgs struct {
+	Term              uint64        `json:"term"`
+	LeaderID          string        `json:"leader_id"`
+	LastIncludedIndex uint64        `json:"last_included_index"`
+	LastIncludedTerm  uint64        `json:"last_included_term"`
+	Snapshot          *lockSnapshot `json:"snapshot"`
+}
+
+type installSnapshotReply struct {
+	Term uint64 `json:"term"`
+}
+
+// raftWaiter 等待某条日志被应用的提议者
+type raftWaiter struct {
+	term   uint64
+	result chan raftApplyResult
+}
+
+// RaftNode 一个 Raft 副本
+// 所有改变锁状态的操作都作为命令写入Raft日志，提交后由每个副本按相同顺序应用到本地 LockManager，
+// 因此任意副本当选leader后，持有中的锁、fencing token 和等待队列顺序都与原leader一致。
+// 只有leader处理客户端请求，follower 把请求重定向到leader。
+//
+// 配置了 Storage 时任期、投票和日志在回复RPC之前写入磁盘，重启的副本从磁盘恢复后重新加入；
+// 否则只保存在内存中，重启的副本以空状态重新加入并从leader追赶，集群只能容忍少于半数的副本同时故障（包括重启）。
+type RaftNode struct {
+	config      RaftConfig
+	lockManager *LockManager
+	httpClient  *http.Client
+
+	mu          sync.Mutex
+	role        string
+	currentTerm uint64
+	votedFor    string
+	leaderID    string
+
+	// 日志：log[i] 的下标为 snapshotIndex+1+i，snapshotIndex 之前的日志已压缩进 snapshot
+	log           []raftEntry
+	snapshotIndex uint64
+	snapshotTerm  uint64
+	snapshot      *lockSnapshot
+
+	commitIndex uint64
+	lastApplied uint64
+
+	// leader 状态
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	triggers   map[string]chan struct{}
+
+	electionDeadline time.Time
+	waiters          map[uint64]*raftWaiter
+
+	// applyMu 串行化应用日志与安装快照（两者都会修改 LockManager 状态）
+	applyMu     sync.Mutex
+	applyNotify chan struct{}
+	applyingAt  atomic.Int64 // 正在应用的日志条目的提议时间（UnixNano），见 applyClock
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewRaftNode 创建 Raft 副本，lockManager 是本副本的状态机
+// 注意：复制模式下所有锁状态变更都必须经过 RaftNode，不能直接调用 lockManager.TryLock/Unlock
+func NewRaftNode(config RaftConfig, lockManager *LockManager) *RaftNode {
+	if config.ElectionTimeout <= 0 {
+		config.ElectionTimeout = DefaultRaftElectionTimeout
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = DefaultRaftHeartbeatInterval
+	}
+	if config.SnapshotThreshold <= 0 {
+		config.SnapshotThreshold = DefaultRaftSnapshotThreshold
+	}
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: config.ElectionTimeout}
+	}
+
+	n := &RaftNode{
+		config:      config,
+		lockManager: lockManager,
+		httpClient:  httpClient,
+		role:        RaftRoleFollower,
+		waiters:     make(map[uint64]*raftWaiter),
+		applyNotify: make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+	}
+	lockManager.clock = n.applyClock
+	if config.Storage != nil {
+		n.restore(config.Storage)
+	}
+	return n
+}
+
+// restore 从持久化存储恢复任期、投票、快照和日志
+// 快照直接导入 LockManager；快照之后的日志在得知提交下标后重新应用
+func (n *RaftNode) restore(storage *RaftStorage) {
+	state, snapshot, entries := storage.takeRecovered()
+	n.currentTerm = state.Term
+	n.votedFor = state.VotedFor
+	if snapshot != nil && snapshot.Snapshot != nil {
+		n.snapshotIndex = snapshot.LastIncludedIndex
+		n.snapshotTerm = snapshot.LastIncludedTerm
+		n.snapshot = snapshot.Snapshot
+		n.commitIndex = snapshot.LastIncludedIndex
+		n.lastApplied = snapshot.LastIncludedIndex
+		n.lockManager.importState(snapshot.Snapshot)
+	}
+	n.log = entries
+	log.Printf("[Raft] 从磁盘恢复: id=%s, term=%d, voted_for=%s, snapshot_index=%d, last_index=%d",
+		n.config.ID, n.currentTerm, n.votedFor, n.snapshotIndex, n.lastIndexLocked())
+}
+
+// Start 启动选举计时、日志应用、租约回收、死锁检测和节点失效检测协程
+func (n *RaftNode) Start() {
+	n.mu.Lock()
+	n.resetElectionDeadlineLocked()
+	n.mu.Unlock()
+
+	n.wg.Add(5)
+	go n.runTicker()
+	go n.runApplier()
+	go n.runLeaseReaper()
+	go n.runDeadlockDetector()
+	go n.runFailureDetector()
+
+	log.Printf("[Raft] 副本启动: id=%s, 成员数量=%d", n.config.ID, n.clusterSize())
+}
+
+// Stop 停止副本：不再参与选举和复制，等待中的提议返回 ErrRaftStopped
+func (n *RaftNode) Stop() {
+	n.stopOnce.Do(func() {
+		close(n.stopCh)
+		n.mu.Lock()
+		n.role = RaftRoleFollower
+		n.leaderID = ""
+		n.failWaitersLocked(ErrRaftStopped)
+		n.mu.Unlock()
+	})
+	n.wg.Wait()
+	log.Printf("[Raft] 副本已停止: id=%s", n.config.ID)
+}
+
+// IsLeader 当前副本是否是leader
+func (n *RaftNode) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == RaftRoleLeader
+}
+
+// Leader 返回当前已知的leader ID和地址（未知时返回空字符串）
+func (n *RaftNode) Leader() (string, string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.leaderID == "" {
+		return "", ""
+	}
+	return n.leaderID, n.config.Peers[n.leaderID]
+}
+
+// Status 返回副本状态（用于 /raft/status 和调试）
+func (n *RaftNode) Status() map[string]interface{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return map[string]interface{}{
+		"id":             n.config.ID,
+		"role":           n.role,
+		"term":           n.currentTerm,
+		"leader_id":      n.leaderID,
+		"commit_index":   n.commitIndex,
+		"last_applied":   n.lastApplied,
+		"last_index":     n.lastIndexLocked(),
+		"snapshot_index": n.snapshotIndex,
+	}
+}
+
+// propose 提议一条命令，等待其被提交并在本副本应用后返回应用结果
+func (n *RaftNode) propose(command *raftCommand) (raftApplyResult, error) {
+	command.At = time.Now()
+	data, err := json.Marshal(command)
+	if err != nil {
+		return raftApplyResult{}, fmt.Errorf("序列化命令失败: %w", err)
+	}
+
+	n.mu.Lock()
+	if n.role != RaftRoleLeader {
+		n.mu.Unlock()
+		return raftApplyResult{}, ErrNotLeader
+	}
+	entry := raftEntry{Index: n.lastIndexLocked() + 1, Term: n.currentTerm, Command: data}
+	// leader 自己也计入多数派：日志写入磁盘后才能参与提交
+	if err := n.persistEntriesLocked([]raftEntry{entry}); err != nil {
+		n.mu.Unlock()
+		return raftApplyResult{}, err
+	}
+	n.log = append(n.log, entry)
+	waiter := &raftWaiter{term: entry.Term, result: make(chan raftApplyResult, 1)}
+	n.waiters[entry.Index] = waiter
+	n.advanceCommitLocked()
+	n.triggerReplicationLocked()
+	n.mu.Unlock()
+
+	timer := time.NewTimer(raftProposeTimeout)
+	defer timer.Stop()
+	select {
+	case result := <-waiter.result:
+		return result, result.err
+	case <-timer.C:
+		n.mu.Lock()
+		delete(n.waiters, entry.Index)
+		n.mu.Unlock()
+		return raftApplyResult{}, ErrProposalTimeout
+	}
+}
+
+// ========== 定时器：选举与心跳 ==========
+
+// runTicker 检查选举超时，超时的 follower/candidate 发起选举
+func (n *RaftNode) runTicker() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.config.HeartbeatInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		if n.role != RaftRoleLeader && time.Now().After(n.electionDeadline) {
+			n.startElectionLocked()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// startElectionLocked 发起选举：任期加一，给自己投票，向其他成员请求投票
+// 注意：调用此函数时，n.mu 必须已经加锁
+func (n *RaftNode) startElectionLocked() {
+	n.role = RaftRoleCandidate
+	n.currentTerm++
+	n.votedFor = n.config.ID
+	n.leaderID = ""
+	n.resetElectionDeadlineLocked()
+	// 给自己的投票写入磁盘后才请求投票，否则重启后可能在该任期再投给其他候选人
+	if err := n.persistStateLocked(); err != nil {
+		log.Printf("[Raft] 错误: 持久化任期和投票失败，放弃本轮选举: id=%s, term=%d, error=%v", n.config.ID, n.currentTerm, err)
+		return
+	}
+
+	term := n.currentTerm
+	args := &requestVoteArgs{
+		Term:         term,
+		CandidateID:  n.config.ID,
+		LastLogIndex: n.lastIndexLocked(),
+		LastLogTerm:  n.termAtLocked(n.lastIndexLocked()),
+	}
+	log.Printf("[Raft] 发起选举: id=%s, term=%d", n.config.ID, term)
+
+	votes := 1
+	if votes*2 > n.clusterSize() {
+		n.becomeLeaderLocked()
+		return
+	}
+
+	for peerID, peerURL := range n.config.Peers {
+		if peerID == n.config.ID {
+			continue
+		}
+		go func(peerID, peerURL string) {
+			var reply requestVoteReply
+			if err := n.call(peerURL, "/raft/vote", args, &reply); err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if reply.Term > n.currentTerm {
+				n.becomeFollowerLocked(reply.Term, "")
+				return
+			}
+			if n.role != RaftRoleCandidate || n.currentTerm != term || !reply.VoteGranted {
+				return
+			}
+			votes++
+			if votes*2 > n.clusterSize() {
+				n.becomeLeaderLocked()
+			}
+		}(peerID, peerURL)
+	}
+}
+
+// becomeLeaderLocked 当选leader：初始化复制进度，追加一条空日志以提交之前任期的日志
+// 注意：调用此函数时，n.mu 必须已经加锁
+func (n *RaftNode) becomeLeaderLocked() {
+	if n.stopped() {
+		return
+	}
+	n.role = RaftRoleLeader
+	n.leaderID = n.config.ID
+	log.Printf("[Raft] 当选leader: id=%s, term=%d", n.config.ID, n.currentTerm)
+
+	noop := raftEntry{Index: n.lastIndexLocked() + 1, Term: n.currentTerm}
+	if err := n.persistEntriesLocked([]raftEntry{noop}); err != nil {
+		log.Printf("[Raft] 错误: 持久化日志失败，卸任leader: id=%s, term=%d, error=%v", n.config.ID, n.currentTerm, err)
+		n.becomeFollowerLocked(n.currentTerm, "")
+		return
+	}
+	n.log = append(n.log, noop)
+
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.triggers = make(map[string]chan struct{})
+	for peerID := range n.config.Peers {
+		if peerID == n.config.ID {
+			continue
+		}
+		n.nextIndex[peerID] = n.lastIndexLocked()
+		n.matchIndex[peerID] = 0
+		trigger := make(chan struct{}, 1)
+		n.triggers[peerID] = trigger
+		n.wg.Add(1)
+		go n.replicate(peerID, n.currentTerm, trigger)
+	}
+	n.advanceCommitLocked()
+	n.triggerReplicationLocked()
+
+	// 租约只在leader上计时：给持有者时间重新连接新leader并续约
+	go n.lockManager.resetLeases(time.Now())
+}
+
+// becomeFollowerLocked 转为 follower（发现更高任期或收到当前leader的消息）
+// 注意：调用此函数时，n.mu 必须已经加锁
+func (n *RaftNode) becomeFollowerLocked(term uint64, leaderID string) {
+	if term > n.currentTerm {
+		n.currentTerm = term
+		n.votedFor = ""
+		// 写入失败时重启后任期较小，不影响安全性（之前任期的投票仍然有效）
+		if err := n.persistStateLocked(); err != nil {
+			log.Printf("[Raft] 错误: 持久化任期失败: id=%s, term=%d, error=%v", n.config.ID, term, err)
+		}
+	}
+	if n.role == RaftRoleLeader {
+		log.Printf("[Raft] 卸任leader: id=%s, term=%d", n.config.ID, n.currentTerm)
+		// 尚未应用的提议结果未知（可能被新leader提交也可能被覆盖）
+		n.failWaitersLocked(ErrNotLeader)
+	}
+	n.role = RaftRoleFollower
+	n.leaderID = leaderID
+	n.resetElectionDeadlineLocked()
+}
+
+// ========== 日志复制（leader） ==========
+
+// replicate 向一个follower持续复制日志：有新日志时立即发送，否则按心跳间隔发送空 AppendEntries
+// term 变化（卸任或新任期）后退出
+func (n *RaftNode) replicate(peerID string, term uint64, trigger chan struct{}) {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			more, ok := n.sendAppendEntries(peerID, term)
+			if !ok {
+				return
+			}
+			if !more {
+				break
+			}
+		}
+
+		select {
+		case <-n.stopCh:
+			return
+		case <-ticker.C:
+		case <-trigger:
+		}
+	}
+}
+
+// sendAppendEntries 给follower发送一次 AppendEntries（或快照）
+// 返回：是否还有待发送的日志，是否仍是该任期的leader
+func (n *RaftNode) sendAppendEntries(peerID string, term uint64) (bool, bool) {
+	n.mu.Lock()
+	if n.role != RaftRoleLeader || n.currentTerm != term {
+		n.mu.Unlock()
+		return false, false
+	}
+	peerURL := n.config.Peers[peerID]
+	nextIndex := n.nextIndex[peerID]
+
+	// follower 需要的日志已经被压缩：发送快照
+	if nextIndex <= n.snapshotIndex {
+		args := &installSnapshotArgs{
+			Term:              term,
+			LeaderID:          n.config.ID,
+			LastIncludedIndex: n.snapshotIndex,
+			LastIncludedTerm:  n.snapshotTerm,
+			Snapshot:          n.snapshot,
+		}
+		n.mu.Unlock()
+
+		var reply installSnapshotReply
+		if err := n.call(peerURL, "/raft/snapshot", args, &reply); err != nil {
+			return false, true
+		}
+
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if reply.Term > n.currentTerm {
+			n.becomeFollowerLocked(reply.Term, "")
+			return false, false
+		}
+		if n.role != RaftRoleLeader || n.currentTerm != term {
+			return false, false
+		}
+		if args.LastIncludedIndex > n.matchIndex[peerID] {
+			n.matchIndex[peerID] = args.LastIncludedIndex
+			n.nextIndex[peerID] = args.LastIncludedIndex + 1
+		}
+		n.advanceCommitLocked()
+		return n.nextIndex[peerID] <= n.lastIndexLocked(), true
+	}
+
+	prevIndex := nextIndex - 1
+	args := &appendEntriesArgs{
+		Term:         term,
+		LeaderID:     n.config.ID,
+		PrevLogIndex: prevIndex,
+		PrevLogTerm:  n.termAtLocked(prevIndex),
+		LeaderCommit: n.commitIndex,
+	}
+	if last := n.lastIndexLocked(); nextIndex <= last {
+		end := last
+		if end-nextIndex+1 > raftMaxAppendEntries {
+			end = nextIndex + raftMaxAppendEntries - 1
+		}
+		args.Entries = append([]raftEntry(nil), n.log[nextIndex-n.snapshotIndex-1:end-n.snapshotIndex]...)
+	}
+	n.mu.Unlock()
+
+	var reply appendEntriesReply
+	if err := n.call(peerURL, "/raft/append", args, &reply); err != nil {
+		return false, true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if reply.Term > n.currentTerm {
+		n.becomeFollowerLocked(reply.Term, "")
+		return false, false
+	}
+	if n.role != RaftRoleLeader || n.currentTerm != term {
+		return false, false
+	}
+
+	if reply.Success {
+		match := prevIndex + uint64(len(args.Entries))
+		if match > n.matchIndex[peerID] {
+			n.matchIndex[peerID] = match
+		}
+		n.nextIndex[peerID] = n.matchIndex[peerID] + 1
+		n.advanceCommitLocked()
+	} else {
+		// 日志不一致：按 follower 给出的位置回退
+		next := reply.ConflictIndex
+		if next == 0 || next > prevIndex {
+			next = prevIndex
+		}
+		if next < 1 {
+			next = 1
+		}
+		n.nextIndex[peerID] = next
+	}
+	return n.nextIndex[peerID] <= n.lastIndexLocked(), true
+}
+
+// advanceCommitLocked leader 根据多数派的复制进度推进提交下标
+// 只直接提交当前任期的日志（之前任期的日志随之间接提交）
+// 注意：调用此函数时，n.mu 必须已经加锁
+func (n *RaftNode) advanceCommitLocked() {
+	if n.role != RaftRoleLeader {
+		return
+	}
+	for index := n.lastIndexLocked(); index > n.commitIndex; index-- {
+		if n.termAtLocked(index) != n.currentTerm {
+			break
+		}
+		replicas := 1
+		for _, match := range n.matchIndex {
+			if match >= index {
+				replicas++
+			}
+		}
+		if replicas*2 > n.clusterSize() {
+			n.commitIndex = index
+			n.notifyApplierLocked()
+			return
+		}
+	}
+}
+
+// triggerReplicationLocked 通知所有复制协程立即发送新日志
+// 注意：调用此函数时，n.mu 必须已经加锁
+func (n *RaftNode) triggerReplicationLocked() {
+	for _, trigger := range n.triggers {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ========== RPC 处理（follower） ==========
+
+// handleRequestVote 处理投票请求
+func (n *RaftNode) handleRequestVote(args *requestVoteArgs) *requestVoteReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if args.Term > n.currentTerm {
+		n.becomeFollowerLocked(args.Term, "")
+	}
+	reply := &requestVoteReply{Term: n.currentTerm}
+	if args.Term < n.currentTerm {
+		return reply
+	}
+
+	// 候选人的日志至少和自己一样新才投票
+	lastIndex := n.lastIndexLocked()
+	lastTerm := n.termAtLocked(lastIndex)
+	upToDate := args.LastLogTerm > lastTerm || (args.LastLogTerm == lastTerm && args.LastLogIndex >= lastIndex)
+	if (n.votedFor == "" || n.votedFor == args.CandidateID) && upToDate {
+		previous := n.votedFor
+		n.votedFor = args.CandidateID
+		// 投票写入磁盘后才回复，保证每个任期最多投一票（包括重启前后）
+		if err := n.persistStateLocked(); err != nil {
+			log.Printf("[Raft] 错误: 持久化投票失败，拒绝投票: id=%s, term=%d, candidate=%s, error=%v",
+				n.config.ID, n.currentTerm, args.CandidateID, err)
+			n.votedFor = previous
+			return reply
+		}
+		n.resetElectionDeadlineLocked()
+		reply.VoteGranted = true
+	}
+	return reply
+}
+
+// handleAppendEntries 处理日志复制/心跳
+func (n *RaftNode) handleAppendEntries(args *appendEntriesArgs) *appendEntriesReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if args.Term < n.currentTerm {
+		return &appendEntriesReply{Term: n.currentTerm}
+	}
+	n.becomeFollowerLocked(args.Term, args.LeaderID)
+	reply := &appendEntriesReply{Term: n.currentTerm}
+
+	// 跳过已压缩进快照的日志（快照中的日志一定已提交）
+	entries := args.Entries
+	prevIndex, prevTerm := args.PrevLogIndex, args.PrevLogTerm
+	if prevIndex < n.snapshotIndex {
+		skip := n.snapshotIndex - prevIndex
+		if uint64(len(entries)) <= skip {
+			entries = nil
+		} else {
+			entries = entries[skip:]
+		}
+		prevIndex, prevTerm = n.snapshotIndex, n.snapshotTerm
+	}
+
+	lastIndex := n.lastIndexLocked()
+	if prevIndex > lastIndex {
+		reply.ConflictIndex = lastIndex + 1
+		return reply
+	}
+	if term := n.termAtLocked(prevIndex); term != prevTerm {
+		// 回退到冲突任期的第一条日志
+		conflict := prevIndex
+		for conflict > n.snapshotIndex+1 && n.termAtLocked(conflict-1) == term {
+			conflict--
+		}
+		reply.ConflictIndex = conflict
+		return reply
+	}
+
+	for i, entry := range entries {
+		if entry.Index <= n.lastIndexLocked() && n.termAtLocked(entry.Index) == entry.Term {
+			continue
+		}
+		// 新日志写入磁盘后才修改内存中的日志并回复成功（恢复时覆盖冲突位置之后的日志）
+		if err := n.persistEntriesLocked(entries[i:]); err != nil {
+			log.Printf("[Raft] 错误: 持久化日志失败: id=%s, index=%d, error=%v", n.config.ID, entry.Index, err)
+			return reply
+		}
+		if entry.Index <= n.lastIndexLocked() {
+			// 冲突：删除该位置及之后的日志（这些日志一定未提交）
+			n.log = n.log[:entry.Index-n.snapshotIndex-1]
+		}
+		n.log = append(n.log, entries[i:]...)
+		break
+	}
+
+	if args.LeaderCommit > n.commitIndex {
+		lastNew := prevIndex + uint64(len(entries))
+		n.commitIndex = args.LeaderCommit
+		if lastNew < n.commitIndex {
+			n.commitIndex = lastNew
+		}
+		n.notifyApplierLocked()
+	}
+	reply.Success = true
+	return reply
+}
+
+// handleInstallSnapshot 用leader的快照替换本地状态
+func (n *RaftNode) handleInstallSnapshot(args *installSnapshotArgs) *installSnapshotReply {
+	// 先获取 applyMu：安装快照与应用日志互斥
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	if args.Term < n.currentTerm {
+		defer n.mu.Unlock()
+		return &installSnapshotReply{Term: n.currentTerm}
+	}
+	n.becomeFollowerLocked(args.Term, args.LeaderID)
+	reply := &installSnapshotReply{Term: n.currentTerm}
+	if args.LastIncludedIndex <= n.lastApplied || args.Snapshot == nil {
+		n.mu.Unlock()
+		return reply
+	}
+
+	// 本地日志包含快照的最后一条时保留其后的日志，否则全部丢弃
+	var remaining []raftEntry
+	if args.LastIncludedIndex < n.lastIndexLocked() && n.termAtLocked(args.LastIncludedIndex) == args.LastIncludedTerm {
+		remaining = append([]raftEntry(nil), n.log[args.LastIncludedIndex-n.snapshotIndex:]...)
+	}
+	// 快照写入磁盘后才替换本地状态，失败时不安装（leader 之后重试）
+	if n.config.Storage != nil {
+		if err := n.config.Storage.SaveSnapshot(args.LastIncludedIndex, args.LastIncludedTerm, args.Snapshot, remaining); err != nil {
+			log.Printf("[Raft] 错误: 持久化leader快照失败: id=%s, last_included_index=%d, error=%v", n.config.ID, args.LastIncludedIndex, err)
+			n.mu.Unlock()
+			return reply
+		}
+	}
+	n.log = remaining
+	n.snapshotIndex = args.LastIncludedIndex
+	n.snapshotTerm = args.LastIncludedTerm
+	n.snapshot = args.Snapshot
+	if n.commitIndex < args.LastIncludedIndex {
+		n.commitIndex = args.LastIncludedIndex
+	}
+	n.lastApplied = args.LastIncludedIndex
+	n.mu.Unlock()
+
+	log.Printf("[Raft] 安装leader快照: id=%s, last_included_index=%d, 锁数量=%d",
+		n.config.ID, args.LastIncludedIndex, len(args.Snapshot.Locks))
+	n.lockManager.importState(args.Snapshot)
+	return reply
+}
+
+// ========== 应用日志 ==========
+
+// runApplier 把已提交的日志按顺序应用到 LockManager
+func (n *RaftNode) runApplier() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-n.applyNotify:
+		}
+		n.applyCommitted()
+	}
+}
+
+// applyCommitted 应用 lastApplied 之后所有已提交的日志，必要时压缩日志
+func (n *RaftNode) applyCommitted() {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	if n.commitIndex <= n.lastApplied {
+		n.mu.Unlock()
+		return
+	}
+	first := n.lastApplied + 1
+	entries := append([]raftEntry(nil), n.log[first-n.snapshotIndex-1:n.commitIndex-n.snapshotIndex]...)
+	n.mu.Unlock()
+
+	for _, entry := range entries {
+		result := n.applyEntry(entry)
+
+		n.mu.Lock()
+		n.lastApplied = entry.Index
+		if waiter, exists := n.waiters[entry.Index]; exists {
+			delete(n.waiters, entry.Index)
+			if waiter.term != entry.Term {
+				// 该位置的日志已被其他leader的日志覆盖
+				result = raftApplyResult{err: ErrProposalDropped}
+			}
+			waiter.result <- result
+		}
+		n.mu.Unlock()
+	}
+
+	n.maybeCompact()
+}
+
+// maybeCompact 已应用的日志过多时生成快照并截断日志
+// 注意：调用此函数时，n.applyMu 必须已经加锁（保证快照与 lastApplied 一致）
+func (n *RaftNode) maybeCompact() {
+	n.mu.Lock()
+	if n.lastApplied-n.snapshotIndex < uint64(n.config.SnapshotThreshold) {
+		n.mu.Unlock()
+		return
+	}
+	n.mu.Unlock()
+
+	snapshot := n.lockManager.exportState()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	index := n.lastApplied
+	term := n.termAtLocked(index)
+	n.log = append([]raftEntry(nil), n.log[index-n.snapshotIndex:]...)
+	n.snapshotIndex = index
+	n.snapshotTerm = term
+	n.snapshot = snapshot
+	n.persistSnapshotLocked()
+	log.Printf("[Raft] 压缩日志: id=%s, snapshot_index=%d, 剩余日志=%d", n.config.ID, index, len(n.log))
+}
+
+// notifyApplierLocked 通知应用协程有新的已提交日志
+// 注意：调用此函数时，n.mu 必须已经加锁
+func (n *RaftNode) notifyApplierLocked() {
+	select {
+	case n.applyNotify <- struct{}{}:
+	default:
+	}
+}
+
+// failWaitersLocked 让所有等待中的提议返回错误
+// 注意：调用此函数时，n.mu 必须已经加锁
+func (n *RaftNode) failWaitersLocked(err error) {
+	for index, waiter := range n.waiters {
+		waiter.result <- raftApplyResult{err: err}
+		delete(n.waiters, index)
+	}
+}
+
+// ========== 持久化 ==========
+
+// persistStateLocked 把任期和投票写入磁盘（未配置 Storage 时忽略）
+// 注意：调用此函数时，n.mu 必须已经加锁
+func (n *RaftNode) persistStateLocked() error {
+	if n.config.Storage == nil {
+		return nil
+	}
+	return n.config.Storage.SaveState(n.currentTerm, n.votedFor)
+}
+
+// persistEntriesLocked 把即将追加到内存日志的条目写入磁盘（未配置 Storage 时忽略）
+// 注意：调用此函数时，n.mu 必须已经加锁
+func (n *RaftNode) persistEntriesLocked(entries []raftEntry) error {
+	if n.config.Storage == nil {
+		return nil
+	}
+	return n.config.Storage.AppendEntries(entries)
+}
+
+// persistSnapshotLocked 把压缩日志生成的快照和快照之后的日志写入磁盘（未配置 Storage 时忽略）
+// 写入失败时磁盘上仍是之前的快照和完整的日志，只记录错误
+// 注意：调用此函数时，n.mu 必须已经加锁
+func (n *RaftNode) persistSnapshotLocked() {
+	if n.config.Storage == nil {
+		return
+	}
+	if err := n.config.Storage.SaveSnapshot(n.snapshotIndex, n.snapshotTerm, n.snapshot, n.log); err != nil {
+		log.Printf("[Raft] 错误: 持久化快照失败: id=%s, snapshot_index=%d, error=%v", n.config.ID, n.snapshotIndex, err)
+	}
+}
+
+// ========== 辅助函数 ==========
+
+// lastIndexLocked 最后一条日志的下标
+// 注意：调用此函数时，n.mu 必须已经加锁
+func (n *RaftNode) lastIndexLocked() uint64 {
+	return n.snapshotIndex + uint64(len(n.log))
+}
+
+// termAtLocked 指定下标日志的任期（下标为0或已压缩到快照边界时返回快照任期）
+// 注意：调用此函数时，n.mu 必须已经加锁
+func (n *RaftNode) termAtLocked(index uint64) uint64 {
+	if index <= n.snapshotIndex {
+		return n.snapshotTerm
+	}
+	if index > n.lastIndexLocked() {
+		return 0
+	}
+	return n.log[index-n.snapshotIndex-1].Term
+}
+
+// resetElectionDeadlineLocked 重置选举超时（在 [T, 2T) 之间随机，避免多个副本同时发起选举）
+// 注意：调用此函数时，n.mu 必须已经加锁
+func (n *RaftNode) resetElectionDeadlineLocked() {
+	timeout := n.config.ElectionTimeout + time.Duration(rand.Int63n(int64(n.config.ElectionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+// stopped 副本是否已停止
+func (n *RaftNode) stopped() bool {
+	select {
+	case <-n.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// clusterSize 集群成员数量（包含本副本）
+func (n *RaftNode) clusterSize() int {
+	size := len(n.config.Peers)
+	if _, exists := n.config.Peers[n.config.ID]; !exists {
+		size++
+	}
+	return size
+}
+
+// call 向其他副本发送一次RPC（HTTP POST JSON）
+func (n *RaftNode) call(peerURL, path string, args, reply interface{}) error {
+	if n.stopped() {
+		return ErrRaftStopped
+	}
+
+	data, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	resp, err := n.httpClient.Post(peerURL+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("RPC %s 返回状态码 %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// ParseRaftPeers 解析集群成员配置，格式：id1=http://host1:8086,id2=http://host2:8086
+func ParseRaftPeers(spec string) (map[string]string, error) {
+	peers := make(map[string]string)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, peerURL, ok := strings.Cut(item, "=")
+		if !ok || id == "" || peerURL == "" {
+			return nil, fmt.Errorf("无效的成员配置: %q（格式应为 id=url）", item)
+		}
+		peers[id] = strings.TrimSuffix(peerURL, "/")
+	}
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("成员列表为空")
+	}
+	return peers, nil
+}
+
+// ========== HTTP 路由 ==========
+
+// memberIDs 所有副本的ID（含本副本）
+func (n *RaftNode) memberIDs() []string {
+	ids := make([]string, 0, len(n.config.Peers)+1)
+	for id := range n.config.Peers {
+		ids = append(ids, id)
+	}
+	return append(ids, n.config.ID)
+}
+
+// RegisterRoutes 注册副本间RPC路由和状态查询路由
+func (n *RaftNode) RegisterRoutes(router *mux.Router) {
+	// 启用 mTLS 时只接受证书身份为副本ID的调用方
+	router.HandleFunc("/raft/vote", peerOnly(n.memberIDs, n.serveRequestVote)).Methods("POST")
+	router.HandleFunc("/raft/append", peerOnly(n.memberIDs, n.serveAppendEntries)).Methods("POST")
+	router.HandleFunc("/raft/snapshot", peerOnly(n.memberIDs, n.serveInstallSnapshot)).Methods("POST")
+	router.HandleFunc("/raft/status", n.serveStatus).Methods("GET")
+}
+
+func (n *RaftNode) serveRequestVote(w http.ResponseWriter, r *http.Request) {
+	var args requestVoteArgs
+	if !n.decodeRPC(w, r, &args) {
+		return
+	}
+	writeRaftReply(w, n.handleRequestVote(&args))
+}
+
+func (n *RaftNode) serveAppendEntries(w http.ResponseWriter, r *http.Request) {
+	var args appendEntriesArgs
+	if !n.decodeRPC(w, r, &args) {
+		return
+	}
+	writeRaftReply(w, n.handleAppendEntries(&args))
+}
+
+func (n *RaftNode) serveInstallSnapshot(w http.ResponseWriter, r *http.Request) {
+	var args installSnapshotArgs
+	if !n.decodeRPC(w, r, &args) {
+		return
+	}
+	writeRaftReply(w, n.handleInstallSnapshot(&args))
+}
+
+func (n *RaftNode) serveStatus(w http.ResponseWriter, r *http.Request) {
+	writeRaftReply(w, n.Status())
+}
+
+// decodeRPC 解析RPC请求体，副本已停止时返回503
+func (n *RaftNode) decodeRPC(w http.ResponseWriter, r *http.Request, args interface{}) bool {
+	if n.stopped() {
+		http.Error(w, ErrRaftStopped.Error(), http.StatusServiceUnavailable)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(args); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeRaftReply(w http.ResponseWriter, reply interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
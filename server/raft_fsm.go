@@ -0,0 +1,402 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Raft 命令类型
+const (
+	raftOpLock   = "lock"   // 加锁（LockManager.TryLock）
+	raftOpUnlock = "unlock" // 解锁（LockManager.Unlock）
+	raftOpRevoke = "revoke" // 回收租约过期的锁（LockManager.RevokeLease）
+
+	raftOpUpgrade   = "upgrade"   // 共享锁升级为独占锁（LockManager.Upgrade）
+	raftOpDowngrade = "downgrade" // 独占锁降级为共享锁（LockManager.Downgrade）
+	raftOpCancel    = "cancel"    // 取消等待（LockManager.CancelWait）
+	raftOpBatch     = "batch"     // 批量加锁（LockManager.TryLockBatch）
+	raftOpDeadlock  = "deadlock"  // 中止死锁中的等待者（LockManager.BreakDeadlock）
+	raftOpEvict     = "evict"     // 清理失效节点（LockManager.EvictNode）
+
+	raftOpInvalidate = "invalidate" // 作废完成记录（LockManager.InvalidateCompletion）
+
+	raftOpSemAcquire  = "sem_acquire"  // 获取信号量许可（LockManager.AcquireSemaphore）
+	raftOpSemRelease  = "sem_release"  // 释放许可或放弃等待，也用于回收租约过期的许可（LockManager.ReleaseSemaphore）
+	raftOpSemCapacity = "sem_capacity" // 修改信号量容量（LockManager.SetSemaphoreCapacity）
+)
+
+// raftCommand 写入Raft日志的锁操作
+// 复制的是操作本身而不是结果：每个副本按日志顺序执行相同的操作，得到相同的锁状态
+type raftCommand struct {
+	Op     string         `json:"op"`
+	Lock   *LockRequest   `json:"lock,omitempty"`
+	Unlock *UnlockRequest `json:"unlock,omitempty"`
+	Revoke *expiredLease  `json:"revoke,omitempty"`
+
+	ModeChange *ModeChangeRequest `json:"mode_change,omitempty"` // upgrade/downgrade
+	Cancel     *CancelWaitRequest `json:"cancel,omitempty"`
+	Batch      *BatchLockRequest  `json:"batch,omitempty"`
+	Deadlock   *DeadlockReport    `json:"deadlock,omitempty"`
+	Evict      *NodeRequest       `json:"evict,omitempty"`
+	Invalidate *InvalidateRequest `json:"invalidate,omitempty"`
+
+	Semaphore *SemaphoreRequest         `json:"semaphore,omitempty"` // sem_acquire/sem_release
+	Capacity  *SemaphoreCapacityRequest `json:"capacity,omitempty"`  // sem_capacity
+
+	// At leader提议命令时的时间：应用时作为 LockManager 的时钟，各副本据此一致地判断等待期限
+	At time.Time `json:"at,omitempty"`
+}
+
+// raftApplyResult 命令在本副本应用后的结果（返回给leader上等待的提议者）
+type raftApplyResult struct {
+	acquired     bool
+	skip         bool
+	errMsg       string
+	fencingToken uint64
+	released     bool
+	changed      bool // upgrade/downgrade：是否已切换模式
+	removed      int  // cancel：移出队列的请求数量
+	batch        []*BatchLockResult
+	err          error
+}
+
+// TryLock 通过Raft提交加锁命令，语义与 LockManager.TryLock 相同
+// 命令被多数副本确认并在本副本应用后才返回；获得锁时 request.FencingToken 会被填写
+// 返回：是否获得锁，是否跳过操作，错误信息，复制错误（不是leader、超时等）
+func (n *RaftNode) TryLock(request *LockRequest) (bool, bool, string, error) {
+	// 会话ID由leader在提交之前分配，保证所有节点应用同一个会话ID
+	if request.SessionID == "" {
+		request.SessionID = newSessionID()
+	}
+	result, err := n.propose(&raftCommand{Op: raftOpLock, Lock: request})
+	if err != nil {
+		return false, false, "", err
+	}
+	request.FencingToken = result.fencingToken
+	return result.acquired, result.skip, result.errMsg, nil
+}
+
+// TryLockBatch 通过Raft提交批量加锁命令，语义与 LockManager.TryLockBatch 相同
+// 返回：是否全部获得，每个资源的结果，错误信息，复制错误（不是leader、超时等）
+func (n *RaftNode) TryLockBatch(batch *BatchLockRequest) (bool, []*BatchLockResult, string, error) {
+	if batch.SessionID == "" {
+		batch.SessionID = newSessionID()
+	}
+	result, err := n.propose(&raftCommand{Op: raftOpBatch, Batch: batch})
+	if err != nil {
+		return false, nil, "", err
+	}
+	return result.acquired, result.batch, result.errMsg, nil
+}
+
+// Unlock 通过Raft提交解锁命令，语义与 LockManager.Unlock 相同
+// 返回：是否释放成功，复制错误（不是leader、超时等）
+func (n *RaftNode) Unlock(request *UnlockRequest) (bool, error) {
+	result, err := n.propose(&raftCommand{Op: raftOpUnlock, Unlock: request})
+	if err != nil {
+		return false, err
+	}
+	return result.released, nil
+}
+
+// Upgrade 通过Raft提交升级命令，语义与 LockManager.Upgrade 相同
+// 返回：是否已升级，升级后的fencing token，错误信息，复制错误（不是leader、超时等）
+func (n *RaftNode) Upgrade(request *ModeChangeRequest) (bool, uint64, string, error) {
+	result, err := n.propose(&raftCommand{Op: raftOpUpgrade, ModeChange: request})
+	if err != nil {
+		return false, 0, "", err
+	}
+	return result.changed, result.fencingToken, result.errMsg, nil
+}
+
+// Downgrade 通过Raft提交降级命令，语义与 LockManager.Downgrade 相同
+// 返回：是否降级成功，降级后的fencing token，错误信息，复制错误（不是leader、超时等）
+func (n *RaftNode) Downgrade(request *ModeChangeRequest) (bool, uint64, string, error) {
+	result, err := n.propose(&raftCommand{Op: raftOpDowngrade, ModeChange: request})
+	if err != nil {
+		return false, 0, "", err
+	}
+	return result.changed, result.fencingToken, result.errMsg, nil
+}
+
+// CancelWait 通过Raft提交取消等待命令，语义与 LockManager.CancelWait 相同
+// 返回：移出的排队请求数量，是否释放了已分配的锁，复制错误（不是leader、超时等）
+func (n *RaftNode) CancelWait(request *CancelWaitRequest) (int, bool, error) {
+	result, err := n.propose(&raftCommand{Op: raftOpCancel, Cancel: request})
+	if err != nil {
+		return 0, false, err
+	}
+	return result.removed, result.released, nil
+}
+
+// InvalidateCompletion 通过Raft提交作废完成记录命令，语义与 LockManager.InvalidateCompletion 相同
+// 返回：作废的记录数量，复制错误（不是leader、超时等）
+func (n *RaftNode) InvalidateCompletion(request *InvalidateRequest) (int, error) {
+	result, err := n.propose(&raftCommand{Op: raftOpInvalidate, Invalidate: request})
+	if err != nil {
+		return 0, err
+	}
+	return result.removed, nil
+}
+
+// AcquireSemaphore 通过Raft提交获取许可命令，语义与 LockManager.AcquireSemaphore 相同
+// 返回：是否已持有许可，错误信息，复制错误（不是leader、超时等）
+func (n *RaftNode) AcquireSemaphore(request *SemaphoreRequest) (bool, string, error) {
+	// 会话ID由leader在提交之前分配，保证所有节点应用同一个会话ID
+	if request.SessionID == "" {
+		request.SessionID = newSessionID()
+	}
+	if request.Permits <= 0 {
+		request.Permits = 1
+	}
+	result, err := n.propose(&raftCommand{Op: raftOpSemAcquire, Semaphore: request})
+	if err != nil {
+		return false, "", err
+	}
+	return result.acquired, result.errMsg, nil
+}
+
+// ReleaseSemaphore 通过Raft提交释放许可命令，语义与 LockManager.ReleaseSemaphore 相同
+// 返回：是否释放了许可，是否移出了等待队列，复制错误（不是leader、超时等）
+func (n *RaftNode) ReleaseSemaphore(request *SemaphoreRequest) (bool, bool, error) {
+	result, err := n.propose(&raftCommand{Op: raftOpSemRelease, Semaphore: request})
+	if err != nil {
+		return false, false, err
+	}
+	return result.released, result.removed > 0, nil
+}
+
+// SetSemaphoreCapacity 通过Raft提交修改容量命令，语义与 LockManager.SetSemaphoreCapacity 相同
+func (n *RaftNode) SetSemaphoreCapacity(request *SemaphoreCapacityRequest) error {
+	_, err := n.propose(&raftCommand{Op: raftOpSemCapacity, Capacity: request})
+	return err
+}
+
+// applyClock 复制模式下 LockManager 的时钟：正在应用的日志条目的提议时间
+// 只在应用日志期间有意义（应用协程串行调用 LockManager），没有提议时间时使用本地时间
+func (n *RaftNode) applyClock() time.Time {
+	if at := n.applyingAt.Load(); at != 0 {
+		return time.Unix(0, at)
+	}
+	return time.Now()
+}
+
+// applyEntry 把一条已提交的日志应用到 LockManager
+// 每个副本从日志反序列化出独立的请求对象，不与leader上的原始请求共享
+func (n *RaftNode) applyEntry(entry raftEntry) raftApplyResult {
+	if len(entry.Command) == 0 {
+		return raftApplyResult{}
+	}
+
+	var command raftCommand
+	if err := json.Unmarshal(entry.Command, &command); err != nil {
+		log.Printf("[Raft] 忽略无法解析的日志: id=%s, index=%d, error=%v", n.config.ID, entry.Index, err)
+		return raftApplyResult{}
+	}
+
+	if !command.At.IsZero() {
+		n.applyingAt.Store(command.At.UnixNano())
+		defer n.applyingAt.Store(0)
+	}
+
+	switch command.Op {
+	case raftOpLock:
+		if command.Lock == nil {
+			break
+		}
+		acquired, skip, errMsg := n.lockManager.TryLock(command.Lock)
+		return raftApplyResult{
+			acquired:     acquired,
+			skip:         skip,
+			errMsg:       errMsg,
+			fencingToken: command.Lock.FencingToken,
+		}
+
+	case raftOpUnlock:
+		if command.Unlock == nil {
+			break
+		}
+		return raftApplyResult{released: n.lockManager.Unlock(command.Unlock)}
+
+	case raftOpRevoke:
+		if command.Revoke == nil {
+			break
+		}
+		n.lockManager.RevokeLease(command.Revoke.Type, command.Revoke.ResourceID, command.Revoke.FencingToken)
+		return raftApplyResult{}
+
+	case raftOpUpgrade, raftOpDowngrade:
+		if command.ModeChange == nil {
+			break
+		}
+		apply := n.lockManager.Upgrade
+		if command.Op == raftOpDowngrade {
+			apply = n.lockManager.Downgrade
+		}
+		changed, fencingToken, errMsg := apply(command.ModeChange)
+		return raftApplyResult{changed: changed, fencingToken: fencingToken, errMsg: errMsg}
+
+	case raftOpCancel:
+		if command.Cancel == nil {
+			break
+		}
+		removed, released := n.lockManager.CancelWait(command.Cancel)
+		return raftApplyResult{removed: removed, released: released}
+
+	case raftOpBatch:
+		if command.Batch == nil {
+			break
+		}
+		acquired, results, errMsg := n.lockManager.TryLockBatch(command.Batch)
+		return raftApplyResult{acquired: acquired, batch: results, errMsg: errMsg}
+
+	case raftOpDeadlock:
+		if command.Deadlock == nil {
+			break
+		}
+		return raftApplyResult{removed: n.lockManager.BreakDeadlock(command.Deadlock)}
+
+	case raftOpEvict:
+		if command.Evict == nil {
+			break
+		}
+		_, purged := n.lockManager.EvictNode(command.Evict.NodeID)
+		return raftApplyResult{removed: purged}
+
+	case raftOpInvalidate:
+		if command.Invalidate == nil {
+			break
+		}
+		return raftApplyResult{removed: n.lockManager.InvalidateCompletion(command.Invalidate)}
+
+	case raftOpSemAcquire:
+		if command.Semaphore == nil {
+			break
+		}
+		acquired, errMsg := n.lockManager.AcquireSemaphore(command.Semaphore)
+		return raftApplyResult{acquired: acquired, errMsg: errMsg}
+
+	case raftOpSemRelease:
+		if command.Semaphore == nil {
+			break
+		}
+		released, dequeued := n.lockManager.ReleaseSemaphore(command.Semaphore)
+		result := raftApplyResult{released: released}
+		if dequeued {
+			result.removed = 1
+		}
+		return result
+
+	case raftOpSemCapacity:
+		if command.Capacity == nil {
+			break
+		}
+		n.lockManager.SetSemaphoreCapacity(command.Capacity.Name, command.Capacity.Capacity)
+		return raftApplyResult{}
+	}
+
+	log.Printf("[Raft] 忽略未知的命令: id=%s, index=%d, op=%s", n.config.ID, entry.Index, command.Op)
+	return raftApplyResult{}
+}
+
+// runLeaseReaper 复制模式下的租约回收：只在leader上判断租约是否过期，并通过Raft提交回收命令
+// （follower 上的租约时间只在其当选leader时重新计算，不参与回收）
+func (n *RaftNode) runLeaseReaper() {
+	defer n.wg.Done()
+
+	interval := n.lockManager.LeaseTTL / 4
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-ticker.C:
+		}
+		if !n.IsLeader() {
+			continue
+		}
+
+		for _, lease := range n.lockManager.expiredLeases(time.Now()) {
+			log.Printf("[Raft] 租约过期，提交回收命令: type=%s, resource_id=%s, fencing_token=%d",
+				lease.Type, lease.ResourceID, lease.FencingToken)
+			if _, err := n.propose(&raftCommand{Op: raftOpRevoke, Revoke: &lease}); err != nil {
+				log.Printf("[Raft] 提交回收命令失败: resource_id=%s, error=%v", lease.ResourceID, err)
+				break
+			}
+		}
+		for _, holder := range n.lockManager.expiredSemaphoreHolders(time.Now()) {
+			log.Printf("[Raft] 许可租约过期，提交释放命令: name=%s, node=%s, session=%s",
+				holder.Name, holder.NodeID, holder.SessionID)
+			release := &SemaphoreRequest{Name: holder.Name, NodeID: holder.NodeID, SessionID: holder.SessionID}
+			if _, err := n.propose(&raftCommand{Op: raftOpSemRelease, Semaphore: release}); err != nil {
+				log.Printf("[Raft] 提交释放命令失败: name=%s, error=%v", holder.Name, err)
+				break
+			}
+		}
+	}
+}
+
+// runDeadlockDetector 复制模式下的死锁检测：只在leader上检测，并通过Raft提交中止命令
+func (n *RaftNode) runDeadlockDetector() {
+	defer n.wg.Done()
+
+	interval := n.lockManager.DeadlockCheckInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-ticker.C:
+		}
+		if !n.IsLeader() {
+			continue
+		}
+
+		for _, report := range n.lockManager.DetectDeadlocks() {
+			log.Printf("[Raft] 检测到死锁，提交中止命令: victim=%s, keys=%v", report.VictimSession, report.VictimKeys)
+			if _, err := n.propose(&raftCommand{Op: raftOpDeadlock, Deadlock: report}); err != nil {
+				log.Printf("[Raft] 提交中止命令失败: victim=%s, error=%v", report.VictimSession, err)
+				break
+			}
+		}
+	}
+}
+
+// runFailureDetector 复制模式下的节点失效检测：只在leader上维护成员表，并通过Raft提交清理命令
+func (n *RaftNode) runFailureDetector() {
+	defer n.wg.Done()
+
+	interval := n.lockManager.NodeTimeout / 4
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-ticker.C:
+		}
+		if !n.IsLeader() {
+			continue
+		}
+
+		for _, nodeID := range n.lockManager.detectFailedNodes(time.Now()) {
+			log.Printf("[Raft] 节点失效，提交清理命令: node=%s", nodeID)
+			if _, err := n.propose(&raftCommand{Op: raftOpEvict, Evict: &NodeRequest{NodeID: nodeID}}); err != nil {
+				log.Printf("[Raft] 提交清理命令失败: node=%s, error=%v", nodeID, err)
+				break
+			}
+		}
+	}
+}
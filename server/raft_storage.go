@@ -0,0 +1,287 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Raft 状态持久化
+//
+// 任期、投票和日志只保存在内存中时，重启的副本可能在已经投过票的任期再投一次票（同一任期出现两个leader），
+// 也可能以空日志帮助缺少已确认日志的候选人当选（已提交的授予和 fencing token 丢失）。
+// 设置 LOCK_STATE_DIR 的副本把这些状态写入磁盘，并在回复 RequestVote/AppendEntries 之前 fsync。
+// 目录结构（与单机模式的 WAL 使用不同的文件名）：
+//   - raft-state.json：当前任期和投票（原子替换）
+//   - raft-snapshot.json：压缩日志时的快照及其最后一条日志的下标和任期（原子替换）
+//   - raft-log.log：日志条目，一行一条JSON，只追加；下标不大于前面条目的条目覆盖该位置及之后的条目（冲突截断），
+//     压缩日志时整体重写为快照之后的条目
+const (
+	raftStateFileName    = "raft-state.json"
+	raftSnapshotFileName = "raft-snapshot.json"
+	raftLogFileName      = "raft-log.log"
+)
+
+// raftHardState 必须在回复RPC之前持久化的任期和投票
+type raftHardState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"voted_for,omitempty"`
+}
+
+// raftSnapshotFile 持久化的Raft快照
+type raftSnapshotFile struct {
+	LastIncludedIndex uint64        `json:"last_included_index"`
+	LastIncludedTerm  uint64        `json:"last_included_term"`
+	Snapshot          *lockSnapshot `json:"snapshot"`
+}
+
+// RaftStorage Raft 副本的持久化存储（每次写入都 fsync）
+type RaftStorage struct {
+	dir string
+
+	mu      sync.Mutex
+	logFile *os.File
+	logSize int64 // 日志文件最后一条完整条目的结束位置
+	state   raftHardState
+
+	// 恢复数据（由 NewRaftNode 取出一次）
+	recoveredSnapshot *raftSnapshotFile
+	recoveredEntries  []raftEntry
+}
+
+// OpenRaftStorage 打开（或创建）持久化目录，读取任期、投票、快照和日志用于恢复
+func OpenRaftStorage(dir string) (*RaftStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建Raft状态目录失败 %s: %w", dir, err)
+	}
+	s := &RaftStorage{dir: dir}
+
+	if data, err := os.ReadFile(filepath.Join(dir, raftStateFileName)); err == nil {
+		if err := json.Unmarshal(data, &s.state); err != nil {
+			return nil, fmt.Errorf("解析Raft任期和投票失败: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取Raft任期和投票失败: %w", err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, raftSnapshotFileName)); err == nil {
+		snapshot := &raftSnapshotFile{}
+		if err := json.Unmarshal(data, snapshot); err != nil {
+			return nil, fmt.Errorf("解析Raft快照失败: %w", err)
+		}
+		s.recoveredSnapshot = snapshot
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取Raft快照失败: %w", err)
+	}
+
+	entries, err := s.readLog()
+	if err != nil {
+		return nil, err
+	}
+	s.recoveredEntries = entries
+
+	if err := s.openLog(); err != nil {
+		return nil, err
+	}
+
+	snapshotIndex := uint64(0)
+	if s.recoveredSnapshot != nil {
+		snapshotIndex = s.recoveredSnapshot.LastIncludedIndex
+	}
+	log.Printf("[RaftStorage] 打开Raft状态目录: dir=%s, term=%d, voted_for=%s, snapshot_index=%d, 日志条数=%d",
+		dir, s.state.Term, s.state.VotedFor, snapshotIndex, len(entries))
+	return s, nil
+}
+
+// SaveState 持久化任期和投票（与上次写入相同时跳过）
+func (s *RaftStorage) SaveState(term uint64, votedFor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := raftHardState{Term: term, VotedFor: votedFor}
+	if state == s.state {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("序列化Raft任期和投票失败: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, raftStateFileName), data); err != nil {
+		return fmt.Errorf("写入Raft任期和投票失败: %w", err)
+	}
+	s.state = state
+	return nil
+}
+
+// AppendEntries 追加日志条目并 fsync；第一条的下标不大于已写入的最后一条时，恢复时覆盖该位置及之后的条目
+func (s *RaftStorage) AppendEntries(entries []raftEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.logFile == nil {
+		return fmt.Errorf("Raft日志已关闭")
+	}
+	var data []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("序列化Raft日志失败: %w", err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if _, err := s.logFile.Write(data); err != nil {
+		s.discardFailedLocked()
+		return fmt.Errorf("写入Raft日志失败: %w", err)
+	}
+	if err := s.logFile.Sync(); err != nil {
+		s.discardFailedLocked()
+		return fmt.Errorf("同步Raft日志失败: %w", err)
+	}
+	s.logSize += int64(len(data))
+	return nil
+}
+
+// discardFailedLocked 写入失败后截断到最后一条完整条目的结束位置
+// 截断失败时关闭日志文件：之后的写入都失败（副本不再接受日志），避免新条目跟在半条记录后面
+// 注意：调用此函数时，s.mu 必须已经加锁
+func (s *RaftStorage) discardFailedLocked() {
+	if err := s.logFile.Truncate(s.logSize); err != nil {
+		log.Printf("[RaftStorage] 错误: 截断Raft日志失败，停止写入: error=%v", err)
+		s.logFile.Close()
+		s.logFile = nil
+	}
+}
+
+// SaveSnapshot 持久化快照，并把日志文件重写为快照之后的条目
+// 先写快照再重写日志：两者之间崩溃时日志中多出的条目在恢复时按快照下标跳过
+func (s *RaftStorage) SaveSnapshot(index, term uint64, snapshot *lockSnapshot, remaining []raftEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(&raftSnapshotFile{LastIncludedIndex: index, LastIncludedTerm: term, Snapshot: snapshot})
+	if err != nil {
+		return fmt.Errorf("序列化Raft快照失败: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, raftSnapshotFileName), data); err != nil {
+		return fmt.Errorf("写入Raft快照失败: %w", err)
+	}
+
+	var logData []byte
+	for _, entry := range remaining {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("序列化Raft日志失败: %w", err)
+		}
+		logData = append(append(logData, line...), '\n')
+	}
+	if s.logFile != nil {
+		s.logFile.Close()
+		s.logFile = nil
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, raftLogFileName), logData); err != nil {
+		// 旧日志文件仍然完整（快照之前的条目在恢复时跳过），重新打开继续追加
+		if openErr := s.openLog(); openErr != nil {
+			log.Printf("[RaftStorage] 重新打开Raft日志失败: %v", openErr)
+		}
+		return fmt.Errorf("重写Raft日志失败: %w", err)
+	}
+	return s.openLog()
+}
+
+// Close 关闭日志文件
+func (s *RaftStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.logFile == nil {
+		return nil
+	}
+	err := s.logFile.Close()
+	s.logFile = nil
+	return err
+}
+
+// takeRecovered 取出恢复数据（只能取一次，由 NewRaftNode 使用）
+// 返回的日志条目从快照的下一条开始且下标连续
+func (s *RaftStorage) takeRecovered() (raftHardState, *raftSnapshotFile, []raftEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot, entries := s.recoveredSnapshot, s.recoveredEntries
+	s.recoveredSnapshot, s.recoveredEntries = nil, nil
+	return s.state, snapshot, entries
+}
+
+// readLog 读取日志文件：按下标覆盖冲突的条目，跳过快照已包含的条目，并截掉末尾不完整的条目
+func (s *RaftStorage) readLog() ([]raftEntry, error) {
+	path := filepath.Join(s.dir, raftLogFileName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var entries []raftEntry
+	validSize, err := readRecordLines(path, func(line []byte) error {
+		var entry raftEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			first, last := entries[0].Index, entries[len(entries)-1].Index
+			switch {
+			case entry.Index <= first:
+				entries = entries[:0]
+			case entry.Index <= last:
+				entries = entries[:entry.Index-first]
+			case entry.Index != last+1:
+				// 安装leader快照后、重写日志文件前失败：之前的条目已被快照包含，下面检查与快照是否连续
+				entries = entries[:0]
+			}
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(path); err == nil && info.Size() > validSize {
+		if err := os.Truncate(path, validSize); err != nil {
+			return nil, fmt.Errorf("截断Raft日志失败: %w", err)
+		}
+	}
+
+	snapshotIndex := uint64(0)
+	if s.recoveredSnapshot != nil {
+		snapshotIndex = s.recoveredSnapshot.LastIncludedIndex
+	}
+	for len(entries) > 0 && entries[0].Index <= snapshotIndex {
+		entries = entries[1:]
+	}
+	if len(entries) > 0 && entries[0].Index != snapshotIndex+1 {
+		return nil, fmt.Errorf("Raft日志与快照不连续: snapshot_index=%d, 第一条日志下标=%d", snapshotIndex, entries[0].Index)
+	}
+	return entries, nil
+}
+
+// openLog 打开日志文件用于追加
+// 注意：调用此函数时，s.mu 必须已经加锁（OpenRaftStorage 初始化时除外）
+func (s *RaftStorage) openLog() error {
+	path := filepath.Join(s.dir, raftLogFileName)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("打开Raft日志失败 %s: %w", path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("读取Raft日志信息失败 %s: %w", path, err)
+	}
+	s.logFile = f
+	s.logSize = info.Size()
+	return nil
+}
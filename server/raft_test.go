@@ -0,0 +1,401 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// testReplica 进程内的一个 Raft 副本（监听 loopback 端口）
+type testReplica struct {
+	id      string
+	peers   map[string]string
+	dir     string // 不为空时 Raft 状态写入该目录
+	lm      *LockManager
+	node    *RaftNode
+	storage *RaftStorage
+	server  *httptest.Server
+	started bool
+	stopped bool
+
+	snapshotThreshold int
+}
+
+// build 创建副本的 LockManager、RaftNode 和路由（dir 不为空时从该目录恢复 Raft 状态）
+func (r *testReplica) build(t *testing.T) {
+	t.Helper()
+	config := RaftConfig{
+		ID:                r.id,
+		Peers:             r.peers,
+		ElectionTimeout:   100 * time.Millisecond,
+		HeartbeatInterval: 20 * time.Millisecond,
+		SnapshotThreshold: r.snapshotThreshold,
+	}
+	if r.dir != "" {
+		storage, err := OpenRaftStorage(r.dir)
+		if err != nil {
+			t.Fatalf("打开Raft状态目录失败: %v", err)
+		}
+		r.storage = storage
+		config.Storage = storage
+	}
+	r.lm = NewLockManager(true)
+	r.node = NewRaftNode(config, r.lm)
+	router := mux.NewRouter()
+	NewReplicatedHandler(r.lm, r.node).RegisterRoutes(router)
+	r.server.Config.Handler = router
+}
+
+// start 启动副本的HTTP服务和Raft协程
+func (r *testReplica) start() {
+	r.server.Start()
+	r.node.Start()
+	r.started = true
+}
+
+// stop 模拟副本宕机
+func (r *testReplica) stop() {
+	if !r.started || r.stopped {
+		return
+	}
+	r.stopped = true
+	r.node.Stop()
+	r.server.CloseClientConnections()
+	r.server.Close()
+	if r.storage != nil {
+		r.storage.Close()
+	}
+}
+
+// restart 模拟宕机的副本重启：在原地址上以新的进程状态（从 dir 恢复）启动
+func (r *testReplica) restart(t *testing.T) {
+	t.Helper()
+	listener, err := net.Listen("tcp", strings.TrimPrefix(r.peers[r.id], "http://"))
+	if err != nil {
+		t.Fatalf("重新监听 %s 失败: %v", r.peers[r.id], err)
+	}
+	r.server = &httptest.Server{Listener: listener, Config: &http.Server{}}
+	r.build(t)
+	r.started, r.stopped = false, false
+	r.start()
+}
+
+// newTestCluster 创建 size 个副本（未启动），副本之间通过 loopback HTTP 通信
+func newTestCluster(t *testing.T, size int, snapshotThreshold int) []*testReplica {
+	return newTestClusterWithStorage(t, size, snapshotThreshold, false)
+}
+
+// newTestClusterWithStorage 同 newTestCluster，persistent 为 true 时每个副本把 Raft 状态写入各自的临时目录
+func newTestClusterWithStorage(t *testing.T, size int, snapshotThreshold int, persistent bool) []*testReplica {
+	t.Helper()
+
+	replicas := make([]*testReplica, size)
+	peers := make(map[string]string)
+	for i := range replicas {
+		server := httptest.NewUnstartedServer(nil)
+		id := fmt.Sprintf("replica-%d", i+1)
+		peers[id] = "http://" + server.Listener.Addr().String()
+		replicas[i] = &testReplica{id: id, peers: peers, server: server, snapshotThreshold: snapshotThreshold}
+		if persistent {
+			replicas[i].dir = t.TempDir()
+		}
+	}
+
+	for _, r := range replicas {
+		r.build(t)
+	}
+
+	t.Cleanup(func() {
+		for _, r := range replicas {
+			r.stop()
+		}
+	})
+	return replicas
+}
+
+// waitForLeader 等待存活副本中选出唯一的leader
+func waitForLeader(t *testing.T, replicas []*testReplica) *testReplica {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var leaders []*testReplica
+		for _, r := range replicas {
+			if r.started && !r.stopped && r.node.IsLeader() {
+				leaders = append(leaders, r)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("等待leader选举超时")
+	return nil
+}
+
+// waitFor 轮询直到 cond 成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("等待超时: %s", what)
+}
+
+// TestRaftFailoverPreservesGrantsAndQueue 测试leader宕机后新leader保留持有中的锁、fencing token和队列顺序
+func TestRaftFailoverPreservesGrantsAndQueue(t *testing.T) {
+	replicas := newTestCluster(t, 3, 0)
+	for _, r := range replicas {
+		r.start()
+	}
+	leader := waitForLeader(t, replicas)
+	resourceID := "sha256:raft1"
+
+	req1 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	acquired, _, _, err := leader.node.TryLock(req1)
+	if err != nil || !acquired || req1.FencingToken == 0 {
+		t.Fatalf("node-1 应该获得锁: acquired=%v, token=%d, err=%v", acquired, req1.FencingToken, err)
+	}
+	for _, nodeID := range []string{"node-2", "node-3"} {
+		acquired, _, _, err := leader.node.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: nodeID})
+		if err != nil || acquired {
+			t.Fatalf("%s 应该进入等待队列: acquired=%v, err=%v", nodeID, acquired, err)
+		}
+	}
+
+	// 所有副本都应用了相同的状态
+	for _, r := range replicas {
+		waitFor(t, r.id+" 应用日志", func() bool { return r.lm.GetQueueLength(OperationTypePull, resourceID) == 2 })
+	}
+
+	// leader 宕机
+	leader.stop()
+	newLeader := waitForLeader(t, replicas)
+	if newLeader == leader {
+		t.Fatal("宕机的副本不应再是leader")
+	}
+
+	lockInfo := newLeader.lm.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-1" || lockInfo.FencingToken != req1.FencingToken {
+		t.Fatalf("新leader上 node-1 应仍持有锁（token=%d），实际 %+v", req1.FencingToken, lockInfo)
+	}
+
+	// 持有者操作失败，锁按原队列顺序依次交给 node-2、node-3
+	previousToken := req1.FencingToken
+	holder := "node-1"
+	for _, next := range []string{"node-2", "node-3"} {
+		released, err := newLeader.node.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID,
+			NodeID: holder, FencingToken: previousToken, Error: "下载失败"})
+		if err != nil || !released {
+			t.Fatalf("%s 解锁失败: released=%v, err=%v", holder, released, err)
+		}
+		lockInfo = newLeader.lm.GetLockInfo(OperationTypePull, resourceID)
+		if lockInfo == nil || lockInfo.Request.NodeID != next {
+			t.Fatalf("期望 %s 获得锁，实际 %+v", next, lockInfo)
+		}
+		if lockInfo.FencingToken <= previousToken {
+			t.Errorf("新分配的token应大于 %d，实际 %d", previousToken, lockInfo.FencingToken)
+		}
+		holder, previousToken = next, lockInfo.FencingToken
+	}
+
+	// 原持有者的旧token已失效
+	released, err := newLeader.node.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID,
+		NodeID: "node-1", FencingToken: req1.FencingToken})
+	if err != nil || released {
+		t.Errorf("旧token解锁应该失败: released=%v, err=%v", released, err)
+	}
+}
+
+// TestRaftFollowerRedirectsToLeader 测试follower把客户端请求重定向到leader
+func TestRaftFollowerRedirectsToLeader(t *testing.T) {
+	replicas := newTestCluster(t, 3, 0)
+	for _, r := range replicas {
+		r.start()
+	}
+	leader := waitForLeader(t, replicas)
+
+	var follower *testReplica
+	for _, r := range replicas {
+		if r != leader {
+			follower = r
+			break
+		}
+	}
+	waitFor(t, "follower 知道leader", func() bool {
+		leaderID, _ := follower.node.Leader()
+		return leaderID == leader.id
+	})
+
+	// 不跟随重定向：应返回 307 并指向leader
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Post(follower.server.URL+"/lock", "application/json",
+		strings.NewReader(`{"type":"pull","resource_id":"sha256:raft2","node_id":"node-1"}`))
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("follower 应返回 307，实际 %d", resp.StatusCode)
+	}
+	if location := resp.Header.Get("Location"); location != leader.server.URL+"/lock" {
+		t.Errorf("重定向地址应为 %s，实际 %s", leader.server.URL+"/lock", location)
+	}
+
+	// 跟随重定向：请求体随 307 一起转发，在leader上获得锁
+	status, body := postJSON(t, follower.server.URL+"/lock", map[string]interface{}{
+		"type": "pull", "resource_id": "sha256:raft2", "node_id": "node-1",
+	})
+	if status != http.StatusOK || body["acquired"] != true {
+		t.Fatalf("经follower重定向加锁失败: status=%d, resp=%v", status, body)
+	}
+	token := body["fencing_token"]
+
+	status, body = postJSON(t, follower.server.URL+"/unlock", map[string]interface{}{
+		"type": "pull", "resource_id": "sha256:raft2", "node_id": "node-1", "fencing_token": token,
+	})
+	if status != http.StatusOK || body["released"] != true {
+		t.Fatalf("经follower重定向解锁失败: status=%d, resp=%v", status, body)
+	}
+}
+
+// TestRaftLaggingReplicaCatchesUpFromSnapshot 测试日志压缩后，落后的副本通过快照追上leader
+func TestRaftLaggingReplicaCatchesUpFromSnapshot(t *testing.T) {
+	replicas := newTestCluster(t, 3, 4)
+	replicas[0].start()
+	replicas[1].start()
+	leader := waitForLeader(t, replicas)
+
+	// 生成足够多的日志触发压缩
+	for i := 0; i < 10; i++ {
+		request := &LockRequest{Type: OperationTypePull, ResourceID: fmt.Sprintf("sha256:lag%d", i), NodeID: "node-1"}
+		if acquired, _, _, err := leader.node.TryLock(request); err != nil || !acquired {
+			t.Fatalf("加锁失败: acquired=%v, err=%v", acquired, err)
+		}
+	}
+	leader.node.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:lag0", NodeID: "node-2"})
+	waitFor(t, "leader 压缩日志", func() bool {
+		leader.node.mu.Lock()
+		defer leader.node.mu.Unlock()
+		return leader.node.snapshotIndex > 0
+	})
+
+	// 第三个副本此时才启动，需要的日志已经被压缩
+	lagging := replicas[2]
+	lagging.start()
+	waitFor(t, "落后副本追上leader", func() bool {
+		return lagging.lm.GetQueueLength(OperationTypePull, "sha256:lag0") == 1 &&
+			lagging.lm.GetLockInfo(OperationTypePull, "sha256:lag9") != nil
+	})
+
+	lockInfo := lagging.lm.GetLockInfo(OperationTypePull, "sha256:lag0")
+	expected := leader.lm.GetLockInfo(OperationTypePull, "sha256:lag0")
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-1" || lockInfo.FencingToken != expected.FencingToken {
+		t.Errorf("落后副本的锁状态与leader不一致: %+v", lockInfo)
+	}
+}
+
+// TestRaftRestartRecoversFromDisk 测试所有副本同时重启后从磁盘恢复：持有中的锁、fencing token 和队列都不丢失
+func TestRaftRestartRecoversFromDisk(t *testing.T) {
+	replicas := newTestClusterWithStorage(t, 3, 4, true)
+	for _, r := range replicas {
+		r.start()
+	}
+	leader := waitForLeader(t, replicas)
+
+	// 日志条数超过压缩阈值：恢复同时用到快照和快照之后的日志
+	var requests []*LockRequest
+	for i := 0; i < 6; i++ {
+		request := &LockRequest{Type: OperationTypePull, ResourceID: fmt.Sprintf("sha256:disk%d", i), NodeID: "node-1"}
+		if acquired, _, _, err := leader.node.TryLock(request); err != nil || !acquired {
+			t.Fatalf("加锁失败: acquired=%v, err=%v", acquired, err)
+		}
+		requests = append(requests, request)
+	}
+	if acquired, _, _, err := leader.node.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:disk0", NodeID: "node-2"}); err != nil || acquired {
+		t.Fatalf("node-2 应该进入等待队列: acquired=%v, err=%v", acquired, err)
+	}
+	for _, r := range replicas {
+		waitFor(t, r.id+" 应用日志", func() bool { return r.lm.GetQueueLength(OperationTypePull, "sha256:disk0") == 1 })
+	}
+	term := leader.node.Status()["term"].(uint64)
+
+	for _, r := range replicas {
+		r.stop()
+	}
+	for _, r := range replicas {
+		r.restart(t)
+	}
+	newLeader := waitForLeader(t, replicas)
+	if newTerm := newLeader.node.Status()["term"].(uint64); newTerm <= term {
+		t.Errorf("重启后的任期应大于重启前的 %d，实际 %d", term, newTerm)
+	}
+
+	waitFor(t, "新leader应用恢复的日志", func() bool {
+		return newLeader.lm.GetQueueLength(OperationTypePull, "sha256:disk0") == 1
+	})
+	for _, request := range requests {
+		lockInfo := newLeader.lm.GetLockInfo(OperationTypePull, request.ResourceID)
+		if lockInfo == nil || lockInfo.Request.NodeID != "node-1" || lockInfo.FencingToken != request.FencingToken {
+			t.Errorf("%s: 重启后 node-1 应仍持有锁（token=%d），实际 %+v", request.ResourceID, request.FencingToken, lockInfo)
+		}
+	}
+}
+
+// TestRaftStorageRestoresVoteAndLog 测试重启后恢复任期和投票（同一任期不再投给其他候选人）以及覆盖冲突后的日志
+func TestRaftStorageRestoresVoteAndLog(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := OpenRaftStorage(dir)
+	if err != nil {
+		t.Fatalf("打开Raft状态目录失败: %v", err)
+	}
+	if err := storage.SaveState(3, "replica-2"); err != nil {
+		t.Fatalf("保存任期和投票失败: %v", err)
+	}
+	storage.AppendEntries([]raftEntry{{Index: 1, Term: 1}, {Index: 2, Term: 1}, {Index: 3, Term: 1}})
+	// leader 用任期2的日志覆盖下标2及之后的日志
+	storage.AppendEntries([]raftEntry{{Index: 2, Term: 2}})
+	storage.Close()
+
+	// 模拟崩溃时写了一半的日志
+	f, err := os.OpenFile(filepath.Join(dir, raftLogFileName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"index":3,"te`)
+	f.Close()
+
+	storage, err = OpenRaftStorage(dir)
+	if err != nil {
+		t.Fatalf("重新打开Raft状态目录失败: %v", err)
+	}
+	defer storage.Close()
+	node := NewRaftNode(RaftConfig{ID: "replica-1", Peers: map[string]string{
+		"replica-1": "http://127.0.0.1:1", "replica-2": "http://127.0.0.1:2", "replica-3": "http://127.0.0.1:3",
+	}, Storage: storage}, NewLockManager(true))
+
+	status := node.Status()
+	if status["term"] != uint64(3) || status["last_index"] != uint64(2) || node.termAtLocked(2) != 2 {
+		t.Fatalf("恢复的任期或日志不正确: %v, term(2)=%d", status, node.termAtLocked(2))
+	}
+	// 任期3已经投给 replica-2
+	reply := node.handleRequestVote(&requestVoteArgs{Term: 3, CandidateID: "replica-3", LastLogIndex: 10, LastLogTerm: 3})
+	if reply.VoteGranted {
+		t.Error("重启后不应在已投票的任期再投给其他候选人")
+	}
+	if reply := node.handleRequestVote(&requestVoteArgs{Term: 3, CandidateID: "replica-2", LastLogIndex: 10, LastLogTerm: 3}); !reply.VoteGranted {
+		t.Error("同一任期可以再次投给已投票的候选人")
+	}
+}
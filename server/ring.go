@@ -0,0 +1,117 @@
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// 一致性哈希环
+//
+// 集群模式下每个服务端进程负责环上的一部分资源：资源ID（信号量为名称）用 FNV-1a（与 getShard 相同）
+// 加 fmix32 混合映射到环上，顺时针遇到的第一个虚拟节点所属的成员就是该资源的 owner。同一资源的所有操作类型
+// 落在同一个 owner 上，跨操作类型的互斥和兼容矩阵不受影响。
+// 客户端使用相同的算法（client.HashRing）直接把请求发给 owner，两边必须保持一致。
+
+// DefaultRingVirtualNodes 每个成员默认的虚拟节点数量
+const DefaultRingVirtualNodes = 64
+
+// RingMember 环上的一个服务端
+type RingMember struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// HashRing 一致性哈希环（创建后只读）
+type HashRing struct {
+	Version      uint64       `json:"version"`       // 环版本，每次成员变更递增
+	VirtualNodes int          `json:"virtual_nodes"` // 每个成员的虚拟节点数量
+	Members      []RingMember `json:"members"`       // 按ID排序
+
+	points []ringPoint // 按哈希值排序的虚拟节点
+}
+
+// ringPoint 环上的一个虚拟节点
+type ringPoint struct {
+	hash   uint32
+	member int // Members 下标
+}
+
+// NewHashRing 创建哈希环，members 为 成员ID -> URL（格式与 RAFT_PEERS 相同，见 ParseRaftPeers）
+// virtualNodes <= 0 时使用 DefaultRingVirtualNodes
+func NewHashRing(version uint64, members map[string]string, virtualNodes int) (*HashRing, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("成员列表为空")
+	}
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultRingVirtualNodes
+	}
+
+	ring := &HashRing{Version: version, VirtualNodes: virtualNodes}
+	for id, memberURL := range members {
+		if id == "" || memberURL == "" {
+			return nil, fmt.Errorf("无效的成员: %q=%q", id, memberURL)
+		}
+		ring.Members = append(ring.Members, RingMember{ID: id, URL: memberURL})
+	}
+	sort.Slice(ring.Members, func(i, j int) bool { return ring.Members[i].ID < ring.Members[j].ID })
+
+	ring.points = make([]ringPoint, 0, len(ring.Members)*virtualNodes)
+	for i, member := range ring.Members {
+		for v := 0; v < virtualNodes; v++ {
+			ring.points = append(ring.points, ringPoint{hash: ringHash(member.ID + "#" + strconv.Itoa(v)), member: i})
+		}
+	}
+	// 哈希值相同时按成员排序，保证各进程（以及客户端）得到相同的环
+	sort.Slice(ring.points, func(i, j int) bool {
+		if ring.points[i].hash == ring.points[j].hash {
+			return ring.points[i].member < ring.points[j].member
+		}
+		return ring.points[i].hash < ring.points[j].hash
+	})
+	return ring, nil
+}
+
+// ringHash FNV-1a 哈希（与 getShard 相同）再做一次 murmur3 的 fmix32 混合
+// 成员ID只差一个字符的虚拟节点（lock-1#0、lock-1#1...）直接用 FNV-1a 时在环上分布不均匀
+func ringHash(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	hash := h.Sum32()
+	hash ^= hash >> 16
+	hash *= 0x85ebca6b
+	hash ^= hash >> 13
+	hash *= 0xc2b2ae35
+	hash ^= hash >> 16
+	return hash
+}
+
+// Owner 返回负责 resourceID 的成员
+func (r *HashRing) Owner(resourceID string) RingMember {
+	hash := ringHash(resourceID)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.Members[r.points[i].member]
+}
+
+// Member 按ID查找成员
+func (r *HashRing) Member(id string) (RingMember, bool) {
+	for _, member := range r.Members {
+		if member.ID == id {
+			return member, true
+		}
+	}
+	return RingMember{}, false
+}
+
+// memberMap 成员ID -> URL
+func (r *HashRing) memberMap() map[string]string {
+	members := make(map[string]string, len(r.Members))
+	for _, member := range r.Members {
+		members[member.ID] = member.URL
+	}
+	return members
+}
@@ -0,0 +1,455 @@
+package server
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// 计数信号量
+//
+// 锁保证同一层只有一个节点下载，信号量限制整个集群同时进行的下载数量（例如镜像仓库的限流）：
+// 每个信号量有一个容量，请求获取 N 个许可，剩余许可不足时按 FIFO 排队（队头不满足时后面的请求也等待，
+// 避免需要多个许可的请求被饿死）。许可释放后按顺序分配给队头，并在 /lock/subscribe?type=semaphore
+// 上广播 permits_released 和 permits_granted 事件。
+// 持有者与锁一样有租约，需要续约；租约过期或节点失效时许可被回收。
+
+// SemaphoreType 信号量事件使用的类型（订阅：/lock/subscribe?type=semaphore&resource_id=<name>）
+const SemaphoreType = "semaphore"
+
+// Semaphore 一个信号量的状态
+type Semaphore struct {
+	Name    string                      `json:"name"`
+	Holders map[string]*SemaphoreHolder `json:"holders"` // sessionID -> 持有者
+	Waiters []*SemaphoreRequest         `json:"waiters"` // 等待队列（FIFO）
+
+	// Capacity 通过 /semaphore/capacity 设置的容量，覆盖启动配置（Override 为 false 时使用配置）
+	Capacity int  `json:"capacity,omitempty"`
+	Override bool `json:"override,omitempty"`
+}
+
+// SemaphoreHolder 持有许可的会话
+type SemaphoreHolder struct {
+	NodeID         string    `json:"node_id"`
+	SessionID      string    `json:"session_id"`
+	Permits        int       `json:"permits"`
+	AcquiredAt     time.Time `json:"acquired_at"`
+	LeaseExpiresAt time.Time `json:"lease_expires_at,omitempty"`
+}
+
+// SemaphoreRequest 获取/释放/续约许可请求
+type SemaphoreRequest struct {
+	Name      string    `json:"name"` // 信号量名称，例如 global、registry:docker.io
+	NodeID    string    `json:"node_id"`
+	SessionID string    `json:"session_id,omitempty"` // 为空时由服务端分配
+	Permits   int       `json:"permits,omitempty"`    // 请求的许可数量（默认1）
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// SemaphoreCapacityRequest 修改信号量容量请求
+type SemaphoreCapacityRequest struct {
+	Name     string `json:"name"`
+	Capacity int    `json:"capacity"`          // <= 0 表示不限制
+	NodeID   string `json:"node_id,omitempty"` // 设置授权策略时按该节点的角色检查（启用 mTLS 时可省略，使用证书身份）
+}
+
+// SemaphoreInfo 信号量状态（管理接口和查询返回）
+type SemaphoreInfo struct {
+	Name      string              `json:"name"`
+	Capacity  int                 `json:"capacity"`  // <= 0 表示不限制
+	Used      int                 `json:"used"`      // 已分配的许可数量
+	Available int                 `json:"available"` // 剩余许可数量，不限制时为 -1
+	Holders   []*SemaphoreHolder  `json:"holders"`   // 按获取时间排序
+	Waiters   []*SemaphoreRequest `json:"waiters"`
+}
+
+// semaphoreLocked 返回名称对应的信号量，不存在时创建
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) semaphoreLocked(shard *resourceShard, name string) *Semaphore {
+	sem, exists := shard.semaphores[name]
+	if !exists {
+		sem = &Semaphore{Name: name, Holders: make(map[string]*SemaphoreHolder)}
+		shard.semaphores[name] = sem
+	}
+	return sem
+}
+
+// semaphoreCapacity 信号量当前的容量：设置过的容量，否则为启动配置（SemaphoreCapacities，未列出时为 DefaultSemaphoreCapacity）
+// 复制模式下所有副本必须使用相同的配置
+func (lm *LockManager) semaphoreCapacity(sem *Semaphore) int {
+	if sem.Override {
+		return sem.Capacity
+	}
+	if capacity, configured := lm.SemaphoreCapacities[sem.Name]; configured {
+		return capacity
+	}
+	return lm.DefaultSemaphoreCapacity
+}
+
+// used 已分配的许可数量
+func (sem *Semaphore) used() int {
+	used := 0
+	for _, holder := range sem.Holders {
+		used += holder.Permits
+	}
+	return used
+}
+
+// available 容量为 capacity 时剩余的许可数量，不限制时为 -1
+func (sem *Semaphore) available(capacity int) int {
+	if capacity <= 0 {
+		return -1
+	}
+	if available := capacity - sem.used(); available > 0 {
+		return available
+	}
+	return 0
+}
+
+// fits 容量为 capacity 时是否可以立即分配 permits 个许可
+func (sem *Semaphore) fits(capacity, permits int) bool {
+	return capacity <= 0 || sem.used()+permits <= capacity
+}
+
+// AcquireSemaphore 获取 request.Permits 个许可
+// 剩余许可足够且没有更早的等待者时立即分配，否则加入等待队列（许可释放后按FIFO分配并发送 permits_granted 事件）
+// 同一会话重复请求是幂等的：已持有时续约并返回 true，已排队时返回 false
+// 返回：是否已持有许可，错误信息（请求的许可超过容量时不排队）
+func (lm *LockManager) AcquireSemaphore(request *SemaphoreRequest) (bool, string) {
+	if request.Permits <= 0 {
+		request.Permits = 1
+	}
+	if request.SessionID == "" {
+		request.SessionID = newSessionID()
+	}
+	now := lm.now()
+	request.Timestamp = now
+
+	shard := lm.getShard(request.Name)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	sem := lm.semaphoreLocked(shard, request.Name)
+	if holder, exists := sem.Holders[request.SessionID]; exists {
+		holder.LeaseExpiresAt = lm.leaseDeadline(now)
+		return true, ""
+	}
+	for _, waiter := range sem.Waiters {
+		if waiter.SessionID == request.SessionID {
+			return false, ""
+		}
+	}
+	capacity := lm.semaphoreCapacity(sem)
+	if capacity > 0 && request.Permits > capacity {
+		return false, "请求的许可数超过信号量容量"
+	}
+
+	if len(sem.Waiters) == 0 && sem.fits(capacity, request.Permits) {
+		lm.grantSemaphoreLocked(sem, request, now)
+		log.Printf("[AcquireSemaphore] 获得许可: name=%s, node=%s, permits=%d, available=%d",
+			request.Name, request.NodeID, request.Permits, sem.available(capacity))
+	} else {
+		sem.Waiters = append(sem.Waiters, request)
+		log.Printf("[AcquireSemaphore] 许可不足，加入等待队列: name=%s, node=%s, permits=%d, 队列长度=%d",
+			request.Name, request.NodeID, request.Permits, len(sem.Waiters))
+	}
+	lm.appendSemaphoreWAL(sem)
+	_, held := sem.Holders[request.SessionID]
+	return held, ""
+}
+
+// ReleaseSemaphore 释放会话持有的许可，或把会话移出等待队列（放弃等待）
+// 释放后按FIFO把许可分配给等待者。释放是幂等的
+// 返回：是否释放了许可，是否移出了等待队列
+func (lm *LockManager) ReleaseSemaphore(request *SemaphoreRequest) (bool, bool) {
+	shard := lm.getShard(request.Name)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	sem, exists := shard.semaphores[request.Name]
+	if !exists {
+		return false, false
+	}
+
+	if holder, held := sem.Holders[request.SessionID]; held && holder.NodeID == request.NodeID {
+		lm.releaseSemaphoreLocked(shard, sem, holder, "释放许可")
+		return true, false
+	}
+	for i, waiter := range sem.Waiters {
+		if waiter.SessionID == request.SessionID && waiter.NodeID == request.NodeID {
+			sem.Waiters = append(sem.Waiters[:i:i], sem.Waiters[i+1:]...)
+			// 队头离开后，后面的请求可能已经可以分配
+			lm.grantWaitersLocked(shard, sem, lm.now())
+			lm.appendSemaphoreWAL(sem)
+			log.Printf("[ReleaseSemaphore] 放弃等待: name=%s, node=%s", request.Name, request.NodeID)
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// KeepAliveSemaphore 续约会话持有的许可
+// 返回：是否续约成功（许可已被回收或会话不是持有者时返回false）
+func (lm *LockManager) KeepAliveSemaphore(request *SemaphoreRequest) bool {
+	shard := lm.getShard(request.Name)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	sem, exists := shard.semaphores[request.Name]
+	if !exists {
+		return false
+	}
+	holder, held := sem.Holders[request.SessionID]
+	if !held || holder.NodeID != request.NodeID {
+		return false
+	}
+	holder.LeaseExpiresAt = lm.leaseDeadline(lm.now())
+	return true
+}
+
+// SetSemaphoreCapacity 修改信号量容量（<= 0 表示不限制）
+// 扩容后立即把许可分配给等待者；缩容不回收已分配的许可，只是之后的请求需要等待已用许可降到容量以下
+func (lm *LockManager) SetSemaphoreCapacity(name string, capacity int) {
+	shard := lm.getShard(name)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	sem := lm.semaphoreLocked(shard, name)
+	sem.Capacity = capacity
+	sem.Override = true
+	lm.grantWaitersLocked(shard, sem, lm.now())
+	lm.appendSemaphoreWAL(sem)
+	log.Printf("[SetSemaphoreCapacity] 修改信号量容量: name=%s, capacity=%d, used=%d", name, capacity, sem.used())
+}
+
+// GetSemaphore 查询信号量状态（不存在时返回按配置容量的空状态）
+func (lm *LockManager) GetSemaphore(name string) *SemaphoreInfo {
+	shard := lm.getShard(name)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	sem, exists := shard.semaphores[name]
+	if !exists {
+		sem = &Semaphore{Name: name}
+	}
+	return sem.info(lm.semaphoreCapacity(sem))
+}
+
+// ListSemaphores 列出所有信号量的状态（按名称排序）
+func (lm *LockManager) ListSemaphores() []*SemaphoreInfo {
+	var semaphores []*SemaphoreInfo
+	for _, shard := range lm.shards {
+		shard.mu.RLock()
+		for _, sem := range shard.semaphores {
+			semaphores = append(semaphores, sem.info(lm.semaphoreCapacity(sem)))
+		}
+		shard.mu.RUnlock()
+	}
+	sort.Slice(semaphores, func(i, j int) bool { return semaphores[i].Name < semaphores[j].Name })
+	return semaphores
+}
+
+// info 复制信号量状态（capacity 为当前容量）
+func (sem *Semaphore) info(capacity int) *SemaphoreInfo {
+	info := &SemaphoreInfo{
+		Name:      sem.Name,
+		Capacity:  capacity,
+		Used:      sem.used(),
+		Available: sem.available(capacity),
+		Holders:   make([]*SemaphoreHolder, 0, len(sem.Holders)),
+		Waiters:   make([]*SemaphoreRequest, 0, len(sem.Waiters)),
+	}
+	for _, holder := range sem.Holders {
+		holderCopy := *holder
+		info.Holders = append(info.Holders, &holderCopy)
+	}
+	sort.Slice(info.Holders, func(i, j int) bool {
+		if info.Holders[i].AcquiredAt.Equal(info.Holders[j].AcquiredAt) {
+			return info.Holders[i].SessionID < info.Holders[j].SessionID
+		}
+		return info.Holders[i].AcquiredAt.Before(info.Holders[j].AcquiredAt)
+	})
+	for _, waiter := range sem.Waiters {
+		waiterCopy := *waiter
+		info.Waiters = append(info.Waiters, &waiterCopy)
+	}
+	return info
+}
+
+// copy 深拷贝信号量状态（写入WAL和快照，以及从中恢复）
+func (sem *Semaphore) copy() *Semaphore {
+	semCopy := &Semaphore{
+		Name:     sem.Name,
+		Capacity: sem.Capacity,
+		Override: sem.Override,
+		Holders:  make(map[string]*SemaphoreHolder, len(sem.Holders)),
+		Waiters:  make([]*SemaphoreRequest, len(sem.Waiters)),
+	}
+	for sessionID, holder := range sem.Holders {
+		holderCopy := *holder
+		semCopy.Holders[sessionID] = &holderCopy
+	}
+	for i, waiter := range sem.Waiters {
+		waiterCopy := *waiter
+		semCopy.Waiters[i] = &waiterCopy
+	}
+	return semCopy
+}
+
+// grantSemaphoreLocked 把许可分配给请求
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) grantSemaphoreLocked(sem *Semaphore, request *SemaphoreRequest, now time.Time) {
+	sem.Holders[request.SessionID] = &SemaphoreHolder{
+		NodeID:         request.NodeID,
+		SessionID:      request.SessionID,
+		Permits:        request.Permits,
+		AcquiredAt:     now,
+		LeaseExpiresAt: lm.leaseDeadline(now),
+	}
+}
+
+// grantWaitersLocked 按FIFO把剩余许可分配给等待者（队头不满足时停止），并通知被分配的会话
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) grantWaitersLocked(shard *resourceShard, sem *Semaphore, now time.Time) {
+	capacity := lm.semaphoreCapacity(sem)
+	for len(sem.Waiters) > 0 && sem.fits(capacity, sem.Waiters[0].Permits) {
+		waiter := sem.Waiters[0]
+		sem.Waiters = sem.Waiters[1:]
+		lm.grantSemaphoreLocked(sem, waiter, now)
+		log.Printf("[AcquireSemaphore] 等待者获得许可: name=%s, node=%s, permits=%d, available=%d",
+			sem.Name, waiter.NodeID, waiter.Permits, sem.available(capacity))
+		lm.broadcastEvent(shard, LockKey(SemaphoreType, sem.Name), &OperationEvent{
+			Event:       EventTypePermitsGranted,
+			Type:        SemaphoreType,
+			ResourceID:  sem.Name,
+			NodeID:      waiter.NodeID,
+			SessionID:   waiter.SessionID,
+			CompletedAt: now,
+			Permits:     waiter.Permits,
+			Available:   sem.available(capacity),
+		})
+	}
+}
+
+// releaseSemaphoreLocked 回收持有者的许可：广播 permits_released，再按FIFO分配给等待者
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) releaseSemaphoreLocked(shard *resourceShard, sem *Semaphore, holder *SemaphoreHolder, reason string) {
+	delete(sem.Holders, holder.SessionID)
+	now := lm.now()
+	log.Printf("[ReleaseSemaphore] %s: name=%s, node=%s, permits=%d", reason, sem.Name, holder.NodeID, holder.Permits)
+	lm.broadcastEvent(shard, LockKey(SemaphoreType, sem.Name), &OperationEvent{
+		Event:       EventTypePermitsReleased,
+		Type:        SemaphoreType,
+		ResourceID:  sem.Name,
+		NodeID:      holder.NodeID,
+		SessionID:   holder.SessionID,
+		CompletedAt: now,
+		Permits:     holder.Permits,
+		Available:   sem.available(lm.semaphoreCapacity(sem)),
+	})
+	lm.grantWaitersLocked(shard, sem, now)
+	lm.appendSemaphoreWAL(sem)
+}
+
+// appendSemaphoreWAL 把信号量变更后的完整状态写入WAL（恢复时直接替换）
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) appendSemaphoreWAL(sem *Semaphore) {
+	lm.appendWAL(&WALRecord{Op: WALOpSemaphore, Type: SemaphoreType, ResourceID: sem.Name, Semaphore: sem.copy()})
+}
+
+// expiredSemaphoreHolder 租约已过期的许可持有者
+type expiredSemaphoreHolder struct {
+	Name      string
+	NodeID    string
+	SessionID string
+}
+
+// expiredSemaphoreHolders 收集在 now 之前租约到期的许可持有者（按名称和会话排序）
+func (lm *LockManager) expiredSemaphoreHolders(now time.Time) []expiredSemaphoreHolder {
+	if lm.LeaseTTL <= 0 {
+		return nil
+	}
+	var expired []expiredSemaphoreHolder
+	for _, shard := range lm.shards {
+		shard.mu.RLock()
+		for _, sem := range shard.semaphores {
+			for _, holder := range sem.Holders {
+				if !holder.LeaseExpiresAt.IsZero() && now.After(holder.LeaseExpiresAt) {
+					expired = append(expired, expiredSemaphoreHolder{Name: sem.Name, NodeID: holder.NodeID, SessionID: holder.SessionID})
+				}
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		if expired[i].Name == expired[j].Name {
+			return expired[i].SessionID < expired[j].SessionID
+		}
+		return expired[i].Name < expired[j].Name
+	})
+	return expired
+}
+
+// reapExpiredSemaphores 回收租约过期的许可（单机模式，由租约回收协程调用）
+// 返回：被回收的持有者数量
+func (lm *LockManager) reapExpiredSemaphores(now time.Time) int {
+	reaped := 0
+	for _, expired := range lm.expiredSemaphoreHolders(now) {
+		shard := lm.getShard(expired.Name)
+		shard.mu.Lock()
+		if sem, exists := shard.semaphores[expired.Name]; exists {
+			// 收集之后可能已经续约或释放，重新检查
+			if holder, held := sem.Holders[expired.SessionID]; held && now.After(holder.LeaseExpiresAt) {
+				lm.releaseSemaphoreLocked(shard, sem, holder, "许可持有者租约过期，回收许可")
+				reaped++
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return reaped
+}
+
+// evictNodeFromSemaphores 回收失效节点持有的许可并把它移出等待队列
+// 返回：回收的持有者数量，移出队列的请求数量
+func (lm *LockManager) evictNodeFromSemaphores(nodeID string) (int, int) {
+	released, purged := 0, 0
+	for _, shard := range lm.shards {
+		shard.mu.Lock()
+		names := make([]string, 0, len(shard.semaphores))
+		for name := range shard.semaphores {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sem := shard.semaphores[name]
+			remaining := sem.Waiters[:0:0]
+			for _, waiter := range sem.Waiters {
+				if waiter.NodeID == nodeID {
+					purged++
+					continue
+				}
+				remaining = append(remaining, waiter)
+			}
+			changed := len(remaining) != len(sem.Waiters)
+			sem.Waiters = remaining
+
+			var holders []*SemaphoreHolder
+			for _, holder := range sem.Holders {
+				if holder.NodeID == nodeID {
+					holders = append(holders, holder)
+				}
+			}
+			sort.Slice(holders, func(i, j int) bool { return holders[i].SessionID < holders[j].SessionID })
+			for _, holder := range holders {
+				lm.releaseSemaphoreLocked(shard, sem, holder, "节点失效，回收许可")
+				released++
+			}
+			if changed && len(holders) == 0 {
+				lm.grantWaitersLocked(shard, sem, lm.now())
+				lm.appendSemaphoreWAL(sem)
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return released, purged
+}
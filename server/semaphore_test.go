@@ -0,0 +1,219 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// acquirePermits 获取许可并返回请求（会话ID由服务端分配）
+func acquirePermits(t *testing.T, lm *LockManager, name, nodeID string, permits int) (*SemaphoreRequest, bool) {
+	t.Helper()
+	request := &SemaphoreRequest{Name: name, NodeID: nodeID, Permits: permits}
+	acquired, errMsg := lm.AcquireSemaphore(request)
+	if errMsg != "" {
+		t.Fatalf("%s 获取许可失败: %s", nodeID, errMsg)
+	}
+	return request, acquired
+}
+
+// TestSemaphoreFIFO 测试许可不足时按FIFO排队（队头不满足时后面的请求也等待），释放后依次分配并广播事件
+func TestSemaphoreFIFO(t *testing.T) {
+	lm := NewLockManager(true)
+	lm.SemaphoreCapacities["registry"] = 2
+	sub := &mockSubscriber{events: make([]OperationEvent, 0)}
+	lm.Subscribe(SemaphoreType, "registry", sub)
+
+	first, acquired := acquirePermits(t, lm, "registry", "node-1", 1)
+	if !acquired {
+		t.Fatal("node-1 应该获得许可")
+	}
+	second, acquired := acquirePermits(t, lm, "registry", "node-2", 2)
+	if acquired {
+		t.Fatal("剩余1个许可，node-2 请求2个应排队")
+	}
+	third, acquired := acquirePermits(t, lm, "registry", "node-3", 1)
+	if acquired {
+		t.Fatal("已有更早的等待者，node-3 即使剩余许可足够也应排队")
+	}
+	if again, _ := lm.AcquireSemaphore(&SemaphoreRequest{Name: "registry", NodeID: "node-2", SessionID: second.SessionID, Permits: 2}); again {
+		t.Error("排队的会话重复请求不应获得许可")
+	}
+	if info := lm.GetSemaphore("registry"); info.Used != 1 || info.Available != 1 || len(info.Waiters) != 2 {
+		t.Fatalf("信号量状态不正确: %+v", info)
+	}
+
+	if released, _ := lm.ReleaseSemaphore(first); !released {
+		t.Fatal("node-1 应释放许可")
+	}
+	if info := lm.GetSemaphore("registry"); info.Used != 2 || len(info.Holders) != 1 || info.Holders[0].NodeID != "node-2" || len(info.Waiters) != 1 {
+		t.Fatalf("释放后许可应分配给队头 node-2: %+v", info)
+	}
+	lm.ReleaseSemaphore(second)
+	if acquired, _ := lm.AcquireSemaphore(third); !acquired {
+		t.Error("node-2 释放后 node-3 应持有许可（重复请求是幂等的）")
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	expected := []struct {
+		event, nodeID string
+		permits       int
+	}{
+		{EventTypePermitsReleased, "node-1", 1},
+		{EventTypePermitsGranted, "node-2", 2},
+		{EventTypePermitsReleased, "node-2", 2},
+		{EventTypePermitsGranted, "node-3", 1},
+	}
+	if len(sub.events) != len(expected) {
+		t.Fatalf("期望收到%d个事件，实际 %d: %+v", len(expected), len(sub.events), sub.events)
+	}
+	for i, want := range expected {
+		got := sub.events[i]
+		if got.Event != want.event || got.NodeID != want.nodeID || got.Permits != want.permits || got.Type != SemaphoreType {
+			t.Errorf("第%d个事件应为 %s/%s/%d，实际 %+v", i, want.event, want.nodeID, want.permits, got)
+		}
+	}
+	if sub.events[3].SessionID != third.SessionID || sub.events[3].Available != 1 {
+		t.Errorf("permits_granted 应携带会话和剩余许可: %+v", sub.events[3])
+	}
+}
+
+// TestSemaphoreCapacity 测试超过容量的请求被拒绝、修改容量后分配给等待者，以及不限制容量
+func TestSemaphoreCapacity(t *testing.T) {
+	lm := NewLockManager(true)
+	lm.DefaultSemaphoreCapacity = 1
+
+	if acquired, errMsg := lm.AcquireSemaphore(&SemaphoreRequest{Name: "global", NodeID: "node-1", Permits: 2}); acquired || errMsg == "" {
+		t.Error("请求的许可超过容量时应返回错误")
+	}
+	acquirePermits(t, lm, "global", "node-1", 1)
+	waiter, acquired := acquirePermits(t, lm, "global", "node-2", 1)
+	if acquired {
+		t.Fatal("容量为1时 node-2 应排队")
+	}
+
+	lm.SetSemaphoreCapacity("global", 2)
+	if info := lm.GetSemaphore("global"); info.Capacity != 2 || info.Used != 2 || len(info.Waiters) != 0 {
+		t.Fatalf("扩容后等待者应获得许可: %+v", info)
+	}
+	if acquired, _ := lm.AcquireSemaphore(waiter); !acquired {
+		t.Error("扩容后 node-2 应持有许可")
+	}
+
+	lm.SetSemaphoreCapacity("global", 0)
+	for i := 0; i < 5; i++ {
+		if _, acquired := acquirePermits(t, lm, "global", "node-3", 3); !acquired {
+			t.Fatal("不限制容量时应立即获得许可")
+		}
+	}
+	if info := lm.GetSemaphore("global"); info.Available != -1 || info.Used != 17 {
+		t.Errorf("不限制容量时剩余许可应为-1: %+v", info)
+	}
+}
+
+// TestSemaphoreLeaseAndEviction 测试持有者租约过期或节点失效时许可被回收并分配给等待者
+func TestSemaphoreLeaseAndEviction(t *testing.T) {
+	lm := NewLockManager(true)
+	lm.LeaseTTL = 50 * time.Millisecond
+	lm.SemaphoreCapacities["global"] = 1
+
+	holder, _ := acquirePermits(t, lm, "global", "node-1", 1)
+	acquirePermits(t, lm, "global", "node-2", 1)
+	acquirePermits(t, lm, "global", "node-3", 1)
+
+	if n := lm.reapExpiredSemaphores(time.Now()); n != 0 {
+		t.Fatalf("租约未到期，不应回收，实际回收 %d", n)
+	}
+	if n := lm.reapExpiredSemaphores(time.Now().Add(100 * time.Millisecond)); n != 1 {
+		t.Fatalf("期望回收1个持有者，实际 %d", n)
+	}
+	if lm.KeepAliveSemaphore(holder) {
+		t.Error("许可被回收后续约应失败")
+	}
+	if info := lm.GetSemaphore("global"); len(info.Holders) != 1 || info.Holders[0].NodeID != "node-2" {
+		t.Fatalf("租约过期后许可应分配给 node-2: %+v", info)
+	}
+
+	released, _ := lm.EvictNode("node-2")
+	if released != 1 {
+		t.Errorf("期望回收 node-2 的1个持有者，实际 %d", released)
+	}
+	if info := lm.GetSemaphore("global"); len(info.Holders) != 1 || info.Holders[0].NodeID != "node-3" || len(info.Waiters) != 0 {
+		t.Fatalf("node-2 失效后许可应分配给 node-3: %+v", info)
+	}
+}
+
+// TestSemaphoreRecoveredFromWAL 测试信号量的持有者、等待者和设置的容量在重启后恢复
+func TestSemaphoreRecoveredFromWAL(t *testing.T) {
+	dir := t.TempDir()
+	lm, store := openTestLockManager(t, dir)
+	lm.SetSemaphoreCapacity("global", 1)
+	holder, _ := acquirePermits(t, lm, "global", "node-1", 1)
+	waiter, _ := acquirePermits(t, lm, "global", "node-2", 1)
+	store.Close()
+
+	lm2, store2 := openTestLockManager(t, dir)
+	defer store2.Close()
+	info := lm2.GetSemaphore("global")
+	if info.Capacity != 1 || len(info.Holders) != 1 || info.Holders[0].SessionID != holder.SessionID ||
+		len(info.Waiters) != 1 || info.Waiters[0].SessionID != waiter.SessionID {
+		t.Fatalf("重启后信号量状态应恢复: %+v", info)
+	}
+	if released, _ := lm2.ReleaseSemaphore(holder); !released {
+		t.Fatal("重启后持有者应能释放许可")
+	}
+	if acquired, _ := lm2.AcquireSemaphore(waiter); !acquired {
+		t.Error("释放后等待者应获得许可")
+	}
+}
+
+// TestSemaphoreHTTP 测试 /semaphore/acquire、/semaphore/release、/semaphore/capacity 和查询接口
+func TestSemaphoreHTTP(t *testing.T) {
+	lm := NewLockManager(true)
+	lm.DefaultSemaphoreCapacity = 1
+	server := newTestServer(t, lm)
+
+	status, resp := postJSON(t, server.URL+"/semaphore/acquire", map[string]interface{}{"name": "global", "node_id": "node-1"})
+	if status != http.StatusOK || resp["acquired"] != true || resp["session_id"] == "" || resp["available"] != float64(0) {
+		t.Fatalf("node-1 应获得许可: status=%d, resp=%v", status, resp)
+	}
+	holderSession := resp["session_id"]
+
+	_, resp = postJSON(t, server.URL+"/semaphore/acquire", map[string]interface{}{"name": "global", "node_id": "node-2"})
+	if resp["acquired"] != false || resp["queue_position"] != float64(0) {
+		t.Fatalf("node-2 应排队: %v", resp)
+	}
+	if status, resp = postJSON(t, server.URL+"/semaphore/acquire", map[string]interface{}{"name": "global", "node_id": "node-3", "permits": 2}); status != http.StatusForbidden || resp["error"] == nil {
+		t.Errorf("超过容量应返回 403: status=%d, resp=%v", status, resp)
+	}
+	if status, _ = postJSON(t, server.URL+"/semaphore/release", map[string]interface{}{"name": "global", "node_id": "node-1"}); status != http.StatusBadRequest {
+		t.Errorf("缺少 session_id 应返回 400，实际 %d", status)
+	}
+
+	status, resp = postJSON(t, server.URL+"/semaphore/keepalive", map[string]interface{}{"name": "global", "node_id": "node-1", "session_id": holderSession})
+	if status != http.StatusOK || resp["renewed"] != true {
+		t.Errorf("持有者续约应成功: status=%d, resp=%v", status, resp)
+	}
+	status, resp = postJSON(t, server.URL+"/semaphore/release", map[string]interface{}{"name": "global", "node_id": "node-1", "session_id": holderSession})
+	if status != http.StatusOK || resp["released"] != true {
+		t.Fatalf("释放许可失败: status=%d, resp=%v", status, resp)
+	}
+
+	var info SemaphoreInfo
+	if status := getJSON(t, server.URL+"/semaphore?name=global", &info); status != http.StatusOK ||
+		len(info.Holders) != 1 || info.Holders[0].NodeID != "node-2" {
+		t.Fatalf("释放后 node-2 应持有许可: status=%d, info=%+v", status, info)
+	}
+
+	if status, _ = postJSON(t, server.URL+"/semaphore/capacity", map[string]interface{}{"name": "global", "capacity": 4}); status != http.StatusOK {
+		t.Fatalf("修改容量失败: status=%d", status)
+	}
+	var page struct {
+		Semaphores []*SemaphoreInfo `json:"semaphores"`
+		Total      int              `json:"total"`
+	}
+	if status := getJSON(t, server.URL+"/admin/semaphores", &page); status != http.StatusOK || page.Total != 1 || page.Semaphores[0].Available != 3 {
+		t.Errorf("管理接口应列出信号量: status=%d, page=%+v", status, page)
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// 会话（session）
+//
+// 每个加锁请求在第一次到达服务端时分配一个唯一的会话ID，持有者身份以会话为准而不是节点ID：
+// 同一节点上的两个协程拉取同一层时是两个不同的会话，后到的会话排队等待，而不会被当作"同一节点重新请求"。
+// 客户端在后续请求（排队后重新请求、续约、升级/降级、取消等待、解锁）中携带同一个会话ID。
+
+// newSessionID 生成新的会话ID（128位随机数）
+func newSessionID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("生成会话ID失败: " + err.Error())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// ownedBy 判断锁是否由该会话持有
+// 没有携带会话ID的请求（会话之前的客户端或进程内调用）按节点ID判断，此时调用方应同时校验 fencing token
+func (info *LockInfo) ownedBy(sessionID, nodeID string) bool {
+	if sessionID != "" {
+		return info.Request.SessionID == sessionID
+	}
+	return info.Request.NodeID == nodeID
+}
+
+// findSharedHolder 查找共享持有者：携带会话ID时按会话查找，否则按节点ID（以及非0的fencing token）查找
+func findSharedHolder(holders map[string]*LockInfo, sessionID, nodeID string, fencingToken uint64) *LockInfo {
+	if sessionID != "" {
+		return holders[sessionID]
+	}
+	var found *LockInfo
+	for _, holder := range holders {
+		if holder.Request.NodeID != nodeID {
+			continue
+		}
+		if fencingToken != 0 && holder.FencingToken != fencingToken {
+			continue
+		}
+		// 同一节点有多个共享会话时取token最小的，保证结果确定
+		if found == nil || holder.FencingToken < found.FencingToken {
+			found = holder
+		}
+	}
+	return found
+}
+
+// queuedLocked 判断会话是否已经在key的等待队列中（排队的会话重新请求时不重复加入队列）
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func queuedLocked(shard *resourceShard, key string, sessionID string) bool {
+	for _, queued := range shard.queues[key] {
+		if queued.SessionID == sessionID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestSameNodeDifferentSessions 测试同一节点上的两个会话不会同时持有锁
+func TestSameNodeDifferentSessions(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:session1"
+
+	first := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	if acquired, _, _ := lm.TryLock(first); !acquired {
+		t.Fatal("第一个会话应该获得锁")
+	}
+	if first.SessionID == "" {
+		t.Fatal("服务端应该分配会话ID")
+	}
+
+	second := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	if acquired, _, _ := lm.TryLock(second); acquired {
+		t.Fatal("同一节点上的第二个会话不应该获得锁")
+	}
+	if second.SessionID == first.SessionID {
+		t.Fatal("不同的请求应该分配不同的会话ID")
+	}
+
+	// 排队的会话重新请求不会重复加入队列
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", SessionID: second.SessionID})
+	if n := lm.GetQueueLength(OperationTypePull, resourceID); n != 1 {
+		t.Errorf("期望队列长度为1，实际 %d", n)
+	}
+
+	// 同一会话重新请求仍然持有锁
+	recheck := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", SessionID: first.SessionID}
+	if acquired, _, _ := lm.TryLock(recheck); !acquired || recheck.FencingToken != first.FencingToken {
+		t.Errorf("同一会话重新请求应返回原来的锁: acquired=%v, token=%d", acquired, recheck.FencingToken)
+	}
+}
+
+// TestUnlockBySession 测试解锁按会话校验持有者，失败后锁交给同一节点上排队的会话
+func TestUnlockBySession(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:session2"
+
+	first := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	lm.TryLock(first)
+	second := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	lm.TryLock(second)
+
+	// 排队的会话不能释放其他会话持有的锁
+	if lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1",
+		SessionID: second.SessionID, FencingToken: first.FencingToken, Error: "下载失败"}) {
+		t.Fatal("非持有者会话不应释放锁")
+	}
+
+	subscriber := &mockSubscriber{events: make([]OperationEvent, 0)}
+	lm.Subscribe(OperationTypePull, resourceID, subscriber)
+
+	if !lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1",
+		SessionID: first.SessionID, FencingToken: first.FencingToken, Error: "下载失败"}) {
+		t.Fatal("持有者会话应该可以释放锁")
+	}
+	lockInfo := lm.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.SessionID != second.SessionID {
+		t.Fatalf("锁应分配给排队的会话，实际 %+v", lockInfo)
+	}
+
+	subscriber.mu.Lock()
+	defer subscriber.mu.Unlock()
+	events := subscriber.events
+	if len(events) == 0 || events[len(events)-1].SessionID != second.SessionID {
+		t.Errorf("lock_assigned 事件应携带被分配的会话ID: %+v", events)
+	}
+}
+
+// TestLockResponseSessionID 测试 /lock 响应返回会话ID，携带会话ID的 /unlock 按会话校验
+func TestLockResponseSessionID(t *testing.T) {
+	lm := NewLockManager(true)
+	server := newTestServer(t, lm)
+	resourceID := "sha256:session3"
+
+	status, first := postJSON(t, server.URL+"/lock", map[string]interface{}{
+		"type": "pull", "resource_id": resourceID, "node_id": "node-1",
+	})
+	if status != http.StatusOK || first["acquired"] != true || first["session_id"] == "" {
+		t.Fatalf("加锁应返回会话ID: status=%d, resp=%v", status, first)
+	}
+	_, second := postJSON(t, server.URL+"/lock", map[string]interface{}{
+		"type": "pull", "resource_id": resourceID, "node_id": "node-1",
+	})
+	if second["acquired"] != false || second["session_id"] == "" || second["session_id"] == first["session_id"] {
+		t.Fatalf("同一节点的第二个会话应进入等待队列并获得新的会话ID: %v", second)
+	}
+
+	status, resp := postJSON(t, server.URL+"/unlock", map[string]interface{}{
+		"type": "pull", "resource_id": resourceID, "node_id": "node-1",
+		"session_id": second["session_id"], "fencing_token": first["fencing_token"],
+	})
+	if status != http.StatusForbidden || resp["released"] != false {
+		t.Errorf("排队的会话解锁应被拒绝: status=%d, resp=%v", status, resp)
+	}
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"log"
+	"sort"
+)
+
+// 共享/独占模式
+//
+// 同一个 type:resource_id 上：
+//   - 独占（exclusive，默认）：只有一个持有者，其他请求排队或合并等待完成事件
+//   - 共享（shared）：多个共享持有者可以同时持有，与独占持有者互斥
+//
+// 不同操作类型之间仍由兼容矩阵决定是否互斥，与模式无关（共享持有者同样会阻塞冲突的操作类型）。
+// 队列按 FIFO 分配：队头是独占请求时新来的共享请求也要排队，避免独占请求被源源不断的共享请求饿死。
+
+// tryLockSharedLocked 共享模式加锁
+// 注意：调用此函数时，资源锁和 shard.mu 都必须已经加锁
+// 返回：是否获得锁，是否跳过操作，错误信息（与 TryLock 相同）
+func (lm *LockManager) tryLockSharedLocked(shard *resourceShard, key string, request *LockRequest) (bool, bool, string) {
+	// 同一会话重新请求：刷新租约
+	if holder, exists := shard.shared[key][request.SessionID]; exists {
+		log.Printf("[TryLock] 同一会话重新请求共享锁: key=%s, node=%s, session=%s", key, request.NodeID, request.SessionID)
+		request.FencingToken = holder.FencingToken
+		holder.LeaseExpiresAt = lm.leaseDeadline(lm.now())
+		return true, false, ""
+	}
+	if lockInfo, exists := shard.locks[key]; exists && lockInfo.Request.SessionID == request.SessionID {
+		return false, false, "已持有独占锁，请通过 /lock/downgrade 降级为共享锁"
+	}
+	if queuedLocked(shard, key, request.SessionID) {
+		return false, false, ""
+	}
+
+	// 已有共享持有者且队列中有等待者（独占请求或等待中的升级）时不插队
+	lm.pruneQueueLocked(shard, key, request.Timestamp)
+	blocking := lm.blockingLockLocked(shard, request)
+	if blocking == nil && len(shard.shared[key]) > 0 && len(shard.queues[key]) > 0 {
+		blocking = firstSharedHolder(shard.shared[key])
+	}
+	if blocking != nil {
+		if !lm.AllowMultiNodeDownload {
+			log.Printf("[TryLock] 多节点下载已关闭，共享锁请求被阻塞: key=%s, node=%s, 阻塞操作=%s, 持有者=%s",
+				key, request.NodeID, blocking.Request.Type, blocking.Request.NodeID)
+			return false, false, "多节点下载模式已关闭，资源正在被冲突的操作占用"
+		}
+		log.Printf("[TryLock] 共享锁请求加入等待队列: key=%s, node=%s, 阻塞操作=%s, 持有者=%s",
+			key, request.NodeID, blocking.Request.Type, blocking.Request.NodeID)
+		lm.addToQueue(shard, key, request)
+		return false, false, ""
+	}
+
+	log.Printf("[TryLock] 获取共享锁成功: key=%s, node=%s, 共享持有者数量=%d",
+		key, request.NodeID, len(shard.shared[key])+1)
+	now := lm.now()
+	request.FencingToken = lm.nextFencingToken(shard, key)
+	lm.addSharedHolderLocked(shard, key, &LockInfo{
+		Request:        request,
+		AcquiredAt:     now,
+		FencingToken:   request.FencingToken,
+		LeaseExpiresAt: lm.leaseDeadline(now),
+	})
+	lm.metrics.granted(request, false, now)
+	lm.appendWAL(&WALRecord{
+		Op:           WALOpGrant,
+		Type:         request.Type,
+		ResourceID:   request.ResourceID,
+		Request:      request,
+		FencingToken: request.FencingToken,
+		AcquiredAt:   now,
+	})
+	return true, false, ""
+}
+
+// unlockSharedLocked 共享持有者释放锁
+// 共享持有者之间没有"合并等待"的关系，释放时不广播完成事件；最后一个共享持有者释放后把锁分配给队头
+// 注意：调用此函数时，资源锁和 shard.mu 都必须已经加锁
+func (lm *LockManager) unlockSharedLocked(shard *resourceShard, key string, request *UnlockRequest) bool {
+	holder := findSharedHolder(shard.shared[key], request.SessionID, request.NodeID, request.FencingToken)
+	if holder == nil {
+		return false
+	}
+	if request.FencingToken != holder.FencingToken {
+		log.Printf("[Unlock] fencing token 已过期，拒绝释放共享锁: key=%s, node=%s, token=%d, 当前token=%d",
+			key, request.NodeID, request.FencingToken, holder.FencingToken)
+		return false
+	}
+
+	log.Printf("[Unlock] 释放共享锁: key=%s, node=%s, 剩余共享持有者=%d", key, request.NodeID, len(shard.shared[key])-1)
+	lm.removeSharedHolderLocked(shard, key, holder.Request.SessionID, request.Error == "")
+	lm.afterSharedReleaseLocked(shard, key)
+	return true
+}
+
+// afterSharedReleaseLocked 共享持有者减少后的处理
+//   - 有等待中的升级且只剩升级者本人：完成升级并通知
+//   - 否则把锁分配给队头（仍有共享持有者时只有共享请求能获得锁，例如升级者放弃升级后被阻塞的共享请求），
+//     并唤醒因类型冲突而等待的其他操作类型
+//
+// 注意：调用此函数时，资源锁和 shard.mu 都必须已经加锁
+func (lm *LockManager) afterSharedReleaseLocked(shard *resourceShard, key string) {
+	if sessionID, pending := shard.upgrades[key]; pending {
+		if _, holds := shard.shared[key][sessionID]; holds && len(shard.shared[key]) == 1 {
+			lm.completeUpgradeLocked(shard, key, sessionID)
+			lm.notifyLockAssigned(shard, key, sessionID)
+		}
+		return
+	}
+
+	for _, sessionID := range lm.processQueue(shard, key) {
+		lm.notifyLockAssigned(shard, key, sessionID)
+	}
+	if _, resourceID, ok := splitLockKey(key); ok {
+		lm.dispatchBlockedLocked(shard, resourceID, "")
+	}
+}
+
+// Upgrade 把共享锁升级为独占锁
+// 只剩调用者一个共享持有者时立即升级（返回新的fencing token）；否则登记为等待升级，
+// 新的共享请求排队，其他共享持有者全部释放后完成升级并通过 lock_assigned 事件（mode=exclusive）通知。
+// 等待期间重复调用是幂等的；升级完成后再调用返回当前的独占token。
+// 返回：是否已升级，升级后的fencing token，错误信息（为空且未升级表示正在等待）
+func (lm *LockManager) Upgrade(request *ModeChangeRequest) (bool, uint64, string) {
+	key := LockKey(request.Type, request.ResourceID)
+	shard := lm.getShard(request.ResourceID)
+
+	shard.mu.Lock()
+	resourceLock, exists := shard.resourceLocks[key]
+	shard.mu.Unlock()
+	if !exists {
+		return false, 0, "不是共享锁的持有者"
+	}
+
+	resourceLock.Lock()
+	defer resourceLock.Unlock()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	holder := findSharedHolder(shard.shared[key], request.SessionID, request.NodeID, request.FencingToken)
+	if holder == nil {
+		// 升级已经完成（客户端轮询等待升级时会重复调用）
+		if lockInfo, held := shard.locks[key]; held && lockInfo.ownedBy(request.SessionID, request.NodeID) && !lockInfo.Completed {
+			return true, lockInfo.FencingToken, ""
+		}
+		return false, 0, "不是共享锁的持有者"
+	}
+	if request.FencingToken != 0 && request.FencingToken != holder.FencingToken {
+		return false, 0, "fencing token 已过期"
+	}
+
+	sessionID := holder.Request.SessionID
+	if pendingSession, pending := shard.upgrades[key]; pending && pendingSession != sessionID {
+		// 两个共享持有者同时等待升级会互相等待对方释放（死锁）
+		return false, 0, "已有其他共享持有者在等待升级，请先释放共享锁后重新请求独占锁"
+	}
+
+	if len(shard.shared[key]) == 1 {
+		fencingToken := lm.completeUpgradeLocked(shard, key, sessionID)
+		return true, fencingToken, ""
+	}
+
+	if _, pending := shard.upgrades[key]; !pending {
+		log.Printf("[Upgrade] 等待其他共享持有者释放: key=%s, node=%s, 共享持有者数量=%d",
+			key, request.NodeID, len(shard.shared[key]))
+		shard.upgrades[key] = sessionID
+		lm.appendWAL(&WALRecord{Op: WALOpUpgradeWait, Type: request.Type, ResourceID: request.ResourceID,
+			NodeID: request.NodeID, SessionID: sessionID})
+	}
+	return false, 0, ""
+}
+
+// completeUpgradeLocked 把唯一的共享持有者转为独占持有者（新的fencing token）
+// 注意：调用此函数时，资源锁和 shard.mu 都必须已经加锁
+// 返回：新的fencing token
+func (lm *LockManager) completeUpgradeLocked(shard *resourceShard, key string, sessionID string) uint64 {
+	holder := shard.shared[key][sessionID]
+	delete(shard.shared, key)
+	delete(shard.upgrades, key)
+
+	now := lm.now()
+	request := *holder.Request
+	request.Mode = LockModeExclusive
+	request.FencingToken = lm.nextFencingToken(shard, key)
+	shard.locks[key] = &LockInfo{
+		Request:        &request,
+		AcquiredAt:     now,
+		FencingToken:   request.FencingToken,
+		LeaseExpiresAt: lm.leaseDeadline(now),
+	}
+	lm.appendWAL(&WALRecord{
+		Op:           WALOpUpgrade,
+		Type:         request.Type,
+		ResourceID:   request.ResourceID,
+		NodeID:       request.NodeID,
+		SessionID:    sessionID,
+		FencingToken: request.FencingToken,
+		AcquiredAt:   now,
+	})
+
+	log.Printf("[Upgrade] 共享锁已升级为独占锁: key=%s, node=%s, fencing_token=%d", key, request.NodeID, request.FencingToken)
+	return request.FencingToken
+}
+
+// Downgrade 把独占锁降级为共享锁（新的fencing token）
+// 降级后队头连续的共享请求一起获得锁
+// 返回：是否降级成功，降级后的fencing token，错误信息
+func (lm *LockManager) Downgrade(request *ModeChangeRequest) (bool, uint64, string) {
+	key := LockKey(request.Type, request.ResourceID)
+	shard := lm.getShard(request.ResourceID)
+
+	shard.mu.Lock()
+	resourceLock, exists := shard.resourceLocks[key]
+	shard.mu.Unlock()
+	if !exists {
+		return false, 0, "不是独占锁的持有者"
+	}
+
+	resourceLock.Lock()
+	defer resourceLock.Unlock()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	lockInfo, exists := shard.locks[key]
+	if !exists || lockInfo.Completed || !lockInfo.ownedBy(request.SessionID, request.NodeID) {
+		// 降级已经完成（客户端重试）
+		if holder := findSharedHolder(shard.shared[key], request.SessionID, request.NodeID, request.FencingToken); holder != nil {
+			return true, holder.FencingToken, ""
+		}
+		return false, 0, "不是独占锁的持有者"
+	}
+	if request.FencingToken != 0 && request.FencingToken != lockInfo.FencingToken {
+		return false, 0, "fencing token 已过期"
+	}
+
+	delete(shard.locks, key)
+	now := lm.now()
+	sharedRequest := *lockInfo.Request
+	sharedRequest.Mode = LockModeShared
+	sharedRequest.FencingToken = lm.nextFencingToken(shard, key)
+	lm.addSharedHolderLocked(shard, key, &LockInfo{
+		Request:        &sharedRequest,
+		AcquiredAt:     now,
+		FencingToken:   sharedRequest.FencingToken,
+		LeaseExpiresAt: lm.leaseDeadline(now),
+	})
+	lm.appendWAL(&WALRecord{
+		Op:           WALOpDowngrade,
+		Type:         request.Type,
+		ResourceID:   request.ResourceID,
+		NodeID:       request.NodeID,
+		SessionID:    sharedRequest.SessionID,
+		FencingToken: sharedRequest.FencingToken,
+		AcquiredAt:   now,
+	})
+	log.Printf("[Downgrade] 独占锁已降级为共享锁: key=%s, node=%s, fencing_token=%d", key, request.NodeID, sharedRequest.FencingToken)
+
+	// 队头的共享请求可以一起持有
+	for _, sessionID := range lm.processQueue(shard, key) {
+		lm.notifyLockAssigned(shard, key, sessionID)
+	}
+	return true, sharedRequest.FencingToken, ""
+}
+
+// GetSharedHolders 获取共享持有者（按节点ID、fencing token排序，用于监控和测试）
+func (lm *LockManager) GetSharedHolders(lockType, resourceID string) []*LockInfo {
+	key := LockKey(lockType, resourceID)
+	shard := lm.getShard(resourceID)
+
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	holders := make([]*LockInfo, 0, len(shard.shared[key]))
+	for _, holder := range shard.shared[key] {
+		holders = append(holders, holder)
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		if holders[i].Request.NodeID != holders[j].Request.NodeID {
+			return holders[i].Request.NodeID < holders[j].Request.NodeID
+		}
+		return holders[i].FencingToken < holders[j].FencingToken
+	})
+	return holders
+}
+
+// addSharedHolderLocked 加入共享持有者集合
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) addSharedHolderLocked(shard *resourceShard, key string, lockInfo *LockInfo) {
+	if _, exists := shard.shared[key]; !exists {
+		shard.shared[key] = make(map[string]*LockInfo)
+	}
+	shard.shared[key][lockInfo.Request.SessionID] = lockInfo
+}
+
+// removeSharedHolderLocked 从共享持有者集合中移除（同时取消该会话等待中的升级），success 为持有者的操作结果
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) removeSharedHolderLocked(shard *resourceShard, key string, sessionID string, success bool) {
+	holder, exists := shard.shared[key][sessionID]
+	if !exists {
+		return
+	}
+	lm.metrics.released(holder, success, lm.now())
+	delete(shard.shared[key], sessionID)
+	if len(shard.shared[key]) == 0 {
+		delete(shard.shared, key)
+	}
+	if shard.upgrades[key] == sessionID {
+		delete(shard.upgrades, key)
+	}
+	lm.appendWAL(&WALRecord{
+		Op:         WALOpRelease,
+		Type:       holder.Request.Type,
+		ResourceID: holder.Request.ResourceID,
+		Mode:       LockModeShared,
+		NodeID:     holder.Request.NodeID,
+		SessionID:  sessionID,
+	})
+}
+
+// firstSharedHolder 返回任意一个共享持有者（fencing token最小，保证结果确定），没有时返回nil
+func firstSharedHolder(holders map[string]*LockInfo) *LockInfo {
+	var first *LockInfo
+	for _, holder := range holders {
+		if first == nil || holder.FencingToken < first.FencingToken {
+			first = holder
+		}
+	}
+	return first
+}
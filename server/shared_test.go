@@ -0,0 +1,273 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestSharedHoldersAndExclusiveWaiter 测试多个共享持有者并发持有，独占请求等待全部释放
+func TestSharedHoldersAndExclusiveWaiter(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:shared1"
+
+	sub := &mockSubscriber{events: make([]OperationEvent, 0)}
+	lm.Subscribe(OperationTypePull, resourceID, sub)
+
+	reader1 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", Mode: LockModeShared}
+	reader2 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2", Mode: LockModeShared}
+	for _, req := range []*LockRequest{reader1, reader2} {
+		if acquired, _, errMsg := lm.TryLock(req); !acquired || errMsg != "" {
+			t.Fatalf("%s 应该获得共享锁: error=%s", req.NodeID, errMsg)
+		}
+	}
+	if reader1.FencingToken == reader2.FencingToken {
+		t.Error("每个共享持有者应该有自己的fencing token")
+	}
+	if n := len(lm.GetSharedHolders(OperationTypePull, resourceID)); n != 2 {
+		t.Fatalf("期望2个共享持有者，实际 %d", n)
+	}
+
+	// 独占请求排队；排在独占请求之后的共享请求也排队（不插队）
+	writer := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-3"}
+	if acquired, _, _ := lm.TryLock(writer); acquired {
+		t.Fatal("有共享持有者时独占请求不应该获得锁")
+	}
+	if acquired, _, _ := lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-4", Mode: LockModeShared}); acquired {
+		t.Fatal("队列中有独占请求时新的共享请求应该排队")
+	}
+
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", FencingToken: reader1.FencingToken})
+	if lm.GetLockInfo(OperationTypePull, resourceID) != nil {
+		t.Fatal("仍有共享持有者，独占请求不应该获得锁")
+	}
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2", FencingToken: reader2.FencingToken})
+	lockInfo := lm.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-3" {
+		t.Fatalf("共享持有者全部释放后应分配给 node-3，实际 %+v", lockInfo)
+	}
+
+	// 独占持有者完成后，队头的共享请求获得锁
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-3", FencingToken: lockInfo.FencingToken})
+	holders := lm.GetSharedHolders(OperationTypePull, resourceID)
+	if len(holders) != 1 || holders[0].Request.NodeID != "node-4" {
+		t.Fatalf("期望 node-4 获得共享锁，实际 %d 个持有者", len(holders))
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	var assigned []OperationEvent
+	for _, event := range sub.events {
+		if event.Event == EventTypeLockAssigned {
+			assigned = append(assigned, event)
+		}
+	}
+	if len(assigned) != 2 || assigned[0].NodeID != "node-3" || assigned[0].Mode != LockModeExclusive ||
+		assigned[1].NodeID != "node-4" || assigned[1].Mode != LockModeShared {
+		t.Errorf("lock_assigned 事件不正确: %+v", assigned)
+	}
+}
+
+// TestUpgradeWaitsForOtherSharedHolders 测试升级等待其他共享持有者释放，期间新的共享请求排队
+func TestUpgradeWaitsForOtherSharedHolders(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:shared2"
+
+	sub := &mockSubscriber{events: make([]OperationEvent, 0)}
+	lm.Subscribe(OperationTypeUpdate, resourceID, sub)
+
+	holder1 := &LockRequest{Type: OperationTypeUpdate, ResourceID: resourceID, NodeID: "node-1", Mode: LockModeShared}
+	holder2 := &LockRequest{Type: OperationTypeUpdate, ResourceID: resourceID, NodeID: "node-2", Mode: LockModeShared}
+	lm.TryLock(holder1)
+	lm.TryLock(holder2)
+
+	upgrade := &ModeChangeRequest{Type: OperationTypeUpdate, ResourceID: resourceID, NodeID: "node-1", FencingToken: holder1.FencingToken}
+	if upgraded, _, errMsg := lm.Upgrade(upgrade); upgraded || errMsg != "" {
+		t.Fatalf("还有其他共享持有者，升级应该等待: upgraded=%v, error=%s", upgraded, errMsg)
+	}
+	// 等待期间重复调用是幂等的，另一个持有者同时升级会被拒绝（否则互相等待）
+	if upgraded, _, errMsg := lm.Upgrade(upgrade); upgraded || errMsg != "" {
+		t.Fatalf("重复升级应该继续等待: upgraded=%v, error=%s", upgraded, errMsg)
+	}
+	if _, _, errMsg := lm.Upgrade(&ModeChangeRequest{Type: OperationTypeUpdate, ResourceID: resourceID, NodeID: "node-2"}); errMsg == "" {
+		t.Error("已有等待中的升级，第二个升级应该被拒绝")
+	}
+	if acquired, _, _ := lm.TryLock(&LockRequest{Type: OperationTypeUpdate, ResourceID: resourceID, NodeID: "node-3", Mode: LockModeShared}); acquired {
+		t.Fatal("等待升级期间新的共享请求应该排队")
+	}
+
+	// node-2 释放后升级完成
+	lm.Unlock(&UnlockRequest{Type: OperationTypeUpdate, ResourceID: resourceID, NodeID: "node-2", FencingToken: holder2.FencingToken})
+	lockInfo := lm.GetLockInfo(OperationTypeUpdate, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-1" || lockInfo.Request.Mode != LockModeExclusive {
+		t.Fatalf("node-1 应该升级为独占持有者，实际 %+v", lockInfo)
+	}
+	if lockInfo.FencingToken <= holder2.FencingToken {
+		t.Errorf("升级后的token应大于之前的token，实际 %d", lockInfo.FencingToken)
+	}
+	// 轮询的客户端再次调用时返回当前的独占token
+	if upgraded, token, _ := lm.Upgrade(upgrade); !upgraded || token != lockInfo.FencingToken {
+		t.Errorf("升级完成后再次调用应返回 upgraded=true, token=%d，实际 upgraded=%v, token=%d", lockInfo.FencingToken, upgraded, token)
+	}
+	// 旧的共享token已失效
+	if lm.Unlock(&UnlockRequest{Type: OperationTypeUpdate, ResourceID: resourceID, NodeID: "node-1", FencingToken: holder1.FencingToken}) {
+		t.Error("升级后旧的共享token不应该能解锁")
+	}
+
+	sub.mu.Lock()
+	if n := len(sub.events); n != 1 || sub.events[0].Event != EventTypeLockAssigned || sub.events[0].Mode != LockModeExclusive {
+		t.Errorf("升级完成应发送 mode=exclusive 的 lock_assigned 事件，实际 %+v", sub.events)
+	}
+	sub.mu.Unlock()
+
+	// 降级后队头的共享请求一起持有
+	downgraded, token, errMsg := lm.Downgrade(&ModeChangeRequest{Type: OperationTypeUpdate, ResourceID: resourceID, NodeID: "node-1", FencingToken: lockInfo.FencingToken})
+	if !downgraded || errMsg != "" || token <= lockInfo.FencingToken {
+		t.Fatalf("降级失败: downgraded=%v, token=%d, error=%s", downgraded, token, errMsg)
+	}
+	if holders := lm.GetSharedHolders(OperationTypeUpdate, resourceID); len(holders) != 2 {
+		t.Errorf("降级后 node-1 和 node-3 应该共同持有共享锁，实际 %d 个持有者", len(holders))
+	}
+	if lm.GetLockInfo(OperationTypeUpdate, resourceID) != nil {
+		t.Error("降级后不应再有独占持有者")
+	}
+}
+
+// TestSharedModeRespectsCompatibilityMatrix 测试共享持有者同样阻塞冲突的操作类型
+func TestSharedModeRespectsCompatibilityMatrix(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:shared3"
+
+	reader := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", Mode: LockModeShared}
+	lm.TryLock(reader)
+
+	deleteReq := &LockRequest{Type: OperationTypeDelete, ResourceID: resourceID, NodeID: "node-2"}
+	if acquired, _, _ := lm.TryLock(deleteReq); acquired {
+		t.Fatal("pull 共享持有中，delete 不应该获得锁")
+	}
+	if acquired, _, _ := lm.TryLock(&LockRequest{Type: OperationTypeUpdate, ResourceID: resourceID, NodeID: "node-3", Mode: LockModeShared}); !acquired {
+		t.Error("update 与 pull 兼容，应该直接获得共享锁")
+	}
+
+	// 独占持有者请求同一个key的独占锁需要通过升级
+	if _, _, errMsg := lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", SessionID: reader.SessionID}); errMsg == "" {
+		t.Error("已持有共享锁时请求独占锁应该返回错误")
+	}
+
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", FencingToken: reader.FencingToken})
+	if lm.GetLockInfo(OperationTypeDelete, resourceID) != nil {
+		t.Fatal("update 仍在进行，delete 不应该获得锁")
+	}
+	lm.Unlock(&UnlockRequest{Type: OperationTypeUpdate, ResourceID: resourceID, NodeID: "node-3",
+		FencingToken: lm.GetSharedHolders(OperationTypeUpdate, resourceID)[0].FencingToken})
+	if lockInfo := lm.GetLockInfo(OperationTypeDelete, resourceID); lockInfo == nil || lockInfo.Request.NodeID != "node-2" {
+		t.Errorf("共享持有者全部释放后 delete 应分配给 node-2，实际 %+v", lockInfo)
+	}
+}
+
+// TestSharedLeaseExpiry 测试共享持有者租约过期只回收该持有者，升级等待者随之完成升级
+func TestSharedLeaseExpiry(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:shared4"
+
+	holder1 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", Mode: LockModeShared}
+	holder2 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2", Mode: LockModeShared}
+	lm.TryLock(holder1)
+	lm.TryLock(holder2)
+	lm.Upgrade(&ModeChangeRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"})
+
+	// node-1 续约，node-2 不续约
+	for _, holder := range lm.GetSharedHolders(OperationTypePull, resourceID) {
+		if holder.Request.NodeID == "node-2" {
+			holder.LeaseExpiresAt = holder.AcquiredAt
+		}
+	}
+	if reaped := lm.reapExpiredLeases(holder2.Timestamp.Add(lm.LeaseTTL / 2)); reaped != 1 {
+		t.Fatalf("期望回收1个共享持有者，实际 %d", reaped)
+	}
+	lockInfo := lm.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-1" {
+		t.Fatalf("node-2 被回收后 node-1 应完成升级，实际 %+v", lockInfo)
+	}
+}
+
+// TestRecoverSharedHoldersFromWAL 测试共享持有者和升级状态可以从WAL恢复
+func TestRecoverSharedHoldersFromWAL(t *testing.T) {
+	dir := t.TempDir()
+	resourceID := "sha256:shared5"
+
+	lm, store := openTestLockManager(t, dir)
+	holder1 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", Mode: LockModeShared}
+	holder2 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2", Mode: LockModeShared}
+	holder3 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-3", Mode: LockModeShared}
+	lm.TryLock(holder1)
+	lm.TryLock(holder2)
+	lm.TryLock(holder3)
+	lm.Upgrade(&ModeChangeRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"})
+	if err := lm.Snapshot(); err != nil {
+		t.Fatalf("快照失败: %v", err)
+	}
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-3", FencingToken: holder3.FencingToken})
+	store.Close()
+
+	lm, store = openTestLockManager(t, dir)
+	defer store.Close()
+	if holders := lm.GetSharedHolders(OperationTypePull, resourceID); len(holders) != 2 {
+		t.Fatalf("恢复后应有2个共享持有者，实际 %d", len(holders))
+	}
+
+	// 恢复出的升级等待：node-2 释放后 node-1 完成升级
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2", FencingToken: holder2.FencingToken})
+	lockInfo := lm.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-1" || lockInfo.FencingToken <= holder3.FencingToken {
+		t.Fatalf("恢复后 node-1 应完成升级并获得更大的token，实际 %+v", lockInfo)
+	}
+}
+
+// TestLockModeHTTP 测试 /lock 的 mode 参数和 /lock/upgrade、/lock/downgrade 接口
+func TestLockModeHTTP(t *testing.T) {
+	lm := NewLockManager(true)
+	server := newTestServer(t, lm)
+	resourceID := "sha256:shared6"
+
+	status, _ := postJSON(t, server.URL+"/lock", map[string]interface{}{
+		"type": "pull", "resource_id": resourceID, "node_id": "node-1", "mode": "readonly",
+	})
+	if status != http.StatusBadRequest {
+		t.Errorf("无效的 mode 应返回 400，实际 %d", status)
+	}
+
+	tokens := make(map[string]interface{})
+	for _, nodeID := range []string{"node-1", "node-2"} {
+		status, body := postJSON(t, server.URL+"/lock", map[string]interface{}{
+			"type": "pull", "resource_id": resourceID, "node_id": nodeID, "mode": "shared",
+		})
+		if status != http.StatusOK || body["acquired"] != true || body["mode"] != LockModeShared {
+			t.Fatalf("%s 加共享锁失败: status=%d, resp=%v", nodeID, status, body)
+		}
+		tokens[nodeID] = body["fencing_token"]
+	}
+
+	status, body := postJSON(t, server.URL+"/lock/upgrade", map[string]interface{}{
+		"type": "pull", "resource_id": resourceID, "node_id": "node-1", "fencing_token": tokens["node-1"],
+	})
+	if status != http.StatusOK || body["upgraded"] != false || body["pending"] != true {
+		t.Fatalf("升级应该等待: status=%d, resp=%v", status, body)
+	}
+
+	postJSON(t, server.URL+"/unlock", map[string]interface{}{
+		"type": "pull", "resource_id": resourceID, "node_id": "node-2", "fencing_token": tokens["node-2"],
+	})
+	status, body = postJSON(t, server.URL+"/lock/upgrade", map[string]interface{}{
+		"type": "pull", "resource_id": resourceID, "node_id": "node-1", "fencing_token": tokens["node-1"],
+	})
+	if status != http.StatusOK || body["upgraded"] != true || body["mode"] != LockModeExclusive {
+		t.Fatalf("升级应该已完成: status=%d, resp=%v", status, body)
+	}
+
+	status, body = postJSON(t, server.URL+"/lock/downgrade", map[string]interface{}{
+		"type": "pull", "resource_id": resourceID, "node_id": "node-2", "fencing_token": body["fencing_token"],
+	})
+	if status != http.StatusForbidden || body["downgraded"] != false {
+		t.Errorf("非持有者降级应返回 403，实际 status=%d, resp=%v", status, body)
+	}
+}
@@ -0,0 +1,461 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// LockManagerOption NewLockManager 的可选配置
+type LockManagerOption func(*LockManager)
+
+// WithStateStore 启用持久化：NewLockManager 会先从快照和WAL重建分段状态，
+// 之后所有锁状态变更都追加写入WAL
+func WithStateStore(store *StateStore) LockManagerOption {
+	return func(lm *LockManager) {
+		lm.store = store
+	}
+}
+
+// Snapshot 生成一次快照并删除已被快照覆盖的WAL分段
+// 未启用持久化时直接返回
+func (lm *LockManager) Snapshot() error {
+	if lm.store == nil {
+		return nil
+	}
+
+	// 持有所有分段锁，保证快照与WAL分段切换点一致
+	lm.lockAllShards()
+	snapshot, err := lm.beginSnapshotLocked()
+	lm.unlockAllShards()
+	if err != nil {
+		return err
+	}
+	return lm.finishSnapshot(snapshot)
+}
+
+// beginSnapshotLocked 复制当前锁状态并切换到新的WAL分段，返回的快照在释放分段锁后由 finishSnapshot 写入
+// 注意：调用此函数时，所有分段的 shard.mu 都必须已经加锁，且已启用持久化
+func (lm *LockManager) beginSnapshotLocked() (*lockSnapshot, error) {
+	snapshot := lm.captureSnapshotLocked()
+	snapshot.failures = lm.store.failureCount()
+	lastSeq, err := lm.store.rotate()
+	if err != nil {
+		return nil, err
+	}
+	snapshot.LastSeq = lastSeq
+	return snapshot, nil
+}
+
+// finishSnapshot 写入 beginSnapshotLocked 生成的快照并删除已被覆盖的WAL分段
+func (lm *LockManager) finishSnapshot(snapshot *lockSnapshot) error {
+	if err := lm.store.writeSnapshot(snapshot); err != nil {
+		return err
+	}
+	lm.store.repaired(snapshot.failures)
+	log.Printf("[Snapshot] 快照完成: last_seq=%d, 锁数量=%d, 队列数量=%d",
+		snapshot.LastSeq, len(snapshot.Locks), len(snapshot.Queues))
+	return nil
+}
+
+// StartSnapshotter 启动后台快照协程，每隔 interval 生成一次快照
+// 返回 stop 函数，用于停止快照协程
+func (lm *LockManager) StartSnapshotter(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := lm.Snapshot(); err != nil {
+					log.Printf("[Snapshot] 快照失败: %v", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// captureSnapshotLocked 复制所有分段的锁状态
+// 注意：调用此函数时，所有分段的 shard.mu 都必须已经加锁
+func (lm *LockManager) captureSnapshotLocked() *lockSnapshot {
+	snapshot := &lockSnapshot{
+		Locks:         make(map[string]*LockInfo),
+		Queues:        make(map[string][]*LockRequest),
+		FencingTokens: make(map[string]uint64),
+		Shared:        make(map[string][]*LockInfo),
+		Upgrades:      make(map[string]string),
+		Completions:   make(map[string]*CompletionRecord),
+		Semaphores:    make(map[string]*Semaphore),
+		CreatedAt:     lm.now(),
+	}
+	for _, shard := range lm.shards {
+		// 复制请求本身：快照在释放分段锁之后才序列化，而排队中的请求在分配锁时会被修改
+		for key, lockInfo := range shard.locks {
+			infoCopy := *lockInfo
+			requestCopy := *lockInfo.Request
+			infoCopy.Request = &requestCopy
+			snapshot.Locks[key] = &infoCopy
+		}
+		for key, queue := range shard.queues {
+			queueCopy := make([]*LockRequest, len(queue))
+			for i, request := range queue {
+				requestCopy := *request
+				queueCopy[i] = &requestCopy
+			}
+			snapshot.Queues[key] = queueCopy
+		}
+		for key, token := range shard.fencingTokens {
+			snapshot.FencingTokens[key] = token
+		}
+		for key, holders := range shard.shared {
+			for _, holder := range holders {
+				infoCopy := *holder
+				requestCopy := *holder.Request
+				infoCopy.Request = &requestCopy
+				snapshot.Shared[key] = append(snapshot.Shared[key], &infoCopy)
+			}
+		}
+		for key, sessionID := range shard.upgrades {
+			snapshot.Upgrades[key] = sessionID
+		}
+		for key, record := range shard.completions {
+			recordCopy := *record
+			snapshot.Completions[key] = &recordCopy
+		}
+		for name, sem := range shard.semaphores {
+			snapshot.Semaphores[name] = sem.copy()
+		}
+	}
+	return snapshot
+}
+
+// exportState 复制当前全部锁状态（复制模式下用于压缩Raft日志和发送快照给落后的follower）
+func (lm *LockManager) exportState() *lockSnapshot {
+	lm.lockAllShards()
+	defer lm.unlockAllShards()
+	return lm.captureSnapshotLocked()
+}
+
+// importState 丢弃当前锁状态并替换为快照中的状态（订阅者保留）
+// 复制模式下 follower 收到 leader 发送的快照时调用
+func (lm *LockManager) importState(snapshot *lockSnapshot) {
+	lm.lockAllShards()
+	for _, shard := range lm.shards {
+		shard.resourceLocks = make(map[string]*sync.Mutex)
+		shard.locks = make(map[string]*LockInfo)
+		shard.queues = make(map[string][]*LockRequest)
+		shard.fencingTokens = make(map[string]uint64)
+		shard.shared = make(map[string]map[string]*LockInfo)
+		shard.upgrades = make(map[string]string)
+		shard.aborted = make(map[string]map[string]time.Time)
+		shard.completions = make(map[string]*CompletionRecord)
+		shard.outcomes = make(map[string]*lockOutcome)
+		shard.semaphores = make(map[string]*Semaphore)
+	}
+	lm.loadSnapshotLocked(snapshot)
+	lm.unlockAllShards()
+
+	lm.resetLeases(lm.now())
+}
+
+// restoreState 从快照和WAL记录重建分段状态（NewLockManager 启动时调用）
+// 恢复出的锁会重新计算租约，给持有者在服务端重启后续约的机会
+func (lm *LockManager) restoreState(snapshot *lockSnapshot, records []*WALRecord) {
+	if snapshot != nil {
+		lm.lockAllShards()
+		lm.loadSnapshotLocked(snapshot)
+		lm.unlockAllShards()
+	}
+
+	for _, record := range records {
+		lm.applyWALRecord(record)
+	}
+
+	restoredLocks := lm.resetLeases(lm.now())
+	restoredWaiters := 0
+	for _, shard := range lm.shards {
+		shard.mu.RLock()
+		for _, queue := range shard.queues {
+			restoredWaiters += len(queue)
+		}
+		shard.mu.RUnlock()
+	}
+	log.Printf("[Recovery] 锁状态恢复完成: 持有中的锁=%d, 等待中的请求=%d, 重放WAL记录=%d",
+		restoredLocks, restoredWaiters, len(records))
+}
+
+// resetLeases 把所有持有中的锁的租约重新计算为从 now 开始
+// 用于重启恢复和复制模式下的leader切换：给持有者重新连接并续约的机会
+// 返回：持有中的锁数量
+func (lm *LockManager) resetLeases(now time.Time) int {
+	count := 0
+	for _, shard := range lm.shards {
+		shard.mu.Lock()
+		for _, lockInfo := range shard.locks {
+			lockInfo.LeaseExpiresAt = lm.leaseDeadline(now)
+			count++
+		}
+		for _, holders := range shard.shared {
+			for _, holder := range holders {
+				holder.LeaseExpiresAt = lm.leaseDeadline(now)
+				count++
+			}
+		}
+		for _, sem := range shard.semaphores {
+			for _, holder := range sem.Holders {
+				holder.LeaseExpiresAt = lm.leaseDeadline(now)
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return count
+}
+
+// loadSnapshotLocked 把快照中的锁、共享持有者、等待队列和fencing token计数器写入分段
+// 注意：调用此函数时，所有分段的 shard.mu 都必须已经加锁
+func (lm *LockManager) loadSnapshotLocked(snapshot *lockSnapshot) {
+	for key, lockInfo := range snapshot.Locks {
+		shard := lm.getShard(lockInfo.Request.ResourceID)
+		shard.locks[key] = lockInfo
+		shard.resourceLocks[key] = &sync.Mutex{}
+	}
+	for key, queue := range snapshot.Queues {
+		if len(queue) == 0 {
+			continue
+		}
+		shard := lm.getShard(queue[0].ResourceID)
+		shard.queues[key] = queue
+		if _, exists := shard.resourceLocks[key]; !exists {
+			shard.resourceLocks[key] = &sync.Mutex{}
+		}
+	}
+	for key, token := range snapshot.FencingTokens {
+		_, resourceID, ok := splitLockKey(key)
+		if !ok {
+			continue
+		}
+		shard := lm.getShard(resourceID)
+		shard.fencingTokens[key] = token
+	}
+	for key, holders := range snapshot.Shared {
+		for _, holder := range holders {
+			shard := lm.getShard(holder.Request.ResourceID)
+			lm.addSharedHolderLocked(shard, key, holder)
+			if _, exists := shard.resourceLocks[key]; !exists {
+				shard.resourceLocks[key] = &sync.Mutex{}
+			}
+		}
+	}
+	for key, sessionID := range snapshot.Upgrades {
+		_, resourceID, ok := splitLockKey(key)
+		if !ok {
+			continue
+		}
+		lm.getShard(resourceID).upgrades[key] = sessionID
+	}
+	for key, record := range snapshot.Completions {
+		lm.getShard(record.ResourceID).completions[key] = record
+	}
+	for name, sem := range snapshot.Semaphores {
+		lm.getShard(name).semaphores[name] = sem.copy()
+	}
+}
+
+// lockAllShards 按分段下标递增顺序给所有分段加锁
+// 与单分段操作（资源锁 -> 分段锁）不冲突
+func (lm *LockManager) lockAllShards() {
+	for _, shard := range lm.shards {
+		shard.mu.Lock()
+	}
+}
+
+// unlockAllShards 释放所有分段锁
+func (lm *LockManager) unlockAllShards() {
+	for _, shard := range lm.shards {
+		shard.mu.Unlock()
+	}
+}
+
+// applyWALRecord 把一条WAL记录应用到分段状态（只修改状态，不写WAL、不发送事件）
+func (lm *LockManager) applyWALRecord(record *WALRecord) {
+	key := LockKey(record.Type, record.ResourceID)
+	shard := lm.getShard(record.ResourceID)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	switch record.Op {
+	case WALOpGrant:
+		lockInfo := &LockInfo{
+			Request:      record.Request,
+			AcquiredAt:   record.AcquiredAt,
+			FencingToken: record.FencingToken,
+		}
+		if record.Request.shared() {
+			lm.addSharedHolderLocked(shard, key, lockInfo)
+		} else {
+			shard.locks[key] = lockInfo
+		}
+		if _, exists := shard.resourceLocks[key]; !exists {
+			shard.resourceLocks[key] = &sync.Mutex{}
+		}
+		if record.FencingToken > shard.fencingTokens[key] {
+			shard.fencingTokens[key] = record.FencingToken
+		}
+
+	case WALOpEnqueue:
+		shard.queues[key] = append(shard.queues[key], record.Request)
+		if _, exists := shard.resourceLocks[key]; !exists {
+			shard.resourceLocks[key] = &sync.Mutex{}
+		}
+
+	case WALOpDequeue:
+		queue := shard.queues[key]
+		for i, queued := range queue {
+			if queued.SessionID == record.Request.SessionID && queued.Timestamp.Equal(record.Request.Timestamp) {
+				shard.queues[key] = append(queue[:i:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(shard.queues[key]) == 0 {
+			delete(shard.queues, key)
+		}
+
+	case WALOpRelease:
+		if record.Mode == LockModeShared {
+			if holders := shard.shared[key]; holders != nil {
+				delete(holders, record.SessionID)
+				if len(holders) == 0 {
+					delete(shard.shared, key)
+				}
+			}
+			if shard.upgrades[key] == record.SessionID {
+				delete(shard.upgrades, key)
+			}
+			break
+		}
+		delete(shard.locks, key)
+		if record.Success {
+			delete(shard.resourceLocks, key)
+		}
+
+	case WALOpReassign:
+		// 因类型冲突而等待的请求被分配锁时，该key的资源锁可能已被删除
+		if _, exists := shard.resourceLocks[key]; !exists {
+			shard.resourceLocks[key] = &sync.Mutex{}
+		}
+		// 分配的请求不一定在队头（例如兼容的共享请求），按会话ID移除
+		removed := false
+		queue := shard.queues[key]
+		for i, queued := range queue {
+			if queued.SessionID == record.Request.SessionID {
+				shard.queues[key] = append(queue[:i:i], queue[i+1:]...)
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			log.Printf("[Recovery] 错误: reassign 记录的请求不在等待队列中: seq=%d, key=%s, session=%s",
+				record.Seq, key, record.Request.SessionID)
+		}
+		if len(shard.queues[key]) == 0 {
+			delete(shard.queues, key)
+		}
+		lockInfo := &LockInfo{
+			Request:      record.Request,
+			AcquiredAt:   record.AcquiredAt,
+			FencingToken: record.FencingToken,
+		}
+		if record.Request.shared() {
+			lm.addSharedHolderLocked(shard, key, lockInfo)
+		} else {
+			shard.locks[key] = lockInfo
+		}
+		if record.FencingToken > shard.fencingTokens[key] {
+			shard.fencingTokens[key] = record.FencingToken
+		}
+
+	case WALOpUpgradeWait:
+		shard.upgrades[key] = record.SessionID
+
+	case WALOpUpgrade:
+		holder, exists := shard.shared[key][record.SessionID]
+		if !exists {
+			break
+		}
+		delete(shard.shared, key)
+		delete(shard.upgrades, key)
+		request := *holder.Request
+		request.Mode = LockModeExclusive
+		request.FencingToken = record.FencingToken
+		shard.locks[key] = &LockInfo{Request: &request, AcquiredAt: record.AcquiredAt, FencingToken: record.FencingToken}
+		if record.FencingToken > shard.fencingTokens[key] {
+			shard.fencingTokens[key] = record.FencingToken
+		}
+
+	case WALOpDowngrade:
+		lockInfo, exists := shard.locks[key]
+		if !exists {
+			break
+		}
+		delete(shard.locks, key)
+		request := *lockInfo.Request
+		request.Mode = LockModeShared
+		request.FencingToken = record.FencingToken
+		lm.addSharedHolderLocked(shard, key, &LockInfo{Request: &request, AcquiredAt: record.AcquiredAt, FencingToken: record.FencingToken})
+		if record.FencingToken > shard.fencingTokens[key] {
+			shard.fencingTokens[key] = record.FencingToken
+		}
+
+	case WALOpComplete:
+		if record.Completion != nil {
+			lm.storeCompletionLocked(shard, key, record.Completion)
+		}
+
+	case WALOpInvalidate:
+		for completedKey, completion := range shard.completions {
+			if completion.ResourceID == record.ResourceID && (record.Type == "" || completion.Type == record.Type) {
+				delete(shard.completions, completedKey)
+			}
+		}
+
+	case WALOpSemaphore:
+		if record.Semaphore != nil {
+			shard.semaphores[record.ResourceID] = record.Semaphore.copy()
+		}
+
+	default:
+		log.Printf("[Recovery] 忽略未知的WAL记录: seq=%d, op=%s", record.Seq, record.Op)
+	}
+}
+
+// appendWAL 追加一条状态变更记录（未启用持久化时忽略）
+// 写入失败时内存状态已经变更：失败记录在 StateStore 中，处理请求的 Handler.durable 据此让请求失败，
+// 这里在后台生成一次快照把内存状态完整写入磁盘
+// 注意：调用此函数时，shard.mu 必须已经加锁，保证同一分段内记录顺序与状态变更顺序一致
+func (lm *LockManager) appendWAL(record *WALRecord) {
+	if lm.store == nil {
+		return
+	}
+	if err := lm.store.Append(record); err != nil {
+		log.Printf("[WAL] 写入失败: op=%s, type=%s, resource_id=%s, error=%v",
+			record.Op, record.Type, record.ResourceID, err)
+		if lm.repairing.CompareAndSwap(false, true) {
+			go func() {
+				defer lm.repairing.Store(false)
+				if err := lm.Snapshot(); err != nil {
+					log.Printf("[WAL] 写入失败后生成快照失败: %v", err)
+				}
+			}()
+		}
+	}
+}
@@ -14,6 +14,7 @@ type SSESubscriber struct {
 	request *http.Request
 	mu      sync.Mutex
 	closed  bool
+	done    chan struct{} // Close 时关闭，Subscribe 处理函数据此结束连接
 }
 
 // NewSSESubscriber 创建新的 SSE 订阅者
@@ -22,6 +23,7 @@ func NewSSESubscriber(w http.ResponseWriter, r *http.Request) *SSESubscriber {
 		writer:  w,
 		request: r,
 		closed:  false,
+		done:    make(chan struct{}),
 	}
 }
 
@@ -49,6 +51,7 @@ func (s *SSESubscriber) SendEvent(event *OperationEvent) error {
 		_, err = fmt.Fprint(s.writer, data)
 		if err != nil {
 			s.closed = true
+			close(s.done)
 			return fmt.Errorf("发送数据失败: %w", err)
 		}
 		flusher.Flush()
@@ -67,6 +70,12 @@ func (s *SSESubscriber) Close() {
 
 	if !s.closed {
 		s.closed = true
+		close(s.done)
 		log.Printf("[SSESubscriber] 关闭订阅者连接")
 	}
 }
+
+// Done 返回订阅者被关闭时关闭的 channel
+func (s *SSESubscriber) Done() <-chan struct{} {
+	return s.done
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"time"
+)
+
+// outcomeTTL 操作结果记录的有效期：轮询的等待者在此期间内查询状态时能看到上一个持有者的结果
+const outcomeTTL = time.Minute
+
+// lockOutcome key上最近一次结束的独占操作
+// 操作失败（或持有者丢失）时锁被删除并交给队头，这条记录让其余等待者看到 completed=true、success=false
+type lockOutcome struct {
+	NodeID     string
+	Success    bool
+	Error      string
+	FinishedAt time.Time
+}
+
+// recordOutcomeLocked 记录key上独占操作的结果，同时清理分段中已过期的记录
+// 注意：调用此函数时，shard.mu 必须已经加锁
+func (lm *LockManager) recordOutcomeLocked(shard *resourceShard, key string, lockInfo *LockInfo, errMsg string) {
+	for outcomeKey, outcome := range shard.outcomes {
+		if lockInfo.CompletedAt.Sub(outcome.FinishedAt) > outcomeTTL {
+			delete(shard.outcomes, outcomeKey)
+		}
+	}
+	shard.outcomes[key] = &lockOutcome{
+		NodeID:     lockInfo.Request.NodeID,
+		Success:    lockInfo.Success,
+		Error:      errMsg,
+		FinishedAt: lockInfo.CompletedAt,
+	}
+}
+
+// LockStatus 调用方在一个key上的状态（/lock/status 的响应）
+//
+// 轮询客户端在排队后周期查询它：acquired 表示锁已经分配给调用方，
+// completed/success 表示key上最近一次操作已经结束及其结果（成功时等待者跳过操作；
+// 失败时锁交给队头，队头看到 acquired，其余等待者继续等待）。
+// SSE 客户端在连接断开、重新订阅之前也可以用它确认错过的事件。
+type LockStatus struct {
+	Acquired      bool   `json:"acquired"`                // 调用方持有锁（独占或共享，且操作尚未完成）
+	Completed     bool   `json:"completed"`               // key上最近一次操作已经完成
+	Success       bool   `json:"success"`                 // 最近一次操作是否成功
+	LastError     string `json:"last_error,omitempty"`    // 最近一次操作失败时的错误信息
+	Error         string `json:"error,omitempty"`         // 调用方的等待被服务端中止时的错误信息（例如死锁）
+	Code          string `json:"code,omitempty"`          // 错误码
+	FencingToken  uint64 `json:"fencing_token,omitempty"` // 调用方持有锁时的fencing token
+	Mode          string `json:"mode,omitempty"`          // 调用方持有锁的模式
+	Queued        bool   `json:"queued"`                  // 调用方在等待队列中
+	QueuePosition int    `json:"queue_position"`          // 在等待队列中的位置（从0开始，不在队列中为-1）
+	QueueLength   int    `json:"queue_length"`            // 等待队列长度
+	Holder        string `json:"holder,omitempty"`        // 当前独占持有者（或最近一次完成操作）的节点ID
+	SharedHolders int    `json:"shared_holders"`          // 共享持有者数量
+
+	Completion *CompletionRecord `json:"completion,omitempty"` // key上仍然有效的完成记录
+	Progress   *LockProgress     `json:"progress,omitempty"`   // 当前独占持有者最近一次上报的进度
+}
+
+// GetStatus 查询调用方在key上的状态（只读，不加入队列、不分配锁）
+// 携带会话ID时按会话识别调用方，否则按节点ID（会话之前的客户端）
+func (lm *LockManager) GetStatus(request *LockRequest) *LockStatus {
+	key := LockKey(request.Type, request.ResourceID)
+	shard := lm.getShard(request.ResourceID) // 获取对应的分段（只根据resourceID分段）
+
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	now := lm.now()
+	status := &LockStatus{QueuePosition: -1}
+
+	if lockInfo, exists := shard.locks[key]; exists {
+		status.Holder = lockInfo.Request.NodeID
+		if lockInfo.Progress != nil {
+			progressCopy := *lockInfo.Progress
+			status.Progress = &progressCopy
+		}
+		status.Completed = lockInfo.Completed
+		status.Success = lockInfo.Success
+		if !lockInfo.Completed && lockInfo.ownedBy(request.SessionID, request.NodeID) {
+			status.Acquired = true
+			status.FencingToken = lockInfo.FencingToken
+			status.Mode = LockModeExclusive
+		}
+	}
+
+	holders := shard.shared[key]
+	status.SharedHolders = len(holders)
+	if !status.Acquired {
+		if holder := findSharedHolder(holders, request.SessionID, request.NodeID, 0); holder != nil {
+			status.Acquired = true
+			status.FencingToken = holder.FencingToken
+			status.Mode = LockModeShared
+		}
+	}
+
+	// 最近一次结束的操作：失败时锁已交给队头（队头看到 acquired，它自己的操作尚未完成），其余等待者看到 success=false 后继续等待
+	if outcome, exists := shard.outcomes[key]; exists && !status.Acquired && !status.Completed && now.Sub(outcome.FinishedAt) <= outcomeTTL {
+		status.Completed = true
+		status.Success = outcome.Success
+		status.LastError = outcome.Error
+		if status.Holder == "" {
+			status.Holder = outcome.NodeID
+		}
+	}
+
+	queue := shard.queues[key]
+	status.QueueLength = len(queue)
+	matcher := &CancelWaitRequest{NodeID: request.NodeID, SessionID: request.SessionID}
+	for i, queued := range queue {
+		if !matcher.matches(queued) {
+			continue
+		}
+		status.Queued = true
+		status.QueuePosition = i
+
+		// 不保留完成记录时（CompletionTTL <= 0），操作成功后锁被删除，但不会分配给排队的独占请求
+		// （它们应跳过操作），操作失败时锁会交给队头。因此独占请求仍在排队、却没有任何持有者阻塞它，
+		// 说明它等待的操作已经成功完成
+		if !queued.shared() && lm.blockingLockLocked(shard, queued) == nil {
+			status.Completed = true
+			status.Success = true
+		}
+		break
+	}
+
+	// 完成记录：等待的操作成功后排队的独占请求已被移出队列，由完成记录告知结果
+	if record, exists := shard.completions[key]; exists && now.Before(record.ExpiresAt) && !request.shared() {
+		if _, held := shard.locks[key]; !held {
+			recordCopy := *record
+			status.Completion = &recordCopy
+			status.Completed = true
+			status.Success = true
+		}
+	}
+
+	// 因死锁被中止的等待：只查看标记，不消费（标记仍由下一次加锁请求消费）
+	if abortedAt, marked := shard.aborted[key][request.SessionID]; marked && now.Sub(abortedAt) <= abortMarkTTL {
+		status.Error = deadlockAbortMessage
+		status.Code = ErrorCodeDeadlock
+	}
+
+	return status
+}
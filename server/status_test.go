@@ -0,0 +1,133 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestGetStatus 测试状态查询：持有者、排队位置，以及操作成功/失败后等待者看到的状态
+func TestGetStatus(t *testing.T) {
+	lm := NewLockManager(true)
+	holder := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-a", NodeID: "node-1"}
+	lm.TryLock(holder)
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-a", NodeID: "node-2"})
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-a", NodeID: "node-3"})
+
+	status := lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-a", NodeID: "node-1"})
+	if !status.Acquired || status.FencingToken != holder.FencingToken || status.Mode != LockModeExclusive || status.Queued {
+		t.Errorf("node-1 应持有锁: %+v", status)
+	}
+	status = lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-a", NodeID: "node-3"})
+	if status.Acquired || !status.Queued || status.QueuePosition != 1 || status.QueueLength != 2 || status.Holder != "node-1" {
+		t.Errorf("node-3 应排在第2位: %+v", status)
+	}
+	status = lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-a", NodeID: "node-4"})
+	if status.Acquired || status.Queued || status.QueuePosition != -1 {
+		t.Errorf("node-4 既不持有也不排队: %+v", status)
+	}
+
+	// 操作失败：锁交给队头 node-2
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: "sha256:status-a", NodeID: "node-1", FencingToken: holder.FencingToken, Error: "下载失败"})
+	status = lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-a", NodeID: "node-2"})
+	if !status.Acquired || status.Completed || status.FencingToken == 0 {
+		t.Fatalf("操作失败后 node-2 应获得锁: %+v", status)
+	}
+
+	// 其余等待者看到上一次操作已失败，继续等待
+	if waiting := lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-a", NodeID: "node-3"}); waiting.Acquired || !waiting.Completed || waiting.Success || waiting.LastError != "下载失败" || waiting.Holder != "node-2" || !waiting.Queued {
+		t.Errorf("操作失败后 node-3 应看到 completed=true、success=false 并继续排队: %+v", waiting)
+	}
+
+	// 操作成功：等待者看到 completed && success
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: "sha256:status-a", NodeID: "node-2", FencingToken: status.FencingToken})
+	status = lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-a", NodeID: "node-3"})
+	if status.Acquired || !status.Completed || !status.Success {
+		t.Errorf("操作成功后 node-3 应看到已完成: %+v", status)
+	}
+	status = lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-a", NodeID: "node-2"})
+	if status.Acquired {
+		t.Errorf("操作完成后不应再报告持有锁: %+v", status)
+	}
+}
+
+// TestGetStatusSharedAndDeadlock 测试共享持有者按会话识别，以及因死锁被中止的会话返回错误
+func TestGetStatusSharedAndDeadlock(t *testing.T) {
+	lm := NewLockManager(true)
+	shared := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-shared", NodeID: "node-1", SessionID: "session-s", Mode: LockModeShared}
+	lm.TryLock(shared)
+	status := lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-shared", NodeID: "node-1", SessionID: "session-s"})
+	if !status.Acquired || status.Mode != LockModeShared || status.SharedHolders != 1 {
+		t.Errorf("session-s 应持有共享锁: %+v", status)
+	}
+	status = lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-shared", NodeID: "node-1", SessionID: "session-other"})
+	if status.Acquired {
+		t.Errorf("同一节点的其他会话不应被视为持有者: %+v", status)
+	}
+
+	victim := crossWait(t, lm)
+	lm.ResolveDeadlocks()
+	status = lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:res-a", NodeID: "node-2", SessionID: victim})
+	if status.Code != ErrorCodeDeadlock || status.Error == "" || status.Queued {
+		t.Errorf("被中止的会话应返回死锁错误: %+v", status)
+	}
+	// 查询不消费中止标记，下一次加锁请求仍返回死锁错误
+	if _, _, errMsg := lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:res-a", NodeID: "node-2", SessionID: victim}); errMsg != deadlockAbortMessage {
+		t.Errorf("期望死锁错误，实际 %q", errMsg)
+	}
+}
+
+// TestStatusHTTP 测试 /lock/status 的 POST（轮询客户端）和 GET 两种调用方式
+func TestStatusHTTP(t *testing.T) {
+	lm := NewLockManager(true)
+	server := newTestServer(t, lm)
+
+	lockReq := map[string]interface{}{"type": "pull", "resource_id": "sha256:status-http", "node_id": "node-1"}
+	_, lockResp := postJSON(t, server.URL+"/lock", lockReq)
+	postJSON(t, server.URL+"/lock", map[string]interface{}{"type": "pull", "resource_id": "sha256:status-http", "node_id": "node-2"})
+
+	status, resp := postJSON(t, server.URL+"/lock/status", lockReq)
+	if status != http.StatusOK || resp["acquired"] != true || resp["fencing_token"] != lockResp["fencing_token"] {
+		t.Fatalf("node-1 应持有锁: status=%d, resp=%v", status, resp)
+	}
+
+	var queued LockStatus
+	if status := getJSON(t, server.URL+"/lock/status?type=pull&resource_id=sha256:status-http&node_id=node-2", &queued); status != http.StatusOK {
+		t.Fatalf("期望 200，实际 %d", status)
+	}
+	if queued.Acquired || !queued.Queued || queued.QueuePosition != 0 || queued.Holder != "node-1" {
+		t.Errorf("node-2 应排在队头: %+v", queued)
+	}
+
+	if status, _ := postJSON(t, server.URL+"/lock/status", map[string]interface{}{"type": "pull"}); status != http.StatusBadRequest {
+		t.Errorf("缺少参数应返回 400，实际 %d", status)
+	}
+}
+
+// TestGetStatusOutcomeUsesClock 测试操作结果记录和中止标记的有效期按 LockManager 的时钟计算
+func TestGetStatusOutcomeUsesClock(t *testing.T) {
+	lm := NewLockManager(true)
+	now := time.Now()
+	lm.clock = func() time.Time { return now }
+
+	holder := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-clock", NodeID: "node-1"}
+	lm.TryLock(holder)
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: "sha256:status-clock", NodeID: "node-1", FencingToken: holder.FencingToken, Error: "下载失败"})
+	status := lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-clock", NodeID: "node-2"})
+	if !status.Completed || status.Success || status.Holder != "node-1" {
+		t.Errorf("没有等待者时也应报告失败的结果: %+v", status)
+	}
+
+	victim := crossWait(t, lm)
+	lm.ResolveDeadlocks()
+
+	now = now.Add(outcomeTTL + abortMarkTTL + time.Second)
+	status = lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:status-clock", NodeID: "node-2"})
+	if status.Completed {
+		t.Errorf("结果记录过期后不应再报告: %+v", status)
+	}
+	status = lm.GetStatus(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:res-a", NodeID: "node-2", SessionID: victim})
+	if status.Code != "" {
+		t.Errorf("中止标记过期后不应再报告死锁错误: %+v", status)
+	}
+}
@@ -79,10 +79,11 @@ func TestSubscriberPattern(t *testing.T) {
 
 	// 3. 释放锁（操作成功）
 	unlockReq := &UnlockRequest{
-		Type:       lockType,
-		ResourceID: resourceID,
-		NodeID:     nodeID,
-		Error:      "", // 空字符串表示操作成功
+		Type:         lockType,
+		ResourceID:   resourceID,
+		NodeID:       nodeID,
+		FencingToken: lockReq.FencingToken,
+		Error:        "", // 空字符串表示操作成功
 	}
 	lm.Unlock(unlockReq)
 
@@ -190,10 +191,11 @@ func TestMultipleSubscribers(t *testing.T) {
 	time.Sleep(50 * time.Millisecond)
 
 	unlockReq := &UnlockRequest{
-		Type:       lockType,
-		ResourceID: resourceID,
-		NodeID:     nodeID,
-		Error:      "", // 空字符串表示操作成功
+		Type:         lockType,
+		ResourceID:   resourceID,
+		NodeID:       nodeID,
+		FencingToken: lockReq.FencingToken,
+		Error:        "", // 空字符串表示操作成功
 	}
 	lm.Unlock(unlockReq)
 
@@ -292,10 +294,11 @@ func TestSubscriberUnsubscribe(t *testing.T) {
 	lm.TryLock(lockReq)
 
 	unlockReq := &UnlockRequest{
-		Type:       lockType,
-		ResourceID: resourceID,
-		NodeID:     "node-test",
-		Error:      "", // 空字符串表示操作成功
+		Type:         lockType,
+		ResourceID:   resourceID,
+		NodeID:       "node-test",
+		FencingToken: lockReq.FencingToken,
+		Error:        "", // 空字符串表示操作成功
 	}
 	lm.Unlock(unlockReq)
 
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// TLS 与节点身份
+//
+// 设置 LOCK_TLS_CERT/LOCK_TLS_KEY 后 TCP 监听使用 TLS；同时设置 LOCK_TLS_CA 时要求客户端出示由该 CA 签发的证书（mTLS），
+// 节点身份取自客户端证书的 Subject CommonName 和 SAN（DNS、URI）：请求中的 node_id 必须是其中之一，否则返回 403，
+// 一个节点不能冒充其他节点加锁、解锁。复制模式和集群模式的成员之间用本进程的证书作为客户端证书互相访问，
+// 成员之间的接口要求证书身份是配置的成员ID（见 peerOnly）。
+// unix socket 依靠文件权限控制访问，不使用 TLS，也不校验节点身份。
+
+// LoadServerTLSConfig 读取服务端证书；caFile 非空时要求并校验客户端证书
+func LoadServerTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取服务端证书失败: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// PeerTLSConfig 成员之间通信使用的客户端 TLS 配置：出示本进程的证书，用服务端配置的 CA 校验对方
+func PeerTLSConfig(server *tls.Config) *tls.Config {
+	return &tls.Config{
+		Certificates: server.Certificates,
+		RootCAs:      server.ClientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// loadCertPool 读取 PEM 格式的 CA 证书
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取 CA 证书失败: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s 中没有有效的 PEM 证书", caFile)
+	}
+	return pool, nil
+}
+
+// certificateIdentities 证书代表的节点身份：Subject CommonName、DNS SAN、URI SAN
+func certificateIdentities(certificate *x509.Certificate) []string {
+	var identities []string
+	if certificate.Subject.CommonName != "" {
+		identities = append(identities, certificate.Subject.CommonName)
+	}
+	identities = append(identities, certificate.DNSNames...)
+	for _, uri := range certificate.URIs {
+		identities = append(identities, uri.String())
+	}
+	return identities
+}
+
+// checkNodeIdentity 校验 node_id 与客户端证书的身份一致，没有客户端证书（未启用 mTLS、unix socket）时不校验
+// 携带 session_id 而没有 node_id 的请求也被拒绝：会话只能由证书对应的节点使用
+func checkNodeIdentity(state *tls.ConnectionState, nodeID, sessionID string) error {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	if nodeID == "" && sessionID == "" {
+		return nil
+	}
+	identities := certificateIdentities(state.PeerCertificates[0])
+	for _, identity := range identities {
+		if identity == nodeID {
+			return nil
+		}
+	}
+	if nodeID == "" {
+		return fmt.Errorf("使用客户端证书时必须携带 node_id（证书身份: %v）", identities)
+	}
+	return fmt.Errorf("node_id %s 与客户端证书身份 %v 不一致", nodeID, identities)
+}
+
+// authenticated 校验请求（查询参数或 JSON 请求体）中的 node_id 与客户端证书的身份一致
+func (h *Handler) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			next(w, r)
+			return
+		}
+
+		var identity struct {
+			NodeID    string `json:"node_id"`
+			SessionID string `json:"session_id"`
+		}
+		query := r.URL.Query()
+		identity.NodeID = query.Get("node_id")
+		identity.SessionID = query.Get("session_id")
+		if err := peekJSON(r, &identity); err != nil {
+			http.Error(w, "读取请求失败", http.StatusBadRequest)
+			return
+		}
+
+		if err := checkNodeIdentity(r.TLS, identity.NodeID, identity.SessionID); err != nil {
+			log.Printf("[authenticated] 拒绝请求: path=%s, error=%v", r.URL.Path, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// peerOnly 校验成员之间的内部接口（Raft RPC、环变更、资源交接）的调用方：启用 mTLS 时客户端证书的身份
+// 必须是 members 返回的成员ID之一，否则返回 403；CA 签发的普通节点证书不能追加日志、安装快照、修改环或交接伪造的锁
+func peerOnly(members func() []string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			next(w, r)
+			return
+		}
+
+		identities := certificateIdentities(r.TLS.PeerCertificates[0])
+		for _, member := range members() {
+			for _, identity := range identities {
+				if identity == member {
+					next(w, r)
+					return
+				}
+			}
+		}
+		log.Printf("[peerOnly] 拒绝请求: path=%s, 客户端证书身份 %v 不是成员, remote=%s", r.URL.Path, identities, r.RemoteAddr)
+		http.Error(w, fmt.Sprintf("客户端证书身份 %v 不是成员", identities), http.StatusForbidden)
+	}
+}
+
+// peekJSON 读取 JSON 请求体中的字段并把请求体原样放回，交给后面的处理函数解析
+// 格式错误的请求体不返回错误（由处理函数返回 400）
+func peekJSON(r *http.Request, v interface{}) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	json.Unmarshal(body, v)
+	return nil
+}
+
+// checkGRPCIdentity 校验 gRPC 请求的 node_id 与客户端证书的身份一致
+func checkGRPCIdentity(ctx context.Context, nodeID, sessionID string) error {
+	caller, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := caller.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	if err := checkNodeIdentity(&info.State, nodeID, sessionID); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"distributed-lock/lockpb"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testCA 测试用的内存 CA
+type testCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pool        *x509.CertPool
+	serial      int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成 CA 密钥失败: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("生成 CA 证书失败: %v", err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return &testCA{certificate: certificate, key: key, pool: pool, serial: 1}
+}
+
+// issue 签发证书：commonName 为节点身份，服务端证书包含 127.0.0.1 和 localhost
+func (ca *testCA) issue(t *testing.T, commonName string, uris ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	for _, raw := range uris {
+		uri, _ := url.Parse(raw)
+		template.URIs = append(template.URIs, uri)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("签发证书失败: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newMTLSServer 启动要求客户端证书的测试服务器
+func newMTLSServer(t *testing.T, ca *testCA, lm *LockManager) *httptest.Server {
+	t.Helper()
+	router := mux.NewRouter()
+	NewHandler(lm).RegisterRoutes(router)
+	server := httptest.NewUnstartedServer(router)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "lock-server")},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// mtlsClient 出示 certificate 的 HTTP 客户端
+func mtlsClient(ca *testCA, certificate ...tls.Certificate) *http.Client {
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool, Certificates: certificate}}}
+}
+
+// TestMTLSNodeIdentity 测试 node_id 必须与客户端证书身份一致：一个节点不能替另一个节点解锁
+func TestMTLSNodeIdentity(t *testing.T) {
+	ca := newTestCA(t)
+	server := newMTLSServer(t, ca, NewLockManager(true))
+	node1 := mtlsClient(ca, ca.issue(t, "node-1"))
+	node2 := mtlsClient(ca, ca.issue(t, "node-2", "spiffe://cluster.local/node/node-2"))
+
+	post := func(client *http.Client, path, body string) *http.Response {
+		t.Helper()
+		resp, err := client.Post(server.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := post(node1, "/lock", `{"type":"pull","resource_id":"sha256:mtls","node_id":"node-1"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("node-1 以自己的身份加锁应成功，实际 %d", resp.StatusCode)
+	}
+	// node-2 冒充 node-1 解锁
+	if resp := post(node2, "/unlock", `{"type":"pull","resource_id":"sha256:mtls","node_id":"node-1","fencing_token":1}`); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("冒充其他节点解锁应返回403，实际 %d", resp.StatusCode)
+	}
+	// 只携带会话ID
+	if resp := post(node2, "/lock/keepalive", `{"type":"pull","resource_id":"sha256:mtls","session_id":"s-1"}`); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("只携带 session_id 应返回403，实际 %d", resp.StatusCode)
+	}
+	// URI SAN 也是身份
+	if resp := post(node2, "/lock", `{"type":"pull","resource_id":"sha256:mtls-2","node_id":"spiffe://cluster.local/node/node-2"}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("以 URI SAN 作为 node_id 加锁应成功，实际 %d", resp.StatusCode)
+	}
+	if resp := post(node1, "/unlock", `{"type":"pull","resource_id":"sha256:mtls","node_id":"node-1","fencing_token":1}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("持有者以自己的身份解锁应成功，实际 %d", resp.StatusCode)
+	}
+
+	// 查询参数中的 node_id 同样校验
+	resp, err := node2.Get(server.URL + "/lock/status?type=pull&resource_id=sha256:mtls&node_id=node-1")
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("查询参数中冒充其他节点应返回403，实际 %d", resp.StatusCode)
+	}
+
+	// 没有客户端证书时握手失败
+	if _, err := mtlsClient(ca).Get(server.URL + "/admin/locks"); err == nil {
+		t.Error("没有客户端证书的连接应被拒绝")
+	}
+}
+
+// TestGRPCNodeIdentity 测试 gRPC 请求的 node_id 与客户端证书身份不一致时返回 PermissionDenied
+func TestGRPCNodeIdentity(t *testing.T) {
+	ca := newTestCA(t)
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "lock-server")},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+	NewGRPCServer(NewHandler(NewLockManager(true))).Register(server)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///localhost",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: ca.pool, ServerName: "localhost",
+			Certificates: []tls.Certificate{ca.issue(t, "node-1")}})))
+	if err != nil {
+		t.Fatalf("创建 gRPC 连接失败: %v", err)
+	}
+	defer conn.Close()
+	rpc := lockpb.NewLockServiceClient(conn)
+
+	if resp, err := rpc.Acquire(context.Background(), &lockpb.AcquireRequest{Type: "pull", ResourceId: "sha256:grpc-mtls", NodeId: "node-1"}); err != nil || !resp.Acquired {
+		t.Fatalf("以自己的身份加锁应成功: resp=%v, err=%v", resp, err)
+	}
+	if _, err := rpc.Acquire(context.Background(), &lockpb.AcquireRequest{Type: "pull", ResourceId: "sha256:grpc-mtls", NodeId: "node-2"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("冒充其他节点应返回 PermissionDenied，实际 %v", err)
+	}
+}
+
+// TestPeerRoutesRequireMember 测试 CA 签发的非成员证书不能调用副本、集群成员之间的接口
+func TestPeerRoutesRequireMember(t *testing.T) {
+	ca := newTestCA(t)
+	lm := NewLockManager(true)
+	raftNode := NewRaftNode(RaftConfig{ID: "replica-1", Peers: map[string]string{
+		"replica-1": "https://127.0.0.1:1", "replica-2": "https://127.0.0.1:2"}}, lm)
+	cluster, err := NewCluster(ClusterConfig{ID: "member-1", Members: map[string]string{
+		"member-1": "https://127.0.0.1:1", "spiffe://cluster.local/member-2": "https://127.0.0.1:2"}}, NewLockManager(true))
+	if err != nil {
+		t.Fatalf("创建集群成员失败: %v", err)
+	}
+	router := mux.NewRouter()
+	raftNode.RegisterRoutes(router)
+	cluster.RegisterRoutes(router)
+	server := httptest.NewUnstartedServer(router)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "lock-server")},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	outsider := mtlsClient(ca, ca.issue(t, "node-1"))
+	replica := mtlsClient(ca, ca.issue(t, "replica-2"))
+	member := mtlsClient(ca, ca.issue(t, "other", "spiffe://cluster.local/member-2"))
+
+	post := func(client *http.Client, path, body string) int {
+		t.Helper()
+		resp, err := client.Post(server.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, path := range []string{"/raft/vote", "/raft/append", "/raft/snapshot", "/cluster/ring", "/cluster/handoff"} {
+		if status := post(outsider, path, `{}`); status != http.StatusForbidden {
+			t.Errorf("非成员证书调用 %s 应返回403，实际 %d", path, status)
+		}
+	}
+	if status := post(replica, "/raft/vote", `{"term":1,"candidate_id":"replica-2"}`); status != http.StatusOK {
+		t.Errorf("副本证书请求投票应被接受，实际 %d", status)
+	}
+	if status := post(member, "/cluster/handoff", `{"from":"spiffe://cluster.local/member-2"}`); status != http.StatusOK {
+		t.Errorf("成员证书（URI SAN）交接应被接受，实际 %d", status)
+	}
+}
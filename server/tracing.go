@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 分布式追踪
+//
+// HTTP 请求（除复制、集群成员之间的内部路由和 /metrics）从请求头的 traceparent 继续客户端的追踪，span 以路由模板命名；
+// gRPC 请求从 metadata 继续（main 中为 gRPC 服务设置了 otelgrpc）。加锁和解锁各有一个 LockManager 的 span，
+// 排队的请求离开等待队列（获得锁、等待超时、取消等待、操作已被完成、死锁中止、节点失效）时记录一个从加入队列到离开的
+// 排队等待 span，父 span 为提交该请求的加锁请求。追踪的初始化见 tracing 包。
+
+// tracerName 服务端 span 的 instrumentation scope
+const tracerName = "distributed-lock/server"
+
+// tracer 使用全局 TracerProvider（未初始化追踪时为空操作）
+var tracer = otel.Tracer(tracerName)
+
+// 排队等待 span 的结果（lock.queue.outcome）
+const (
+	queueOutcomeGranted   = "granted"
+	queueOutcomeExpired   = "expired"
+	queueOutcomeCanceled  = "canceled"
+	queueOutcomeCompleted = "completed"
+	queueOutcomeDeadlock  = "deadlock"
+	queueOutcomeNodeLost  = "node_failed"
+)
+
+// untracedPrefixes 不追踪的路由：成员之间的心跳、日志复制和监控抓取没有调用方的追踪，只会产生大量根 span
+var untracedPrefixes = []string{"/raft/", "/cluster/", "/metrics"}
+
+// traced 路由中间件：为每个请求创建服务端 span（从请求头继续调用方的追踪）
+func traced(next http.Handler) http.Handler {
+	return otelhttp.NewMiddleware("distributed-lock",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + routeTemplate(r)
+		}),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			for _, prefix := range untracedPrefixes {
+				if strings.HasPrefix(r.URL.Path, prefix) {
+					return false
+				}
+			}
+			return true
+		}),
+	)(next)
+}
+
+// routeTemplate 请求匹配的路由模板（没有匹配的路由时为请求路径）
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+// lockAttributes 锁请求在 span 上的属性
+func lockAttributes(opType, resourceID, nodeID, sessionID string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("lock.type", opType),
+		attribute.String("lock.resource_id", resourceID),
+		attribute.String("lock.node_id", nodeID),
+		attribute.String("lock.session_id", sessionID),
+	}
+}
+
+// endSpan 结束 span，err 不为 nil 时记录错误
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedTryLock 在 LockManager.TryLock 的 span 中加锁；请求进入等待队列时记住调用方的 span，离开队列时记录排队等待
+func (h *Handler) tracedTryLock(ctx context.Context, request *LockRequest) (bool, bool, string, error) {
+	request.spanContext = trace.SpanContextFromContext(ctx)
+	_, span := tracer.Start(ctx, "LockManager.TryLock", trace.WithAttributes(
+		lockAttributes(request.Type, request.ResourceID, request.NodeID, request.SessionID)...))
+	span.SetAttributes(attribute.String("lock.mode", request.Mode))
+
+	acquired, skip, errMsg, err := h.tryLock(request)
+	span.SetAttributes(
+		attribute.Bool("lock.acquired", acquired),
+		attribute.Bool("lock.skip", skip),
+		attribute.Bool("lock.queued", err == nil && errMsg == "" && !acquired && !skip),
+		attribute.String("lock.session_id", request.SessionID), // 第一次请求时由 TryLock 分配
+	)
+	if acquired {
+		span.SetAttributes(attribute.Int64("lock.fencing_token", int64(request.FencingToken)))
+	}
+	if errMsg != "" {
+		span.SetStatus(codes.Error, errMsg)
+	}
+	endSpan(span, err)
+	return acquired, skip, errMsg, err
+}
+
+// tracedUnlock 在 LockManager.Unlock 的 span 中解锁
+func (h *Handler) tracedUnlock(ctx context.Context, request *UnlockRequest) (bool, error) {
+	_, span := tracer.Start(ctx, "LockManager.Unlock", trace.WithAttributes(
+		lockAttributes(request.Type, request.ResourceID, request.NodeID, request.SessionID)...))
+	span.SetAttributes(
+		attribute.Int64("lock.fencing_token", int64(request.FencingToken)),
+		attribute.Bool("lock.success", request.Error == ""),
+	)
+
+	released, err := h.unlock(request)
+	span.SetAttributes(attribute.Bool("lock.released", released))
+	endSpan(span, err)
+	return released, err
+}
+
+// traceQueueWait 请求离开等待队列时记录排队等待的 span（从加入队列到 now）
+// 没有追踪上下文的请求（未启用追踪、复制模式下由日志应用、从 WAL 恢复）不记录
+func traceQueueWait(request *LockRequest, outcome string, now time.Time) {
+	if !request.spanContext.IsValid() {
+		return
+	}
+	ctx := trace.ContextWithSpanContext(context.Background(), request.spanContext)
+	_, span := tracer.Start(ctx, "LockManager.QueueWait",
+		trace.WithTimestamp(request.Timestamp),
+		trace.WithAttributes(lockAttributes(request.Type, request.ResourceID, request.NodeID, request.SessionID)...),
+	)
+	span.SetAttributes(
+		attribute.String("lock.mode", request.Mode),
+		attribute.String("lock.queue.outcome", outcome),
+	)
+	span.End(trace.WithTimestamp(now))
+}
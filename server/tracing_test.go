@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	testSpansOnce sync.Once
+	testSpans     *tracetest.InMemoryExporter
+)
+
+// recordSpans 把全局 TracerProvider 设置为写入内存的 provider（整个测试进程只设置一次：
+// 包级的 tracer 只会委托给第一次设置的 provider），返回的导出器中的 span 按追踪ID过滤使用
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	testSpansOnce.Do(func() {
+		testSpans = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(testSpans)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return testSpans
+}
+
+// spansOf 属于 traceID 的已结束 span
+func spansOf(exporter *tracetest.InMemoryExporter, traceID trace.TraceID) []tracetest.SpanStub {
+	var spans []tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID() == traceID {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// spanAttribute span 上的字符串形式的属性值
+func spanAttribute(span tracetest.SpanStub, key string) string {
+	for _, kv := range span.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+// postTraced 在 ctx 的追踪中发送 JSON POST 请求（请求头携带 traceparent）
+func postTraced(t *testing.T, ctx context.Context, url string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	result := make(map[string]interface{})
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+// TestTracePropagation 测试服务端从 traceparent 继续调用方的追踪：/lock、TryLock、排队等待和 /unlock、Unlock 都在同一个追踪中
+func TestTracePropagation(t *testing.T) {
+	exporter := recordSpans(t)
+	server := newTestServer(t, NewLockManager(true))
+
+	ctx, root := otel.Tracer("test").Start(context.Background(), "pull-image")
+	resourceID := "sha256:traced"
+
+	status, resp := postTraced(t, ctx, server.URL+"/lock", map[string]interface{}{
+		"type": "pull", "resource_id": resourceID, "node_id": "node-1",
+	})
+	if status != http.StatusOK || resp["acquired"] != true {
+		t.Fatalf("node-1 加锁失败: status=%d, resp=%v", status, resp)
+	}
+	token := resp["fencing_token"]
+	if status, resp := postTraced(t, ctx, server.URL+"/lock", map[string]interface{}{
+		"type": "pull", "resource_id": resourceID, "node_id": "node-2",
+	}); status != http.StatusOK || resp["acquired"] != false {
+		t.Fatalf("node-2 应进入等待队列: status=%d, resp=%v", status, resp)
+	}
+	// node-1 失败：锁从队列分配给 node-2
+	if status, resp := postTraced(t, ctx, server.URL+"/unlock", map[string]interface{}{
+		"type": "pull", "resource_id": resourceID, "node_id": "node-1", "fencing_token": token, "error": "下载失败",
+	}); status != http.StatusOK || resp["released"] != true {
+		t.Fatalf("node-1 解锁失败: status=%d, resp=%v", status, resp)
+	}
+	root.End()
+
+	counts := make(map[string]int)
+	var queueWait, node2Lock *tracetest.SpanStub
+	spans := spansOf(exporter, root.SpanContext().TraceID())
+	for i, span := range spans {
+		counts[span.Name]++
+		switch {
+		case span.Name == "LockManager.QueueWait":
+			queueWait = &spans[i]
+		case span.Name == "LockManager.TryLock" && spanAttribute(span, "lock.node_id") == "node-2":
+			node2Lock = &spans[i]
+			if spanAttribute(span, "lock.queued") != "true" {
+				t.Errorf("node-2 的 TryLock span 应标记 lock.queued=true: %v", span.Attributes)
+			}
+		}
+	}
+	for name, want := range map[string]int{
+		"POST /lock":            2,
+		"LockManager.TryLock":   2,
+		"POST /unlock":          1,
+		"LockManager.Unlock":    1,
+		"LockManager.QueueWait": 1,
+	} {
+		if counts[name] != want {
+			t.Errorf("span %s 期望 %d 个，实际 %d（全部: %v）", name, want, counts[name], counts)
+		}
+	}
+	if queueWait == nil || node2Lock == nil {
+		t.Fatalf("缺少排队等待或 node-2 的 TryLock span: %v", counts)
+	}
+	if spanAttribute(*queueWait, "lock.queue.outcome") != queueOutcomeGranted || spanAttribute(*queueWait, "lock.node_id") != "node-2" {
+		t.Errorf("排队等待 span 的属性不正确: %v", queueWait.Attributes)
+	}
+	// 排队等待与 TryLock 同为 node-2 的 /lock 请求的子 span
+	if queueWait.Parent.SpanID() != node2Lock.Parent.SpanID() {
+		t.Errorf("排队等待 span 的父 span 应为 node-2 的 /lock 请求: %s != %s", queueWait.Parent.SpanID(), node2Lock.Parent.SpanID())
+	}
+	if queueWait.EndTime.Before(queueWait.StartTime) {
+		t.Errorf("排队等待 span 的结束时间早于开始时间")
+	}
+}
+
+// TestQueueWaitCanceled 测试取消等待时记录排队等待 span（outcome=canceled）
+func TestQueueWaitCanceled(t *testing.T) {
+	exporter := recordSpans(t)
+	lm := NewLockManager(true)
+
+	ctx, root := otel.Tracer("test").Start(context.Background(), "pull-image")
+	handler := NewHandler(lm)
+	holder := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:traced-cancel", NodeID: "node-1"}
+	if acquired, _, _, _ := handler.tracedTryLock(ctx, holder); !acquired {
+		t.Fatal("node-1 应该获得锁")
+	}
+	waiter := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:traced-cancel", NodeID: "node-2"}
+	if acquired, _, _, _ := handler.tracedTryLock(ctx, waiter); acquired {
+		t.Fatal("node-2 应该进入等待队列")
+	}
+	lm.CancelWait(&CancelWaitRequest{Type: OperationTypePull, ResourceID: "sha256:traced-cancel", NodeID: "node-2", SessionID: waiter.SessionID})
+	root.End()
+
+	found := false
+	for _, span := range spansOf(exporter, root.SpanContext().TraceID()) {
+		if span.Name == "LockManager.QueueWait" {
+			found = true
+			if outcome := spanAttribute(span, "lock.queue.outcome"); outcome != queueOutcomeCanceled {
+				t.Errorf("期望 outcome=%s，实际 %s", queueOutcomeCanceled, outcome)
+			}
+			if span.Parent.SpanID() != root.SpanContext().SpanID() {
+				t.Errorf("排队等待 span 的父 span 应为提交请求的 span")
+			}
+		}
+	}
+	if !found {
+		t.Error("取消等待时应记录排队等待 span")
+	}
+}
+
+// TestUntracedRoutes 测试 /metrics 不创建 span
+func TestUntracedRoutes(t *testing.T) {
+	exporter := recordSpans(t)
+	server := newTestServer(t, NewLockManager(true))
+
+	ctx, root := otel.Tracer("test").Start(context.Background(), "scrape")
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/metrics", nil)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("请求 /metrics 失败: %v", err)
+	}
+	resp.Body.Close()
+	root.End()
+
+	for _, span := range spansOf(exporter, root.SpanContext().TraceID()) {
+		if span.Name != "scrape" {
+			t.Errorf("/metrics 不应创建 span，实际有 %s", span.Name)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // 操作类型常量
@@ -11,6 +14,12 @@ const (
 	OperationTypeDelete = "delete" // 删除镜像层
 )
 
+// 锁模式常量（LockRequest.Mode）
+const (
+	LockModeExclusive = "exclusive" // 独占（默认）：同一个key同时只有一个持有者
+	LockModeShared    = "shared"    // 共享：多个节点可以同时持有（例如读取/使用镜像层），与独占互斥
+)
+
 // LockRequest 锁请求
 type LockRequest struct {
 	Type       string    `json:"type"`        // 操作类型：pull, update, delete
@@ -18,6 +27,43 @@ type LockRequest struct {
 	NodeID     string    `json:"node_id"`
 	Timestamp  time.Time // 请求时间戳，用于FIFO排序
 	Error      string    `json:"error,omitempty"` // 错误信息（用于callback）
+
+	// FencingToken 由服务端在授予锁时填写（客户端传入的值会被忽略）
+	// 同一个key上单调递增，持有者解锁时必须携带
+	FencingToken uint64 `json:"fencing_token,omitempty"`
+
+	// Mode 锁模式：exclusive（默认）或 shared
+	Mode string `json:"mode,omitempty"`
+
+	// WaitTimeoutMs 可选：最长排队等待时间（毫秒），<= 0 表示一直等待
+	// 超过等待期限后请求被移出队列，processQueue 不会再把锁分配给它
+	WaitTimeoutMs int64 `json:"wait_timeout_ms,omitempty"`
+
+	// WaitDeadline 等待期限，由服务端在加入等待队列时根据 WaitTimeoutMs 填写
+	WaitDeadline time.Time `json:"wait_deadline,omitempty"`
+
+	// SessionID 会话ID：第一次请求时由服务端分配并返回，同一会话的后续请求必须携带
+	// 持有者身份以会话为准，同一节点上的不同会话互相排队
+	SessionID string `json:"session_id,omitempty"`
+
+	// spanContext 提交该请求的加锁请求的 span，离开等待队列时作为排队等待 span 的父 span（见 tracing.go）
+	// 不序列化：复制模式下由日志应用的请求、从 WAL 恢复的请求没有追踪上下文
+	spanContext trace.SpanContext
+}
+
+// shared 是否是共享模式的请求
+func (r *LockRequest) shared() bool {
+	return r.Mode == LockModeShared
+}
+
+// waitExpired 排队中的请求在 now 时是否已超过等待期限
+func (r *LockRequest) waitExpired(now time.Time) bool {
+	return !r.WaitDeadline.IsZero() && now.After(r.WaitDeadline)
+}
+
+// validLockMode 判断锁模式是否合法（空字符串表示默认的独占模式）
+func validLockMode(mode string) bool {
+	return mode == "" || mode == LockModeExclusive || mode == LockModeShared
 }
 
 // LockInfo 锁信息
@@ -27,6 +73,76 @@ type LockInfo struct {
 	Completed   bool         `json:"completed"`    // 操作是否完成
 	Success     bool         `json:"success"`      // 操作是否成功
 	CompletedAt time.Time    `json:"completed_at"` // 完成时间
+
+	// FencingToken 本次授予的fencing token（同一个key上严格递增）
+	// 锁被重新分配后旧持有者的token失效，无法再解锁或续约
+	FencingToken uint64 `json:"fencing_token"`
+
+	// LeaseExpiresAt 租约到期时间，持有者需要在此之前调用 /lock/keepalive 续约
+	// 零值表示不启用租约（LockManager.LeaseTTL <= 0）
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+
+	// Progress 持有者最近一次通过 /lock/progress 上报的进度（没有上报时为nil）
+	Progress *LockProgress `json:"progress,omitempty"`
+}
+
+// KeepAliveRequest 续约请求（持有者心跳）
+type KeepAliveRequest struct {
+	Type         string `json:"type"` // 操作类型：pull, update, delete
+	ResourceID   string `json:"resource_id"`
+	NodeID       string `json:"node_id"`
+	FencingToken uint64 `json:"fencing_token,omitempty"` // 必须与当前授予的token一致（缺失时续约失败）
+	SessionID    string `json:"session_id,omitempty"`    // 持有者的会话ID
+}
+
+// ModeChangeRequest 升级/降级请求：共享持有者升级为独占，或独占持有者降级为共享，期间不释放锁
+type ModeChangeRequest struct {
+	Type         string `json:"type"` // 操作类型：pull, update, delete
+	ResourceID   string `json:"resource_id"`
+	NodeID       string `json:"node_id"`
+	FencingToken uint64 `json:"fencing_token"` // 当前持有的fencing token，必须与服务端一致
+	SessionID    string `json:"session_id,omitempty"`
+}
+
+// CancelWaitRequest 取消等待请求：客户端放弃等待时把自己移出等待队列
+type CancelWaitRequest struct {
+	Type       string `json:"type"` // 操作类型：pull, update, delete
+	ResourceID string `json:"resource_id"`
+	NodeID     string `json:"node_id"`
+	SessionID  string `json:"session_id,omitempty"` // 只取消该会话；为空时取消节点的所有排队请求（不释放已分配的锁）
+}
+
+// matches 排队的请求是否属于要取消的会话（未携带会话ID时按节点匹配）
+func (r *CancelWaitRequest) matches(queued *LockRequest) bool {
+	if r.SessionID != "" {
+		return queued.SessionID == r.SessionID
+	}
+	return queued.NodeID == r.NodeID
+}
+
+// BatchLockRequest 批量加锁请求：一组资源要么全部获得，要么都不持有
+// 所有资源共用一个会话ID（不同key上的持有者互不影响），按各自的 type/resource_id 解锁
+type BatchLockRequest struct {
+	NodeID        string         `json:"node_id"`
+	SessionID     string         `json:"session_id,omitempty"`      // 第一次请求时由服务端分配，之后重新请求必须携带
+	WaitTimeoutMs int64          `json:"wait_timeout_ms,omitempty"` // 排队的资源最长等待时间（毫秒）
+	Resources     []*LockRequest `json:"resources"`                 // 只使用 type、resource_id、mode
+}
+
+// 批量加锁中单个资源的状态（BatchLockResult.Status）
+const (
+	BatchStatusAcquired  = "acquired"  // 已获得锁（整批成功）
+	BatchStatusQueued    = "queued"    // 被占用，已加入该key的等待队列
+	BatchStatusAvailable = "available" // 当前空闲，但整批未成功，没有持有
+)
+
+// BatchLockResult 批量加锁中单个资源的结果（按规范顺序返回）
+type BatchLockResult struct {
+	Type         string `json:"type"`
+	ResourceID   string `json:"resource_id"`
+	Mode         string `json:"mode"`
+	Status       string `json:"status"`
+	FencingToken uint64 `json:"fencing_token,omitempty"` // acquired 时返回
 }
 
 // UnlockRequest 解锁请求
@@ -36,6 +152,22 @@ type UnlockRequest struct {
 	NodeID     string `json:"node_id"`
 	Error      string `json:"error,omitempty"` // 错误信息（如果为空，表示操作成功）
 	// Success 字段已移除，改为根据 Error 自动推断：Error == "" → Success = true
+
+	// FencingToken 获得锁时返回的fencing token，/unlock 必须携带
+	// 与当前授予的token不一致时（锁已被重新分配）拒绝解锁
+	// 注意：为0时 LockManager 不校验（仅供进程内调用），HTTP 层会拒绝缺少token的请求
+	FencingToken uint64 `json:"fencing_token"`
+
+	// SessionID 加锁时返回的会话ID，携带时按会话校验持有者
+	// 为空时按 NodeID 校验（兼容会话之前的客户端，fencing token 仍然必须一致）
+	SessionID string `json:"session_id,omitempty"`
+
+	// Result 可选：操作成功时的结果，保存在完成记录中返回给之后跳过操作的请求
+	Result string `json:"result,omitempty"`
+
+	// PeerAddr 可选：持有者的 blob 服务地址（host:port），操作成功时随 completed 事件和完成记录发给等待者，
+	// 等待者可以直接从持有者拉取镜像层而不是重新从仓库下载
+	PeerAddr string `json:"peer_addr,omitempty"`
 }
 
 // 注意：ReferenceCount 类型已迁移到 callback 包
@@ -46,14 +178,65 @@ func LockKey(lockType, resourceID string) string {
 	return lockType + ":" + resourceID
 }
 
+// splitLockKey 从锁的唯一标识解析出操作类型和资源ID（LockKey 的逆操作）
+// resourceID 本身可能包含冒号（例如 sha256:xxx），只按第一个冒号切分
+func splitLockKey(key string) (lockType, resourceID string, ok bool) {
+	lockType, resourceID, ok = strings.Cut(key, ":")
+	return lockType, resourceID, ok
+}
+
+// 事件类型常量（OperationEvent.Event）
+const (
+	EventTypeCompleted    = "completed"     // 持有者操作成功完成
+	EventTypeLockAssigned = "lock_assigned" // 锁已分配给队头节点
+	EventTypeHolderLost   = "holder_lost"   // 持有者租约过期，视为操作失败
+	EventTypeAborted      = "aborted"       // 等待被服务端中止（Code 说明原因，例如死锁）
+	EventTypeProgress     = "progress"      // 持有者上报的操作进度（不表示锁状态变化）
+
+	EventTypePermitsGranted  = "permits_granted"  // 信号量：许可已分配给等待的会话
+	EventTypePermitsReleased = "permits_released" // 信号量：持有者释放（或被回收）了许可
+)
+
+// 错误码（OperationEvent.Code，以及 /lock 等接口错误响应中的 code）
+const (
+	ErrorCodeDeadlock  = "deadlock"  // 检测到死锁，等待被中止
+	ErrorCodeForbidden = "forbidden" // 授权策略不允许该操作（HTTP 403）
+)
+
 // OperationEvent 操作完成事件
 type OperationEvent struct {
-	Type        string    `json:"type"`         // 操作类型：pull, update, delete
-	ResourceID  string    `json:"resource_id"`  // 资源ID
-	NodeID      string    `json:"node_id"`      // 执行操作的节点ID
-	Success     bool      `json:"success"`      // 操作是否成功
-	Error       string    `json:"error"`        // 错误信息（如果有）
-	CompletedAt time.Time `json:"completed_at"` // 完成时间
+	Event       string    `json:"event,omitempty"` // 事件类型：completed, lock_assigned, holder_lost, aborted, progress
+	Type        string    `json:"type"`            // 操作类型：pull, update, delete
+	ResourceID  string    `json:"resource_id"`     // 资源ID
+	NodeID      string    `json:"node_id"`         // 执行操作的节点ID
+	Success     bool      `json:"success"`         // 操作是否成功
+	Error       string    `json:"error"`           // 错误信息（如果有）
+	CompletedAt time.Time `json:"completed_at"`    // 完成时间
+
+	// FencingToken 事件对应授予的fencing token
+	// completed/holder_lost：原持有者的token；lock_assigned：新分配的token
+	FencingToken uint64 `json:"fencing_token"`
+
+	// Mode 事件对应授予的锁模式（lock_assigned：分配的模式，共享持有者升级完成时为 exclusive）
+	Mode string `json:"mode,omitempty"`
+
+	// SessionID 事件对应的会话（lock_assigned：被分配锁的会话，客户端据此判断锁是否分配给自己）
+	SessionID string `json:"session_id,omitempty"`
+
+	// Code 错误码（aborted：中止原因，例如 deadlock）
+	Code string `json:"code,omitempty"`
+
+	// progress：持有者上报的进度（BytesTotal 为0表示总量未知）
+	BytesDone  int64  `json:"bytes_done,omitempty"`
+	BytesTotal int64  `json:"bytes_total,omitempty"`
+	Phase      string `json:"phase,omitempty"`
+
+	// PeerAddr completed：持有者解锁时携带的 blob 服务地址（可从该节点拉取结果）
+	PeerAddr string `json:"peer_addr,omitempty"`
+
+	// 信号量事件（type=semaphore，resource_id 为信号量名称）：分配/释放的许可数量、之后剩余的许可数量（不限制时为 -1）
+	Permits   int `json:"permits,omitempty"`
+	Available int `json:"available,omitempty"`
 }
 
 // Subscriber 订阅者接口
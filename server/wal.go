@@ -0,0 +1,463 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WAL 记录类型常量
+const (
+	WALOpGrant    = "grant"    // 授予锁（直接获取，或同一节点重新请求更新锁信息）
+	WALOpEnqueue  = "enqueue"  // 加入等待队列
+	WALOpRelease  = "release"  // 释放锁（操作成功或失败）
+	WALOpReassign = "reassign" // 从队头取出请求并分配锁（processQueue）
+	WALOpDequeue  = "dequeue"  // 从队列中移除等待超时或取消等待的请求（不分配锁）
+
+	WALOpUpgradeWait = "upgrade_wait" // 共享持有者登记等待升级
+	WALOpUpgrade     = "upgrade"      // 共享锁升级为独占锁
+	WALOpDowngrade   = "downgrade"    // 独占锁降级为共享锁
+
+	WALOpComplete   = "complete"   // 保存完成记录（操作成功）
+	WALOpInvalidate = "invalidate" // 作废完成记录
+
+	WALOpSemaphore = "semaphore" // 信号量变更后的完整状态（恢复时直接替换）
+)
+
+const (
+	snapshotFileName = "snapshot.json"
+	walFilePrefix    = "wal-"
+	walFileSuffix    = ".log"
+)
+
+// WALRecord 锁状态变更记录（追加写入WAL，一行一条JSON）
+type WALRecord struct {
+	Seq        uint64 `json:"seq"` // 全局递增序号，快照之前的记录在恢复时跳过
+	Op         string `json:"op"`
+	Type       string `json:"type"`
+	ResourceID string `json:"resource_id"`
+
+	Request      *LockRequest `json:"request,omitempty"`       // grant/enqueue/reassign/dequeue：对应的请求
+	FencingToken uint64       `json:"fencing_token,omitempty"` // grant/reassign：授予的token
+	AcquiredAt   time.Time    `json:"acquired_at,omitempty"`   // grant/reassign：授予时间
+	Success      bool         `json:"success,omitempty"`       // release：操作是否成功
+
+	Mode      string `json:"mode,omitempty"`       // release：shared 表示释放的是共享持有者
+	NodeID    string `json:"node_id,omitempty"`    // 共享持有者的release、upgrade_wait/upgrade/downgrade：对应的节点（仅用于排查）
+	SessionID string `json:"session_id,omitempty"` // 共享持有者的release、upgrade_wait/upgrade/downgrade：对应的会话
+
+	Completion *CompletionRecord `json:"completion,omitempty"` // complete：完成记录（invalidate 的 type 为空时作废所有类型）
+	Semaphore  *Semaphore        `json:"semaphore,omitempty"`  // semaphore：信号量状态（resource_id 为信号量名称）
+}
+
+// lockSnapshot 某一时刻全部分段的锁状态
+type lockSnapshot struct {
+	LastSeq       uint64                       `json:"last_seq"` // 快照包含的最后一条WAL记录序号
+	Locks         map[string]*LockInfo         `json:"locks"`
+	Queues        map[string][]*LockRequest    `json:"queues"`
+	FencingTokens map[string]uint64            `json:"fencing_tokens"`
+	Shared        map[string][]*LockInfo       `json:"shared,omitempty"`      // 共享持有者
+	Upgrades      map[string]string            `json:"upgrades,omitempty"`    // 等待升级的会话
+	Completions   map[string]*CompletionRecord `json:"completions,omitempty"` // 完成记录
+	Semaphores    map[string]*Semaphore        `json:"semaphores,omitempty"`  // 信号量
+	CreatedAt     time.Time                    `json:"created_at"`
+
+	failures uint64 // 生成快照时WAL写入失败的累计次数（快照写入后据此判断内存状态是否已完整落盘）
+}
+
+// StateStore 锁状态持久化：追加写WAL + 定期快照
+// 目录结构：
+//   - snapshot.json：最近一次快照
+//   - wal-<起始序号>.log：WAL分段，每次快照时切换到新分段，快照写入成功后删除旧分段
+type StateStore struct {
+	dir string
+
+	// SyncWrites 每条记录写入后是否 fsync（默认开启，关闭可提升吞吐但崩溃时可能丢失最后几条记录）
+	SyncWrites bool
+
+	mu         sync.Mutex
+	wal        *os.File
+	walStart   uint64 // 当前WAL分段的起始序号
+	walSize    int64  // 当前WAL分段最后一条完整记录的结束位置
+	seq        uint64 // 最后一条已写入记录的序号
+	failures   uint64 // WAL写入失败的累计次数
+	failure    error  // 最近一次WAL写入失败（之后的快照写入成功前，内存中有未落盘的变更）
+	closed     bool
+	recovered  *lockSnapshot
+	recoveries []*WALRecord
+}
+
+// OpenStateStore 打开（或创建）持久化目录，读取快照和WAL用于恢复
+func OpenStateStore(dir string) (*StateStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建状态目录失败 %s: %w", dir, err)
+	}
+
+	store := &StateStore{
+		dir:        dir,
+		SyncWrites: true,
+	}
+
+	snapshot, err := store.readSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	store.recovered = snapshot
+	store.seq = snapshot.LastSeq
+
+	segments, err := store.listSegments()
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		path := filepath.Join(dir, segment)
+		records, validSize, err := readWALSegment(path)
+		if err != nil {
+			return nil, err
+		}
+		// 截掉末尾不完整的记录，之后追加的记录才不会跟在半条记录后面
+		if info, err := os.Stat(path); err == nil && info.Size() > validSize {
+			if err := os.Truncate(path, validSize); err != nil {
+				return nil, fmt.Errorf("截断WAL分段失败 %s: %w", path, err)
+			}
+		}
+		for _, record := range records {
+			if record.Seq <= snapshot.LastSeq {
+				// 快照已包含该记录（快照写入后、旧分段删除前崩溃）
+				continue
+			}
+			store.recoveries = append(store.recoveries, record)
+			if record.Seq > store.seq {
+				store.seq = record.Seq
+			}
+		}
+	}
+
+	if err := store.openSegment(store.seq + 1); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[StateStore] 打开状态目录: dir=%s, 快照锁数量=%d, 待重放WAL记录=%d",
+		dir, len(snapshot.Locks), len(store.recoveries))
+	return store, nil
+}
+
+// Append 追加一条记录到WAL
+func (s *StateStore) Append(record *WALRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wal == nil {
+		return s.failLocked(fmt.Errorf("WAL已关闭"))
+	}
+
+	record.Seq = s.seq + 1
+	data, err := json.Marshal(record)
+	if err != nil {
+		return s.failLocked(fmt.Errorf("序列化WAL记录失败: %w", err))
+	}
+	data = append(data, '\n')
+
+	if _, err := s.wal.Write(data); err != nil {
+		s.discardFailedLocked(record.Seq)
+		return s.failLocked(fmt.Errorf("写入WAL失败: %w", err))
+	}
+	if s.SyncWrites {
+		if err := s.wal.Sync(); err != nil {
+			s.discardFailedLocked(record.Seq)
+			return s.failLocked(fmt.Errorf("同步WAL失败: %w", err))
+		}
+	}
+	s.seq = record.Seq
+	s.walSize += int64(len(data))
+	return nil
+}
+
+// failLocked 记录一次写入失败并返回 err
+// 注意：调用此函数时，s.mu 必须已经加锁
+func (s *StateStore) failLocked(err error) error {
+	s.failures++
+	s.failure = err
+	return err
+}
+
+// Err 最近一次WAL写入失败的错误；之后的快照写入成功（内存状态已完整落盘）后返回nil
+func (s *StateStore) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failure
+}
+
+// failureCount WAL写入失败的累计次数
+func (s *StateStore) failureCount() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failures
+}
+
+// repaired 快照写入成功：生成快照之后没有新的写入失败时，清除 Err
+func (s *StateStore) repaired(failures uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failure != nil && s.failures == failures {
+		log.Printf("[StateStore] 快照已写入，之前未写入WAL的状态变更已落盘")
+		s.failure = nil
+	}
+}
+
+// discardFailedLocked 写入失败后去掉可能已写入一部分的记录：截断到最后一条完整记录的结束位置，
+// 截断失败时切换到新分段（旧分段以半条记录结尾，恢复时忽略），并跳过该记录的序号
+// 注意：调用此函数时，s.mu 必须已经加锁
+func (s *StateStore) discardFailedLocked(seq uint64) {
+	err := s.wal.Truncate(s.walSize)
+	if err == nil {
+		return
+	}
+	log.Printf("[StateStore] 截断WAL分段失败，切换到新分段: start=%d, error=%v", s.walStart, err)
+	s.wal.Close()
+	s.wal = nil
+	s.seq = seq
+	if err := s.openSegment(seq + 1); err != nil {
+		log.Printf("[StateStore] 打开新WAL分段失败: %v", err)
+	}
+}
+
+// Close 关闭WAL文件
+func (s *StateStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.wal == nil {
+		return nil
+	}
+	err := s.wal.Close()
+	s.wal = nil
+	return err
+}
+
+// takeRecovered 取出恢复数据（只能取一次，由 NewLockManager 使用）
+func (s *StateStore) takeRecovered() (*lockSnapshot, []*WALRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot, records := s.recovered, s.recoveries
+	s.recovered, s.recoveries = nil, nil
+	return snapshot, records
+}
+
+// rotate 切换到新的WAL分段，返回切换前最后一条记录的序号
+// 注意：调用方必须保证此时没有并发的状态变更（持有所有分段锁）
+func (s *StateStore) rotate() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, fmt.Errorf("WAL已关闭")
+	}
+	if s.wal != nil {
+		if err := s.wal.Close(); err != nil {
+			return 0, fmt.Errorf("关闭WAL分段失败: %w", err)
+		}
+		s.wal = nil
+	}
+	if err := s.openSegment(s.seq + 1); err != nil {
+		return 0, err
+	}
+	return s.seq, nil
+}
+
+// writeSnapshot 原子写入快照（先写临时文件再rename），并删除快照已覆盖的WAL分段
+func (s *StateStore) writeSnapshot(snapshot *lockSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("序列化快照失败: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(s.dir, snapshotFileName), data); err != nil {
+		return fmt.Errorf("写入快照失败: %w", err)
+	}
+
+	// 删除快照已覆盖的旧分段（当前分段的起始序号一定大于 LastSeq）
+	s.mu.Lock()
+	currentStart := s.walStart
+	s.mu.Unlock()
+
+	segments, err := s.listSegments()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segmentStart(segment) < currentStart {
+			if err := os.Remove(filepath.Join(s.dir, segment)); err != nil {
+				log.Printf("[StateStore] 删除旧WAL分段失败: file=%s, error=%v", segment, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic 原子替换文件：先写临时文件并 fsync，再 rename 并同步目录
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("同步临时文件失败: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("关闭临时文件失败: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("替换文件失败 %s: %w", path, err)
+	}
+	// 同步目录，保证 rename 本身在崩溃后仍然生效
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("打开目录失败: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("同步目录失败: %w", err)
+	}
+	return nil
+}
+
+// openSegment 打开新的WAL分段
+// 注意：调用此函数时，s.mu 必须已经加锁（OpenStateStore 初始化时除外）
+func (s *StateStore) openSegment(start uint64) error {
+	path := filepath.Join(s.dir, fmt.Sprintf("%s%020d%s", walFilePrefix, start, walFileSuffix))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("打开WAL分段失败 %s: %w", path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("读取WAL分段信息失败 %s: %w", path, err)
+	}
+	s.wal = f
+	s.walStart = start
+	s.walSize = info.Size()
+	return nil
+}
+
+// readSnapshot 读取快照，不存在时返回空快照
+func (s *StateStore) readSnapshot() (*lockSnapshot, error) {
+	snapshot := &lockSnapshot{
+		Locks:         make(map[string]*LockInfo),
+		Queues:        make(map[string][]*LockRequest),
+		FencingTokens: make(map[string]uint64),
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFileName))
+	if os.IsNotExist(err) {
+		return snapshot, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取快照失败: %w", err)
+	}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("解析快照失败: %w", err)
+	}
+	return snapshot, nil
+}
+
+// listSegments 按起始序号列出所有WAL分段文件名
+func (s *StateStore) listSegments() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取状态目录失败: %w", err)
+	}
+
+	var segments []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, walFilePrefix) && strings.HasSuffix(name, walFileSuffix) {
+			segments = append(segments, name)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segmentStart(segments[i]) < segmentStart(segments[j])
+	})
+	return segments, nil
+}
+
+// segmentStart 从分段文件名解析起始序号
+func segmentStart(name string) uint64 {
+	var start uint64
+	fmt.Sscanf(strings.TrimSuffix(strings.TrimPrefix(name, walFilePrefix), walFileSuffix), "%d", &start)
+	return start
+}
+
+// readWALSegment 读取一个WAL分段，返回记录和最后一条完整记录的结束位置
+func readWALSegment(path string) ([]*WALRecord, int64, error) {
+	var records []*WALRecord
+	validSize, err := readRecordLines(path, func(line []byte) error {
+		var record WALRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		records = append(records, &record)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return records, validSize, nil
+}
+
+// readRecordLines 逐行读取一行一条JSON的记录文件（WAL分段、Raft日志），返回最后一条完整记录的结束位置
+// 只有文件末尾不完整的记录（崩溃或写入失败时写了一半）会被忽略，其他位置无法解析的记录返回错误
+func readRecordLines(path string, decode func(line []byte) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("打开记录文件失败 %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var offset int64
+	reader := bufio.NewReader(f)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// 没有换行符的记录未写完，追加成功的记录一定以换行符结尾
+			if len(line) > 0 {
+				log.Printf("[StateStore] 忽略末尾不完整的记录: file=%s, line=%d", path, lineNo)
+			}
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("读取记录文件失败 %s: %w", path, err)
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			offset += int64(len(line))
+			continue
+		}
+		if err := decode(line); err != nil {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				log.Printf("[StateStore] 忽略末尾无法解析的记录: file=%s, line=%d, error=%v", path, lineNo, err)
+				break
+			}
+			return 0, fmt.Errorf("记录文件 %s 第 %d 行无法解析（之后还有记录）: %w", path, lineNo, err)
+		}
+		offset += int64(len(line))
+	}
+	return offset, nil
+}
@@ -0,0 +1,359 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestLockManager 在 dir 上打开持久化存储并创建锁管理器（模拟一次服务端启动），opts 为其他配置
+func openTestLockManager(t *testing.T, dir string, opts ...LockManagerOption) (*LockManager, *StateStore) {
+	t.Helper()
+	store, err := OpenStateStore(dir)
+	if err != nil {
+		t.Fatalf("打开状态目录失败: %v", err)
+	}
+	store.SyncWrites = false
+	return NewLockManager(true, append(opts, WithStateStore(store))...), store
+}
+
+// TestRecoverFromWAL 测试重启后从WAL恢复持有的锁、等待队列和fencing token
+func TestRecoverFromWAL(t *testing.T) {
+	dir := t.TempDir()
+	resourceID := "sha256:wal1"
+
+	lm, store := openTestLockManager(t, dir)
+	req1 := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"}
+	if acquired, _, _ := lm.TryLock(req1); !acquired {
+		t.Fatal("node-1 应该获得锁")
+	}
+	for _, nodeID := range []string{"node-2", "node-3"} {
+		if acquired, _, _ := lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: nodeID}); acquired {
+			t.Fatalf("%s 应该进入等待队列", nodeID)
+		}
+	}
+	// 另一个资源：获得后成功释放，重启后不应存在
+	other := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:wal-other", NodeID: "node-1"}
+	lm.TryLock(other)
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: other.ResourceID, NodeID: "node-1", FencingToken: other.FencingToken})
+	store.Close()
+
+	// 重启
+	lm2, store2 := openTestLockManager(t, dir)
+	defer store2.Close()
+
+	lockInfo := lm2.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-1" || lockInfo.FencingToken != req1.FencingToken {
+		t.Fatalf("重启后 node-1 应仍持有锁（token=%d），实际 %+v", req1.FencingToken, lockInfo)
+	}
+	if lockInfo.LeaseExpiresAt.IsZero() {
+		t.Error("恢复的锁应重新计算租约")
+	}
+	if n := lm2.GetQueueLength(OperationTypePull, resourceID); n != 2 {
+		t.Fatalf("重启后队列长度应为2，实际 %d", n)
+	}
+	if lm2.GetLockInfo(OperationTypePull, other.ResourceID) != nil {
+		t.Error("已成功释放的锁重启后不应存在")
+	}
+
+	// 持有者在重启后可以正常解锁（失败），锁按原FIFO顺序交给 node-2
+	if !lm2.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1",
+		FencingToken: req1.FencingToken, Error: "下载失败"}) {
+		t.Fatal("重启后持有者应能正常解锁")
+	}
+	lockInfo = lm2.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-2" {
+		t.Fatalf("期望 node-2 获得锁，实际 %+v", lockInfo)
+	}
+	if lockInfo.FencingToken <= req1.FencingToken {
+		t.Errorf("重启后分配的token应继续递增，实际 %d", lockInfo.FencingToken)
+	}
+}
+
+// TestRecoverFromSnapshotAndWAL 测试快照 + 快照之后的WAL一起恢复，且旧分段被清理
+func TestRecoverFromSnapshotAndWAL(t *testing.T) {
+	dir := t.TempDir()
+
+	lm, store := openTestLockManager(t, dir)
+	reqA := &LockRequest{Type: OperationTypePull, ResourceID: "sha256:snapA", NodeID: "node-1"}
+	lm.TryLock(reqA)
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:snapA", NodeID: "node-2"})
+
+	if err := lm.Snapshot(); err != nil {
+		t.Fatalf("快照失败: %v", err)
+	}
+
+	// 快照之后的变更只存在于新的WAL分段
+	lm.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: "sha256:snapA", NodeID: "node-1",
+		FencingToken: reqA.FencingToken, Error: "下载失败"})
+	reqB := &LockRequest{Type: OperationTypeDelete, ResourceID: "sha256:snapB", NodeID: "node-3"}
+	lm.TryLock(reqB)
+	store.Close()
+
+	segments, err := filepath.Glob(filepath.Join(dir, walFilePrefix+"*"+walFileSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 {
+		t.Errorf("快照后应只保留1个WAL分段，实际 %d", len(segments))
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil {
+		t.Fatalf("快照文件应存在: %v", err)
+	}
+
+	lm2, store2 := openTestLockManager(t, dir)
+	defer store2.Close()
+
+	lockInfo := lm2.GetLockInfo(OperationTypePull, "sha256:snapA")
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-2" {
+		t.Fatalf("期望 node-2 持有 snapA，实际 %+v", lockInfo)
+	}
+	if n := lm2.GetQueueLength(OperationTypePull, "sha256:snapA"); n != 0 {
+		t.Errorf("snapA 队列应为空，实际 %d", n)
+	}
+	lockInfo = lm2.GetLockInfo(OperationTypeDelete, "sha256:snapB")
+	if lockInfo == nil || lockInfo.FencingToken != reqB.FencingToken {
+		t.Fatalf("期望 node-3 持有 snapB（token=%d），实际 %+v", reqB.FencingToken, lockInfo)
+	}
+}
+
+// TestRecoverSkipsRecordsCoveredBySnapshot 测试快照写入后、旧分段删除前崩溃时不会重复重放
+func TestRecoverSkipsRecordsCoveredBySnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	lm, store := openTestLockManager(t, dir)
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:dup", NodeID: "node-1"})
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:dup", NodeID: "node-2"})
+
+	// 保留快照前的WAL分段副本，模拟删除旧分段之前崩溃
+	segments, _ := filepath.Glob(filepath.Join(dir, walFilePrefix+"*"+walFileSuffix))
+	if len(segments) != 1 {
+		t.Fatalf("期望1个WAL分段，实际 %d", len(segments))
+	}
+	oldSegment, err := os.ReadFile(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lm.Snapshot(); err != nil {
+		t.Fatalf("快照失败: %v", err)
+	}
+	store.Close()
+	if err := os.WriteFile(segments[0], oldSegment, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lm2, store2 := openTestLockManager(t, dir)
+	defer store2.Close()
+	if n := lm2.GetQueueLength(OperationTypePull, "sha256:dup"); n != 1 {
+		t.Errorf("快照已覆盖的记录不应重复重放，期望队列长度1，实际 %d", n)
+	}
+}
+
+// TestRecoverTruncatesIncompleteTail 测试末尾写了一半的记录被忽略并截掉，之后追加的记录在重启后仍能恢复
+func TestRecoverTruncatesIncompleteTail(t *testing.T) {
+	dir := t.TempDir()
+
+	lm, store := openTestLockManager(t, dir)
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:tail1", NodeID: "node-1"})
+	store.Close()
+
+	// 模拟崩溃时写了一半的记录
+	segments, _ := filepath.Glob(filepath.Join(dir, walFilePrefix+"*"+walFileSuffix))
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":2,"op":"gra`)
+	f.Close()
+
+	lm2, store2 := openTestLockManager(t, dir)
+	lm2.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:tail2", NodeID: "node-1"})
+	store2.Close()
+
+	lm3, store3 := openTestLockManager(t, dir)
+	defer store3.Close()
+	for _, resourceID := range []string{"sha256:tail1", "sha256:tail2"} {
+		if lm3.GetLockInfo(OperationTypePull, resourceID) == nil {
+			t.Errorf("%s 的锁在重启后应仍被持有", resourceID)
+		}
+	}
+}
+
+// TestRecoverFailsOnCorruptRecord 测试中间的记录无法解析时恢复失败，而不是丢弃之后的记录
+func TestRecoverFailsOnCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	lm, store := openTestLockManager(t, dir)
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:corrupt1", NodeID: "node-1"})
+	store.Close()
+
+	segments, _ := filepath.Glob(filepath.Join(dir, walFilePrefix+"*"+walFileSuffix))
+	data, err := os.ReadFile(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(segments[0], append([]byte("{\"seq\":1,\"op\n"), data...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenStateStore(dir); err == nil {
+		t.Fatal("中间的记录损坏时打开状态目录应失败")
+	}
+}
+
+// TestAppendFailureKeepsLaterRecords 测试写入失败后之后追加的记录不会跟在半条记录后面，重启后仍能恢复
+func TestAppendFailureKeepsLaterRecords(t *testing.T) {
+	dir := t.TempDir()
+
+	lm, store := openTestLockManager(t, dir)
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:append1", NodeID: "node-1"})
+
+	// 底层文件失效：写入和截断都失败，切换到新分段
+	store.wal.Close()
+	if err := store.Append(&WALRecord{Op: WALOpInvalidate, ResourceID: "sha256:append-lost"}); err == nil {
+		t.Fatal("底层文件已关闭，追加应失败")
+	}
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:append2", NodeID: "node-1"})
+	store.Close()
+
+	lm2, store2 := openTestLockManager(t, dir)
+	defer store2.Close()
+	for _, resourceID := range []string{"sha256:append1", "sha256:append2"} {
+		if lm2.GetLockInfo(OperationTypePull, resourceID) == nil {
+			t.Errorf("%s 的锁在重启后应仍被持有", resourceID)
+		}
+	}
+}
+
+// TestReplayReassignRemovesMatchingSession 测试重放 reassign 时移除会话ID匹配的排队请求，而不是队头
+func TestReplayReassignRemovesMatchingSession(t *testing.T) {
+	lm := NewLockManager(true)
+	resourceID := "sha256:reassign"
+	head := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1", SessionID: "session-1"}
+	second := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2", SessionID: "session-2", Mode: LockModeShared}
+
+	lm.applyWALRecord(&WALRecord{Seq: 1, Op: WALOpEnqueue, Type: OperationTypePull, ResourceID: resourceID, Request: head})
+	lm.applyWALRecord(&WALRecord{Seq: 2, Op: WALOpEnqueue, Type: OperationTypePull, ResourceID: resourceID, Request: second})
+	lm.applyWALRecord(&WALRecord{Seq: 3, Op: WALOpReassign, Type: OperationTypePull, ResourceID: resourceID, Request: second, FencingToken: 1})
+
+	queues := lm.AdminQueues(&AdminFilter{})
+	if len(queues) != 1 || len(queues[0].Waiters) != 1 || queues[0].Waiters[0].SessionID != "session-1" {
+		t.Fatalf("重放后队列中应只剩 session-1，实际 %+v", queues)
+	}
+}
+
+// TestRecoverMergedHandoff 测试合并交接来的资源后重启：交接来的等待者（排在本地等待者之前）和 fencing token 计数器都不丢失
+func TestRecoverMergedHandoff(t *testing.T) {
+	dir := t.TempDir()
+	resourceID := "sha256:handoff"
+	key := LockKey(OperationTypePull, resourceID)
+
+	lm, store := openTestLockManager(t, dir)
+	local := &LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-3"}
+	lm.TryLock(local)
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-4"})
+
+	// 前任 owner 上的状态：node-1 持有锁，node-2 在等待，token 计数器为7
+	previous := NewLockManager(true)
+	previous.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-1"})
+	previous.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-2"})
+	state := previous.extractResources(func(string) bool { return false })
+	state.FencingTokens[key] = 7
+
+	// 本地已有持有者 node-3：交接来的持有者被丢弃，交接来的等待者 node-2 排在本地等待者 node-4 之前
+	lm.mergeResources(state)
+	store.Close()
+
+	lm2, store2 := openTestLockManager(t, dir)
+	defer store2.Close()
+	if lockInfo := lm2.GetLockInfo(OperationTypePull, resourceID); lockInfo == nil || lockInfo.Request.NodeID != "node-3" {
+		t.Fatalf("重启后 node-3 应仍持有锁，实际 %+v", lockInfo)
+	}
+	queues := lm2.AdminQueues(&AdminFilter{ResourceID: resourceID})
+	if len(queues) != 1 || len(queues[0].Waiters) != 2 || queues[0].Waiters[0].NodeID != "node-2" || queues[0].Waiters[1].NodeID != "node-4" {
+		t.Fatalf("重启后队列应为 node-2、node-4，实际 %+v", queues)
+	}
+
+	// 合并后的 token 计数器在重启后仍然生效
+	lm2.Unlock(&UnlockRequest{Type: OperationTypePull, ResourceID: resourceID, NodeID: "node-3", FencingToken: local.FencingToken, Error: "下载失败"})
+	lockInfo := lm2.GetLockInfo(OperationTypePull, resourceID)
+	if lockInfo == nil || lockInfo.Request.NodeID != "node-2" || lockInfo.FencingToken != 8 {
+		t.Fatalf("期望 node-2 获得锁（token=8），实际 %+v", lockInfo)
+	}
+}
+
+// TestRecoverUsesConfiguredOptions 测试恢复时使用构造选项中的配置：重置租约使用配置的租约时长，信号量使用配置的容量
+func TestRecoverUsesConfiguredOptions(t *testing.T) {
+	dir := t.TempDir()
+
+	lm, store := openTestLockManager(t, dir)
+	lm.TryLock(&LockRequest{Type: OperationTypePull, ResourceID: "sha256:configured", NodeID: "node-1"})
+	lm.AcquireSemaphore(&SemaphoreRequest{Name: "registry:configured", NodeID: "node-1", Permits: 1})
+	store.Close()
+
+	lm2, store2 := openTestLockManager(t, dir, func(lm *LockManager) {
+		lm.LeaseTTL = time.Hour
+		lm.SemaphoreCapacities["registry:configured"] = 3
+	})
+	defer store2.Close()
+	lockInfo := lm2.GetLockInfo(OperationTypePull, "sha256:configured")
+	if lockInfo == nil {
+		t.Fatal("重启后应仍持有锁")
+	}
+	if remaining := time.Until(lockInfo.LeaseExpiresAt); remaining < 30*time.Minute {
+		t.Errorf("恢复时应按配置的租约时长（1h）重置租约，实际剩余 %v", remaining)
+	}
+	if sem := lm2.GetSemaphore("registry:configured"); sem.Capacity != 3 {
+		t.Errorf("恢复后信号量应使用配置的容量3，实际 %d", sem.Capacity)
+	}
+}
+
+// TestWALFailureFailsRequest 测试WAL写入失败时请求返回 503，快照把内存状态写入磁盘之前拒绝新的变更请求
+func TestWALFailureFailsRequest(t *testing.T) {
+	dir := t.TempDir()
+	lm, store := openTestLockManager(t, dir)
+	server := newTestServer(t, lm)
+
+	// 底层文件失效：这一次写入失败，后台快照修复
+	store.wal.Close()
+	status, _ := postJSON(t, server.URL+"/lock", map[string]interface{}{"type": "pull", "resource_id": "sha256:wal-fail", "node_id": "node-1"})
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("WAL写入失败时加锁应返回503，实际 %d", status)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for store.Err() != nil || lm.repairing.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("后台快照应修复写入失败: %v", store.Err())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 未修复的写入失败：拒绝变更请求，直到快照写入
+	store.mu.Lock()
+	store.failLocked(errors.New("磁盘已满"))
+	store.mu.Unlock()
+	status, _ = postJSON(t, server.URL+"/lock", map[string]interface{}{"type": "pull", "resource_id": "sha256:wal-fail-2", "node_id": "node-1"})
+	if status != http.StatusServiceUnavailable || lm.GetLockInfo(OperationTypePull, "sha256:wal-fail-2") != nil {
+		t.Errorf("写入失败未修复时应拒绝加锁且不修改状态，实际 %d", status)
+	}
+	if err := lm.Snapshot(); err != nil {
+		t.Fatalf("快照失败: %v", err)
+	}
+	status, resp := postJSON(t, server.URL+"/lock", map[string]interface{}{"type": "pull", "resource_id": "sha256:wal-fail-2", "node_id": "node-1"})
+	if status != http.StatusOK || resp["acquired"] != true {
+		t.Errorf("快照修复后加锁应成功，实际 %d %v", status, resp)
+	}
+	store.Close()
+
+	// 修复快照包含写入失败的那次授予，重启后状态与内存一致
+	lm2, store2 := openTestLockManager(t, dir)
+	defer store2.Close()
+	for _, resourceID := range []string{"sha256:wal-fail", "sha256:wal-fail-2"} {
+		if lm2.GetLockInfo(OperationTypePull, resourceID) == nil {
+			t.Errorf("%s 的锁在重启后应仍被持有", resourceID)
+		}
+	}
+}
@@ -19,6 +19,9 @@ type LockRequest struct {
 	Type       string `json:"type"`
 	ResourceID string `json:"resource_id"`
 	NodeID     string `json:"node_id"`
+
+	// SessionID 第一次请求时由服务端分配，之后的重新请求、状态查询和解锁都要携带
+	SessionID string `json:"session_id,omitempty"`
 }
 
 type LockResponse struct {
@@ -26,6 +29,9 @@ type LockResponse struct {
 	Skip     bool   `json:"skip"`
 	Message  string `json:"message"`
 	Error    string `json:"error,omitempty"`
+
+	FencingToken uint64 `json:"fencing_token,omitempty"`
+	SessionID    string `json:"session_id,omitempty"`
 }
 
 type UnlockRequest struct {
@@ -34,12 +40,17 @@ type UnlockRequest struct {
 	NodeID     string `json:"node_id"`
 	Success    bool   `json:"success"`
 	Error      string `json:"error,omitempty"`
+
+	FencingToken uint64 `json:"fencing_token"`
+	SessionID    string `json:"session_id,omitempty"`
 }
 
 type StatusResponse struct {
 	Acquired  bool `json:"acquired"`
 	Completed bool `json:"completed"`
 	Success   bool `json:"success"`
+
+	FencingToken uint64 `json:"fencing_token,omitempty"`
 }
 
 // downloadLayer 下载单个层
@@ -50,12 +61,13 @@ func downloadLayer(nodeID, layerID string, duration time.Duration) error {
 	return nil
 }
 
-// requestLock 请求锁
-func requestLock(nodeID, layerID string) (*LockResponse, error) {
+// requestLock 请求锁（sessionID 为空时由服务端分配新的会话）
+func requestLock(nodeID, sessionID, layerID string) (*LockResponse, error) {
 	req := LockRequest{
 		Type:       "pull",
 		ResourceID: layerID,
 		NodeID:     nodeID,
+		SessionID:  sessionID,
 	}
 
 	jsonData, err := json.Marshal(req)
@@ -83,12 +95,14 @@ func requestLock(nodeID, layerID string) (*LockResponse, error) {
 }
 
 // unlock 释放锁
-func unlock(nodeID, layerID string, success bool) error {
+func unlock(nodeID, sessionID, layerID string, fencingToken uint64, success bool) error {
 	req := UnlockRequest{
-		Type:       "pull",
-		ResourceID: layerID,
-		NodeID:     nodeID,
-		Success:    success,
+		Type:         "pull",
+		ResourceID:   layerID,
+		NodeID:       nodeID,
+		Success:      success,
+		FencingToken: fencingToken,
+		SessionID:    sessionID,
 	}
 
 	jsonData, err := json.Marshal(req)
@@ -106,11 +120,12 @@ func unlock(nodeID, layerID string, success bool) error {
 }
 
 // queryStatus 查询锁状态
-func queryStatus(nodeID, layerID string) (*StatusResponse, error) {
+func queryStatus(nodeID, sessionID, layerID string) (*StatusResponse, error) {
 	req := LockRequest{
 		Type:       "pull",
 		ResourceID: layerID,
 		NodeID:     nodeID,
+		SessionID:  sessionID,
 	}
 
 	jsonData, err := json.Marshal(req)
@@ -149,11 +164,13 @@ func processLayer(nodeID, layerID string, layerDuration time.Duration, wg *sync.
 	log.Printf("[%s] 📋 请求层 %s 的锁...", nodeID, layerID)
 
 	// 请求锁
-	lockResp, err := requestLock(nodeID, layerID)
+	lockResp, err := requestLock(nodeID, "", layerID)
 	if err != nil {
 		log.Printf("[%s] ❌ 请求层 %s 的锁失败: %v", nodeID, layerID, err)
 		return
 	}
+	// 之后的请求携带同一个会话ID，重新请求时不会在队列中重复排队
+	sessionID := lockResp.SessionID
 
 	log.Printf("[%s] 🔒 层 %s 锁响应: acquired=%v, skip=%v, message=%s",
 		nodeID, layerID, lockResp.Acquired, lockResp.Skip, lockResp.Message)
@@ -169,11 +186,11 @@ func processLayer(nodeID, layerID string, layerDuration time.Duration, wg *sync.
 		log.Printf("[%s] ✅ 获得层 %s 的锁，开始下载", nodeID, layerID)
 		if err := downloadLayer(nodeID, layerID, layerDuration); err != nil {
 			log.Printf("[%s] ❌ 层 %s 下载失败: %v", nodeID, layerID, err)
-			unlock(nodeID, layerID, false)
+			unlock(nodeID, sessionID, layerID, lockResp.FencingToken, false)
 			return
 		}
 		log.Printf("[%s] 🔓 释放层 %s 的锁（成功）", nodeID, layerID)
-		unlock(nodeID, layerID, true)
+		unlock(nodeID, sessionID, layerID, lockResp.FencingToken, true)
 		return
 	}
 
@@ -191,7 +208,7 @@ func processLayer(nodeID, layerID string, layerDuration time.Duration, wg *sync.
 			log.Printf("[%s] ⏰ 层 %s 等待超时", nodeID, layerID)
 			return
 		case <-ticker.C:
-			status, err := queryStatus(nodeID, layerID)
+			status, err := queryStatus(nodeID, sessionID, layerID)
 			if err != nil {
 				log.Printf("[%s] ⚠️  查询层 %s 状态失败: %v", nodeID, layerID, err)
 				continue
@@ -209,7 +226,7 @@ func processLayer(nodeID, layerID string, layerDuration time.Duration, wg *sync.
 			// 如果操作已完成但失败，再次尝试获取锁
 			if status.Completed && !status.Success {
 				log.Printf("[%s] 🔄 层 %s 操作失败，再次尝试获取锁", nodeID, layerID)
-				lockResp, err := requestLock(nodeID, layerID)
+				lockResp, err := requestLock(nodeID, sessionID, layerID)
 				if err != nil {
 					log.Printf("[%s] ⚠️  再次请求层 %s 的锁失败: %v", nodeID, layerID, err)
 					continue
@@ -218,11 +235,11 @@ func processLayer(nodeID, layerID string, layerDuration time.Duration, wg *sync.
 					log.Printf("[%s] ✅ 再次获得层 %s 的锁，开始下载", nodeID, layerID)
 					if err := downloadLayer(nodeID, layerID, layerDuration); err != nil {
 						log.Printf("[%s] ❌ 层 %s 下载失败: %v", nodeID, layerID, err)
-						unlock(nodeID, layerID, false)
+						unlock(nodeID, sessionID, layerID, lockResp.FencingToken, false)
 						return
 					}
 					log.Printf("[%s] 🔓 释放层 %s 的锁（成功）", nodeID, layerID)
-					unlock(nodeID, layerID, true)
+					unlock(nodeID, sessionID, layerID, lockResp.FencingToken, true)
 					return
 				}
 				if lockResp.Skip {
@@ -238,11 +255,11 @@ func processLayer(nodeID, layerID string, layerDuration time.Duration, wg *sync.
 				log.Printf("[%s] ✅ 从队列中获得层 %s 的锁，开始下载", nodeID, layerID)
 				if err := downloadLayer(nodeID, layerID, layerDuration); err != nil {
 					log.Printf("[%s] ❌ 层 %s 下载失败: %v", nodeID, layerID, err)
-					unlock(nodeID, layerID, false)
+					unlock(nodeID, sessionID, layerID, status.FencingToken, false)
 					return
 				}
 				log.Printf("[%s] 🔓 释放层 %s 的锁（成功）", nodeID, layerID)
-				unlock(nodeID, layerID, true)
+				unlock(nodeID, sessionID, layerID, status.FencingToken, true)
 				return
 			}
 		}
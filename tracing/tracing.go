@@ -0,0 +1,141 @@
+// Package tracing 分布式追踪（OpenTelemetry）的初始化，服务端、客户端和 content 插件共用
+//
+// 一次镜像层拉取经过 content 插件（containerd 通过 gRPC 调用 Store.Writer）、client.ClusterLock、服务端的 /lock、
+// 排队等待（SSE 订阅）和 /unlock。各环节通过 W3C Trace Context 传递追踪上下文：containerd 的 gRPC metadata ->
+// 插件的 context -> LockClient 请求的 traceparent 头 -> 服务端的 span（TryLock、排队等待、Unlock）。
+//
+// 导出方式由 LOCK_TRACE_EXPORTER 选择：
+//
+//	otlp    OTLP 导出（协议由 OTEL_EXPORTER_OTLP_PROTOCOL 选择：grpc（默认）或 http/protobuf，
+//	        地址等由 OTEL_EXPORTER_OTLP_ENDPOINT 等标准环境变量配置）
+//	stdout  以 JSON 写到标准输出（离线测试）
+//	file    以 JSON 追加写到 LOCK_TRACE_FILE（离线测试）
+//	未设置或 none 时不导出，但仍然传递收到的追踪上下文（上游已采样的追踪在下游继续）
+//
+// 服务名默认为调用方传入的名称，可用 OTEL_SERVICE_NAME 覆盖；采样率可用 OTEL_TRACES_SAMPLER 等标准环境变量配置。
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+)
+
+// 导出方式（LOCK_TRACE_EXPORTER）
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Config 追踪配置
+type Config struct {
+	ServiceName string // 服务名（OTEL_SERVICE_NAME 优先）
+	Exporter    string // 导出方式，为空等同于 none
+	File        string // Exporter 为 file 时写入的文件
+}
+
+// ConfigFromEnv 从 LOCK_TRACE_EXPORTER、LOCK_TRACE_FILE 读取配置
+func ConfigFromEnv(serviceName string) Config {
+	return Config{
+		ServiceName: serviceName,
+		Exporter:    strings.ToLower(strings.TrimSpace(os.Getenv("LOCK_TRACE_EXPORTER"))),
+		File:        os.Getenv("LOCK_TRACE_FILE"),
+	}
+}
+
+// Setup 设置全局的追踪上下文传播器和 TracerProvider（cfg.Exporter 为空或 none 时只设置传播器）
+// 返回的 shutdown 在进程退出前调用，导出尚未导出的 span
+func Setup(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var closer io.Closer
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		exporter, err = newOTLPExporter(ctx)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		if cfg.File == "" {
+			return nil, fmt.Errorf("导出方式为 file 时必须设置 LOCK_TRACE_FILE")
+		}
+		var file *os.File
+		file, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("打开追踪文件失败: %w", err)
+		}
+		closer = file
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	default:
+		return nil, fmt.Errorf("无效的追踪导出方式: %s（应为 otlp、stdout、file 或 none）", cfg.Exporter)
+	}
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, fmt.Errorf("创建追踪导出器失败: %w", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(cfg.ServiceName)),
+		resource.WithFromEnv(), // OTEL_SERVICE_NAME、OTEL_RESOURCE_ATTRIBUTES 覆盖上面的服务名
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	// 部分属性检测失败（ErrPartialResource）时仍然使用检测到的属性
+	if err != nil && !errors.Is(err, resource.ErrPartialResource) {
+		exporter.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, fmt.Errorf("创建追踪资源失败: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+// newOTLPExporter 按 OTEL_EXPORTER_OTLP_TRACES_PROTOCOL / OTEL_EXPORTER_OTLP_PROTOCOL 创建 OTLP 导出器
+func newOTLPExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	switch protocol {
+	case "", "grpc":
+		return otlptracegrpc.New(ctx)
+	case "http/protobuf":
+		return otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("不支持的 OTLP 协议: %s（应为 grpc 或 http/protobuf）", protocol)
+	}
+}
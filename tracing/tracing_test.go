@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+// TestSetupFileExporter 测试 file 导出：关闭时把 span 以 JSON 写入文件，服务名写入资源属性
+func TestSetupFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Setup(context.Background(), Config{ServiceName: "tracing-test", Exporter: ExporterFile, File: path})
+	if err != nil {
+		t.Fatalf("初始化追踪失败: %v", err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test-span")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("关闭追踪失败: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取追踪文件失败: %v", err)
+	}
+	for _, want := range []string{`"Name":"test-span"`, `"tracing-test"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("追踪文件中没有 %s:\n%s", want, data)
+		}
+	}
+}
+
+// TestSetupInvalidConfig 测试无效的导出方式和缺少文件路径
+func TestSetupInvalidConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{ServiceName: "tracing-test", Exporter: "jaeger"},
+		{ServiceName: "tracing-test", Exporter: ExporterFile},
+	} {
+		if _, err := Setup(context.Background(), cfg); err == nil {
+			t.Errorf("配置 %+v 应返回错误", cfg)
+		}
+	}
+
+	shutdown, err := Setup(context.Background(), Config{ServiceName: "tracing-test", Exporter: ExporterNone})
+	if err != nil {
+		t.Fatalf("不导出时不应返回错误: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("不导出时关闭不应返回错误: %v", err)
+	}
+}
+
+// TestConfigFromEnv 测试从环境变量读取配置
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("LOCK_TRACE_EXPORTER", " File ")
+	t.Setenv("LOCK_TRACE_FILE", "/tmp/spans.json")
+	cfg := ConfigFromEnv("svc")
+	if cfg.Exporter != ExporterFile || cfg.File != "/tmp/spans.json" || cfg.ServiceName != "svc" {
+		t.Errorf("配置不正确: %+v", cfg)
+	}
+}